@@ -36,3 +36,12 @@ export function searchElec(data){
     data
   })
 }
+
+// 按交割时段起点[from, to)（Unix秒）、电网分区zone、分时时段band查询，zone、band为空时不筛选
+export function searchElecByDelivery(data){
+  return request({
+    url: '/good/search',
+    method: 'post',
+    data
+  })
+}
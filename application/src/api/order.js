@@ -57,3 +57,11 @@ export function searchOrderByBuyer(data){
     data
   })
 }
+
+export function listOrderByDelivery(data){
+  return request({
+    url: '/proposal/getProposalByDelivery',
+    method: 'post',
+    data
+  })
+}
@@ -1,11 +1,19 @@
 package chaincode
 
 import (
+	"bytes"
 	"chaincode_go/utils"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"math/big"
+	"math/bits"
 	"strconv"
 
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
@@ -22,6 +30,18 @@ type Wallet struct {
 	Balance string `json:"balance"`
 }
 
+/*
+密钥登记
+Address：钱包地址 base64(SM3(公钥JSON))
+Pub：公钥JSON的base64
+UID：SM2签名使用的用户标识(hex)，即地址解码后的32字节，验证方以此计算ZA
+*/
+type KeyRecord struct {
+	Address string `json:"address"`
+	Pub     string `json:"pub"`
+	UID     string `json:"uid"`
+}
+
 /*
 公钥环
 在用户初始化时将加入环
@@ -41,6 +61,44 @@ type Goods struct {
 	Price  int64  `json:"price"`
 	Amount int64  `json:"amount"`
 	Status int64  `json:"status"` //状态，0无操作；1售卖中；2锁定中
+	Meter  string `json:"meter"`  //电量来源的电表ID，由CreateGoods从该电表的余电中扣除
+	// 交割时段[DeliveryStart, DeliveryEnd)，按SettlementPeriod对齐，Period为起始结算时段的编号
+	DeliveryStart int64  `json:"deliveryStart"`
+	DeliveryEnd   int64  `json:"deliveryEnd"`
+	Period        int64  `json:"period"`
+	Zone          string `json:"zone"` //电网分区/节点
+	Band          string `json:"band"` //交割时段所属的分时电价时段：peak峰、flat平、valley谷
+}
+
+/*
+电表
+智能电表持有自己的SM2密钥，登记时绑定到拥有者（发电方）的钱包地址
+Seq、End：最后一条已接受读数的序号和结束时间，新读数的序号须为Seq+1、开始时间不早于End
+Metered：累计上网电量(kWh)；Listed：已挂牌出售的电量，余电 = Metered - Listed
+*/
+type Meter struct {
+	ID      string `json:"id"`
+	Owner   string `json:"owner"`
+	Pub     string `json:"pub"`
+	UID     string `json:"uid"`
+	Seq     int64  `json:"seq"`
+	End     int64  `json:"end"`
+	Metered int64  `json:"metered"`
+	Listed  int64  `json:"listed"`
+}
+
+/*
+MeterReading 电表签名的区间读数，[Start, End)内的上网电量Kwh
+Sign为电表对 MeterID|Start|End|Kwh|Seq 的SM2签名(hex)，UID由电表公钥派生
+*/
+type MeterReading struct {
+	MeterID string `json:"meterId"`
+	Start   int64  `json:"start"`
+	End     int64  `json:"end"`
+	Kwh     int64  `json:"kwh"`
+	Seq     int64  `json:"seq"`
+	Sign    string `json:"sign"`
+	TxID    string `json:"txId"`
 }
 
 /*
@@ -75,20 +133,31 @@ type Order struct {
 	CommA        string `json:"commA"`        //买方对价格的承诺
 	Seller_Opt   int64  `json:"seller_opt"`   //卖方对方案的确认；0未操作；1同意；2拒绝
 	Sign_CommA   string `json:"sign_commA"`   //对承诺的签名
-	RP_m         string `json:"rp_m"`         //交易金额大于0的承诺
-	RP_b         string `json:"rp_b"`         //余额不小于0的承诺
+	Enc_R_B      string `json:"enc_r_b"`      //用买方公钥加密的卖方承诺盲化因子
+	Proof_Eq     string `json:"proof_eq"`     //CommA与CommB承诺同一价格的零知识证明
+	RP           string `json:"rp"`           //聚合范围证明：交易金额RP(m)与余额不小于0的RP(b)
+	Proof_B      string `json:"proof_b"`      //RP中余额的承诺与Enc_A_B加密同一余额的证明
 	Link_sign_1  string `json:"link_sign_1"`  //可链接环签名1 Enc_A(m)||Enc_B(m)||Enc_A(b)
 	Link_sign_2  string `json:"link_sign_2"`  //可链接环签名2 Add_A||Add_B||OrderNum||Sign_B
 	Enc_B_M      string `json:"enc_b_m"`      //卖方公钥加密价格
 	Enc_B_B      string `json:"enc_b_b"`      //卖方加密余额
-	Enc_A_M      string `json:"enc_a_m"`      //买方公钥加密价格
+	Enc_A_M      string `json:"enc_a_m"`      //买方公钥加密价格，由卖方从Enc_B_M密钥切换得到
+	Proof_KS     string `json:"proof_ks"`     //Enc_A_M与Enc_B_M加密同一价格的密钥切换证明
 	Enc_A_B      string `json:"enc_a_b"`      //卖方余额加密
 	Enc_S_Add_A  string `json:"enc_s_add_a"`  //用CA公钥加密买方地址
 	Enc_S_Add_B  string `json:"enc_s_add_b"`  //用CA公钥加密卖方地址
+	Enc_O_M      string `json:"enc_o_m"`      //市场运营方公钥加密价格，用于同态统计
 	Buyer        string `json:"buyer"`        //买方的地址
 	Seller       string `json:"seller"`       //卖方的地址
-	Pubs         string `json:"pubs"`         //环公钥
+	Pubs         string `json:"pubs"`         //环成员的SM3哈希承诺，不再保存整个环
 	Flag         bool   `json:"flag"`         //订单标志ture已完成 false未完成
+	Time         int64  `json:"time"`         //提交订单(SetOrder)的交易时间戳(秒)
+	// 交割时段、分区和分时电价时段，SetProposal时从商品复制
+	DeliveryStart int64  `json:"deliveryStart"`
+	DeliveryEnd   int64  `json:"deliveryEnd"`
+	Period        int64  `json:"period"`
+	Zone          string `json:"zone"`
+	Band          string `json:"band"`
 }
 
 type Commit struct {
@@ -98,10 +167,241 @@ type Commit struct {
 	Sign       string `json:"sign"`
 }
 
+/*
+AuditRecord 监管方对订单去匿名化的审计记录，只追加不修改
+记录谁(Auditor)在何时(Time)因何(Reason)查看了哪个订单，Digest为审计结果的SM3摘要，
+结果本身（买卖双方地址）不上链
+*/
+type AuditRecord struct {
+	OrderNum string `json:"orderNum"`
+	Auditor  string `json:"auditor"`
+	MSPID    string `json:"mspId"` //提交审计记录的组织
+	Reason   string `json:"reason"`
+	Digest   string `json:"digest"`
+	TxID     string `json:"txId"`
+	Time     int64  `json:"time"` //交易时间戳(秒)
+}
+
+/*
+Aggregate 一个统计周期内已完成交易金额的同态和
+Sum为各订单Enc_O_M用CiperAdd相加的密文，只有市场运营方能解密；
+运营方解密后用PublishAggregate公布Total，并附Sum解密为Total的证明，任何人都可以用Operator登记的公钥重新验证
+*/
+type Aggregate struct {
+	ID        string `json:"id"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+	Count     int64  `json:"count"`
+	Sum       string `json:"sum"`
+	Total     int64  `json:"total"`
+	Operator  string `json:"operator"` //公布方地址，其登记的公钥即加密Enc_O_M的运营方公钥
+	Proof     string `json:"proof"`    //Sum在Operator公钥下解密为Total的Chaum-Pedersen证明
+	Published bool   `json:"published"`
+}
+
+/*
+MatchAnchor 撮合引擎一个事件（下单或撤单）的哈希锚定
+Hash为SM3(Prev||事件)，Prev须等于上一个锚定的Hash，链上因此保存撮合事件的完整顺序，
+服务端可据此证明价格优先、时间优先的撮合结果没有被改动
+*/
+type MatchAnchor struct {
+	Hash  string `json:"hash"`
+	Prev  string `json:"prev"`
+	Event string `json:"event"`
+	Order string `json:"order"` //下单或撤单的订单ID
+	Fills int64  `json:"fills"` //本事件的成交笔数
+	TxID  string `json:"txId"`
+	Time  int64  `json:"time"`
+}
+
+/*
+Clearing 一个结算时段和分区的集合竞价（统一价格）结果
+全部成交的买单按Price付款、卖单按Price收款，共成交Volume；Allocations为全部订单及其成交量的JSON，
+任何人可据此重放出清，Hash为出清结果的SM3
+*/
+type Clearing struct {
+	Period      int64  `json:"period"`
+	Zone        string `json:"zone"`
+	Price       int64  `json:"price"`
+	Volume      int64  `json:"volume"`
+	Allocations string `json:"allocations"`
+	Hash        string `json:"hash"`
+	TxID        string `json:"txId"`
+	Time        int64  `json:"time"`
+}
+
+// ClearingAllocation 集合竞价中一个订单的成交量，Side为0买单、1卖单
+type ClearingAllocation struct {
+	ID       string `json:"id"`
+	Owner    string `json:"owner"`
+	Side     int    `json:"side"`
+	Price    int64  `json:"price"`
+	Quantity int64  `json:"quantity"`
+	Filled   int64  `json:"filled"`
+}
+
+/*
+Auction 一个商品的密封拍卖（第一价格）
+Key为拍卖方公钥JSON的base64，出价用它同态加密；Seller为商品电表拥有者的地址
+Deadline前接受出价，之后由拍卖方公布结果：Winner为中标者地址，Price为中标价，
+Outcome为中标价的解密证明和其余每个出价不高于中标价（或都低于保留价Reserve）的范围证明，落标价不公开；
+链码不验证Outcome中的零知识证明，中标者和中标价是否正确完全取决于创建时指定的Verifier：
+Verifier在链下重新验证后签名Attestation，之后才能付款或恢复商品在售；验证不通过时Verifier用RejectAuction
+驳回，拍卖回到open，由拍卖方重新公布结果
+*/
+type Auction struct {
+	ID          string `json:"id"`
+	GoodId      string `json:"goodId"`
+	Seller      string `json:"seller"`
+	Key         string `json:"key"`
+	Reserve     int64  `json:"reserve"`
+	Deadline    int64  `json:"deadline"`
+	Bids        int64  `json:"bids"`   //已接受的出价数
+	Status      string `json:"status"` //open接受出价或等待公布结果；closed已公布结果；attested结果已验证；settled已付款
+	Winner      string `json:"winner"`
+	Price       int64  `json:"price"`
+	Outcome     string `json:"outcome"`
+	Verifier    string `json:"verifier"`    //验证结果的地址，不能是卖方
+	Attestation string `json:"attestation"` //Verifier对结果的SM2签名(hex)，见 AttestAuction
+	OrderNum    string `json:"orderNum"`    //中标付款的订单号
+	TxID        string `json:"txId"`
+	Time        int64  `json:"time"`
+}
+
+/*
+AuctionBid 密封出价，均为hex编码
+Cipher：拍卖方公钥下的同态密文C1||C2；Comm：对出价的Pedersen承诺；
+Range：承诺的范围证明；Link：密文与承诺为同一出价的证明
+*/
+type AuctionBid struct {
+	Auction string `json:"auction"`
+	Seq     int64  `json:"seq"` //出价顺序，同价时先出价者中标
+	Bidder  string `json:"bidder"`
+	Cipher  string `json:"cipher"`
+	Comm    string `json:"comm"`
+	Range   string `json:"range"`
+	Link    string `json:"link"`
+	TxID    string `json:"txId"`
+	Time    int64  `json:"time"`
+}
+
+/*
+ImbalanceTariff 一个分时时段的偏差电价，Band为空表示未单独定价的时段
+Shortfall：少发1kWh卖方向买方支付的金额；Excess：多发1kWh买方向卖方支付的金额
+*/
+type ImbalanceTariff struct {
+	Band      string `json:"band"`
+	Shortfall int64  `json:"shortfall"`
+	Excess    int64  `json:"excess"`
+	TxID      string `json:"txId"`
+	Time      int64  `json:"time"`
+}
+
+/*
+Imbalance 一个已完成订单的偏差结算：交割时段内电表计量的上网电量Delivered与合同电量Contracted之差
+按Rate计价，少发时卖方付款、多发时买方付款，Amount公开，双方钱包均保持加密。
+PayerCipher、PayeeCipher为Amount在付款方、收款方公钥下的同态密文，PayerK、PayeeK为其随机数，任何人可验证；
+PayerBalance为付款方扣款后的余额密文，Comm、Range、Proof证明其不为负，均为hex
+*/
+type Imbalance struct {
+	OrderNum     string `json:"orderNum"`
+	Meter        string `json:"meter"`
+	Contracted   int64  `json:"contracted"`
+	Delivered    int64  `json:"delivered"`
+	Shortfall    int64  `json:"shortfall"`
+	Excess       int64  `json:"excess"`
+	Band         string `json:"band"`
+	Rate         int64  `json:"rate"`
+	Amount       int64  `json:"amount"`
+	Payer        string `json:"payer"`
+	Payee        string `json:"payee"`
+	PayerCipher  string `json:"payerCipher"`
+	PayerK       string `json:"payerK"`
+	PayeeCipher  string `json:"payeeCipher"`
+	PayeeK       string `json:"payeeK"`
+	PayerBalance string `json:"payerBalance"`
+	PayeeBalance string `json:"payeeBalance"`
+	Comm         string `json:"comm"`
+	Range        string `json:"range"`
+	Proof        string `json:"proof"`
+	TxID         string `json:"txId"`
+	Time         int64  `json:"time"`
+}
+
 const (
 	Proposalkey  = "proposal-key" //复合主键
 	Signaturekey = "signature-key"
 	CommitKey    = "commit-key"
+	AuditKey     = "audit-key"
+	AggregateKey = "aggregate-key"
+	KeyRegistry  = "key-registry"
+	MeterKey     = "meter-key"
+	ReadingKey   = "reading-key"
+	MatchKey     = "match-key"
+	MatchHead    = "match-head"
+	AuctionKey   = "auction-key"
+	BidKey       = "bid-key"
+	ClearingKey  = "clearing-key"
+	TariffKey    = "tariff-key"
+	ImbalanceKey = "imbalance-key"
+)
+
+// 余额承诺与余额密文一致的证明为3字节头、3个33字节的压缩点和3个32字节的标量，hex编码后的长度
+const balanceProofHexLen = 2 * (3 + 3*33 + 3*32)
+
+// 出价与交易金额相同，须小于2^AuctionBidBits
+const AuctionBidBits = 32
+
+/*
+市场运营方的Fabric身份，即服务端连接网关使用的Org1 User1证书（见服务端 populateWallet）；
+由服务端计算、链码无法重新验证的结果只接受这个身份提交，见 requireOperator
+*/
+const (
+	OperatorMSP = "Org1MSP"
+	OperatorCN  = "User1@org1.example.com"
+)
+
+// 统计周期为互不重叠的AggregatePeriod时段，且至少包含MinAggregateCount笔交易，
+// 避免通过很短、只有一笔交易或相互重叠的周期相减得到单笔交易的金额
+const (
+	AggregatePeriod   = 3600
+	MinAggregateCount = 3
+)
+
+/*
+交割时段按SettlementPeriod(15分钟)对齐，最长MaxDelivery；
+分时电价按北京时间的小时划分：峰 8-11、18-23 时，谷 23-7 时，其余为平，
+一个商品的交割时段只能落在同一个分时时段内，价格按该时段定价
+*/
+const (
+	SettlementPeriod = 900
+	MaxDelivery      = 86400
+	BandPeak         = "peak"
+	BandFlat         = "flat"
+	BandValley       = "valley"
+)
+
+var touBands = [24]string{
+	BandValley, BandValley, BandValley, BandValley, BandValley, BandValley, BandValley, BandFlat,
+	BandPeak, BandPeak, BandPeak, BandFlat, BandFlat, BandFlat, BandFlat, BandFlat,
+	BandFlat, BandFlat, BandPeak, BandPeak, BandPeak, BandPeak, BandPeak, BandValley,
+}
+
+// 同态密文C1||C2为两个65字节的未压缩点，hex编码后的长度
+const homoCipherHexLen = 4 * 65
+
+// 一笔偏差结算的金额与交易金额相同，须小于2^ImbalanceBits
+const ImbalanceBits = 32
+
+/*
+付款方余额不为负的证明：comm为33字节的压缩点，range_proof为comm在BalanceRangeBits位内的范围证明，
+编码为版本、类型、曲线各1字节，2字节位数，5个点、3个标量，log2(64)=6轮内积论证的6+6个点和2个标量，
+见服务端 bulletproof/src/encoding.go
+*/
+const (
+	BalanceRangeBits   = 64
+	pointHexLen        = 2 * 33
+	balanceRangeHexLen = 2 * (3 + 2 + (5+2*6)*33 + (3+2)*32)
 )
 
 /*
@@ -114,19 +414,34 @@ func (s *SmartContract) Hello(ctx contractapi.TransactionContextInterface) strin
 /*
 初始化账本，将两个商品加入账本
 并初始化公钥环
+两个商品分别在次日的峰时段和谷时段交割
 */
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) (string, error) {
 	ids := [2]string{"10000", "10001"}
 	owners := [2]string{"Bob", "Alice"}
 	prices := [2]int64{100, 50}
 	amounts := [2]int64{20, 30}
+	hours := [2]int64{9, 2}
+	zones := [2]string{"north", "south"}
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	// 北京时间的次日零点
+	tomorrow := (ts.GetSeconds()+8*3600)/86400*86400 + 86400 - 8*3600
 	for i, v := range ids {
+		start := tomorrow + hours[i]*3600
 		good := Goods{
-			ID:     v,
-			Owner:  owners[i],
-			Price:  prices[i],
-			Amount: amounts[i],
-			Status: 0,
+			ID:            v,
+			Owner:         owners[i],
+			Price:         prices[i],
+			Amount:        amounts[i],
+			Status:        0,
+			DeliveryStart: start,
+			DeliveryEnd:   start + 3600,
+			Period:        start / SettlementPeriod,
+			Zone:          zones[i],
+			Band:          touBand(start),
 		}
 		res, err := json.Marshal(good)
 		if err != nil {
@@ -148,10 +463,88 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 	return "init success", nil
 }
 
+/*
+RegisterKey 登记公钥，地址和UID都由公钥计算，不接受调用方给出的值；
+同一地址只能登记一次，重复登记相同公钥时返回已有记录
+*/
+func (s *SmartContract) RegisterKey(ctx contractapi.TransactionContextInterface, pub_str string) (*KeyRecord, error) {
+	pub_bytes, err := base64.StdEncoding.DecodeString(pub_str)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %v", err)
+	}
+	var pub sm2.PublicKey
+	if err := json.Unmarshal(pub_bytes, &pub); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal public key: %v", err)
+	}
+	if pub.X == nil || pub.Y == nil || !sm2.GetSm2P256V1().IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("the public key is not on the sm2 curve")
+	}
+	h := sm3.New()
+	h.Write(pub_bytes)
+	digest := h.Sum(nil)
+	record := KeyRecord{
+		Address: base64.StdEncoding.EncodeToString(digest),
+		Pub:     pub_str,
+		UID:     hex.EncodeToString(digest),
+	}
+	exist, err := s.GetKey(ctx, record.Address)
+	if err == nil {
+		if exist.Pub != record.Pub {
+			return nil, fmt.Errorf("the address %s is already registered", record.Address)
+		}
+		return exist, nil
+	}
+	if err := utils.WriteLedger(record, ctx, KeyRegistry, []string{record.Address}); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// GetKey 按钱包地址查询登记的公钥和UID
+func (s *SmartContract) GetKey(ctx contractapi.TransactionContextInterface, address string) (*KeyRecord, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(KeyRegistry, []string{address})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	res, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if res == nil {
+		return nil, fmt.Errorf("the address %s is not registered", address)
+	}
+	var record KeyRecord
+	if err := json.Unmarshal(res, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key record: %v", err)
+	}
+	return &record, nil
+}
+
+// registeredPub 读取地址address登记的公钥
+func (s *SmartContract) registeredPub(ctx contractapi.TransactionContextInterface, address string) (*sm2.PublicKey, error) {
+	record, err := s.GetKey(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	pub_bytes, err := base64.StdEncoding.DecodeString(record.Pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %v", err)
+	}
+	var pub sm2.PublicKey
+	if err := json.Unmarshal(pub_bytes, &pub); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal public key: %v", err)
+	}
+	return &pub, nil
+}
+
 /*
 设置钱包，余额密文、账户地址都存入账本
+地址须先通过RegisterKey登记公钥
 */
 func (s *SmartContract) SetWallet(ctx contractapi.TransactionContextInterface, address string, ctext string) (*Wallet, error) {
+	if _, err := s.GetKey(ctx, address); err != nil {
+		return nil, err
+	}
 	exist, err := ctx.GetStub().GetState(address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
@@ -345,156 +738,578 @@ func (s *SmartContract) UpdateGoodPrice(ctx contractapi.TransactionContextInterf
 }
 
 /*
-构造初始的交易提案，供交易接收方确认
-proposalId：提案ID
-sender: 发送者钱包hash地址
-reciver：接收者钱包hash地址
-ctext：使用接收者公钥加密的密文
+RegisterMeter 登记智能电表的公钥，owner为发电方的钱包地址，须已登记公钥；
+电表ID只能登记一次
 */
-func (s *SmartContract) SetProposal(ctx contractapi.TransactionContextInterface, orderNum string, buyer string, seller string, ctext string, goodid string) (*Order, error) {
-	exist, err := ctx.GetStub().GetState(orderNum)
+func (s *SmartContract) RegisterMeter(ctx contractapi.TransactionContextInterface, id string, owner string, pub_str string) (*Meter, error) {
+	if !validMeterID(id) {
+		return nil, fmt.Errorf("invalid meter id %q", id)
+	}
+	if _, err := s.GetKey(ctx, owner); err != nil {
+		return nil, err
+	}
+	if _, err := s.GetMeter(ctx, id); err == nil {
+		return nil, fmt.Errorf("the meter %s is already registered", id)
+	}
+	pub_bytes, err := base64.StdEncoding.DecodeString(pub_str)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read from world state: %v", err)
+		return nil, fmt.Errorf("failed to decode public key: %v", err)
 	}
-	if exist != nil {
-		return nil, fmt.Errorf("the proposal %s is exists", orderNum)
+	var pub sm2.PublicKey
+	if err := json.Unmarshal(pub_bytes, &pub); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal public key: %v", err)
 	}
-	order := Order{
-		OrderNum:   orderNum,
-		GoodId:     goodid,
-		Enc_B_M:    ctext,
-		Buyer:      buyer,
-		Seller:     seller,
-		Seller_Opt: 0,
+	if pub.X == nil || pub.Y == nil || !sm2.GetSm2P256V1().IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("the public key is not on the sm2 curve")
 	}
-	_, err = s.UpdateGoodStatus(ctx, goodid)
+	h := sm3.New()
+	h.Write(pub_bytes)
+	meter := Meter{
+		ID:    id,
+		Owner: owner,
+		Pub:   pub_str,
+		UID:   hex.EncodeToString(h.Sum(nil)),
+	}
+	if err := utils.WriteLedger(meter, ctx, MeterKey, []string{id}); err != nil {
+		return nil, err
+	}
+	return &meter, nil
+}
+
+// GetMeter 按电表ID查询电表
+func (s *SmartContract) GetMeter(ctx contractapi.TransactionContextInterface, id string) (*Meter, error) {
+	results, err := utils.GetStateByPartialCompositeKeys(ctx, MeterKey, []string{id})
 	if err != nil {
-		return nil, fmt.Errorf("update good status:%v", err)
+		return nil, fmt.Errorf("%s", err)
 	}
-	orderJSON, err := json.Marshal(order)
+	if len(results) != 1 {
+		return nil, fmt.Errorf("the meter %s is not registered", id)
+	}
+	var meter Meter
+	if err := json.Unmarshal(results[0], &meter); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal meter:%v", err)
+	}
+	return &meter, nil
+}
+
+/*
+SubmitReading 提交电表签名的区间读数
+签名须由该电表登记的公钥验证通过；序号须为上一条的下一个，防止重放和重复计量；
+区间须在上一条结束之后且不晚于交易时间。接受后计入电表的累计上网电量
+*/
+func (s *SmartContract) SubmitReading(ctx contractapi.TransactionContextInterface, meter_id string, start_str string, end_str string, kwh_str string, seq_str string, sign string) (*MeterReading, error) {
+	meter, err := s.GetMeter(ctx, meter_id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal proposal:%v", err)
+		return nil, err
 	}
-	// 写入账本
-	if err := ctx.GetStub().PutState(order.OrderNum, orderJSON); err != nil {
-		return nil, fmt.Errorf("failed to put proposal in state:%v", err)
+	reading := MeterReading{MeterID: meter_id, Sign: sign, TxID: ctx.GetStub().GetTxID()}
+	for _, v := range []struct {
+		str string
+		to  *int64
+	}{{start_str, &reading.Start}, {end_str, &reading.End}, {kwh_str, &reading.Kwh}, {seq_str, &reading.Seq}} {
+		if *v.to, err = strconv.ParseInt(v.str, 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to parse reading:%v", err)
+		}
 	}
-	return &order, nil
+	if reading.Start < 0 || reading.End <= reading.Start || reading.Kwh < 0 {
+		return nil, fmt.Errorf("malformed reading")
+	}
+	if err := verifyReading(meter, &reading); err != nil {
+		return nil, err
+	}
+	if reading.Seq != meter.Seq+1 {
+		return nil, fmt.Errorf("reading %d of meter %s replayed or out of sequence, want %d", reading.Seq, meter_id, meter.Seq+1)
+	}
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	if reading.Start < meter.End || reading.End > ts.GetSeconds() {
+		return nil, fmt.Errorf("the interval [%d, %d) must start after %d and end before %d", reading.Start, reading.End, meter.End, ts.GetSeconds())
+	}
+	meter.Seq = reading.Seq
+	meter.End = reading.End
+	meter.Metered += reading.Kwh
+	if err := utils.WriteLedger(reading, ctx, ReadingKey, []string{meter_id, fmt.Sprintf("%020d", reading.Seq)}); err != nil {
+		return nil, err
+	}
+	if err := utils.WriteLedger(meter, ctx, MeterKey, []string{meter_id}); err != nil {
+		return nil, err
+	}
+	return &reading, nil
+}
+
+// GetReadings 按序号顺序查询电表的全部读数
+func (s *SmartContract) GetReadings(ctx contractapi.TransactionContextInterface, meter_id string) ([]*MeterReading, error) {
+	results, err := utils.GetStateByPartialCompositeKeys2(ctx, ReadingKey, []string{meter_id})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	readings := []*MeterReading{}
+	for _, v := range results {
+		var reading MeterReading
+		if err := json.Unmarshal(v, &reading); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal reading:%v", err)
+		}
+		readings = append(readings, &reading)
+	}
+	return readings, nil
 }
 
 /*
-根据ProposalId获取
+CreateGoods 用电表的余电创建商品，amount不能超过该电表的 Metered - Listed
+sign为电表拥有者对 id|owner|meter_id|price|amount|start|end|zone 的SM2签名(hex)，用其登记的公钥和UID验证；
+owner须是电表登记的拥有者地址，并且在签名内，他人不能用截获的签名把商品挂到自己名下；交割时段须在交易时间之后，见 checkDelivery
 */
-func (s *SmartContract) GetProposal(ctx contractapi.TransactionContextInterface, orderNum string) (*Order, error) {
-	res, err := ctx.GetStub().GetState(orderNum)
+func (s *SmartContract) CreateGoods(ctx contractapi.TransactionContextInterface, id string, owner string, meter_id string, price_str string, amount_str string, start_str string, end_str string, zone string, sign string) (*Goods, error) {
+	// GetAllGoods按["10000", "11111")范围查询商品
+	if n, err := strconv.Atoi(id); err != nil || len(id) != 5 || n < 10000 || n >= 11111 {
+		return nil, fmt.Errorf("the good id %s must be in [10000, 11111)", id)
+	}
+	exist, err := ctx.GetStub().GetState(id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
 	}
-	if res == nil {
-		return nil, fmt.Errorf("the proposal %s does not exist", orderNum)
+	if exist != nil {
+		return nil, fmt.Errorf("the good %s already exists", id)
 	}
-
-	var order Order
-	err = json.Unmarshal(res, &order)
+	price, err := strconv.ParseInt(price_str, 10, 64)
+	if err != nil || price <= 0 {
+		return nil, fmt.Errorf("invalid price %s", price_str)
+	}
+	amount, err := strconv.ParseInt(amount_str, 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, fmt.Errorf("invalid amount %s", amount_str)
+	}
+	start, err := strconv.ParseInt(start_str, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse delivery start:%v", err)
+	}
+	end, err := strconv.ParseInt(end_str, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse delivery end:%v", err)
+	}
+	if !validZone(zone) {
+		return nil, fmt.Errorf("invalid zone %q", zone)
+	}
+	band, err := checkDelivery(ctx, start, end)
 	if err != nil {
 		return nil, err
 	}
-	return &order, nil
+	meter, err := s.GetMeter(ctx, meter_id)
+	if err != nil {
+		return nil, err
+	}
+	if owner != meter.Owner {
+		return nil, fmt.Errorf("%s is not the owner of meter %s", owner, meter_id)
+	}
+	record, err := s.GetKey(ctx, meter.Owner)
+	if err != nil {
+		return nil, err
+	}
+	msg := []byte(fmt.Sprintf("%s|%s|%s|%d|%d|%d|%d|%s", id, owner, meter_id, price, amount, start, end, zone))
+	if err := verifySM2(record.Pub, record.UID, msg, sign); err != nil {
+		return nil, fmt.Errorf("the lot is not signed by the owner of meter %s: %v", meter_id, err)
+	}
+	if amount > meter.Metered-meter.Listed {
+		return nil, fmt.Errorf("amount %d exceeds the metered surplus %d of meter %s", amount, meter.Metered-meter.Listed, meter_id)
+	}
+	meter.Listed += amount
+	good := Goods{
+		ID:            id,
+		Owner:         meter.Owner,
+		Price:         price,
+		Amount:        amount,
+		Status:        1,
+		Meter:         meter_id,
+		DeliveryStart: start,
+		DeliveryEnd:   end,
+		Period:        start / SettlementPeriod,
+		Zone:          zone,
+		Band:          band,
+	}
+	goodJSON, err := json.Marshal(good)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal good:%v", err)
+	}
+	if err := ctx.GetStub().PutState(id, goodJSON); err != nil {
+		return nil, fmt.Errorf("failed to put state:%v", err)
+	}
+	if err := utils.WriteLedger(meter, ctx, MeterKey, []string{meter_id}); err != nil {
+		return nil, err
+	}
+	return &good, nil
 }
 
 /*
-sender
-proposalId：提案Id
-获取提案，返回前端进行确认
+GetGoodsByDelivery 查询交割时段起点在[from, to)内的商品
+zone、band为空时不按分区、分时时段筛选
 */
-func (s *SmartContract) GetProposalByBuyer(ctx contractapi.TransactionContextInterface, buyer string) ([]*Order, error) {
-	queryString := fmt.Sprintf("{\"selector\":{\"buyer\":\"%s\"}}", buyer)
+func (s *SmartContract) GetGoodsByDelivery(ctx contractapi.TransactionContextInterface, from_str string, to_str string, zone string, band string) ([]*Goods, error) {
+	queryString, err := deliveryQuery(from_str, to_str, zone, band)
+	if err != nil {
+		return nil, err
+	}
 	result, err := ctx.GetStub().GetQueryResult(queryString) //必须是CouchDB才行
-	var orders []*Order
 	if err != nil {
 		return nil, fmt.Errorf("%s", err)
 	}
 	defer result.Close()
-	// 遍历迭代器
+	goods := []*Goods{}
 	for result.HasNext() {
-		// 获取下一个查询结果
 		queryResult, err := result.Next()
 		if err != nil {
 			return nil, fmt.Errorf("failed to iterate over query results: %v", err)
 		}
-
-		// 将结果反序列化为 Proposal 结构体
-		var order Order
-		err = json.Unmarshal(queryResult.Value, &order)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal proposal: %v", err)
+		var good Goods
+		if err := json.Unmarshal(queryResult.Value, &good); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal good: %v", err)
 		}
-		// 将解析后的 Proposal 添加到列表中
-		orders = append(orders, &order)
+		// 订单也有交割字段，按goodId区分
+		if good.ID == "" {
+			continue
+		}
+		goods = append(goods, &good)
 	}
-	// 返回 order 列表
-	return orders, nil
+	return goods, nil
 }
 
-/*
-Reciver
-proposalId：提案Id
-获取提案，返回前端进行确认
-*/
-func (s *SmartContract) GetProposalBySeller(ctx contractapi.TransactionContextInterface, seller string) ([]*Order, error) {
-	queryString := fmt.Sprintf("{\"selector\":{\"seller\":\"%s\"}}", seller)
+// GetOrdersByDelivery 查询交割时段起点在[from, to)内的订单，筛选条件同 GetGoodsByDelivery
+func (s *SmartContract) GetOrdersByDelivery(ctx contractapi.TransactionContextInterface, from_str string, to_str string, zone string, band string) ([]*Order, error) {
+	queryString, err := deliveryQuery(from_str, to_str, zone, band)
+	if err != nil {
+		return nil, err
+	}
 	result, err := ctx.GetStub().GetQueryResult(queryString) //必须是CouchDB才行
-	var orders []*Order
 	if err != nil {
 		return nil, fmt.Errorf("%s", err)
 	}
 	defer result.Close()
-	// 遍历迭代器
+	orders := []*Order{}
 	for result.HasNext() {
-		// 获取下一个查询结果
 		queryResult, err := result.Next()
 		if err != nil {
 			return nil, fmt.Errorf("failed to iterate over query results: %v", err)
 		}
-
-		// 将结果反序列化为 Proposal 结构体
 		var order Order
-		err = json.Unmarshal(queryResult.Value, &order)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal proposal: %v", err)
+		if err := json.Unmarshal(queryResult.Value, &order); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal order: %v", err)
+		}
+		if order.OrderNum == "" {
+			continue
 		}
-		// 将解析后的 Proposal 添加到列表中
 		orders = append(orders, &order)
 	}
-	// 返回 order 列表
 	return orders, nil
 }
 
-func (s *SmartContract) BuyerSetCommit(ctx contractapi.TransactionContextInterface, orderNum string, comm string, sign string) (*Order, error) {
-	exist, err := ctx.GetStub().GetState(orderNum)
+// deliveryQuery 构造按交割时段、分区、分时时段查询的CouchDB选择器
+func deliveryQuery(from_str string, to_str string, zone string, band string) (string, error) {
+	from, err := strconv.ParseInt(from_str, 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read from world state: %v", err)
+		return "", fmt.Errorf("failed to parse from:%v", err)
 	}
-	if exist == nil {
-		return nil, fmt.Errorf("the order %s is not exists", orderNum)
+	to, err := strconv.ParseInt(to_str, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse to:%v", err)
 	}
-	var order Order
-	if err := json.Unmarshal(exist, &order); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal order:%v", err)
+	if to <= from {
+		return "", fmt.Errorf("empty delivery period [%d, %d)", from, to)
 	}
-	order.CommA = comm
-	order.Sign_CommA = sign
-	orderJSON, err := json.Marshal(order)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal order:%v", err)
+	selector := map[string]interface{}{
+		"deliveryStart": map[string]int64{"$gte": from, "$lt": to},
 	}
-	if err := ctx.GetStub().PutState(orderNum, orderJSON); err != nil {
+	if zone != "" {
+		selector["zone"] = zone
+	}
+	if band != "" {
+		selector["band"] = band
+	}
+	queryString, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal query:%v", err)
+	}
+	return string(queryString), nil
+}
+
+/*
+checkDelivery 检查交割时段：按SettlementPeriod对齐，长度不超过MaxDelivery，
+起点晚于交易时间，且整个时段落在同一个分时时段内，返回该分时时段
+*/
+func checkDelivery(ctx contractapi.TransactionContextInterface, start int64, end int64) (string, error) {
+	if start%SettlementPeriod != 0 || end%SettlementPeriod != 0 || end <= start || end-start > MaxDelivery {
+		return "", fmt.Errorf("the delivery window [%d, %d) must be aligned to %d seconds and at most %d seconds long", start, end, SettlementPeriod, MaxDelivery)
+	}
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	if start <= ts.GetSeconds() {
+		return "", fmt.Errorf("the delivery window starts at %d, not after %d", start, ts.GetSeconds())
+	}
+	band := touBand(start)
+	for t := start; t < end; t += SettlementPeriod {
+		if touBand(t) != band {
+			return "", fmt.Errorf("the delivery window [%d, %d) spans more than one tariff band", start, end)
+		}
+	}
+	return band, nil
+}
+
+// touBand 时刻t(Unix秒)所属的分时电价时段，按北京时间
+func touBand(t int64) string {
+	return touBands[(t+8*3600)%86400/3600]
+}
+
+// validZone 分区只能含字母、数字、-和_
+func validZone(zone string) bool {
+	return validMeterID(zone)
+}
+
+// validMeterID 电表ID只能含字母、数字、-和_，不能含读数签名内容的分隔符
+func validMeterID(id string) bool {
+	if len(id) == 0 || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyReading 用电表登记的公钥验证读数签名
+func verifyReading(meter *Meter, reading *MeterReading) error {
+	msg := []byte(fmt.Sprintf("%s|%d|%d|%d|%d", reading.MeterID, reading.Start, reading.End, reading.Kwh, reading.Seq))
+	if err := verifySM2(meter.Pub, meter.UID, msg, reading.Sign); err != nil {
+		return fmt.Errorf("the reading is not signed by meter %s: %v", meter.ID, err)
+	}
+	return nil
+}
+
+// keyUID 返回公钥JSON的base64对应的UID(hex)，与RegisterKey登记的UID相同
+func keyUID(pub_str string) (string, error) {
+	pub_bytes, err := base64.StdEncoding.DecodeString(pub_str)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode public key: %v", err)
+	}
+	h := sm3.New()
+	h.Write(pub_bytes)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// requireOperator 检查交易由市场运营方的Fabric身份提交，交易本身即带有该身份的签名
+func requireOperator(ctx contractapi.TransactionContextInterface) error {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client msp id: %v", err)
+	}
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("failed to get client certificate: %v", err)
+	}
+	if mspID != OperatorMSP || cert == nil || cert.Subject.CommonName != OperatorCN {
+		return fmt.Errorf("the transaction is not submitted by the market operator")
+	}
+	return nil
+}
+
+// verifySM2 用base64公钥JSON和hex的UID验证hex的SM2签名
+func verifySM2(pub_str string, uid_hex string, msg []byte, sign_hex string) error {
+	pub_bytes, err := base64.StdEncoding.DecodeString(pub_str)
+	if err != nil {
+		return fmt.Errorf("failed to decode public key: %v", err)
+	}
+	var pub sm2.PublicKey
+	if err := json.Unmarshal(pub_bytes, &pub); err != nil {
+		return fmt.Errorf("failed to unmarshal public key: %v", err)
+	}
+	pub.Curve = sm2.GetSm2P256V1()
+	uid, err := hex.DecodeString(uid_hex)
+	if err != nil {
+		return fmt.Errorf("failed to decode uid: %v", err)
+	}
+	sign, err := hex.DecodeString(sign_hex)
+	if err != nil || len(sign) == 0 || !sm2.Verify(&pub, uid, msg, sign) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+/*
+构造初始的交易提案，供交易接收方确认
+proposalId：提案ID
+sender: 发送者钱包hash地址
+reciver：接收者钱包hash地址
+ctext：使用接收者公钥加密的密文
+*/
+func (s *SmartContract) SetProposal(ctx contractapi.TransactionContextInterface, orderNum string, buyer string, seller string, ctext string, goodid string) (*Order, error) {
+	exist, err := ctx.GetStub().GetState(orderNum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if exist != nil {
+		return nil, fmt.Errorf("the proposal %s is exists", orderNum)
+	}
+	good, err := s.GetGoods(ctx, goodid)
+	if err != nil {
+		return nil, err
+	}
+	// 交割时段已开始的商品不能再交易
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	if good.DeliveryStart <= ts.GetSeconds() {
+		return nil, fmt.Errorf("the delivery of good %s has started", goodid)
+	}
+	order := Order{
+		OrderNum:      orderNum,
+		GoodId:        goodid,
+		Enc_B_M:       ctext,
+		Buyer:         buyer,
+		Seller:        seller,
+		Seller_Opt:    0,
+		DeliveryStart: good.DeliveryStart,
+		DeliveryEnd:   good.DeliveryEnd,
+		Period:        good.Period,
+		Zone:          good.Zone,
+		Band:          good.Band,
+	}
+	_, err = s.UpdateGoodStatus(ctx, goodid)
+	if err != nil {
+		return nil, fmt.Errorf("update good status:%v", err)
+	}
+	orderJSON, err := json.Marshal(order)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal proposal:%v", err)
+	}
+	// 写入账本
+	if err := ctx.GetStub().PutState(order.OrderNum, orderJSON); err != nil {
+		return nil, fmt.Errorf("failed to put proposal in state:%v", err)
+	}
+	return &order, nil
+}
+
+/*
+根据ProposalId获取
+*/
+func (s *SmartContract) GetProposal(ctx contractapi.TransactionContextInterface, orderNum string) (*Order, error) {
+	res, err := ctx.GetStub().GetState(orderNum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if res == nil {
+		return nil, fmt.Errorf("the proposal %s does not exist", orderNum)
+	}
+
+	var order Order
+	err = json.Unmarshal(res, &order)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+/*
+sender
+proposalId：提案Id
+获取提案，返回前端进行确认
+*/
+func (s *SmartContract) GetProposalByBuyer(ctx contractapi.TransactionContextInterface, buyer string) ([]*Order, error) {
+	queryString := fmt.Sprintf("{\"selector\":{\"buyer\":\"%s\"}}", buyer)
+	result, err := ctx.GetStub().GetQueryResult(queryString) //必须是CouchDB才行
+	var orders []*Order
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	defer result.Close()
+	// 遍历迭代器
+	for result.HasNext() {
+		// 获取下一个查询结果
+		queryResult, err := result.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over query results: %v", err)
+		}
+
+		// 将结果反序列化为 Proposal 结构体
+		var order Order
+		err = json.Unmarshal(queryResult.Value, &order)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal proposal: %v", err)
+		}
+		// 将解析后的 Proposal 添加到列表中
+		orders = append(orders, &order)
+	}
+	// 返回 order 列表
+	return orders, nil
+}
+
+/*
+Reciver
+proposalId：提案Id
+获取提案，返回前端进行确认
+*/
+func (s *SmartContract) GetProposalBySeller(ctx contractapi.TransactionContextInterface, seller string) ([]*Order, error) {
+	queryString := fmt.Sprintf("{\"selector\":{\"seller\":\"%s\"}}", seller)
+	result, err := ctx.GetStub().GetQueryResult(queryString) //必须是CouchDB才行
+	var orders []*Order
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	defer result.Close()
+	// 遍历迭代器
+	for result.HasNext() {
+		// 获取下一个查询结果
+		queryResult, err := result.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over query results: %v", err)
+		}
+
+		// 将结果反序列化为 Proposal 结构体
+		var order Order
+		err = json.Unmarshal(queryResult.Value, &order)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal proposal: %v", err)
+		}
+		// 将解析后的 Proposal 添加到列表中
+		orders = append(orders, &order)
+	}
+	// 返回 order 列表
+	return orders, nil
+}
+
+func (s *SmartContract) BuyerSetCommit(ctx contractapi.TransactionContextInterface, orderNum string, comm string, sign string, proofEq string) (*Order, error) {
+	exist, err := ctx.GetStub().GetState(orderNum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if exist == nil {
+		return nil, fmt.Errorf("the order %s is not exists", orderNum)
+	}
+	var order Order
+	if err := json.Unmarshal(exist, &order); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal order:%v", err)
+	}
+	order.CommA = comm
+	order.Sign_CommA = sign
+	order.Proof_Eq = proofEq
+	orderJSON, err := json.Marshal(order)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal order:%v", err)
+	}
+	if err := ctx.GetStub().PutState(orderNum, orderJSON); err != nil {
 		return nil, fmt.Errorf("failed to put order: %v", err)
 	}
 	return &order, nil
 }
 
-func (s *SmartContract) SellerSetCommit(ctx contractapi.TransactionContextInterface, orderNum string, comm string, sign string) (*Order, error) {
+/*
+SellerSetCommit 卖方上传价格承诺及其签名、加密给买方的盲化因子，
+以及把Enc_B_M切换到买方公钥下的Enc_A_M和密钥切换证明
+*/
+func (s *SmartContract) SellerSetCommit(ctx contractapi.TransactionContextInterface, orderNum string, comm string, sign string, encBlinding string, encPrice string, proofKS string) (*Order, error) {
 	exist, err := ctx.GetStub().GetState(orderNum)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read from world state: %v", err)
@@ -508,6 +1323,15 @@ func (s *SmartContract) SellerSetCommit(ctx contractapi.TransactionContextInterf
 	}
 	order.CommB = comm
 	order.Sign_CommB = sign
+	order.Enc_R_B = encBlinding
+	if len(encPrice) != homoCipherHexLen {
+		return nil, fmt.Errorf("malformed enc_a_m")
+	}
+	if proofKS == "" {
+		return nil, fmt.Errorf("missing key switch proof")
+	}
+	order.Enc_A_M = encPrice
+	order.Proof_KS = proofKS
 	orderJSON, err := json.Marshal(order)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal order:%v", err)
@@ -688,7 +1512,7 @@ func (s *SmartContract) GetSignature(ctx contractapi.TransactionContextInterface
 	return string(results[0]), nil
 }
 
-func (s *SmartContract) SetOrder(ctx contractapi.TransactionContextInterface, OrderNum string, Enc_A_B string, Enc_A_M string, RP_m string, RP_b string, Link_sign_1 string, Link_sign_2 string, Enc_S_Add_B string, Enc_S_Add_A string, ring_string string) (*Order, error) {
+func (s *SmartContract) SetOrder(ctx contractapi.TransactionContextInterface, OrderNum string, Enc_A_B string, Enc_A_M string, RP string, Link_sign_1 string, Link_sign_2 string, Enc_S_Add_B string, Enc_S_Add_A string, ring_string string, Enc_O_M string, Proof_B string) (*Order, error) {
 	exist, err := ctx.GetStub().GetState(OrderNum)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read state: %v", err)
@@ -708,16 +1532,37 @@ func (s *SmartContract) SetOrder(ctx contractapi.TransactionContextInterface, Or
 	if err := json.Unmarshal(exist, &order); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal order:%v", err)
 	}
+	// Enc_A_M已由卖方在SellerSetCommit中密钥切换得到，买方不能替换
+	if Enc_A_M != order.Enc_A_M {
+		return nil, fmt.Errorf("enc_a_m does not match the key-switched price")
+	}
 	order.Enc_A_B = Enc_A_B
-	order.Enc_A_M = Enc_A_M
-	order.RP_m = RP_m
-	order.RP_b = RP_b
+	order.RP = RP
 	order.Link_sign_1 = Link_sign_1
 	order.Link_sign_2 = Link_sign_2
 	order.Enc_S_Add_A = Enc_S_Add_A
 	order.Enc_S_Add_B = Enc_S_Add_B
+	if len(ring_string) != 2*sm3.DigestLength {
+		return nil, fmt.Errorf("malformed ring commitment")
+	}
 	order.Pubs = ring_string
+	if len(Enc_O_M) != homoCipherHexLen {
+		return nil, fmt.Errorf("malformed enc_o_m")
+	}
+	order.Enc_O_M = Enc_O_M
+	if len(Proof_B) != balanceProofHexLen {
+		return nil, fmt.Errorf("malformed proof_b")
+	}
+	if _, err := hex.DecodeString(Proof_B); err != nil {
+		return nil, fmt.Errorf("malformed proof_b")
+	}
+	order.Proof_B = Proof_B
 	order.Flag = false
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	order.Time = ts.GetSeconds()
 	orderJSON, err := json.Marshal(order)
 	if err != nil {
 		return nil, fmt.Errorf("failed to Marshal order")
@@ -760,53 +1605,1174 @@ func (s *SmartContract) GetOrder(ctx contractapi.TransactionContextInterface, Or
 	return &order, nil
 }
 
-// 获取环签名公钥（直接返回五个公钥）
-func (s *SmartContract) GetRingPublicKeys(ctx contractapi.TransactionContextInterface) (string, error) {
-	pubsBytes, err := ctx.GetStub().GetState("ring")
+/*
+AnchorMatch 锚定撮合引擎的一个事件，prev须等于当前最新的锚定（首个事件为引擎的初始哈希），
+锚定后hash成为最新的锚定
+*/
+func (s *SmartContract) AnchorMatch(ctx contractapi.TransactionContextInterface, prev string, hash string, event string, order string, fills_str string) (*MatchAnchor, error) {
+	if len(hash) != 2*sm3.DigestLength || len(prev) != 2*sm3.DigestLength {
+		return nil, fmt.Errorf("malformed match hash")
+	}
+	if event != "submit" && event != "cancel" {
+		return nil, fmt.Errorf("unknown match event %s", event)
+	}
+	fills, err := strconv.ParseInt(fills_str, 10, 64)
+	if err != nil || fills < 0 {
+		return nil, fmt.Errorf("invalid fills %s", fills_str)
+	}
+	head, err := ctx.GetStub().GetState(MatchHead)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
 	}
-
-	var ring Ring
-	if err := json.Unmarshal(pubsBytes, &ring); err != nil {
-		return "", err
+	if head != nil && string(head) != prev {
+		return nil, fmt.Errorf("the match event does not follow %s", string(head))
 	}
-
-	// 确保Pubs数组至少有五个元素，并且跳过第一个元素
-	if len(ring.Pubs) > 5 {
-		// 只需要前五个公钥（不包括第一个）
-		selectedPubs := ring.Pubs[1:6]
-
-		// 将选定的公钥转换为JSON字符串并返回
-		selectedPubsBytes, err := json.Marshal(selectedPubs)
-		if err != nil {
-			return "json marshal public keys error", err
-		}
-		return string(selectedPubsBytes), nil
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	anchor := MatchAnchor{
+		Hash:  hash,
+		Prev:  prev,
+		Event: event,
+		Order: order,
+		Fills: fills,
+		TxID:  ctx.GetStub().GetTxID(),
+		Time:  ts.GetSeconds(),
+	}
+	if err := utils.WriteLedger(anchor, ctx, MatchKey, []string{hash}); err != nil {
+		return nil, err
 	}
-
-	// 如果Pubs数组的元素少于五个（不包括第一个），或者为空，则返回一个错误或空数组
-	if len(ring.Pubs) > 1 {
-		// 返回除了第一个公钥之外的所有公钥
-		remainingPubs := ring.Pubs[1:]
-
-		// 将剩余的公钥转换为JSON字符串并返回
-		remainingPubsBytes, err := json.Marshal(remainingPubs)
-		if err != nil {
-			return "json marshal public keys error", err
-		}
-		return string(remainingPubsBytes), nil
+	if err := ctx.GetStub().PutState(MatchHead, []byte(hash)); err != nil {
+		return nil, fmt.Errorf("failed to put state:%v", err)
 	}
+	return &anchor, nil
+}
 
-	// 如果Pubs数组只有一个元素（不包括第一个），或者为空，则返回空数组
-	return "[]", nil
+// GetMatchAnchor 按哈希查询撮合事件的锚定
+func (s *SmartContract) GetMatchAnchor(ctx contractapi.TransactionContextInterface, hash string) (*MatchAnchor, error) {
+	results, err := utils.GetStateByPartialCompositeKeys(ctx, MatchKey, []string{hash})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("the match anchor %s is not exist", hash)
+	}
+	var anchor MatchAnchor
+	if err := json.Unmarshal(results[0], &anchor); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal match anchor:%v", err)
+	}
+	return &anchor, nil
 }
 
-// // 获取环签名公钥
-// func (s *SmartContract) GetRingPublicKeys(ctx contractapi.TransactionContextInterface) (string, error) {
-// 	pubsBytes, err := ctx.GetStub().GetState("ring")
-// 	if err != nil {
-// 		return "", err
+/*
+SetClearing 写入一个结算时段和分区的集合竞价结果，每个时段和分区只能出清一次，且须在交割开始之前
+检查每个订单的成交量不超过其电量、只有价格不劣于出清价的订单成交，买卖双方的成交量都等于volume；
+集合竞价的订单只在服务端，链码无法核对allocations中的订单，因此只接受市场运营方提交，见 requireOperator
+*/
+func (s *SmartContract) SetClearing(ctx contractapi.TransactionContextInterface, period_str string, zone string, price_str string, volume_str string, allocations string, hash string) (*Clearing, error) {
+	if err := requireOperator(ctx); err != nil {
+		return nil, err
+	}
+	period, err := strconv.ParseInt(period_str, 10, 64)
+	if err != nil || period < 0 {
+		return nil, fmt.Errorf("invalid period %s", period_str)
+	}
+	if !validZone(zone) {
+		return nil, fmt.Errorf("invalid zone %s", zone)
+	}
+	price, err := strconv.ParseInt(price_str, 10, 64)
+	if err != nil || price < 0 {
+		return nil, fmt.Errorf("invalid price %s", price_str)
+	}
+	volume, err := strconv.ParseInt(volume_str, 10, 64)
+	if err != nil || volume < 0 || (volume == 0) != (price == 0) {
+		return nil, fmt.Errorf("invalid volume %s", volume_str)
+	}
+	if len(hash) != 2*sm3.DigestLength {
+		return nil, fmt.Errorf("malformed clearing hash")
+	}
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	if period*SettlementPeriod <= ts.GetSeconds() {
+		return nil, fmt.Errorf("the delivery of period %d has started", period)
+	}
+	// 按规范形式的时段编号作主键，"010"与"10"是同一时段
+	period_str = strconv.FormatInt(period, 10)
+	results, err := utils.GetStateByPartialCompositeKeys2(ctx, ClearingKey, []string{period_str, zone})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	if len(results) != 0 {
+		return nil, fmt.Errorf("the period %d in %s is already cleared", period, zone)
+	}
+	var allocs []ClearingAllocation
+	if err := json.Unmarshal([]byte(allocations), &allocs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal allocations:%v", err)
+	}
+	var bought, sold int64
+	for _, a := range allocs {
+		if a.Filled < 0 || a.Filled > a.Quantity || (a.Side != 0 && a.Side != 1) {
+			return nil, fmt.Errorf("invalid allocation of order %s", a.ID)
+		}
+		if a.Filled == 0 {
+			continue
+		}
+		if a.Side == 0 {
+			if a.Price < price {
+				return nil, fmt.Errorf("the bid %s is filled below its price", a.ID)
+			}
+			bought += a.Filled
+		} else {
+			if a.Price > price {
+				return nil, fmt.Errorf("the ask %s is filled above its price", a.ID)
+			}
+			sold += a.Filled
+		}
+	}
+	if bought != volume || sold != volume {
+		return nil, fmt.Errorf("the allocations do not add up to volume %d", volume)
+	}
+	clearing := Clearing{
+		Period:      period,
+		Zone:        zone,
+		Price:       price,
+		Volume:      volume,
+		Allocations: allocations,
+		Hash:        hash,
+		TxID:        ctx.GetStub().GetTxID(),
+		Time:        ts.GetSeconds(),
+	}
+	if err := utils.WriteLedger(clearing, ctx, ClearingKey, []string{period_str, zone}); err != nil {
+		return nil, err
+	}
+	return &clearing, nil
+}
+
+// GetClearing 查询一个结算时段和分区的集合竞价结果
+func (s *SmartContract) GetClearing(ctx contractapi.TransactionContextInterface, period_str string, zone string) (*Clearing, error) {
+	period, err := strconv.ParseInt(period_str, 10, 64)
+	if err != nil || period < 0 {
+		return nil, fmt.Errorf("invalid period %s", period_str)
+	}
+	// 与SetClearing相同，按规范的十进制构造主键，"007"、"+7"与"7"是同一时段
+	period_str = strconv.FormatInt(period, 10)
+	results, err := utils.GetStateByPartialCompositeKeys2(ctx, ClearingKey, []string{period_str, zone})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("the period %s in %s is not cleared", period_str, zone)
+	}
+	var clearing Clearing
+	if err := json.Unmarshal(results[0], &clearing); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal clearing:%v", err)
+	}
+	return &clearing, nil
+}
+
+/*
+CreateAuction 为商品创建密封拍卖，商品须在售且有电表，拍卖期间锁定
+key为拍卖方公钥JSON的base64（可以是为本次拍卖联合生成的门限公钥），截止时间须在交易时间之后、交割开始之前
+verifier为验证拍卖结果的地址，须已登记公钥，在出价前确定
+*/
+func (s *SmartContract) CreateAuction(ctx contractapi.TransactionContextInterface, id string, good_id string, key string, reserve_str string, deadline_str string, verifier string) (*Auction, error) {
+	if id == "" {
+		return nil, fmt.Errorf("the args id is null")
+	}
+	if _, err := s.GetAuction(ctx, id); err == nil {
+		return nil, fmt.Errorf("the auction %s already exists", id)
+	}
+	reserve, err := strconv.ParseInt(reserve_str, 10, 64)
+	if err != nil || reserve < 0 || reserve >= 1<<AuctionBidBits {
+		return nil, fmt.Errorf("invalid reserve %s", reserve_str)
+	}
+	deadline, err := strconv.ParseInt(deadline_str, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse deadline:%v", err)
+	}
+	pub_bytes, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode auctioneer key: %v", err)
+	}
+	var pub sm2.PublicKey
+	if err := json.Unmarshal(pub_bytes, &pub); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal auctioneer key: %v", err)
+	}
+	if pub.X == nil || pub.Y == nil || !sm2.GetSm2P256V1().IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("the auctioneer key is not on the sm2 curve")
+	}
+	good, err := s.GetGoods(ctx, good_id)
+	if err != nil {
+		return nil, err
+	}
+	if good.Status != 1 {
+		return nil, fmt.Errorf("the good %s is not on sale", good_id)
+	}
+	if good.Meter == "" {
+		return nil, fmt.Errorf("the good %s is not backed by a meter", good_id)
+	}
+	meter, err := s.GetMeter(ctx, good.Meter)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.GetKey(ctx, verifier); err != nil {
+		return nil, err
+	}
+	if verifier == meter.Owner {
+		return nil, fmt.Errorf("the seller cannot verify its own auction")
+	}
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	if deadline <= ts.GetSeconds() || deadline > good.DeliveryStart {
+		return nil, fmt.Errorf("the deadline must be after %d and not after the delivery start %d", ts.GetSeconds(), good.DeliveryStart)
+	}
+	auction := Auction{
+		ID:       id,
+		GoodId:   good_id,
+		Seller:   meter.Owner,
+		Key:      key,
+		Reserve:  reserve,
+		Deadline: deadline,
+		Verifier: verifier,
+		Status:   "open",
+		TxID:     ctx.GetStub().GetTxID(),
+		Time:     ts.GetSeconds(),
+	}
+	if _, err := s.UpdateGoodStatus(ctx, good_id); err != nil {
+		return nil, fmt.Errorf("update good status:%v", err)
+	}
+	if err := utils.WriteLedger(auction, ctx, AuctionKey, []string{id}); err != nil {
+		return nil, err
+	}
+	return &auction, nil
+}
+
+// GetAuction 按ID查询拍卖
+func (s *SmartContract) GetAuction(ctx contractapi.TransactionContextInterface, id string) (*Auction, error) {
+	results, err := utils.GetStateByPartialCompositeKeys(ctx, AuctionKey, []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("the auction %s is not exist", id)
+	}
+	var auction Auction
+	if err := json.Unmarshal(results[0], &auction); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal auction:%v", err)
+	}
+	return &auction, nil
+}
+
+/*
+SubmitBid 在截止时间前提交密封出价，bidder为出价者的钱包地址，须已登记公钥，每人只能出价一次，卖方不能出价
+证明由服务端提交前验证，任何人都可以用GetBids取回出价重新验证
+*/
+func (s *SmartContract) SubmitBid(ctx contractapi.TransactionContextInterface, auction_id string, bidder string, cipher string, comm string, range_proof string, link string) (*AuctionBid, error) {
+	auction, err := s.GetAuction(ctx, auction_id)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	if auction.Status != "open" || ts.GetSeconds() >= auction.Deadline {
+		return nil, fmt.Errorf("the auction %s is closed for bids", auction_id)
+	}
+	if _, err := s.GetKey(ctx, bidder); err != nil {
+		return nil, err
+	}
+	if bidder == auction.Seller {
+		return nil, fmt.Errorf("the seller cannot bid")
+	}
+	if len(cipher) != homoCipherHexLen {
+		return nil, fmt.Errorf("malformed bid ciphertext")
+	}
+	for _, v := range []string{cipher, comm, range_proof, link} {
+		if _, err := hex.DecodeString(v); err != nil || v == "" {
+			return nil, fmt.Errorf("malformed bid")
+		}
+	}
+	bids, err := s.GetBids(ctx, auction_id)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bids {
+		if b.Bidder == bidder {
+			return nil, fmt.Errorf("%s has already bid in auction %s", bidder, auction_id)
+		}
+	}
+	bid := AuctionBid{
+		Auction: auction_id,
+		Seq:     auction.Bids,
+		Bidder:  bidder,
+		Cipher:  cipher,
+		Comm:    comm,
+		Range:   range_proof,
+		Link:    link,
+		TxID:    ctx.GetStub().GetTxID(),
+		Time:    ts.GetSeconds(),
+	}
+	auction.Bids++
+	if err := utils.WriteLedger(bid, ctx, BidKey, []string{auction_id, fmt.Sprintf("%020d", bid.Seq)}); err != nil {
+		return nil, err
+	}
+	if err := utils.WriteLedger(auction, ctx, AuctionKey, []string{auction_id}); err != nil {
+		return nil, err
+	}
+	return &bid, nil
+}
+
+// GetBids 按出价顺序查询拍卖的全部密封出价
+func (s *SmartContract) GetBids(ctx contractapi.TransactionContextInterface, auction_id string) ([]*AuctionBid, error) {
+	results, err := utils.GetStateByPartialCompositeKeys2(ctx, BidKey, []string{auction_id})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	bids := []*AuctionBid{}
+	for _, v := range results {
+		var bid AuctionBid
+		if err := json.Unmarshal(v, &bid); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal bid:%v", err)
+		}
+		bids = append(bids, &bid)
+	}
+	return bids, nil
+}
+
+/*
+CloseAuction 截止后拍卖方公布拍卖结果，winner为中标出价的序号，-1表示没有出价达到保留价
+outcome为结果的证明（JSON），sign为拍卖方对 id|winner|price|SM3(outcome) 的SM2签名(hex)，
+用拍卖的Key和由Key计算的UID验证，winner为中标者的地址。链码只检查签名、截止时间、序号范围和保留价，不验证outcome，
+即winner、price是否是密封出价的正确结果完全由拍卖方给出；因此这里只记录结果，
+付款（SettleAuction）和商品恢复在售都要等Verifier重新验证并用AttestAuction签名之后
+*/
+func (s *SmartContract) CloseAuction(ctx contractapi.TransactionContextInterface, auction_id string, winner_str string, price_str string, outcome string, sign string) (*Auction, error) {
+	auction, err := s.GetAuction(ctx, auction_id)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	if auction.Status != "open" {
+		return nil, fmt.Errorf("the auction %s is already closed", auction_id)
+	}
+	if ts.GetSeconds() < auction.Deadline {
+		return nil, fmt.Errorf("the auction %s is open until %d", auction_id, auction.Deadline)
+	}
+	winner, err := strconv.ParseInt(winner_str, 10, 64)
+	if err != nil || winner < -1 || winner >= auction.Bids {
+		return nil, fmt.Errorf("invalid winner %s", winner_str)
+	}
+	price, err := strconv.ParseInt(price_str, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse price:%v", err)
+	}
+	if winner >= 0 {
+		if price < auction.Reserve || price < 1 || price >= 1<<AuctionBidBits {
+			return nil, fmt.Errorf("invalid price %s", price_str)
+		}
+		bids, err := s.GetBids(ctx, auction_id)
+		if err != nil {
+			return nil, err
+		}
+		auction.Winner = bids[winner].Bidder
+	} else if price != 0 {
+		return nil, fmt.Errorf("invalid price %s", price_str)
+	}
+	auction.Price = price
+	auction.Outcome = outcome
+	uid, err := keyUID(auction.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySM2(auction.Key, uid, auctionAttestation(auction), sign); err != nil {
+		return nil, fmt.Errorf("the outcome is not signed by the auctioneer: %v", err)
+	}
+	auction.Status = "closed"
+	if err := utils.WriteLedger(auction, ctx, AuctionKey, []string{auction_id}); err != nil {
+		return nil, err
+	}
+	return auction, nil
+}
+
+// auctionAttestation 返回拍卖方和Verifier签名的消息 id|winner|price|SM3(outcome)
+func auctionAttestation(auction *Auction) []byte {
+	h := sm3.New()
+	h.Write([]byte(auction.Outcome))
+	return []byte(fmt.Sprintf("%s|%s|%d|%s", auction.ID, auction.Winner, auction.Price, hex.EncodeToString(h.Sum(nil))))
+}
+
+/*
+AttestAuction Verifier从链上取回出价重新验证CloseAuction公布的结果后，对结果签名
+sign为Verifier对 id|winner|price|SM3(outcome) 的SM2签名(hex)，用其登记的公钥和UID验证；
+签名后没有中标者的商品恢复在售，有中标者的拍卖才能付款
+*/
+func (s *SmartContract) AttestAuction(ctx contractapi.TransactionContextInterface, auction_id string, sign string) (*Auction, error) {
+	auction, err := s.GetAuction(ctx, auction_id)
+	if err != nil {
+		return nil, err
+	}
+	if auction.Status != "closed" {
+		return nil, fmt.Errorf("the auction %s is not awaiting attestation", auction_id)
+	}
+	record, err := s.GetKey(ctx, auction.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySM2(record.Pub, record.UID, auctionAttestation(auction), sign); err != nil {
+		return nil, fmt.Errorf("the outcome is not attested by %s: %v", auction.Verifier, err)
+	}
+	if auction.Winner == "" {
+		good, err := s.GetGoods(ctx, auction.GoodId)
+		if err != nil {
+			return nil, err
+		}
+		good.Status = 1
+		goodJSON, err := json.Marshal(good)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal good:%v", err)
+		}
+		if err := ctx.GetStub().PutState(good.ID, goodJSON); err != nil {
+			return nil, fmt.Errorf("failed to put state:%v", err)
+		}
+	}
+	auction.Attestation = sign
+	auction.Status = "attested"
+	if err := utils.WriteLedger(auction, ctx, AuctionKey, []string{auction_id}); err != nil {
+		return nil, err
+	}
+	return auction, nil
+}
+
+/*
+RejectAuction Verifier重新验证CloseAuction公布的结果不通过时驳回结果，拍卖回到open，
+截止时间已过，不再接受出价，由拍卖方重新公布结果；商品保持锁定
+sign为Verifier对 id|winner|price|SM3(outcome)|reject 的SM2签名(hex)，用其登记的公钥和UID验证
+*/
+func (s *SmartContract) RejectAuction(ctx contractapi.TransactionContextInterface, auction_id string, sign string) (*Auction, error) {
+	auction, err := s.GetAuction(ctx, auction_id)
+	if err != nil {
+		return nil, err
+	}
+	if auction.Status != "closed" {
+		return nil, fmt.Errorf("the auction %s is not awaiting attestation", auction_id)
+	}
+	record, err := s.GetKey(ctx, auction.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	msg := append(auctionAttestation(auction), []byte("|reject")...)
+	if err := verifySM2(record.Pub, record.UID, msg, sign); err != nil {
+		return nil, fmt.Errorf("the outcome is not rejected by %s: %v", auction.Verifier, err)
+	}
+	auction.Winner = ""
+	auction.Price = 0
+	auction.Outcome = ""
+	auction.Status = "open"
+	if err := utils.WriteLedger(auction, ctx, AuctionKey, []string{auction_id}); err != nil {
+		return nil, err
+	}
+	return auction, nil
+}
+
+/*
+SettleAuction 记录中标付款的订单，订单须为该商品上中标者与卖方的订单，每个拍卖只能付款一次
+结果须已由Verifier签名，见 AttestAuction
+*/
+func (s *SmartContract) SettleAuction(ctx contractapi.TransactionContextInterface, auction_id string, orderNum string) (*Auction, error) {
+	auction, err := s.GetAuction(ctx, auction_id)
+	if err != nil {
+		return nil, err
+	}
+	if auction.Status != "attested" || auction.Winner == "" {
+		return nil, fmt.Errorf("the auction %s is not awaiting settlement", auction_id)
+	}
+	order, err := s.GetOrder(ctx, orderNum)
+	if err != nil {
+		return nil, err
+	}
+	if order.GoodId != auction.GoodId || order.Buyer != auction.Winner || order.Seller != auction.Seller {
+		return nil, fmt.Errorf("the order %s does not settle auction %s", orderNum, auction_id)
+	}
+	auction.OrderNum = orderNum
+	auction.Status = "settled"
+	if err := utils.WriteLedger(auction, ctx, AuctionKey, []string{auction_id}); err != nil {
+		return nil, err
+	}
+	return auction, nil
+}
+
+/*
+SetImbalanceTariff 设置分时时段band的偏差电价，band为空时设置未单独定价时段的电价；
+可以重新设置，只影响此后结算的订单
+*/
+func (s *SmartContract) SetImbalanceTariff(ctx contractapi.TransactionContextInterface, band string, shortfall_str string, excess_str string) (*ImbalanceTariff, error) {
+	if band != "" && band != BandPeak && band != BandFlat && band != BandValley {
+		return nil, fmt.Errorf("invalid band %q", band)
+	}
+	tariff := ImbalanceTariff{Band: band, TxID: ctx.GetStub().GetTxID()}
+	for _, v := range []struct {
+		str string
+		to  *int64
+	}{{shortfall_str, &tariff.Shortfall}, {excess_str, &tariff.Excess}} {
+		n, err := strconv.ParseInt(v.str, 10, 64)
+		if err != nil || n < 0 || n >= 1<<ImbalanceBits {
+			return nil, fmt.Errorf("invalid tariff %s", v.str)
+		}
+		*v.to = n
+	}
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	tariff.Time = ts.GetSeconds()
+	if err := utils.WriteLedger(tariff, ctx, TariffKey, []string{band}); err != nil {
+		return nil, err
+	}
+	return &tariff, nil
+}
+
+// GetImbalanceTariff 查询分时时段band的偏差电价，未单独定价时返回band为空的电价
+func (s *SmartContract) GetImbalanceTariff(ctx contractapi.TransactionContextInterface, band string) (*ImbalanceTariff, error) {
+	results, err := utils.GetStateByPartialCompositeKeys(ctx, TariffKey, []string{band})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	if len(results) == 0 && band != "" {
+		return s.GetImbalanceTariff(ctx, "")
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("no imbalance tariff is set")
+	}
+	var tariff ImbalanceTariff
+	if err := json.Unmarshal(results[0], &tariff); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tariff:%v", err)
+	}
+	return &tariff, nil
+}
+
+/*
+ComputeImbalance 计算已完成订单的偏差结算，不写入账本
+合同电量为订单商品的Amount，交割电量见 deliveredEnergy；电表的读数须已覆盖整个交割时段。
+少发时卖方按Shortfall电价向买方付款，多发时买方按Excess电价向卖方付款
+*/
+func (s *SmartContract) ComputeImbalance(ctx contractapi.TransactionContextInterface, orderNum string) (*Imbalance, error) {
+	order, err := s.GetOrder(ctx, orderNum)
+	if err != nil {
+		return nil, err
+	}
+	if order.OrderNum != orderNum || !order.Flag {
+		return nil, fmt.Errorf("the order %s is not completed", orderNum)
+	}
+	good, err := s.GetGoods(ctx, order.GoodId)
+	if err != nil {
+		return nil, err
+	}
+	if good.Meter == "" || good.DeliveryEnd <= good.DeliveryStart {
+		return nil, fmt.Errorf("the good %s is not delivered from a meter", good.ID)
+	}
+	meter, err := s.GetMeter(ctx, good.Meter)
+	if err != nil {
+		return nil, err
+	}
+	if meter.End < good.DeliveryEnd {
+		return nil, fmt.Errorf("the readings of meter %s end at %d, before the delivery ends at %d", meter.ID, meter.End, good.DeliveryEnd)
+	}
+	readings, err := s.GetReadings(ctx, meter.ID)
+	if err != nil {
+		return nil, err
+	}
+	lots, err := s.meterLots(ctx, good)
+	if err != nil {
+		return nil, err
+	}
+	delivered, err := deliveredEnergy(good, lots, readings)
+	if err != nil {
+		return nil, err
+	}
+	tariff, err := s.GetImbalanceTariff(ctx, good.Band)
+	if err != nil {
+		return nil, err
+	}
+	record := Imbalance{
+		OrderNum:   orderNum,
+		Meter:      meter.ID,
+		Contracted: good.Amount,
+		Delivered:  delivered,
+		Band:       good.Band,
+	}
+	kwh := delivered - good.Amount
+	if kwh < 0 {
+		record.Shortfall, record.Rate = -kwh, tariff.Shortfall
+		record.Payer, record.Payee = order.Seller, order.Buyer
+		kwh = -kwh
+	} else {
+		record.Excess, record.Rate = kwh, tariff.Excess
+		record.Payer, record.Payee = order.Buyer, order.Seller
+	}
+	hi, lo := bits.Mul64(uint64(kwh), uint64(record.Rate))
+	if hi != 0 || lo >= 1<<ImbalanceBits {
+		return nil, fmt.Errorf("the imbalance of %d kWh at %d exceeds the largest amount", kwh, record.Rate)
+	}
+	record.Amount = int64(lo)
+	return &record, nil
+}
+
+/*
+SettleImbalance 按 ComputeImbalance 的结果调整双方钱包，每个订单只能结算一次
+金额为0时只记录结算结果；否则payer_cipher、payee_cipher须为金额在双方登记公钥下、随机数为payer_k、payee_k的同态密文，
+付款方余额用CiperSub减去payer_cipher后须等于payer_balance，收款方余额用CiperAdd加上payee_cipher；
+comm、range_proof、proof为payer_balance不为负的证明，均为hex。链码只按 checkNonNegative 检查其长度和结构，
+不验证证明本身，扣款后的余额不为负由服务端提交前的验证保证，因此只接受市场运营方提交，见 requireOperator；
+任何人都可以用服务端 VerifyImbalance 从链上记录重新验证
+*/
+func (s *SmartContract) SettleImbalance(ctx contractapi.TransactionContextInterface, orderNum string, payer_cipher string, payer_k string, payee_cipher string, payee_k string, payer_balance string, comm string, range_proof string, proof string) (*Imbalance, error) {
+	if err := requireOperator(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := s.GetImbalance(ctx, orderNum); err == nil {
+		return nil, fmt.Errorf("the imbalance of order %s is already settled", orderNum)
+	}
+	record, err := s.ComputeImbalance(ctx, orderNum)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	record.TxID = ctx.GetStub().GetTxID()
+	record.Time = ts.GetSeconds()
+	if record.Amount > 0 {
+		curve := sm2.GetSm2P256V1()
+		payer_bytes, err := s.postingCipher(ctx, record.Payer, record.Amount, payer_cipher, payer_k)
+		if err != nil {
+			return nil, err
+		}
+		payee_bytes, err := s.postingCipher(ctx, record.Payee, record.Amount, payee_cipher, payee_k)
+		if err != nil {
+			return nil, err
+		}
+		payer_wallet, err := s.GetWallet(ctx, record.Payer)
+		if err != nil {
+			return nil, err
+		}
+		payer_old, err := hex.DecodeString(payer_wallet.Balance)
+		if err != nil || len(payer_wallet.Balance) != homoCipherHexLen {
+			return nil, fmt.Errorf("malformed balance of wallet %s", record.Payer)
+		}
+		payer_new, err := utils.CiperSub(curve, payer_old, payer_bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to debit wallet %s:%v", record.Payer, err)
+		}
+		if hex.EncodeToString(payer_new) != payer_balance {
+			return nil, fmt.Errorf("the payer balance does not match the debit")
+		}
+		if err := checkNonNegative(comm, range_proof, proof); err != nil {
+			return nil, fmt.Errorf("malformed non-negativity proof: %v", err)
+		}
+		if _, err := s.UpdateWallet(ctx, record.Payer, payer_balance); err != nil {
+			return nil, err
+		}
+		// 同一交易内读不到本交易的写入，付款方与收款方为同一钱包时在扣款后的余额上入账
+		payee_old := payer_new
+		if record.Payee != record.Payer {
+			payee_wallet, err := s.GetWallet(ctx, record.Payee)
+			if err != nil {
+				return nil, err
+			}
+			payee_old, err = hex.DecodeString(payee_wallet.Balance)
+			if err != nil || len(payee_wallet.Balance) != homoCipherHexLen {
+				return nil, fmt.Errorf("malformed balance of wallet %s", record.Payee)
+			}
+		}
+		payee_new, err := utils.CiperAdd(curve, payee_old, payee_bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to credit wallet %s:%v", record.Payee, err)
+		}
+		if _, err := s.UpdateWallet(ctx, record.Payee, hex.EncodeToString(payee_new)); err != nil {
+			return nil, err
+		}
+		record.PayerCipher, record.PayerK = payer_cipher, payer_k
+		record.PayeeCipher, record.PayeeK = payee_cipher, payee_k
+		record.PayerBalance, record.PayeeBalance = payer_balance, hex.EncodeToString(payee_new)
+		record.Comm, record.Range, record.Proof = comm, range_proof, proof
+	}
+	if err := utils.WriteLedger(record, ctx, ImbalanceKey, []string{orderNum}); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// GetImbalance 查询订单的偏差结算
+func (s *SmartContract) GetImbalance(ctx contractapi.TransactionContextInterface, orderNum string) (*Imbalance, error) {
+	results, err := utils.GetStateByPartialCompositeKeys(ctx, ImbalanceKey, []string{orderNum})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("the imbalance of order %s is not settled", orderNum)
+	}
+	var record Imbalance
+	if err := json.Unmarshal(results[0], &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal imbalance:%v", err)
+	}
+	return &record, nil
+}
+
+// meterLots 与商品good同一电表、交割时段重叠且已完成交易的商品，包括good本身
+func (s *SmartContract) meterLots(ctx contractapi.TransactionContextInterface, good *Goods) ([]*Goods, error) {
+	// 交割时段不超过MaxDelivery，重叠的时段起点在(DeliveryStart-MaxDelivery, DeliveryEnd)内
+	orders, err := s.GetOrdersByDelivery(ctx, strconv.FormatInt(good.DeliveryStart-MaxDelivery+1, 10), strconv.FormatInt(good.DeliveryEnd, 10), "", "")
+	if err != nil {
+		return nil, err
+	}
+	lots := []*Goods{}
+	seen := map[string]bool{}
+	for _, o := range orders {
+		if !o.Flag || o.DeliveryEnd <= good.DeliveryStart || seen[o.GoodId] {
+			continue
+		}
+		lot, err := s.GetGoods(ctx, o.GoodId)
+		if err != nil {
+			return nil, err
+		}
+		if lot.Meter != good.Meter {
+			continue
+		}
+		seen[o.GoodId] = true
+		lots = append(lots, lot)
+	}
+	if !seen[good.ID] {
+		lots = append(lots, good)
+	}
+	return lots, nil
+}
+
+/*
+deliveredEnergy 商品good在交割时段内的交割电量，逐个结算时段计算后相加：
+结算时段的计量电量为各读数按与该时段重叠的时长折算之和（各读数向下取整）；
+各商品的合同电量平均分到其交割时段的各结算时段，余数分给前面的时段；
+同一电表在该时段有多个已成交的商品时，计量电量按各商品在该时段的合同电量比例分摊（向下取整）
+*/
+func deliveredEnergy(good *Goods, lots []*Goods, readings []*MeterReading) (int64, error) {
+	var delivered int64
+	for t := good.DeliveryStart; t < good.DeliveryEnd; t += SettlementPeriod {
+		var metered int64
+		for _, r := range readings {
+			lo, hi := r.Start, r.End
+			if lo < t {
+				lo = t
+			}
+			if hi > t+SettlementPeriod {
+				hi = t + SettlementPeriod
+			}
+			if hi > lo {
+				metered += mulDiv(r.Kwh, hi-lo, r.End-r.Start)
+			}
+			if metered < 0 {
+				return 0, fmt.Errorf("the metered energy of meter %s overflows", good.Meter)
+			}
+		}
+		var total int64
+		for _, lot := range lots {
+			total += slotShare(lot, t)
+		}
+		if share := slotShare(good, t); share > 0 {
+			delivered += mulDiv(metered, share, total)
+		}
+	}
+	return delivered, nil
+}
+
+// slotShare 商品lot的合同电量分到结算时段t的部分，t不在交割时段内时为0
+func slotShare(lot *Goods, t int64) int64 {
+	if t < lot.DeliveryStart || t >= lot.DeliveryEnd {
+		return 0
+	}
+	n := (lot.DeliveryEnd - lot.DeliveryStart) / SettlementPeriod
+	share := lot.Amount / n
+	if (t-lot.DeliveryStart)/SettlementPeriod < lot.Amount%n {
+		share++
+	}
+	return share
+}
+
+// mulDiv 计算a*b/c并向下取整，a、b非负，b不大于c，结果不超过a
+func mulDiv(a int64, b int64, c int64) int64 {
+	hi, lo := bits.Mul64(uint64(a), uint64(b))
+	q, _ := bits.Div64(hi, lo, uint64(c))
+	return int64(q)
+}
+
+// checkPoint 检查b为曲线上非无穷远点的33字节压缩编码
+func checkPoint(b []byte) error {
+	params := sm2.GetSm2P256V1().Params()
+	if len(b) != 33 || (b[0] != 0x02 && b[0] != 0x03) {
+		return fmt.Errorf("malformed point")
+	}
+	x := new(big.Int).SetBytes(b[1:])
+	if x.Cmp(params.P) >= 0 {
+		return fmt.Errorf("malformed point")
+	}
+	// y^2 = x^3 - 3x + b
+	rhs := new(big.Int).Exp(x, big.NewInt(3), params.P)
+	rhs.Sub(rhs, new(big.Int).Mul(x, big.NewInt(3)))
+	rhs.Add(rhs, params.B)
+	rhs.Mod(rhs, params.P)
+	if new(big.Int).ModSqrt(rhs, params.P) == nil {
+		return fmt.Errorf("the point is not on the sm2 curve")
+	}
+	return nil
+}
+
+// checkScalar 检查b为小于N的32字节标量
+func checkScalar(b []byte) error {
+	if len(b) != 32 || new(big.Int).SetBytes(b).Cmp(sm2.GetSm2P256V1().Params().N) >= 0 {
+		return fmt.Errorf("malformed scalar")
+	}
+	return nil
+}
+
+/*
+checkNonNegative 按服务端的编码检查付款方余额不为负的证明的长度和结构：点都在曲线上、标量都小于N，
+范围证明为SM2曲线上BalanceRangeBits位的证明且承诺为comm；证明本身由服务端 VerifyImbalance 验证
+*/
+func checkNonNegative(comm_hex string, range_hex string, proof_hex string) error {
+	comm, err := hex.DecodeString(comm_hex)
+	if err != nil || len(comm_hex) != pointHexLen {
+		return fmt.Errorf("malformed comm")
+	}
+	if err := checkPoint(comm); err != nil {
+		return fmt.Errorf("malformed comm: %v", err)
+	}
+	rp, err := hex.DecodeString(range_hex)
+	if err != nil || len(range_hex) != balanceRangeHexLen {
+		return fmt.Errorf("malformed range proof")
+	}
+	// 版本1、范围证明、SM2曲线，位数
+	if rp[0] != 1 || rp[1] != 1 || rp[2] != 2 || int(rp[3])<<8|int(rp[4]) != BalanceRangeBits {
+		return fmt.Errorf("malformed range proof header")
+	}
+	if !bytes.Equal(rp[5:5+33], comm) {
+		return fmt.Errorf("the range proof is not over comm")
+	}
+	rest := rp[5:]
+	for _, field := range []struct {
+		n     int
+		point bool
+	}{{5, true}, {3, false}, {2 * 6, true}, {2, false}} {
+		for i := 0; i < field.n; i++ {
+			if field.point {
+				err, rest = checkPoint(rest[:33]), rest[33:]
+			} else {
+				err, rest = checkScalar(rest[:32]), rest[32:]
+			}
+			if err != nil {
+				return fmt.Errorf("malformed range proof: %v", err)
+			}
+		}
+	}
+	proof, err := hex.DecodeString(proof_hex)
+	if err != nil || len(proof_hex) != balanceProofHexLen {
+		return fmt.Errorf("malformed balance proof")
+	}
+	// 版本1、余额证明、SM2曲线
+	if proof[0] != 1 || proof[1] != 4 || proof[2] != 2 {
+		return fmt.Errorf("malformed balance proof header")
+	}
+	proof = proof[3:]
+	for i := 0; i < 3; i++ {
+		if err := checkPoint(proof[33*i : 33*(i+1)]); err != nil {
+			return fmt.Errorf("malformed balance proof: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := checkScalar(proof[3*33+32*i : 3*33+32*(i+1)]); err != nil {
+			return fmt.Errorf("malformed balance proof: %v", err)
+		}
+	}
+	return nil
+}
+
+// postingCipher 检查cipher_hex为金额amount在地址address登记的公钥P下、随机数为k_hex的同态密文(k*G, amount*G+k*P)
+func (s *SmartContract) postingCipher(ctx contractapi.TransactionContextInterface, address string, amount int64, cipher_hex string, k_hex string) ([]byte, error) {
+	pub, err := s.registeredPub(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	curve := sm2.GetSm2P256V1()
+	k, err := hex.DecodeString(k_hex)
+	if err != nil || len(k) != 32 {
+		return nil, fmt.Errorf("malformed posting randomness")
+	}
+	if n := new(big.Int).SetBytes(k); n.Sign() == 0 || n.Cmp(curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("malformed posting randomness")
+	}
+	c1x, c1y := curve.ScalarBaseMult(k)
+	kpx, kpy := curve.ScalarMult(pub.X, pub.Y, k)
+	mx, my := curve.ScalarBaseMult(big.NewInt(amount).Bytes())
+	c2x, c2y := curve.Add(mx, my, kpx, kpy)
+	want := append(elliptic.Marshal(curve, c1x, c1y), elliptic.Marshal(curve, c2x, c2y)...)
+	cipher, err := hex.DecodeString(cipher_hex)
+	if err != nil || !bytes.Equal(cipher, want) {
+		return nil, fmt.Errorf("the posting to %s does not encrypt %d", address, amount)
+	}
+	return cipher, nil
+}
+
+/*
+SetAuditRecord 写入一条审计记录，复合主键为(orderNum, txId)，同一订单可被多次审计
+*/
+func (s *SmartContract) SetAuditRecord(ctx contractapi.TransactionContextInterface, orderNum string, auditor string, reason string, digest string) (*AuditRecord, error) {
+	if auditor == "" || reason == "" {
+		return nil, fmt.Errorf("auditor and reason are required")
+	}
+	exist, err := ctx.GetStub().GetState(orderNum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %v", err)
+	}
+	if exist == nil {
+		return nil, fmt.Errorf("the order %s is not exist", orderNum)
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client msp id: %v", err)
+	}
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tx timestamp: %v", err)
+	}
+	txID := ctx.GetStub().GetTxID()
+	record := AuditRecord{
+		OrderNum: orderNum,
+		Auditor:  auditor,
+		MSPID:    mspID,
+		Reason:   reason,
+		Digest:   digest,
+		TxID:     txID,
+		Time:     ts.GetSeconds(),
+	}
+	if err := utils.WriteLedger(record, ctx, AuditKey, []string{orderNum, txID}); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// GetAuditRecords 查询订单的全部审计记录
+func (s *SmartContract) GetAuditRecords(ctx contractapi.TransactionContextInterface, orderNum string) ([]*AuditRecord, error) {
+	results, err := utils.GetStateByPartialCompositeKeys2(ctx, AuditKey, []string{orderNum})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	records := []*AuditRecord{}
+	for _, v := range results {
+		var record AuditRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit record:%v", err)
+		}
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+/*
+AggregateVolume 对[from, to)内已完成订单的Enc_O_M做同态求和并写入账本
+只统计全市场，周期须恰好是一个AggregatePeriod时段，同一周期只统计一次
+*/
+func (s *SmartContract) AggregateVolume(ctx contractapi.TransactionContextInterface, from_str string, to_str string) (*Aggregate, error) {
+	from, err := strconv.ParseInt(from_str, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse from:%v", err)
+	}
+	to, err := strconv.ParseInt(to_str, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse to:%v", err)
+	}
+	if from%AggregatePeriod != 0 || to != from+AggregatePeriod {
+		return nil, fmt.Errorf("the period must be one aligned slot of %d seconds", AggregatePeriod)
+	}
+	id := fmt.Sprintf("market-%d-%d", from, to)
+	exist, err := utils.GetStateByPartialCompositeKeys2(ctx, AggregateKey, []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	if len(exist) != 0 {
+		return nil, fmt.Errorf("the aggregate %s already exists", id)
+	}
+
+	selector := map[string]interface{}{
+		"flag": true,
+		"time": map[string]int64{"$gte": from, "$lt": to},
+	}
+	queryString, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query:%v", err)
+	}
+	result, err := ctx.GetStub().GetQueryResult(string(queryString)) //必须是CouchDB才行
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	defer result.Close()
+
+	aggregate := Aggregate{ID: id, From: from, To: to}
+	// AddCiperText只用到公钥的曲线
+	curve := &sm2.PublicKey{Curve: sm2.GetSm2P256V1()}
+	for result.HasNext() {
+		queryResult, err := result.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over query results: %v", err)
+		}
+		var order Order
+		if err := json.Unmarshal(queryResult.Value, &order); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal order: %v", err)
+		}
+		if order.Enc_O_M == "" {
+			continue
+		}
+		if aggregate.Count == 0 {
+			aggregate.Sum = order.Enc_O_M
+		} else {
+			aggregate.Sum, err = utils.AddCiperText(aggregate.Sum, order.Enc_O_M, curve)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add order %s: %v", order.OrderNum, err)
+			}
+		}
+		aggregate.Count++
+	}
+	if aggregate.Count < MinAggregateCount {
+		return nil, fmt.Errorf("the period has %d orders, at least %d are required", aggregate.Count, MinAggregateCount)
+	}
+	if err := utils.WriteLedger(aggregate, ctx, AggregateKey, []string{id}); err != nil {
+		return nil, err
+	}
+	return &aggregate, nil
+}
+
+/*
+PublishAggregate 公布运营方解密后的统计总额，每个统计只能公布一次
+proof为Sum在operator登记的公钥下解密为total的Chaum-Pedersen证明，上下文为统计的ID；
+Enc_O_M在其它公钥下加密时，无法对小于2^63的total给出证明
+*/
+func (s *SmartContract) PublishAggregate(ctx contractapi.TransactionContextInterface, id string, total_str string, operator string, proof_hex string) (*Aggregate, error) {
+	total, err := strconv.ParseInt(total_str, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse total:%v", err)
+	}
+	if total < 0 {
+		return nil, fmt.Errorf("the total must not be negative")
+	}
+	proof, err := hex.DecodeString(proof_hex)
+	if err != nil || len(proof) != utils.DecryptionProofLen {
+		return nil, fmt.Errorf("malformed decryption proof")
+	}
+	pub, err := s.registeredPub(ctx, operator)
+	if err != nil {
+		return nil, err
+	}
+	results, err := utils.GetStateByPartialCompositeKeys2(ctx, AggregateKey, []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("the aggregate %s is not exist", id)
+	}
+	var aggregate Aggregate
+	if err := json.Unmarshal(results[0], &aggregate); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal aggregate:%v", err)
+	}
+	if aggregate.Published {
+		return nil, fmt.Errorf("the aggregate %s is already published", id)
+	}
+	sum, err := hex.DecodeString(aggregate.Sum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sum:%v", err)
+	}
+	if err := utils.VerifyDecryption(pub, sum, total, []byte(id), proof); err != nil {
+		return nil, fmt.Errorf("the aggregate %s does not decrypt to %d: %v", id, total, err)
+	}
+	aggregate.Total = total
+	aggregate.Operator = operator
+	aggregate.Proof = proof_hex
+	aggregate.Published = true
+	if err := utils.WriteLedger(aggregate, ctx, AggregateKey, []string{id}); err != nil {
+		return nil, err
+	}
+	return &aggregate, nil
+}
+
+// GetAggregates 查询全部统计
+func (s *SmartContract) GetAggregates(ctx contractapi.TransactionContextInterface) ([]*Aggregate, error) {
+	results, err := utils.GetStateByPartialCompositeKeys2(ctx, AggregateKey, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("%s", err)
+	}
+	aggregates := []*Aggregate{}
+	for _, v := range results {
+		var aggregate Aggregate
+		if err := json.Unmarshal(v, &aggregate); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal aggregate:%v", err)
+		}
+		aggregates = append(aggregates, &aggregate)
+	}
+	return aggregates, nil
+}
+
+// 获取环签名公钥（直接返回五个公钥）
+func (s *SmartContract) GetRingPublicKeys(ctx contractapi.TransactionContextInterface) (string, error) {
+	pubsBytes, err := ctx.GetStub().GetState("ring")
+	if err != nil {
+		return "", err
+	}
+
+	var ring Ring
+	if err := json.Unmarshal(pubsBytes, &ring); err != nil {
+		return "", err
+	}
+
+	// 确保Pubs数组至少有五个元素，并且跳过第一个元素
+	if len(ring.Pubs) > 5 {
+		// 只需要前五个公钥（不包括第一个）
+		selectedPubs := ring.Pubs[1:6]
+
+		// 将选定的公钥转换为JSON字符串并返回
+		selectedPubsBytes, err := json.Marshal(selectedPubs)
+		if err != nil {
+			return "json marshal public keys error", err
+		}
+		return string(selectedPubsBytes), nil
+	}
+
+	// 如果Pubs数组的元素少于五个（不包括第一个），或者为空，则返回一个错误或空数组
+	if len(ring.Pubs) > 1 {
+		// 返回除了第一个公钥之外的所有公钥
+		remainingPubs := ring.Pubs[1:]
+
+		// 将剩余的公钥转换为JSON字符串并返回
+		remainingPubsBytes, err := json.Marshal(remainingPubs)
+		if err != nil {
+			return "json marshal public keys error", err
+		}
+		return string(remainingPubsBytes), nil
+	}
+
+	// 如果Pubs数组只有一个元素（不包括第一个），或者为空，则返回空数组
+	return "[]", nil
+}
+
+// // 获取环签名公钥
+// func (s *SmartContract) GetRingPublicKeys(ctx contractapi.TransactionContextInterface) (string, error) {
+// 	pubsBytes, err := ctx.GetStub().GetState("ring")
+// 	if err != nil {
+// 		return "", err
 // 	}
 
 // 	var ring Ring
@@ -862,4 +2828,3 @@ func (s *SmartContract) GetRingPublicKeys(ctx contractapi.TransactionContextInte
 // 	}
 // 	return indices
 // }
-
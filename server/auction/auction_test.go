@@ -0,0 +1,205 @@
+package auction
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"testing"
+
+	"server/threshold"
+	"server/utils"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+func auctioneer(t *testing.T) (*sm2.PrivateKey, *sm2.PublicKey) {
+	pri, pub, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pri, pub
+}
+
+// seal seals one bid per value, the bidders named b0, b1, ... in order.
+func seal(t *testing.T, a Auction, values ...int64) []Entry {
+	entries := make([]Entry, len(values))
+	for i, v := range values {
+		bidder := "b" + strconv.Itoa(i)
+		bid, err := a.Seal(bidder, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[i] = Entry{bidder, bid}
+	}
+	return entries
+}
+
+func TestSealedBidAuction(t *testing.T) {
+	pri, pub := auctioneer(t)
+	a := Auction{ID: "auction-1", Key: pub, Reserve: 300}
+	entries := seal(t, a, 500, 820, 640, 820, 120)
+	o, err := a.Close(pri, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Winner != 1 || o.Price != 820 {
+		t.Fatalf("*****Winner %d at %d, want the earliest highest bid 1 at 820", o.Winner, o.Price)
+	}
+	if err := a.Verify(entries, o); err != nil {
+		t.Fatal("*****Auction outcome FAILURE:", err)
+	}
+	// nothing but the winning price is published
+	out, _ := json.Marshal(o)
+	for _, v := range []int64{500, 640, 120} {
+		if bytes.Contains(out, []byte(strconv.FormatInt(v, 10))) {
+			t.Errorf("*****Outcome reveals the losing bid %d", v)
+		}
+	}
+	fmt.Println("Sealed-bid auction outcome verifies without opening the losing bids")
+}
+
+func TestAuctionOutcomeTampered(t *testing.T) {
+	pri, pub := auctioneer(t)
+	a := Auction{ID: "auction-2", Key: pub}
+	entries := seal(t, a, 40, 70, 55)
+	o, err := a.Close(pri, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cheats := map[string]Outcome{}
+	lower := o
+	lower.Price = 69
+	cheats["lower price"] = lower
+	other := o
+	other.Winner = 2
+	cheats["another winner"] = other
+	swapped := o
+	swapped.Others = []Comparison{o.Others[1], o.Others[0]}
+	cheats["swapped comparisons"] = swapped
+	missing := o
+	missing.Others = o.Others[:1]
+	cheats["missing comparison"] = missing
+	for name, c := range cheats {
+		if err := a.Verify(entries, c); !errors.Is(err, ErrBadOutcome) {
+			t.Errorf("*****%s: got %v, want ErrBadOutcome", name, err)
+		}
+	}
+
+	// the outcome of another auction over the same bids does not carry over
+	b := a
+	b.ID = "auction-3"
+	if err := b.Verify(entries, o); err == nil {
+		t.Error("*****Outcome verified for another auction")
+	}
+}
+
+func TestAuctionReserve(t *testing.T) {
+	pri, pub := auctioneer(t)
+	a := Auction{ID: "auction-4", Key: pub, Reserve: 1000}
+	entries := seal(t, a, 500, 999)
+	o, err := a.Close(pri, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Winner != -1 || len(o.Others) != 2 {
+		t.Fatalf("*****Winner %d below the reserve", o.Winner)
+	}
+	if err := a.Verify(entries, o); err != nil {
+		t.Fatal("*****No-sale outcome FAILURE:", err)
+	}
+
+	// claiming no sale when a bid reaches the reserve cannot be proven
+	low := a
+	low.Reserve = 900
+	if err := low.Verify(entries, o); !errors.Is(err, ErrBadOutcome) {
+		t.Errorf("*****No sale over a bid above the reserve: got %v, want ErrBadOutcome", err)
+	}
+
+	// no bids at all is a verifiable no-sale
+	o, err = a.Close(pri, nil)
+	if err != nil || o.Winner != -1 {
+		t.Fatal("*****Empty auction", err)
+	}
+	if err := a.Verify(nil, o); err != nil {
+		t.Error("*****Empty auction outcome FAILURE:", err)
+	}
+}
+
+func TestVerifyBid(t *testing.T) {
+	pri, pub := auctioneer(t)
+	a := Auction{ID: "auction-5", Key: pub}
+	bid, err := a.Seal("alice", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyBid("alice", bid); err != nil {
+		t.Fatal("*****Sealed bid FAILURE:", err)
+	}
+	// the bid is an ordinary HomoEncrypt ciphertext under the auctioneer key
+	m, err := utils.HomoDecrypt(pri, bid.Cipher)
+	if err != nil || new(big.Int).SetBytes(m).Int64() != 7 {
+		t.Errorf("*****HomoDecrypt of a sealed bid: %v", err)
+	}
+
+	if err := a.VerifyBid("bob", bid); !errors.Is(err, ErrBadBid) {
+		t.Errorf("*****Bid claimed by another bidder: got %v, want ErrBadBid", err)
+	}
+	b := a
+	b.ID = "auction-6"
+	if err := b.VerifyBid("alice", bid); !errors.Is(err, ErrBadBid) {
+		t.Errorf("*****Bid replayed in another auction: got %v, want ErrBadBid", err)
+	}
+	other, _ := a.Seal("alice", 9)
+	mixed := bid
+	mixed.Cipher = other.Cipher
+	if err := a.VerifyBid("alice", mixed); !errors.Is(err, ErrBadBid) {
+		t.Errorf("*****Ciphertext of another bid: got %v, want ErrBadBid", err)
+	}
+	for _, v := range []int64{0, -1, 1 << BidBits} {
+		if _, err := a.Seal("alice", v); !errors.Is(err, ErrBadBid) {
+			t.Errorf("*****Bid %d: got %v, want ErrBadBid", v, err)
+		}
+	}
+	if _, err := a.Close(pri, []Entry{{"alice", bid}, {"alice", other}}); !errors.Is(err, ErrBadBid) {
+		t.Errorf("*****Two bids of one bidder: got %v, want ErrBadBid", err)
+	}
+	wrong, _ := auctioneer(t)
+	if _, err := a.Close(wrong, []Entry{{"alice", bid}}); !errors.Is(err, ErrBadKey) {
+		t.Errorf("*****Close with another key: got %v, want ErrBadKey", err)
+	}
+}
+
+func TestThresholdAuctioneerKey(t *testing.T) {
+	pri, _ := auctioneer(t)
+	shares, err := threshold.Split(pri.D, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gk := shares[0].GroupKey
+	a := Auction{ID: "auction-7", Key: gk.PublicKey()}
+	entries := seal(t, a, 65537, 65536)
+
+	// two of the three parties open the key after the deadline
+	key, err := threshold.Reconstruct(gk, []KeyShare{shares[0], shares[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := a.Close(key, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Winner != 0 || o.Price != 65537 {
+		t.Fatalf("*****Winner %d at %d, want 0 at 65537", o.Winner, o.Price)
+	}
+	if err := a.Verify(entries, o); err != nil {
+		t.Error("*****Threshold key auction outcome FAILURE:", err)
+	}
+}
+
+// KeyShare keeps the threshold test readable.
+type KeyShare = threshold.KeyShare
@@ -0,0 +1,290 @@
+/*
+Package auction runs sealed-bid, first-price auctions of a lot without
+revealing the losing bids.
+
+A bid b in [1, 2^BidBits) is sealed under the auctioneer's key P = x*G as
+the ciphertext utils.HomoEncrypt would make,
+
+	C1 = k*G,  C2 = b*G + k*P
+
+together with a Pedersen commitment V = b*G' + r*H' over the Bulletproof
+generators, a range proof of V, and a proof that C2 and V carry the same b.
+Anyone can check a bid, nobody but the holder of x can open it.
+
+After the deadline the auctioneer opens every bid and publishes the winner,
+the earliest of the highest bids, with
+
+  - a proof that the winner's ciphertext decrypts to the price, and
+  - for every other bid j a fresh commitment D_j to the difference
+    d_j = b_w - b_j (minus one for bids made before the winner's), a range
+    proof of D_j and a proof that D_j commits to the value of the
+    ciphertext difference (C1_w - C1_j, C2_w - C2_j - G) under P.
+
+A range proof on d_j shows b_w >= b_j, and b_w > b_j for earlier bids,
+without saying by how much, so only the winning price becomes public. When
+no bid reaches the reserve price every bid is compared against reserve-1 in
+the same way.
+
+The auctioneer key may be a threshold key from package threshold generated
+for one auction: the bids then stay sealed from any single party until t
+parties release their shares after the deadline (see
+threshold.Reconstruct).
+*/
+package auction
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	bullet "server/bulletproof/src"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// BidBits is the bit length of a bid, the same as a price in the order
+// flow, so the winning bid can be paid through it.
+const BidBits = 32
+
+// pointLen is the length of an uncompressed SM2 point; a ciphertext is
+// C1 || C2.
+const (
+	pointLen  = 1 + 2*32
+	cipherLen = 2 * pointLen
+)
+
+// Auction is the public description of one auction that bids are sealed
+// for and outcomes are checked against.
+type Auction struct {
+	ID      string
+	Key     *sm2.PublicKey // auctioneer key the bids are sealed under
+	Reserve int64          // lowest winning bid; 0 for none
+}
+
+// Bid is a sealed bid in its encoded form, as it is stored on chain.
+type Bid struct {
+	Cipher []byte `json:"cipher"` // C1 || C2 under the auctioneer key
+	Comm   []byte `json:"comm"`   // V = b*G' + r*H', compressed
+	Range  []byte `json:"range"`  // range proof of V over BidBits
+	Link   []byte `json:"link"`   // LinkProof that Cipher and V carry the same b
+}
+
+// Entry is a bid with its bidder, in the order the bids were accepted.
+type Entry struct {
+	Bidder string `json:"bidder"`
+	Bid
+}
+
+/*
+LinkProof shows knowledge of b, k and r with C1 = k*G, C2 = b*G + k*P and
+V = b*G' + r*H'. R1, R2 and R3 commit to random u_b, u_k and u_r, and
+S_b = u_b + c*b, S_k = u_k + c*k, S_r = u_r + c*r.
+*/
+type LinkProof struct {
+	R1 bullet.ECPoint // u_k*G
+	R2 bullet.ECPoint // u_b*G + u_k*P
+	R3 bullet.ECPoint // u_b*G' + u_r*H'
+	Sb *big.Int
+	Sk *big.Int
+	Sr *big.Int
+}
+
+func params() *bullet.CryptoParams {
+	return bullet.SM2Params(BidBits)
+}
+
+func basePoint() bullet.ECPoint {
+	p := sm2.GetSm2P256V1().Params()
+	return bullet.ECPoint{X: p.Gx, Y: p.Gy}
+}
+
+// bidContext binds a bid to its auction and bidder, so it cannot be
+// replayed in another auction or claimed by another bidder.
+func (a Auction) bidContext(bidder string) []byte {
+	return []byte(a.ID + "|" + bidder)
+}
+
+// Seal seals value, which must be in [1, 2^BidBits), as bidder's bid in a.
+// Only the holder of the auctioneer key can open it.
+func (a Auction) Seal(bidder string, value int64) (Bid, error) {
+	if value < 1 || value >= 1<<BidBits {
+		return Bid{}, fmt.Errorf("%w: %d out of range", ErrBadBid, value)
+	}
+	P, err := publicPoint(a.Key)
+	if err != nil {
+		return Bid{}, err
+	}
+	ec := params()
+	G := basePoint()
+	b := big.NewInt(value)
+	k, err := randScalar()
+	if err != nil {
+		return Bid{}, err
+	}
+	r, err := randScalar()
+	if err != nil {
+		return Bid{}, err
+	}
+	c1 := ec.Mult(G, k)
+	c2 := ec.Add(ec.Mult(G, b), ec.Mult(P, k))
+	context := a.bidContext(bidder)
+
+	rp, err := ec.RPProveWithBlinding(b, r, context)
+	if err != nil {
+		return Bid{}, err
+	}
+	proof, err := proveLink(P, c1, c2, rp.Comm, b, k, r, context)
+	if err != nil {
+		return Bid{}, err
+	}
+
+	var bid Bid
+	bid.Cipher = marshalCipher(c1, c2)
+	if bid.Comm, err = ec.EncodePoint(rp.Comm); err != nil {
+		return Bid{}, err
+	}
+	if bid.Range, err = ec.EncodeRangeProof(rp); err != nil {
+		return Bid{}, err
+	}
+	if bid.Link, err = proof.Bytes(); err != nil {
+		return Bid{}, err
+	}
+	return bid, nil
+}
+
+// VerifyBid checks that bid is well formed and sealed by bidder for a.
+func (a Auction) VerifyBid(bidder string, bid Bid) error {
+	_, err := a.parseBid(bidder, bid)
+	return err
+}
+
+// sealed is a parsed and checked bid.
+type sealed struct {
+	C1, C2 bullet.ECPoint
+}
+
+func (a Auction) parseBid(bidder string, bid Bid) (sealed, error) {
+	P, err := publicPoint(a.Key)
+	if err != nil {
+		return sealed{}, err
+	}
+	c1, c2, err := parseCipher(bid.Cipher)
+	if err != nil {
+		return sealed{}, err
+	}
+	ec := params()
+	V, err := ec.DecodePoint(bid.Comm)
+	if err != nil {
+		return sealed{}, fmt.Errorf("%w: %v", ErrBadBid, err)
+	}
+	rp, err := ec.DecodeRangeProof(bid.Range)
+	if err != nil {
+		return sealed{}, fmt.Errorf("%w: %v", ErrBadBid, err)
+	}
+	proof, err := ParseLinkProof(bid.Link)
+	if err != nil {
+		return sealed{}, err
+	}
+	context := a.bidContext(bidder)
+	if ok, err := ec.RPVerifyCommitment(rp, V, context); err != nil || !ok {
+		return sealed{}, fmt.Errorf("%w: range proof does not verify", ErrBadBid)
+	}
+	if !verifyLink(P, c1, c2, V, proof, context) {
+		return sealed{}, fmt.Errorf("%w: link proof does not verify", ErrBadBid)
+	}
+	return sealed{c1, c2}, nil
+}
+
+func proveLink(P, c1, c2, V bullet.ECPoint, b, k, r *big.Int, context []byte) (LinkProof, error) {
+	ec := params()
+	G := basePoint()
+	var u [3]*big.Int
+	for i := range u {
+		s, err := randScalar()
+		if err != nil {
+			return LinkProof{}, err
+		}
+		u[i] = s
+	}
+	R1 := ec.Mult(G, u[1])
+	R2 := ec.Add(ec.Mult(G, u[0]), ec.Mult(P, u[1]))
+	R3 := ec.Add(ec.Mult(ec.G, u[0]), ec.Mult(ec.H, u[2]))
+	c := linkChallenge(P, c1, c2, V, R1, R2, R3, context)
+	return LinkProof{R1, R2, R3, response(u[0], c, b), response(u[1], c, k), response(u[2], c, r)}, nil
+}
+
+func verifyLink(P, c1, c2, V bullet.ECPoint, p LinkProof, context []byte) bool {
+	ec := params()
+	G := basePoint()
+	c := linkChallenge(P, c1, c2, V, p.R1, p.R2, p.R3, context)
+	// Sk*G == R1 + c*C1, Sb*G + Sk*P == R2 + c*C2, Sb*G' + Sr*H' == R3 + c*V
+	return ec.Mult(G, p.Sk).Equal(ec.Add(p.R1, ec.Mult(c1, c))) &&
+		ec.Add(ec.Mult(G, p.Sb), ec.Mult(P, p.Sk)).Equal(ec.Add(p.R2, ec.Mult(c2, c))) &&
+		ec.Add(ec.Mult(ec.G, p.Sb), ec.Mult(ec.H, p.Sr)).Equal(ec.Add(p.R3, ec.Mult(V, c)))
+}
+
+func linkChallenge(P, c1, c2, V, R1, R2, R3 bullet.ECPoint, context []byte) *big.Int {
+	t := bullet.NewTranscript("auction/link")
+	t.AppendParams(params())
+	t.AppendPoint("Base", basePoint())
+	t.AppendPoint("P", P)
+	t.AppendPoint("C1", c1)
+	t.AppendPoint("C2", c2)
+	t.AppendPoint("V", V)
+	t.AppendMessage("context", context)
+	t.AppendPoint("R1", R1)
+	t.AppendPoint("R2", R2)
+	t.AppendPoint("R3", R3)
+	return t.ChallengeScalar("c", params().N)
+}
+
+// response returns u + c*w mod N.
+func response(u, c, w *big.Int) *big.Int {
+	s := new(big.Int).Mul(c, w)
+	s.Add(s, u)
+	return s.Mod(s, params().N)
+}
+
+// parseCipher splits a HomoEncrypt ciphertext into its two points.
+func parseCipher(cipher []byte) (bullet.ECPoint, bullet.ECPoint, error) {
+	if len(cipher) != cipherLen {
+		return bullet.ECPoint{}, bullet.ECPoint{}, fmt.Errorf("%w: ciphertext length %d", ErrBadBid, len(cipher))
+	}
+	curve := sm2.GetSm2P256V1()
+	x1, y1 := elliptic.Unmarshal(curve, cipher[:pointLen])
+	x2, y2 := elliptic.Unmarshal(curve, cipher[pointLen:])
+	if x1 == nil || x2 == nil {
+		return bullet.ECPoint{}, bullet.ECPoint{}, fmt.Errorf("%w: ciphertext point not on curve", ErrBadBid)
+	}
+	return bullet.ECPoint{X: x1, Y: y1}, bullet.ECPoint{X: x2, Y: y2}, nil
+}
+
+func marshalCipher(c1, c2 bullet.ECPoint) []byte {
+	curve := sm2.GetSm2P256V1()
+	return append(elliptic.Marshal(curve, c1.X, c1.Y), elliptic.Marshal(curve, c2.X, c2.Y)...)
+}
+
+func publicPoint(pub *sm2.PublicKey) (bullet.ECPoint, error) {
+	if pub == nil || pub.X == nil || pub.Y == nil {
+		return bullet.ECPoint{}, fmt.Errorf("%w: missing public key", ErrBadKey)
+	}
+	p := bullet.ECPoint{X: pub.X, Y: pub.Y}
+	if p.IsZero() || !sm2.GetSm2P256V1().IsOnCurve(p.X, p.Y) {
+		return bullet.ECPoint{}, fmt.Errorf("%w: public key not on curve", ErrBadKey)
+	}
+	return p, nil
+}
+
+func randScalar() (*big.Int, error) {
+	N := params().N
+	for {
+		a, err := rand.Int(rand.Reader, N)
+		if err != nil {
+			return nil, err
+		}
+		if a.Sign() != 0 {
+			return a, nil
+		}
+	}
+}
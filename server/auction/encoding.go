@@ -0,0 +1,100 @@
+package auction
+
+import (
+	"fmt"
+	"math/big"
+
+	bullet "server/bulletproof/src"
+)
+
+// Bytes returns the fixed length encoding R1 || R2 || R3 || Sb || Sk || Sr.
+func (p LinkProof) Bytes() ([]byte, error) {
+	return encodeProof([]bullet.ECPoint{p.R1, p.R2, p.R3}, []*big.Int{p.Sb, p.Sk, p.Sr})
+}
+
+// ParseLinkProof parses a proof written by LinkProof.Bytes.
+func ParseLinkProof(b []byte) (LinkProof, error) {
+	pts, ss, err := decodeProof(b, 3, 3)
+	if err != nil {
+		return LinkProof{}, fmt.Errorf("%w: link proof: %v", ErrBadBid, err)
+	}
+	return LinkProof{pts[0], pts[1], pts[2], ss[0], ss[1], ss[2]}, nil
+}
+
+// Bytes returns the fixed length encoding R1 || R2 || S.
+func (p OpenProof) Bytes() ([]byte, error) {
+	return encodeProof([]bullet.ECPoint{p.R1, p.R2}, []*big.Int{p.S})
+}
+
+// ParseOpenProof parses a proof written by OpenProof.Bytes.
+func ParseOpenProof(b []byte) (OpenProof, error) {
+	pts, ss, err := decodeProof(b, 2, 1)
+	if err != nil {
+		return OpenProof{}, fmt.Errorf("%w: open proof: %v", ErrBadOutcome, err)
+	}
+	return OpenProof{pts[0], pts[1], ss[0]}, nil
+}
+
+// Bytes returns the fixed length encoding R1 || R2 || R3 || Sd || Ss || Sx.
+func (p CompareProof) Bytes() ([]byte, error) {
+	return encodeProof([]bullet.ECPoint{p.R1, p.R2, p.R3}, []*big.Int{p.Sd, p.Ss, p.Sx})
+}
+
+// ParseCompareProof parses a proof written by CompareProof.Bytes.
+func ParseCompareProof(b []byte) (CompareProof, error) {
+	pts, ss, err := decodeProof(b, 3, 3)
+	if err != nil {
+		return CompareProof{}, fmt.Errorf("%w: compare proof: %v", ErrBadOutcome, err)
+	}
+	return CompareProof{pts[0], pts[1], pts[2], ss[0], ss[1], ss[2]}, nil
+}
+
+// encodeProof writes compressed points followed by scalars.
+func encodeProof(pts []bullet.ECPoint, ss []*big.Int) ([]byte, error) {
+	ec := params()
+	out := make([]byte, 0, len(pts)*bullet.PointSize+len(ss)*bullet.ScalarSize)
+	for _, p := range pts {
+		b, err := ec.EncodePoint(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	for _, s := range ss {
+		b, err := ec.EncodeScalar(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// decodeProof reads np points and ns scalars; trailing or missing bytes,
+// the identity and non-canonical encodings are rejected.
+func decodeProof(b []byte, np, ns int) ([]bullet.ECPoint, []*big.Int, error) {
+	if len(b) != np*bullet.PointSize+ns*bullet.ScalarSize {
+		return nil, nil, fmt.Errorf("proof is %d bytes", len(b))
+	}
+	ec := params()
+	pts := make([]bullet.ECPoint, np)
+	for i := range pts {
+		p, err := ec.DecodePoint(b[:bullet.PointSize])
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.IsZero() {
+			return nil, nil, fmt.Errorf("identity in proof")
+		}
+		pts[i], b = p, b[bullet.PointSize:]
+	}
+	ss := make([]*big.Int, ns)
+	for i := range ss {
+		s, err := ec.DecodeScalar(b[:bullet.ScalarSize])
+		if err != nil {
+			return nil, nil, err
+		}
+		ss[i], b = s, b[bullet.ScalarSize:]
+	}
+	return pts, ss, nil
+}
@@ -0,0 +1,17 @@
+package auction
+
+import "errors"
+
+var (
+	// ErrBadBid is returned for a bid value outside [1, 2^BidBits), or a
+	// sealed bid that is malformed or whose proofs do not verify.
+	ErrBadBid = errors.New("auction: invalid bid")
+
+	// ErrBadKey is returned for an auctioneer key that is missing, not a
+	// point of the curve, or a private key that does not match it.
+	ErrBadKey = errors.New("auction: bad auctioneer key")
+
+	// ErrBadOutcome is returned when a published outcome is malformed or
+	// one of its proofs does not verify.
+	ErrBadOutcome = errors.New("auction: invalid outcome")
+)
@@ -0,0 +1,373 @@
+package auction
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+
+	bullet "server/bulletproof/src"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// Outcome is what the auctioneer publishes after the deadline.
+type Outcome struct {
+	Winner int          `json:"winner"` // index of the winning entry, -1 when no bid reaches the reserve
+	Price  int64        `json:"price"`  // the winning bid, 0 when there is no winner
+	Open   []byte       `json:"open"`   // OpenProof that the winner's ciphertext decrypts to Price
+	Others []Comparison `json:"others"` // one for every other entry, in entry order
+}
+
+// Comparison shows that the bid of one entry does not beat the winner, or
+// the reserve when there is no winner.
+type Comparison struct {
+	Comm  []byte `json:"comm"`  // D = d*G' + s*H', compressed
+	Range []byte `json:"range"` // range proof of D over BidBits
+	Proof []byte `json:"proof"` // CompareProof that D carries the ciphertext difference
+}
+
+// OpenProof is a Chaum-Pedersen proof that log_G(P) == log_C1(C2 - m*G),
+// i.e. that a ciphertext decrypts to m under the auctioneer key.
+type OpenProof struct {
+	R1 bullet.ECPoint // u*G
+	R2 bullet.ECPoint // u*C1
+	S  *big.Int       // u + c*x
+}
+
+/*
+CompareProof shows knowledge of d, s and x with D = d*G' + s*H',
+E2 - x*E1 = d*G and P = x*G for a ciphertext difference (E1, E2), i.e. that
+D commits to the value the difference encrypts. S_d, S_s and S_x answer the
+random u_d, u_s and u_x behind R1, R2 and R3.
+*/
+type CompareProof struct {
+	R1 bullet.ECPoint // u_d*G' + u_s*H'
+	R2 bullet.ECPoint // u_d*G + u_x*E1
+	R3 bullet.ECPoint // u_x*G
+	Sd *big.Int
+	Ss *big.Int
+	Sx *big.Int
+}
+
+/*
+Close opens entries with the auctioneer key priv and returns the outcome.
+The winner is the earliest of the highest bids that reach the reserve.
+Entries must be in the order they were accepted, which decides ties.
+*/
+func (a Auction) Close(priv *sm2.PrivateKey, entries []Entry) (Outcome, error) {
+	P, err := publicPoint(a.Key)
+	if err != nil {
+		return Outcome{}, err
+	}
+	ec := params()
+	if priv == nil || priv.D == nil || priv.D.Sign() <= 0 || priv.D.Cmp(ec.N) >= 0 ||
+		!ec.Mult(basePoint(), priv.D).Equal(P) {
+		return Outcome{}, fmt.Errorf("%w: private key does not match", ErrBadKey)
+	}
+	bids, err := a.parseEntries(entries)
+	if err != nil {
+		return Outcome{}, err
+	}
+	values := make([]int64, len(bids))
+	for i, s := range bids {
+		v, err := decrypt(priv.D, s)
+		if err != nil {
+			return Outcome{}, fmt.Errorf("bid %d: %v", i, err)
+		}
+		values[i] = v
+	}
+
+	o := Outcome{Winner: -1, Others: []Comparison{}}
+	for i, v := range values {
+		if v >= a.Reserve && (o.Winner < 0 || v > values[o.Winner]) {
+			o.Winner = i
+		}
+	}
+	top := a.Reserve - 1
+	if o.Winner >= 0 {
+		o.Price = values[o.Winner]
+		top = o.Price
+		w := bids[o.Winner]
+		proof, err := proveOpen(P, w.C1, w.C2, o.Price, priv.D, a.openContext())
+		if err != nil {
+			return Outcome{}, err
+		}
+		if o.Open, err = proof.Bytes(); err != nil {
+			return Outcome{}, err
+		}
+	}
+	for j := range bids {
+		if j == o.Winner {
+			continue
+		}
+		d := top - values[j]
+		if j < o.Winner {
+			d--
+		}
+		e1, e2 := a.difference(bids, o.Winner, j)
+		c, err := proveCompare(P, e1, e2, d, priv.D, a.compareContext(o.Winner, j))
+		if err != nil {
+			return Outcome{}, fmt.Errorf("bid %d: %v", j, err)
+		}
+		o.Others = append(o.Others, c)
+	}
+	return o, nil
+}
+
+// Verify checks o against the entries of a: every bid, the winner's price
+// and that no other bid beats it.
+func (a Auction) Verify(entries []Entry, o Outcome) error {
+	P, err := publicPoint(a.Key)
+	if err != nil {
+		return err
+	}
+	bids, err := a.parseEntries(entries)
+	if err != nil {
+		return err
+	}
+	n := len(bids)
+	if o.Winner < -1 || o.Winner >= n {
+		return fmt.Errorf("%w: winner %d of %d bids", ErrBadOutcome, o.Winner, n)
+	}
+	others := n
+	if o.Winner >= 0 {
+		others--
+	}
+	if len(o.Others) != others {
+		return fmt.Errorf("%w: %d comparisons for %d other bids", ErrBadOutcome, len(o.Others), others)
+	}
+	if o.Winner >= 0 {
+		if o.Price < a.Reserve || o.Price < 1 || o.Price >= 1<<BidBits {
+			return fmt.Errorf("%w: price %d", ErrBadOutcome, o.Price)
+		}
+		proof, err := ParseOpenProof(o.Open)
+		if err != nil {
+			return err
+		}
+		w := bids[o.Winner]
+		if !verifyOpen(P, w.C1, w.C2, o.Price, proof, a.openContext()) {
+			return fmt.Errorf("%w: the winner's bid is not %d", ErrBadOutcome, o.Price)
+		}
+	} else {
+		if o.Price != 0 || len(o.Open) != 0 {
+			return fmt.Errorf("%w: price without a winner", ErrBadOutcome)
+		}
+		if n > 0 && a.Reserve <= 1 {
+			return fmt.Errorf("%w: no winner without a reserve", ErrBadOutcome)
+		}
+	}
+
+	ec := params()
+	k := 0
+	for j := range bids {
+		if j == o.Winner {
+			continue
+		}
+		c := o.Others[k]
+		k++
+		D, err := ec.DecodePoint(c.Comm)
+		if err != nil {
+			return fmt.Errorf("%w: bid %d: %v", ErrBadOutcome, j, err)
+		}
+		rp, err := ec.DecodeRangeProof(c.Range)
+		if err != nil {
+			return fmt.Errorf("%w: bid %d: %v", ErrBadOutcome, j, err)
+		}
+		proof, err := ParseCompareProof(c.Proof)
+		if err != nil {
+			return err
+		}
+		context := a.compareContext(o.Winner, j)
+		if ok, err := ec.RPVerifyCommitment(rp, D, context); err != nil || !ok {
+			return fmt.Errorf("%w: bid %d may beat the winner", ErrBadOutcome, j)
+		}
+		e1, e2 := a.difference(bids, o.Winner, j)
+		if !verifyCompare(P, e1, e2, D, proof, context) {
+			return fmt.Errorf("%w: comparison of bid %d does not match its ciphertext", ErrBadOutcome, j)
+		}
+	}
+	return nil
+}
+
+// parseEntries checks every entry, each bidder bidding once.
+func (a Auction) parseEntries(entries []Entry) ([]sealed, error) {
+	bids := make([]sealed, len(entries))
+	seen := make(map[string]bool)
+	for i, e := range entries {
+		if seen[e.Bidder] {
+			return nil, fmt.Errorf("%w: second bid of %s", ErrBadBid, e.Bidder)
+		}
+		seen[e.Bidder] = true
+		s, err := a.parseBid(e.Bidder, e.Bid)
+		if err != nil {
+			return nil, fmt.Errorf("bid %d: %w", i, err)
+		}
+		bids[i] = s
+	}
+	return bids, nil
+}
+
+/*
+difference returns the ciphertext (E1, E2) that the comparison of bid j
+opens to: the winner's bid minus b_j, less one more if j bid before the
+winner, or reserve-1 minus b_j when there is no winner.
+*/
+func (a Auction) difference(bids []sealed, w, j int) (bullet.ECPoint, bullet.ECPoint) {
+	ec := params()
+	G := basePoint()
+	e1 := ec.Neg(bids[j].C1)
+	e2 := ec.Neg(bids[j].C2)
+	if w >= 0 {
+		e1 = ec.Add(bids[w].C1, e1)
+		e2 = ec.Add(bids[w].C2, e2)
+		if j < w {
+			e2 = ec.Add(e2, ec.Neg(G))
+		}
+	} else if a.Reserve > 1 {
+		e2 = ec.Add(ec.Mult(G, big.NewInt(a.Reserve-1)), e2)
+	}
+	return e1, e2
+}
+
+func (a Auction) openContext() []byte {
+	return []byte(a.ID + "|open")
+}
+
+func (a Auction) compareContext(w, j int) []byte {
+	return []byte(a.ID + "|compare|" + strconv.Itoa(w) + "|" + strconv.Itoa(j))
+}
+
+func proveOpen(P, c1, c2 bullet.ECPoint, m int64, x *big.Int, context []byte) (OpenProof, error) {
+	ec := params()
+	u, err := randScalar()
+	if err != nil {
+		return OpenProof{}, err
+	}
+	M := ec.Add(c2, ec.Neg(ec.Mult(basePoint(), big.NewInt(m))))
+	R1 := ec.Mult(basePoint(), u)
+	R2 := ec.Mult(c1, u)
+	c := openChallenge(P, c1, M, R1, R2, context)
+	return OpenProof{R1, R2, response(u, c, x)}, nil
+}
+
+func verifyOpen(P, c1, c2 bullet.ECPoint, m int64, p OpenProof, context []byte) bool {
+	ec := params()
+	M := ec.Add(c2, ec.Neg(ec.Mult(basePoint(), big.NewInt(m))))
+	c := openChallenge(P, c1, M, p.R1, p.R2, context)
+	// S*G == R1 + c*P, S*C1 == R2 + c*(C2 - m*G)
+	return ec.Mult(basePoint(), p.S).Equal(ec.Add(p.R1, ec.Mult(P, c))) &&
+		ec.Mult(c1, p.S).Equal(ec.Add(p.R2, ec.Mult(M, c)))
+}
+
+func proveCompare(P, e1, e2 bullet.ECPoint, d int64, x *big.Int, context []byte) (Comparison, error) {
+	ec := params()
+	G := basePoint()
+	s, err := randScalar()
+	if err != nil {
+		return Comparison{}, err
+	}
+	rp, err := ec.RPProveWithBlinding(big.NewInt(d), s, context)
+	if err != nil {
+		return Comparison{}, err
+	}
+	var u [3]*big.Int
+	for i := range u {
+		if u[i], err = randScalar(); err != nil {
+			return Comparison{}, err
+		}
+	}
+	R1 := ec.Add(ec.Mult(ec.G, u[0]), ec.Mult(ec.H, u[1]))
+	R2 := ec.Add(ec.Mult(G, u[0]), ec.Mult(e1, u[2]))
+	R3 := ec.Mult(G, u[2])
+	c := compareChallenge(P, e1, e2, rp.Comm, R1, R2, R3, context)
+	proof := CompareProof{R1, R2, R3, response(u[0], c, big.NewInt(d)), response(u[1], c, s), response(u[2], c, x)}
+
+	var out Comparison
+	if out.Comm, err = ec.EncodePoint(rp.Comm); err != nil {
+		return Comparison{}, err
+	}
+	if out.Range, err = ec.EncodeRangeProof(rp); err != nil {
+		return Comparison{}, err
+	}
+	if out.Proof, err = proof.Bytes(); err != nil {
+		return Comparison{}, err
+	}
+	return out, nil
+}
+
+func verifyCompare(P, e1, e2, D bullet.ECPoint, p CompareProof, context []byte) bool {
+	ec := params()
+	G := basePoint()
+	c := compareChallenge(P, e1, e2, D, p.R1, p.R2, p.R3, context)
+	// Sd*G' + Ss*H' == R1 + c*D, Sd*G + Sx*E1 == R2 + c*E2, Sx*G == R3 + c*P
+	return ec.Add(ec.Mult(ec.G, p.Sd), ec.Mult(ec.H, p.Ss)).Equal(ec.Add(p.R1, ec.Mult(D, c))) &&
+		ec.Add(ec.Mult(G, p.Sd), ec.Mult(e1, p.Sx)).Equal(ec.Add(p.R2, ec.Mult(e2, c))) &&
+		ec.Mult(G, p.Sx).Equal(ec.Add(p.R3, ec.Mult(P, c)))
+}
+
+func openChallenge(P, c1, M, R1, R2 bullet.ECPoint, context []byte) *big.Int {
+	t := bullet.NewTranscript("auction/open")
+	t.AppendPoint("Base", basePoint())
+	t.AppendPoint("P", P)
+	t.AppendPoint("C1", c1)
+	t.AppendPoint("M", M)
+	t.AppendMessage("context", context)
+	t.AppendPoint("R1", R1)
+	t.AppendPoint("R2", R2)
+	return t.ChallengeScalar("c", params().N)
+}
+
+func compareChallenge(P, e1, e2, D, R1, R2, R3 bullet.ECPoint, context []byte) *big.Int {
+	t := bullet.NewTranscript("auction/compare")
+	t.AppendParams(params())
+	t.AppendPoint("Base", basePoint())
+	t.AppendPoint("P", P)
+	t.AppendPoint("E1", e1)
+	t.AppendPoint("E2", e2)
+	t.AppendPoint("D", D)
+	t.AppendMessage("context", context)
+	t.AppendPoint("R1", R1)
+	t.AppendPoint("R2", R2)
+	t.AppendPoint("R3", R3)
+	return t.ChallengeScalar("c", params().N)
+}
+
+// babySteps is the number of baby steps of the bid decryption; a bid of
+// BidBits bits takes at most as many giant steps.
+const babySteps = 1 << (BidBits / 2)
+
+var (
+	babyOnce  sync.Once
+	babyTable map[string]int64
+)
+
+/*
+decrypt recovers b from b*G = C2 - x*C1 by baby-step giant-step over
+[0, 2^BidBits), which is bounded by the range proof of the bid. The table of
+baby steps j*G is keyed by x coordinate and built once.
+*/
+func decrypt(x *big.Int, s sealed) (int64, error) {
+	ec := params()
+	G := basePoint()
+	babyOnce.Do(func() {
+		babyTable = make(map[string]int64, babySteps)
+		p := G
+		for j := int64(1); j < babySteps; j++ {
+			babyTable[string(p.X.Bytes())] = j
+			p = ec.Add(p, G)
+		}
+	})
+	q := ec.Add(s.C2, ec.Neg(ec.Mult(s.C1, x)))
+	giant := ec.Neg(ec.Mult(G, big.NewInt(babySteps)))
+	for i := int64(0); i < babySteps; i++ {
+		if q.IsZero() {
+			return i * babySteps, nil
+		}
+		if j, ok := babyTable[string(q.X.Bytes())]; ok && ec.Mult(G, big.NewInt(j)).Equal(q) {
+			return i*babySteps + j, nil
+		}
+		q = ec.Add(q, giant)
+	}
+	return 0, fmt.Errorf("%w: bid does not decrypt to %d bits", ErrBadBid, BidBits)
+}
@@ -0,0 +1,348 @@
+package blockchain
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"server/auction"
+	"server/utils"
+	"strconv"
+
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+// Auction 链上的密封拍卖，见链码 CreateAuction
+type Auction struct {
+	ID          string `json:"id"`
+	GoodId      string `json:"goodId"`
+	Seller      string `json:"seller"`
+	Key         string `json:"key"`
+	Reserve     int64  `json:"reserve"`
+	Deadline    int64  `json:"deadline"`
+	Bids        int64  `json:"bids"`
+	Status      string `json:"status"`
+	Winner      string `json:"winner"`
+	Price       int64  `json:"price"`
+	Outcome     string `json:"outcome"`
+	Verifier    string `json:"verifier"`
+	Attestation string `json:"attestation"`
+	OrderNum    string `json:"orderNum"`
+	TxID        string `json:"txId"`
+	Time        int64  `json:"time"`
+}
+
+// AuctionBid 链上的密封出价，各字段为hex
+type AuctionBid struct {
+	Auction string `json:"auction"`
+	Seq     int64  `json:"seq"`
+	Bidder  string `json:"bidder"`
+	Cipher  string `json:"cipher"`
+	Comm    string `json:"comm"`
+	Range   string `json:"range"`
+	Link    string `json:"link"`
+	TxID    string `json:"txId"`
+	Time    int64  `json:"time"`
+}
+
+/*
+CreateAuction 为商品创建密封拍卖，出价用拍卖方公钥key加密
+reserve为保留价，deadline为截止时间（Unix秒），须在商品交割开始之前
+verifier为验证结果的用户名，链码不验证拍卖方公布的结果，付款前须由verifier验证并签名，见 AttestAuction
+*/
+func (c *Contract) CreateAuction(id string, goodId string, key *sm2.PublicKey, reserve int64, deadline int64, verifier string) ([]byte, error) {
+	if key == nil {
+		return nil, fmt.Errorf("missing auctioneer key")
+	}
+	verifier_pub := utils.ReadPubKey(verifier)
+	if verifier_pub == nil {
+		return nil, fmt.Errorf("failed to read the public key of %s", verifier)
+	}
+	record, err := c.RegisterKey(verifier_pub)
+	if err != nil {
+		return nil, err
+	}
+	key_bytes, err := json.Marshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key:%v", err)
+	}
+	res, err := c.contract.SubmitTransaction("CreateAuction", id, goodId, base64.StdEncoding.EncodeToString(key_bytes),
+		strconv.FormatInt(reserve, 10), strconv.FormatInt(deadline, 10), record.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction CreateAuction:%v", err)
+	}
+	return res, nil
+}
+
+func (c *Contract) GetAuction(id string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetAuction", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+	return res, nil
+}
+
+func (c *Contract) GetBids(id string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetBids", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+	return res, nil
+}
+
+/*
+SubmitBid 用户bidder出价value：在拍卖方公钥下同态加密，附范围证明和密文与承诺一致的证明，
+证明与拍卖ID、出价者地址绑定，验证通过后上链。出价明文不上链也不保存
+*/
+func (c *Contract) SubmitBid(id string, bidder string, value int64) ([]byte, error) {
+	a, _, err := c.auction(id)
+	if err != nil {
+		return nil, err
+	}
+	bidder_address := utils.GetAddress(bidder)
+	if bidder_address == "" {
+		return nil, fmt.Errorf("unknown bidder %s", bidder)
+	}
+	bid, err := a.Seal(bidder_address, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal bid:%v", err)
+	}
+	res, err := c.contract.SubmitTransaction("SubmitBid", id, bidder_address, hex.EncodeToString(bid.Cipher),
+		hex.EncodeToString(bid.Comm), hex.EncodeToString(bid.Range), hex.EncodeToString(bid.Link))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction SubmitBid:%v", err)
+	}
+	return res, nil
+}
+
+/*
+CloseAuction 截止后拍卖方用私钥pri打开全部出价，公布中标者、中标价和证明，并用pri对结果签名
+公布前先验证结果，链码只检查签名、截止时间和保留价，结果在verifier签名之前不生效
+*/
+func (c *Contract) CloseAuction(id string, pri *sm2.PrivateKey) ([]byte, error) {
+	a, record, err := c.auction(id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := c.auctionEntries(id)
+	if err != nil {
+		return nil, err
+	}
+	o, err := a.Close(pri, entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to close auction:%v", err)
+	}
+	if err := a.Verify(entries, o); err != nil {
+		return nil, fmt.Errorf("failed to verify outcome:%v", err)
+	}
+	outcome, err := json.Marshal(o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal outcome:%v", err)
+	}
+	winner := ""
+	if o.Winner >= 0 {
+		winner = entries[o.Winner].Bidder
+	}
+	//拍卖方的UID由拍卖的Key计算，与链码 keyUID 相同
+	key_bytes, err := base64.StdEncoding.DecodeString(record.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode auctioneer key:%v", err)
+	}
+	h := sm3.New()
+	h.Write(key_bytes)
+	sign, err := sm2.Sign(pri, h.Sum(nil), auctionMessage(id, winner, o.Price, string(outcome)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign outcome:%v", err)
+	}
+	res, err := c.contract.SubmitTransaction("CloseAuction", id, strconv.Itoa(o.Winner),
+		strconv.FormatInt(o.Price, 10), string(outcome), hex.EncodeToString(sign))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction CloseAuction:%v", err)
+	}
+	return res, nil
+}
+
+// VerifyAuction 从链上取回出价和结果重新验证，中标者须为中标出价的出价者
+func (c *Contract) VerifyAuction(id string) error {
+	a, record, err := c.auction(id)
+	if err != nil {
+		return err
+	}
+	if record.Status != "closed" && record.Status != "attested" && record.Status != "settled" {
+		return fmt.Errorf("the auction %s is not closed", id)
+	}
+	entries, err := c.auctionEntries(id)
+	if err != nil {
+		return err
+	}
+	var o auction.Outcome
+	if err := json.Unmarshal([]byte(record.Outcome), &o); err != nil {
+		return fmt.Errorf("failed to unmarshal outcome:%v", err)
+	}
+	if err := a.Verify(entries, o); err != nil {
+		return err
+	}
+	if o.Price != record.Price || (o.Winner >= 0 && entries[o.Winner].Bidder != record.Winner) || (o.Winner < 0 && record.Winner != "") {
+		return fmt.Errorf("the outcome does not match the winner of auction %s", id)
+	}
+	return nil
+}
+
+/*
+AttestAuction 验证方verifier重新验证拍卖结果后签名 id|winner|price|SM3(outcome)，
+链码用verifier登记的公钥验证签名后才允许付款，没有中标者时商品恢复在售
+*/
+func (c *Contract) AttestAuction(id string, verifier string) ([]byte, error) {
+	if err := c.VerifyAuction(id); err != nil {
+		return nil, err
+	}
+	record, sign, err := c.verifierSign(id, verifier, "")
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.contract.SubmitTransaction("AttestAuction", record.ID, hex.EncodeToString(sign))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction AttestAuction:%v", err)
+	}
+	return res, nil
+}
+
+/*
+RejectAuction 验证方verifier重新验证拍卖结果不通过时签名 id|winner|price|SM3(outcome)|reject 驳回结果，
+拍卖回到open，由拍卖方重新公布结果；结果能通过验证时不能驳回
+*/
+func (c *Contract) RejectAuction(id string, verifier string) ([]byte, error) {
+	if c.VerifyAuction(id) == nil {
+		return nil, fmt.Errorf("the outcome of auction %s verifies", id)
+	}
+	record, sign, err := c.verifierSign(id, verifier, "|reject")
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.contract.SubmitTransaction("RejectAuction", record.ID, hex.EncodeToString(sign))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction RejectAuction:%v", err)
+	}
+	return res, nil
+}
+
+// verifierSign 由拍卖的verifier对等待验证的结果签名，消息为 auctionMessage 后接suffix
+func (c *Contract) verifierSign(id string, verifier string, suffix string) (*Auction, []byte, error) {
+	_, record, err := c.auction(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if record.Status != "closed" {
+		return nil, nil, fmt.Errorf("the auction %s is not awaiting attestation", id)
+	}
+	address := utils.GetAddress(verifier)
+	if address == "" || address != record.Verifier {
+		return nil, nil, fmt.Errorf("%s is not the verifier of auction %s", verifier, id)
+	}
+	pri := utils.ReadPriKey(verifier)
+	if pri == nil {
+		return nil, nil, fmt.Errorf("failed to read the private key of %s", verifier)
+	}
+	uid, err := utils.UIDFromAddress(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := append(auctionMessage(record.ID, record.Winner, record.Price, record.Outcome), []byte(suffix)...)
+	sign, err := sm2.Sign(pri, uid, msg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign outcome:%v", err)
+	}
+	return record, sign, nil
+}
+
+// auctionMessage 返回拍卖方公布结果和verifier签名的消息 id|winner|price|SM3(outcome)，见链码 auctionAttestation
+func auctionMessage(id string, winner string, price int64, outcome string) []byte {
+	h := sm3.New()
+	h.Write([]byte(outcome))
+	return []byte(fmt.Sprintf("%s|%s|%d|%s", id, winner, price, hex.EncodeToString(h.Sum(nil))))
+}
+
+// SettleAuction 中标者以中标价走保密交易流程向卖方付款，订单号记入拍卖，返回订单号
+func (c *Contract) SettleAuction(id string) (string, error) {
+	settleMu.Lock()
+	defer settleMu.Unlock()
+	if err := c.VerifyAuction(id); err != nil {
+		return "", err
+	}
+	_, record, err := c.auction(id)
+	if err != nil {
+		return "", err
+	}
+	if record.Status != "attested" || record.Winner == "" {
+		return "", fmt.Errorf("the auction %s is not awaiting settlement", id)
+	}
+	buyer, err := utils.FindUserByAddress(record.Winner)
+	if err != nil {
+		return "", err
+	}
+	seller, err := utils.FindUserByAddress(record.Seller)
+	if err != nil {
+		return "", err
+	}
+	orderNum, err := c.settle(buyer, seller, record.Price, record.GoodId)
+	if err != nil {
+		return orderNum, err
+	}
+	if _, err := c.contract.SubmitTransaction("SettleAuction", id, orderNum); err != nil {
+		return orderNum, fmt.Errorf("failed to submit transaction SettleAuction:%v", err)
+	}
+	return orderNum, nil
+}
+
+// auction 读取链上的拍卖，返回验证出价和结果用的 auction.Auction
+func (c *Contract) auction(id string) (auction.Auction, *Auction, error) {
+	res, err := c.GetAuction(id)
+	if err != nil {
+		return auction.Auction{}, nil, err
+	}
+	var record Auction
+	if err := json.Unmarshal(res, &record); err != nil {
+		return auction.Auction{}, nil, fmt.Errorf("failed to unmarshal auction:%v", err)
+	}
+	key_bytes, err := base64.StdEncoding.DecodeString(record.Key)
+	if err != nil {
+		return auction.Auction{}, nil, fmt.Errorf("failed to decode auctioneer key:%v", err)
+	}
+	var key *sm2.PublicKey
+	if err := json.Unmarshal(key_bytes, &key); err != nil {
+		return auction.Auction{}, nil, fmt.Errorf("failed to unmarshal auctioneer key:%v", err)
+	}
+	return auction.Auction{ID: record.ID, Key: key, Reserve: record.Reserve}, &record, nil
+}
+
+// auctionEntries 按出价顺序读取链上的出价
+func (c *Contract) auctionEntries(id string) ([]auction.Entry, error) {
+	res, err := c.GetBids(id)
+	if err != nil {
+		return nil, err
+	}
+	var bids []AuctionBid
+	if len(res) != 0 {
+		if err := json.Unmarshal(res, &bids); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal bids:%v", err)
+		}
+	}
+	entries := make([]auction.Entry, len(bids))
+	for i, b := range bids {
+		if b.Seq != int64(i) {
+			return nil, fmt.Errorf("bid %d of auction %s is out of order", b.Seq, id)
+		}
+		e := auction.Entry{Bidder: b.Bidder}
+		for _, v := range []struct {
+			str string
+			to  *[]byte
+		}{{b.Cipher, &e.Cipher}, {b.Comm, &e.Comm}, {b.Range, &e.Range}, {b.Link, &e.Link}} {
+			if *v.to, err = hex.DecodeString(v.str); err != nil {
+				return nil, fmt.Errorf("failed to decode bid %d:%v", b.Seq, err)
+			}
+		}
+		entries[i] = e
+	}
+	return entries, nil
+}
@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"server/utils"
+
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+// AuditRecord 链上的审计记录，见链码 SetAuditRecord
+type AuditRecord struct {
+	OrderNum string `json:"orderNum"`
+	Auditor  string `json:"auditor"`
+	MSPID    string `json:"mspId"`
+	Reason   string `json:"reason"`
+	Digest   string `json:"digest"`
+	TxID     string `json:"txId"`
+	Time     int64  `json:"time"`
+}
+
+/*
+AuditResult 对一个订单的审计结果，只返回给发起审计的监管方，不上链
+LinkSignErr 为空表示两个可链接环签名验证通过且由同一签名者生成
+链上记录的Digest是不含Record时结果JSON的SM3摘要
+*/
+type AuditResult struct {
+	OrderNum      string       `json:"orderNum"`
+	BuyerAddress  string       `json:"buyerAddress"`
+	SellerAddress string       `json:"sellerAddress"`
+	Buyer         string       `json:"buyer"`
+	Seller        string       `json:"seller"`
+	LinkSignValid bool         `json:"linkSignValid"`
+	LinkSignErr   string       `json:"linkSignErr,omitempty"`
+	Record        *AuditRecord `json:"record,omitempty"`
+}
+
+/*
+AuditOrder 监管方对订单去匿名化：
+用CA私钥解密买卖双方地址，找到对应用户并验证可链接环签名，
+然后先把审计记录（谁、何时、原因、结果摘要）写入账本，写入成功后才返回结果，
+保证每一次去匿名化都在链上留痕
+*/
+func (c *Contract) AuditOrder(orderNum string, auditor string, reason string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetOrder", orderNum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state:%v", err)
+	}
+	var order Order
+	if err := json.Unmarshal(res, &order); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal order:%v", err)
+	}
+	if order.Enc_S_Add_A == "" || order.Enc_S_Add_B == "" {
+		return nil, fmt.Errorf("the order %s has not been submitted", orderNum)
+	}
+
+	pri_CA := utils.ReadPriKey("CA")
+	if pri_CA == nil {
+		return nil, fmt.Errorf("failed to read CA private key")
+	}
+	add_A, err := decryptAddress(pri_CA, order.Enc_S_Add_A)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt add_A:%v", err)
+	}
+	add_B, err := decryptAddress(pri_CA, order.Enc_S_Add_B)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt add_B:%v", err)
+	}
+
+	result := AuditResult{
+		OrderNum:      orderNum,
+		BuyerAddress:  string(add_A),
+		SellerAddress: string(add_B),
+	}
+	result.Buyer, _ = utils.FindUserByAddress(result.BuyerAddress)
+	result.Seller, _ = utils.FindUserByAddress(result.SellerAddress)
+	if result.Buyer == "" {
+		result.LinkSignErr = "buyer public key not found"
+	} else if ring_pubs, err := c.orderRing(utils.ReadPubKey(result.Buyer)); err != nil {
+		result.LinkSignErr = err.Error()
+	} else if err := verifyLinkSigns(&order, ring_pubs); err != nil {
+		result.LinkSignErr = err.Error()
+	}
+	result.LinkSignValid = result.LinkSignErr == ""
+
+	findings, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audit result:%v", err)
+	}
+	h := sm3.New()
+	h.Write(findings)
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	record, err := c.contract.SubmitTransaction("SetAuditRecord", orderNum, auditor, reason, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction SetAuditRecord:%v", err)
+	}
+	result.Record = &AuditRecord{}
+	if err := json.Unmarshal(record, result.Record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal audit record:%v", err)
+	}
+	return json.Marshal(result)
+}
+
+// GetAuditRecords 查询订单的审计记录
+func (c *Contract) GetAuditRecords(orderNum string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetAuditRecords", orderNum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Evaluate transaction: %v", err)
+	}
+	return res, nil
+}
+
+func decryptAddress(pri *sm2.PrivateKey, enc string) ([]byte, error) {
+	enc_bytes, err := hex.DecodeString(enc)
+	if err != nil {
+		return nil, err
+	}
+	return sm2.Decrypt(pri, enc_bytes, sm2.C1C3C2)
+}
@@ -2,9 +2,11 @@ package blockchain
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -12,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	bullet "server/bulletproof/src"
+	"server/keyswitch"
 	"server/utils"
 	"strconv"
 	"sync"
@@ -22,15 +25,39 @@ import (
 	"github.com/hyperledger/fabric-sdk-go/pkg/gateway"
 )
 
+// ledger 链码的调用接口，由gateway.Contract实现
+type ledger interface {
+	SubmitTransaction(name string, args ...string) ([]byte, error)
+	EvaluateTransaction(name string, args ...string) ([]byte, error)
+}
+
 type Contract struct {
-	contract *gateway.Contract
+	contract ledger
 }
 
+// 范围证明的比特长度：交易金额RP(m)与转账后余额RP(b)，均在SM2曲线上
+// 两者聚合为一个证明，每个值占balanceBits位；撮合成交的金额为单价×电量，须小于2^priceBits
+const (
+	priceBits   = 32
+	balanceBits = 64
+	orderRPBits = 2 * balanceBits
+)
+
+// ErrInsufficientBalance 买方余额不足以支付交易金额，无法生成余额的范围证明
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
 type Wallet struct {
 	Address string `json:"address"`
 	Balance string `json:"balance"`
 }
 
+// KeyRecord 链上登记的公钥，UID为SM2签名的用户标识(hex)，由钱包地址派生
+type KeyRecord struct {
+	Address string `json:"address"`
+	Pub     string `json:"pub"`
+	UID     string `json:"uid"`
+}
+
 /*
 商品结构体
 ID、Owner(拥有者)、价格（Price）、Amount（数量）
@@ -41,6 +68,13 @@ type Goods struct {
 	Price  int64  `json:"price"`
 	Amount int64  `json:"amount"`
 	Status int64  `json:"status"`
+	Meter  string `json:"meter"` //电量来源的电表ID
+	// 交割时段[DeliveryStart, DeliveryEnd)、起始结算时段编号、电网分区和分时电价时段，见链码 checkDelivery
+	DeliveryStart int64  `json:"deliveryStart"`
+	DeliveryEnd   int64  `json:"deliveryEnd"`
+	Period        int64  `json:"period"`
+	Zone          string `json:"zone"`
+	Band          string `json:"band"`
 }
 type Proposal struct {
 	OrderNum   string `json:"orderNum"`
@@ -75,20 +109,31 @@ type Order struct {
 	CommA        string `json:"commA"`        //买方对价格的承诺
 	Seller_Opt   int64  `json:"seller_opt"`   //卖方对方案的确认
 	Sign_CommA   string `json:"sign_commA"`   //对承诺的签名
-	RP_m         string `json:"rp_m"`         //交易金额大于0的承诺
-	RP_b         string `json:"rp_b"`         //余额不小于0的承诺
+	Enc_R_B      string `json:"enc_r_b"`      //用买方公钥加密的卖方承诺盲化因子
+	Proof_Eq     string `json:"proof_eq"`     //CommA与CommB承诺同一价格的零知识证明
+	RP           string `json:"rp"`           //聚合范围证明：交易金额RP(m)与余额不小于0的RP(b)
+	Proof_B      string `json:"proof_b"`      //RP中余额的承诺与Enc_A_B加密同一余额的证明
 	Link_sign_1  string `json:"link_sign_1"`  //可链接环签名1 Enc_A(m)||Enc_B(m)||Enc_A(b)
 	Link_sign_2  string `json:"link_sign_2"`  //可链接环签名2 Add_A||Add_B||OrderNum||Sign_B
 	Enc_B_M      string `json:"enc_b_m"`      //卖方公钥加密价格
 	Enc_B_B      string `json:"enc_b_b"`      //卖方加密余额
-	Enc_A_M      string `json:"enc_a_m"`      //买方公钥加密价格
+	Enc_A_M      string `json:"enc_a_m"`      //买方公钥加密价格，由卖方从Enc_B_M密钥切换得到
+	Proof_KS     string `json:"proof_ks"`     //Enc_A_M与Enc_B_M加密同一价格的密钥切换证明
 	Enc_A_B      string `json:"enc_a_b"`      //卖方余额加密
 	Enc_S_Add_A  string `json:"enc_s_add_a"`  //用CA公钥加密买方地址
 	Enc_S_Add_B  string `json:"enc_s_add_b"`  //用CA公钥加密卖方地址
+	Enc_O_M      string `json:"enc_o_m"`      //市场运营方公钥加密价格，用于同态统计
 	Buyer        string `json:"buyer"`        //买方的地址
 	Seller       string `json:"seller"`       //卖方的地址
-	Pubs         string `json:"pubs"`         //环公钥
+	Pubs         string `json:"pubs"`         //环成员的哈希承诺，见utils.RingCommitment
 	Flag         bool   `json:"flag"`         //订单标志ture已完成 false未完成
+	Time         int64  `json:"time"`         //提交订单的交易时间戳(秒)
+	// 交割时段、分区和分时电价时段，从商品复制
+	DeliveryStart int64  `json:"deliveryStart"`
+	DeliveryEnd   int64  `json:"deliveryEnd"`
+	Period        int64  `json:"period"`
+	Zone          string `json:"zone"`
+	Band          string `json:"band"`
 }
 
 var instance *Contract
@@ -220,6 +265,10 @@ func (c *Contract) SetWallet(username string, amount int64) ([]byte, error) {
 	}
 	address := utils.GetAddress(username)
 	pub := utils.ReadPubKey(username)
+	//钱包地址须先在链上登记公钥，签名的UID由地址派生，验证方从登记中读取
+	if _, err := c.RegisterKey(pub); err != nil {
+		return nil, err
+	}
 	ctext, err := utils.EncryptAmount(amount, pub)
 	if err != nil {
 		return nil, err
@@ -231,6 +280,65 @@ func (c *Contract) SetWallet(username string, amount int64) ([]byte, error) {
 	return res, nil
 }
 
+// RegisterKey 在链上登记公钥，地址和UID由链码根据公钥计算
+func (c *Contract) RegisterKey(pub *sm2.PublicKey) (*KeyRecord, error) {
+	if pub == nil {
+		return nil, fmt.Errorf("failed to read public key")
+	}
+	pub_bytes, err := json.Marshal(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key:%v", err)
+	}
+	res, err := c.contract.SubmitTransaction("RegisterKey", base64.StdEncoding.EncodeToString(pub_bytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction RegisterKey: %v", err)
+	}
+	var record KeyRecord
+	if err := json.Unmarshal(res, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal key record:%v", err)
+	}
+	return &record, nil
+}
+
+/*
+registeredKey 从链上密钥登记读取地址对应的公钥和UID，验证签名时使用，
+不使用请求中的用户名，登记的地址、UID须与公钥一致
+*/
+func (c *Contract) registeredKey(address string) (*sm2.PublicKey, []byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetKey", address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to Evaluate transaction GetKey: %v", err)
+	}
+	var record KeyRecord
+	if err := json.Unmarshal(res, &record); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal key record:%v", err)
+	}
+	pub_bytes, err := base64.StdEncoding.DecodeString(record.Pub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode registered public key:%v", err)
+	}
+	var pub *sm2.PublicKey
+	if err := json.Unmarshal(pub_bytes, &pub); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal registered public key:%v", err)
+	}
+	uid, err := hex.DecodeString(record.UID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode registered uid:%v", err)
+	}
+	pub_address, err := utils.AddressOf(pub)
+	if err != nil {
+		return nil, nil, err
+	}
+	want_uid, err := utils.UIDFromAddress(pub_address)
+	if err != nil {
+		return nil, nil, err
+	}
+	if record.Address != address || pub_address != address || !bytes.Equal(uid, want_uid) {
+		return nil, nil, fmt.Errorf("the key registered for %s is inconsistent", address)
+	}
+	return pub, uid, nil
+}
+
 func (c *Contract) GetWallet(username string) ([]byte, error) {
 	address := utils.GetAddress(username)
 	result, err := c.contract.EvaluateTransaction("GetWallet", address)
@@ -278,6 +386,26 @@ func (c *Contract) GetGoodByOwner(owner string) ([]byte, error) {
 	return result, nil
 }
 
+/*
+GetGoodsByDelivery 查询交割时段起点在[from, to)内的商品，zone、band为空时不筛选
+*/
+func (c *Contract) GetGoodsByDelivery(from int64, to int64, zone string, band string) ([]byte, error) {
+	result, err := c.contract.EvaluateTransaction("GetGoodsByDelivery", strconv.FormatInt(from, 10), strconv.FormatInt(to, 10), zone, band)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+	return result, nil
+}
+
+// GetOrdersByDelivery 查询交割时段起点在[from, to)内的订单
+func (c *Contract) GetOrdersByDelivery(from int64, to int64, zone string, band string) ([]byte, error) {
+	result, err := c.contract.EvaluateTransaction("GetOrdersByDelivery", strconv.FormatInt(from, 10), strconv.FormatInt(to, 10), zone, band)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+	return result, nil
+}
+
 func (c *Contract) UpdateGoodPrice(id string, price_str string) ([]byte, error) {
 	result, err := c.contract.SubmitTransaction("UpdateGoodPrice", id, price_str)
 	if err != nil {
@@ -295,7 +423,8 @@ func (c *Contract) SetProposal(buyer string, seller string, price_str string, go
 		return nil, fmt.Errorf("failed to prase price int64:%v", err)
 	}
 	ctext, err := utils.EncryptAmount(price, pub)
-	args := buyer + seller + price_str
+	//订单号包含商品ID，同一对买卖双方以相同价格多次成交时订单号也不同
+	args := buyer + seller + price_str + goodId
 	h := sm3.New()
 	h.Write([]byte(args))
 	hashStr := base64.StdEncoding.EncodeToString(h.Sum(nil))
@@ -391,7 +520,11 @@ func (c *Contract) UpdateProposal(seller string, orderNum string, flag_str strin
 		sign_args := append([]byte(order.Enc_B_M), Enc_B_B...)
 		sign_args = append(sign_args, []byte(orderNum)...)
 		sign_args = append(sign_args, []byte(order.Buyer)...)
-		sign_b, err := sm2.Sign(pri_seller, []byte(seller), sign_args)
+		seller_uid, err := utils.UIDFromAddress(seller_address)
+		if err != nil {
+			return nil, err
+		}
+		sign_b, err := sm2.Sign(pri_seller, seller_uid, sign_args)
 		if err != nil {
 			return nil, fmt.Errorf("failed to sign Enc(m)||Enc(b)||proposalId||SenderAddress byte %v", err)
 		}
@@ -413,18 +546,39 @@ func (c *Contract) UpdateProposal(seller string, orderNum string, flag_str strin
 		amount := bigInt.Int64()
 
 		big_price := big.NewInt(amount)
-		comm, _ := bullet.PedersenCommit(big_price)
-		comm_bytes, err := utils.ECPointToBytes(&comm)
+		comm, opening, err := bullet.SM2Params(balanceBits).PedersenCommit(big_price)
+		if err != nil {
+			return nil, fmt.Errorf("failed to commit price:%v", err)
+		}
+		comm_bytes, err := utils.ECPointToBytes(bullet.SM2Params(balanceBits), &comm)
 		if err != nil {
 			return nil, fmt.Errorf("failed to Marshamal Ecpoint to Bytes%v", err)
 		}
 		comm_bytes_string := hex.EncodeToString(comm_bytes)
-		sign_comm, err := sm2.Sign(pri_seller, []byte(seller), comm_bytes)
+		sign_comm, err := sm2.Sign(pri_seller, seller_uid, comm_bytes)
 		if err != nil {
 			return nil, fmt.Errorf("failed to Sign Comm%v", err)
 		}
 		sign_comm_string := hex.EncodeToString(sign_comm)
-		res, err := c.contract.SubmitTransaction("SellerSetCommit", orderNum, comm_bytes_string, sign_comm_string)
+		//将盲化因子用买方公钥加密，买方据此证明CommA与CommB承诺同一价格
+		pub_buyer, _, err := c.registeredKey(order.Buyer)
+		if err != nil {
+			return nil, err
+		}
+		enc_r, err := sm2.Encrypt(pub_buyer, opening.Blinding.Bytes(), sm2.C1C3C2)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt blinding:%v", err)
+		}
+		//把Enc_B_M密钥切换到买方公钥下并给出证明，买方不再重新加密价格
+		Enc_A_M, proof_ks, err := keyswitch.Switch(pri_seller, pub_buyer, Enc_B_M_bytes, []byte(orderNum))
+		if err != nil {
+			return nil, fmt.Errorf("failed to switch price to buyer key:%v", err)
+		}
+		proof_ks_bytes, err := proof_ks.Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode key switch proof:%v", err)
+		}
+		res, err := c.contract.SubmitTransaction("SellerSetCommit", orderNum, comm_bytes_string, sign_comm_string, hex.EncodeToString(enc_r), hex.EncodeToString(Enc_A_M), hex.EncodeToString(proof_ks_bytes))
 		if err != nil {
 			return nil, fmt.Errorf("failed to Submit Transcation SetCommit:%v", err)
 		}
@@ -471,11 +625,22 @@ func (c *Contract) SubmitProposal(OrderNum string, buyer string, seller string,
 	if err := json.Unmarshal(orderres, &order); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal wallet %v", err)
 	}
+	if order.Seller != seller_address || order.Buyer != buyer_address {
+		return nil, fmt.Errorf("the order %s is not between %s and %s", OrderNum, buyer, seller)
+	}
 	signres := order.Sign_Confirm
 	pub := utils.ReadPubKey(buyer)
 	pri := utils.ReadPriKey(buyer)
+	buyer_uid, err := utils.UIDFromAddress(buyer_address)
+	if err != nil {
+		return nil, err
+	}
 
-	pub1 := utils.ReadPubKey(seller)
+	//卖方公钥和UID取自链上登记
+	pub1, seller_uid, err := c.registeredKey(order.Seller)
+	if err != nil {
+		return nil, err
+	}
 
 	sign, err := hex.DecodeString(signres)
 	if err != nil {
@@ -491,7 +656,7 @@ func (c *Contract) SubmitProposal(OrderNum string, buyer string, seller string,
 	b = append(b, []byte(OrderNum)...)
 	b = append(b, []byte(order.Buyer)...)
 	//verify signature
-	v := sm2.Verify(pub1, []byte(seller), b, sign)
+	v := sm2.Verify(pub1, seller_uid, b, sign)
 	if !v {
 		return nil, fmt.Errorf("failed to verify signature")
 	}
@@ -508,46 +673,81 @@ func (c *Contract) SubmitProposal(OrderNum string, buyer string, seller string,
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse price to int:%v", err)
 	}
-	Enc_A_M_string, err := utils.EncryptAmount(price, pub)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt price:%v", err)
+	//使用卖方密钥切换得到的Enc_A_M：先验证切换证明，再确认它就是自己出的价格
+	if err := verifyKeySwitch(&order, pub1, pub); err != nil {
+		return nil, err
 	}
+	Enc_A_M_string := order.Enc_A_M
 	Enc_A_M, err := hex.DecodeString(Enc_A_M_string)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode Enc_A_M_string:%v", err)
 	}
+	if ok, err := keyswitch.Opens(pri, Enc_A_M, big.NewInt(price)); err != nil || !ok {
+		return nil, fmt.Errorf("the key-switched price does not match the proposal")
+	}
 	Enc_A_B, err := utils.CiperSub(pub.Curve, buyer_balance, Enc_A_M)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sub ciper text:%v", err)
 	}
 	Enc_A_B_string := hex.EncodeToString(Enc_A_B)
+	//用市场运营方公钥加密价格，运营方只解密统计周期内的同态和
+	pub_operator := utils.ReadPubKey("Operator")
+	if pub_operator == nil {
+		return nil, fmt.Errorf("failed to read operator public key")
+	}
+	Enc_O_M_string, err := utils.EncryptAmount(price, pub_operator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt price for operator:%v", err)
+	}
 
 	big_price := big.NewInt(price)
-	comm, _ := bullet.PedersenCommit(big_price)
-	comm_bytes, err := utils.ECPointToBytes(&comm)
+	comm, opening, err := bullet.SM2Params(balanceBits).PedersenCommit(big_price)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit price:%v", err)
+	}
+	comm_bytes, err := utils.ECPointToBytes(bullet.SM2Params(balanceBits), &comm)
 	if err != nil {
 		return nil, fmt.Errorf("failed to Marshamal Ecpoint to Bytes%v", err)
 	}
 	comm_bytes_string := hex.EncodeToString(comm_bytes)
-	sign_commA, err := sm2.Sign(pri, []byte(buyer), comm_bytes)
+	sign_commA, err := sm2.Sign(pri, buyer_uid, comm_bytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to Sign Comm%v", err)
 	}
 	sign_commA_string := hex.EncodeToString(sign_commA)
 
-	_, err = c.contract.SubmitTransaction("BuyerSetCommit", OrderNum, comm_bytes_string, sign_commA_string)
+	//证明CommA与卖方的CommB承诺同一价格
+	enc_r, err := hex.DecodeString(order.Enc_R_B)
 	if err != nil {
-		return nil, fmt.Errorf("failed to submit transcation BuyerSetComit:%v", err)
+		return nil, fmt.Errorf("failed to decode enc_r_b:%v", err)
 	}
-	//生成交易金额大于零零知识证明证据RP(m)
-	bullet.EC = bullet.NewECPrimeGroupKey(8)
-	prove := bullet.RPProve(big.NewInt(price))
-	prove_bytes, err := utils.RangeProofToBytes(&prove)
+	r_b, err := sm2.Decrypt(pri, enc_r, sm2.C1C3C2)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode prove_bytes%v", err)
+		return nil, fmt.Errorf("failed to decrypt seller blinding:%v", err)
 	}
-	prove_bytes_string := hex.EncodeToString(prove_bytes)
-	//生成转账方交易余额大于零零知识证明证据RP(b)
+	commB_bytes, err := hex.DecodeString(order.CommB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode commB:%v", err)
+	}
+	CommB, err := utils.BytesToEcpoint(bullet.SM2Params(balanceBits), commB_bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to return commB:%v", err)
+	}
+	params := bullet.SM2Params(balanceBits)
+	seller_opening := bullet.Opening{Value: big_price, Blinding: new(big.Int).SetBytes(r_b)}
+	if !params.VerifyOpening(*CommB, seller_opening) {
+		return nil, fmt.Errorf("seller commitment does not open to the price")
+	}
+	proof_eq, err := params.ProveEqualCommitments(opening, seller_opening, []byte(OrderNum))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prove commA==commB:%v", err)
+	}
+	proof_eq_bytes, err := utils.EqualityProofToBytes(params, &proof_eq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode proof_eq:%v", err)
+	}
+
+	//转账方交易余额，在提交任何交易之前生成范围证明，余额不足时链上状态不变
 	cipherTextByte, err := hex.DecodeString(wallet.Balance)
 	if err != nil {
 		return nil, fmt.Errorf("failed to DecodeString: %v", err)
@@ -560,32 +760,62 @@ func (c *Contract) SubmitProposal(OrderNum string, buyer string, seller string,
 	bigInt := new(big.Int).SetBytes(plaintext)
 	amount := bigInt.Int64()
 
-	bullet.EC = bullet.NewECPrimeGroupKey(64)
-	prove1 := bullet.RPProve(big.NewInt(amount - price))
-
-	prove1_bytes, err := utils.RangeProofToBytes(&prove1)
+	//生成交易金额RP(m)与余额RP(b)的聚合零知识证明，交易金额的承诺即CommA
+	balance_blinding, err := rand.Int(rand.Reader, params.N)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate blinding:%v", err)
+	}
+	prove, err := bullet.SM2Params(orderRPBits).MRPProveWithBlinding(
+		[]*big.Int{big_price, big.NewInt(amount - price)},
+		[]*big.Int{opening.Blinding, balance_blinding},
+		[]int{priceBits, balanceBits}, []byte(OrderNum))
+	if errors.Is(err, bullet.ErrOutOfRange) && amount < price {
+		return nil, ErrInsufficientBalance
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to prove range:%v", err)
+	}
+	prove_bytes, err := utils.MultiRangeProofToBytes(bullet.SM2Params(orderRPBits), &prove)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode prove1_bytes%v", err)
+		return nil, fmt.Errorf("failed to encode prove_bytes%v", err)
+	}
+	prove_bytes_string := hex.EncodeToString(prove_bytes)
+	//余额的承诺须与扣款后的余额密文Enc_A_B绑定，否则RP(b)与钱包无关
+	c1, c2, err := utils.CipherToEcpoints(Enc_A_B)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Enc_A_B:%v", err)
+	}
+	proof_b, err := bullet.SM2Params(orderRPBits).ProveBalance(pri.D, c1, c2,
+		bullet.Opening{Value: big.NewInt(amount - price), Blinding: balance_blinding}, []byte(OrderNum))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prove balance:%v", err)
+	}
+	proof_b_bytes, err := utils.BalanceProofToBytes(bullet.SM2Params(orderRPBits), &proof_b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode proof_b:%v", err)
 	}
-	prove1_bytes_string := hex.EncodeToString(prove1_bytes)
 
-	pubs, err := c.contract.EvaluateTransaction("GetRingPublicKeys")
+	_, err = c.contract.SubmitTransaction("BuyerSetCommit", OrderNum, comm_bytes_string, sign_commA_string, hex.EncodeToString(proof_eq_bytes))
 	if err != nil {
-		return nil, fmt.Errorf("failed to Evaluate Transcation GetRingPublicKeys: %v", err)
+		return nil, fmt.Errorf("failed to submit transcation BuyerSetComit:%v", err)
 	}
-	pubs_string := hex.EncodeToString(pubs)
-	ring_pubs, err := utils.DecodeKeys(pubs)
-	ring_pubs = append(ring_pubs, pub)
 
-	baseSigner := utils.NewBaseLinkableSigner(pri, ring_pubs)
+	ring_pubs, err := c.orderRing(pub)
+	if err != nil {
+		return nil, err
+	}
+	//订单只记录环成员的哈希承诺
+	ring_comm, err := utils.RingCommitment(ring_pubs)
 	if err != nil {
 		return nil, err
 	}
+	pubs_string := hex.EncodeToString(ring_comm)
+
 	//Enc_A(m)||Enc_B(m)||Enc_A(b)
 	sign1_args := append([]byte(Enc_A_M_string), Enc_B_M...)
 	sign1_args = append(sign1_args, Enc_A_B...)
 
-	link_sign1, err := utils.GenerateLinkSign(baseSigner, sign1_args)
+	link_sign1, err := utils.GenerateLinkSign(utils.DefaultLinkableScheme, pri, ring_pubs, sign1_args)
 	if err != nil {
 		return nil, fmt.Errorf("failed to GenerateSign: %v", err)
 	}
@@ -593,7 +823,7 @@ func (c *Contract) SubmitProposal(OrderNum string, buyer string, seller string,
 	sign2_args := append([]byte(order.Buyer), []byte(order.Seller)...)
 	sign2_args = append(sign2_args, []byte(order.OrderNum)...)
 	sign2_args = append(sign2_args, []byte(order.Sign_Confirm)...)
-	link_sign2, err := utils.GenerateLinkSign(baseSigner, sign2_args)
+	link_sign2, err := utils.GenerateLinkSign(utils.DefaultLinkableScheme, pri, ring_pubs, sign2_args)
 	if err != nil {
 		return nil, fmt.Errorf("failed to GenerateSign: %v", err)
 	}
@@ -610,8 +840,8 @@ func (c *Contract) SubmitProposal(OrderNum string, buyer string, seller string,
 		return nil, fmt.Errorf("failed to Encrypt: %v", err)
 	}
 	Enc_Add_B_string := hex.EncodeToString(Enc_Add_B)
-	// OrderNum , Enc_A_B , Enc_A_M , RP_m , RP_b , Link_sign_1 , Link_sign_2 , Enc_S_Add_B , Enc_S_Add_A , ring_string
-	res, err := c.contract.SubmitTransaction("SetOrder", OrderNum, Enc_A_B_string, Enc_A_M_string, prove_bytes_string, prove1_bytes_string, link_sign1, link_sign2, Enc_Add_B_string, Enc_Add_A_string, pubs_string)
+	// OrderNum , Enc_A_B , Enc_A_M , RP , Link_sign_1 , Link_sign_2 , Enc_S_Add_B , Enc_S_Add_A , ring_string , Enc_O_M , Proof_B
+	res, err := c.contract.SubmitTransaction("SetOrder", OrderNum, Enc_A_B_string, Enc_A_M_string, prove_bytes_string, link_sign1, link_sign2, Enc_Add_B_string, Enc_Add_A_string, pubs_string, Enc_O_M_string, hex.EncodeToString(proof_b_bytes))
 	if err != nil {
 		return nil, fmt.Errorf("failed to Submit Transcation SetSignature: %v", err)
 	}
@@ -628,8 +858,18 @@ func (c *Contract) UpdateOrder(OrderNum string, buyer string, seller string) ([]
 		return nil, fmt.Errorf("failed to unmarshal order:%v", err)
 	}
 	pri_CA := utils.ReadPriKey("CA")
-	pub_seller := utils.ReadPubKey(seller)
-	pub_buyer := utils.ReadPubKey(buyer)
+	//买卖双方的公钥和UID取自链上登记，订单中的地址须与请求中的用户一致
+	if order.Seller != utils.GetAddress(seller) || order.Buyer != utils.GetAddress(buyer) {
+		return nil, fmt.Errorf("the order %s is not between %s and %s", OrderNum, buyer, seller)
+	}
+	pub_seller, seller_uid, err := c.registeredKey(order.Seller)
+	if err != nil {
+		return nil, err
+	}
+	pub_buyer, buyer_uid, err := c.registeredKey(order.Buyer)
+	if err != nil {
+		return nil, err
+	}
 	add_A_bytes, err := hex.DecodeString(order.Enc_S_Add_A)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decoding add_A:%v", err)
@@ -675,17 +915,6 @@ func (c *Contract) UpdateOrder(OrderNum string, buyer string, seller string) ([]
 	if err != nil {
 		return nil, fmt.Errorf("failed to decoding enc_b_b:%v", err)
 	}
-	pubs, err := hex.DecodeString(order.Pubs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode pub string:%v", err)
-	}
-	ring_pubs, err := utils.DecodeKeys(pubs)
-	if err != nil {
-		return nil, err
-	}
-	ring_pubs = append(ring_pubs, pub_buyer)
-
-	baseVerifyer := utils.NewBaseLinkableVerfier(ring_pubs)
 	Enc_B_M_bytes, err := hex.DecodeString(order.Enc_B_M)
 	if err != nil {
 		return nil, fmt.Errorf("failed to Enc_B_M String%v", err)
@@ -698,32 +927,15 @@ func (c *Contract) UpdateOrder(OrderNum string, buyer string, seller string) ([]
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode Enc_A_B string:%v", err)
 	}
-	// sign1_args := append([]byte(Enc_A_M), Enc_B_M...)
-	// sign1_args = append(sign1_args, Enc_A_B...)
-
-	// link_sign1, err := utils.GenerateLinkSign(ring_pubs, pri, sign1_args)
-
-	sign1_args := append([]byte(order.Enc_A_M), Enc_B_M_bytes...)
-	sign1_args = append(sign1_args, Enc_A_B_bytes...)
-	flag1 := utils.LinkSignVerify(baseVerifyer, sign1_args, order.Link_sign_1)
-	if !flag1 {
-		return nil, fmt.Errorf("failed to verify link sign1")
-	}
-	// sign2_args := append([]byte(order.Buyer), []byte(order.Seller)...)
-	// sign2_args = append(sign2_args, []byte(order.OrderNum)...)
-	// sign2_args = append(sign2_args, []byte(order.Sign_Confirm)...)
-	// link_sign2, err := utils.GenerateLinkSign(ring_pubs, pri, sign2_args)
-	sign2_args := append([]byte(order.Buyer), []byte(order.Seller)...)
-	sign2_args = append(sign2_args, []byte(OrderNum)...)
-	sign2_args = append(sign2_args, []byte(order.Sign_Confirm)...)
-	flag2 := utils.LinkSignVerify(baseVerifyer, sign2_args, order.Link_sign_2)
-	if !flag2 {
-		return nil, fmt.Errorf("failed to verify link sign2")
+	if err := verifyKeySwitch(&order, pub_seller, pub_buyer); err != nil {
+		return nil, err
+	}
+	ring_pubs, err := c.orderRing(pub_buyer)
+	if err != nil {
+		return nil, err
 	}
-	link_sing1 := utils.DecodeSignature(order.Link_sign_1)
-	link_sing2 := utils.DecodeSignature(order.Link_sign_2)
-	if !utils.Linkable(link_sing1, link_sing2) {
-		return nil, fmt.Errorf("signature linkable failure")
+	if err := verifyLinkSigns(&order, ring_pubs); err != nil {
+		return nil, err
 	}
 	Enc_A_B_, err := utils.CiperSub(pub_buyer.Curve, enc_a_b_bytes, Enc_A_M_bytes)
 	if err != nil {
@@ -748,7 +960,7 @@ func (c *Contract) UpdateOrder(OrderNum string, buyer string, seller string) ([]
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode enc_b_b:%v", err)
 	}
-	v := sm2.Verify(pub_seller, []byte(seller), sign_args, sign)
+	v := sm2.Verify(pub_seller, seller_uid, sign_args, sign)
 	if !v {
 		return nil, fmt.Errorf("failed to verify sign_confirm")
 	}
@@ -775,7 +987,7 @@ func (c *Contract) UpdateOrder(OrderNum string, buyer string, seller string) ([]
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode sign_comm_a:%v", err)
 	}
-	if !sm2.Verify(pub_buyer, []byte(buyer), commA_bytes, sign_commA_bytes) {
+	if !sm2.Verify(pub_buyer, buyer_uid, commA_bytes, sign_commA_bytes) {
 		return nil, fmt.Errorf("failed to verify sign_commA")
 	}
 	// big_price := big.NewInt(amount)
@@ -794,47 +1006,72 @@ func (c *Contract) UpdateOrder(OrderNum string, buyer string, seller string) ([]
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode sign_comm_b:%v", err)
 	}
-	if !sm2.Verify(pub_seller, []byte(seller), commB_bytes, sign_commB_bytes) {
+	if !sm2.Verify(pub_seller, seller_uid, commB_bytes, sign_commB_bytes) {
 		return nil, fmt.Errorf("failed to verify sign_commB")
 	}
 
-	CommA, err := utils.BytesToEcpoint(commA_bytes)
+	CommA, err := utils.BytesToEcpoint(bullet.SM2Params(balanceBits), commA_bytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to return commA:%v", err)
 	}
-	CommB, err := utils.BytesToEcpoint(commB_bytes)
+	CommB, err := utils.BytesToEcpoint(bullet.SM2Params(balanceBits), commB_bytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to return commB:%v", err)
 	}
-	inv_commA := CommA.Neg()
-	inv_commB := CommB.Neg()
-	if !inv_commA.Add(*CommB).Equal(inv_commB.Add(*CommA)) {
+	proof_eq_bytes, err := hex.DecodeString(order.Proof_Eq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode proof_eq:%v", err)
+	}
+	proof_eq, err := utils.BytesToEqualityProof(bullet.SM2Params(balanceBits), proof_eq_bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to return proof_eq:%v", err)
+	}
+	eq, err := bullet.SM2Params(balanceBits).VerifyEqualCommitments(*CommA, *CommB, *proof_eq, []byte(order.OrderNum))
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify proof_eq:%v", err)
+	}
+	if !eq {
 		return nil, fmt.Errorf("commA!=commB")
 	}
 
-	bullet.EC = bullet.NewECPrimeGroupKey(8)
-	prove_bytes, err := hex.DecodeString(order.RP_m)
+	prove_bytes, err := hex.DecodeString(order.RP)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode rp_m:%v", err)
+		return nil, fmt.Errorf("failed to decode rp:%v", err)
 	}
-	prove, err := utils.BytesToRangeProof(prove_bytes)
+	prove, err := utils.BytesToMultiRangeProof(bullet.SM2Params(orderRPBits), prove_bytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to return prove:%v", err)
 	}
-	if !bullet.RPVerify(*prove) {
-		return nil, fmt.Errorf("rp_m range proof failure")
+	if len(prove.Comms) != 2 || !prove.Comms[0].Equal(*CommA) {
+		return nil, fmt.Errorf("rp is not over commA")
 	}
-	bullet.EC = bullet.NewECPrimeGroupKey(64)
-	prove1_bytes, err := hex.DecodeString(order.RP_b)
+	inRange, err := bullet.SM2Params(orderRPBits).MRPVerifyBits(*prove, []int{priceBits, balanceBits}, []byte(order.OrderNum))
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode rp_b:%v", err)
+		return nil, fmt.Errorf("failed to verify rp:%v", err)
 	}
-	prove1, err := utils.BytesToRangeProof(prove1_bytes)
+	if !inRange {
+		return nil, fmt.Errorf("rp range proof failure")
+	}
+	//RP(b)须是扣款后余额Enc_A_B的承诺
+	proof_b_bytes, err := hex.DecodeString(order.Proof_B)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode proof_b:%v", err)
+	}
+	proof_b, err := utils.BytesToBalanceProof(bullet.SM2Params(orderRPBits), proof_b_bytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to return prove1:%v", err)
+		return nil, fmt.Errorf("failed to return proof_b:%v", err)
 	}
-	if !bullet.RPVerify(*prove1) {
-		return nil, fmt.Errorf("rp_b range proof failure")
+	c1, c2, err := utils.CipherToEcpoints(Enc_A_B_bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Enc_A_B:%v", err)
+	}
+	P := bullet.ECPoint{X: pub_buyer.X, Y: pub_buyer.Y}
+	overBalance, err := bullet.SM2Params(orderRPBits).VerifyBalance(P, c1, c2, prove.Comms[1], *proof_b, []byte(order.OrderNum))
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify proof_b:%v", err)
+	}
+	if !overBalance {
+		return nil, fmt.Errorf("rp is not over the balance enc_a_b")
 	}
 	result1, err := c.contract.SubmitTransaction("UpdateWallet", string(add_A), order.Enc_A_B)
 	if err != nil {
@@ -850,3 +1087,82 @@ func (c *Contract) UpdateOrder(OrderNum string, buyer string, seller string) ([]
 	}
 	return append(result1, result2...), nil
 }
+
+// verifyKeySwitch 验证Enc_A_M是卖方把Enc_B_M密钥切换到买方公钥下得到的，两者加密同一价格
+func verifyKeySwitch(order *Order, pub_seller *sm2.PublicKey, pub_buyer *sm2.PublicKey) error {
+	Enc_B_M, err := hex.DecodeString(order.Enc_B_M)
+	if err != nil {
+		return fmt.Errorf("failed to decode Enc_B_M:%v", err)
+	}
+	Enc_A_M, err := hex.DecodeString(order.Enc_A_M)
+	if err != nil {
+		return fmt.Errorf("failed to decode Enc_A_M:%v", err)
+	}
+	proof_bytes, err := hex.DecodeString(order.Proof_KS)
+	if err != nil {
+		return fmt.Errorf("failed to decode key switch proof:%v", err)
+	}
+	proof, err := keyswitch.ParseProof(proof_bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse key switch proof:%v", err)
+	}
+	if err := keyswitch.Verify(pub_seller, pub_buyer, Enc_B_M, Enc_A_M, proof, []byte(order.OrderNum)); err != nil {
+		return fmt.Errorf("failed to verify key switch:%v", err)
+	}
+	return nil
+}
+
+/*
+orderRing 构造订单使用的环：链上的环公钥加上买方公钥，排序去重后买方在环中的位置不再固定。
+环公钥变化后旧订单的环承诺将无法匹配
+*/
+func (c *Contract) orderRing(pub_buyer *sm2.PublicKey) ([]*sm2.PublicKey, error) {
+	pubs, err := c.contract.EvaluateTransaction("GetRingPublicKeys")
+	if err != nil {
+		return nil, fmt.Errorf("failed to Evaluate Transcation GetRingPublicKeys: %v", err)
+	}
+	ring_pubs, err := utils.DecodeKeys(pubs)
+	if err != nil {
+		return nil, err
+	}
+	return utils.CanonicalRing(append(ring_pubs, pub_buyer))
+}
+
+// verifyLinkSigns 检查环与订单的环承诺一致，并验证两个可链接环签名出自同一签名者
+func verifyLinkSigns(order *Order, ring_pubs []*sm2.PublicKey) error {
+	ring_comm, err := utils.RingCommitment(ring_pubs)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(ring_comm) != order.Pubs {
+		return fmt.Errorf("ring does not match the order's ring commitment")
+	}
+
+	Enc_B_M_bytes, err := hex.DecodeString(order.Enc_B_M)
+	if err != nil {
+		return fmt.Errorf("failed to Enc_B_M String%v", err)
+	}
+	Enc_A_B_bytes, err := hex.DecodeString(order.Enc_A_B)
+	if err != nil {
+		return fmt.Errorf("failed to decode Enc_A_B string:%v", err)
+	}
+	//Enc_A(m)||Enc_B(m)||Enc_A(b)
+	sign1_args := append([]byte(order.Enc_A_M), Enc_B_M_bytes...)
+	sign1_args = append(sign1_args, Enc_A_B_bytes...)
+	link_sign1, err := utils.LinkSignVerify(ring_pubs, sign1_args, order.Link_sign_1)
+	if err != nil {
+		return fmt.Errorf("failed to verify link sign1:%v", err)
+	}
+	//Add_A||Add_B||OrderNum||Sign_B
+	sign2_args := append([]byte(order.Buyer), []byte(order.Seller)...)
+	sign2_args = append(sign2_args, []byte(order.OrderNum)...)
+	sign2_args = append(sign2_args, []byte(order.Sign_Confirm)...)
+	link_sign2, err := utils.LinkSignVerify(ring_pubs, sign2_args, order.Link_sign_2)
+	if err != nil {
+		return fmt.Errorf("failed to verify link sign2:%v", err)
+	}
+	if !link_sign1.Links(link_sign2) {
+		return fmt.Errorf("signature linkable failure")
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package blockchain
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"server/keyswitch"
+	"server/utils"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// fakeLedger keeps the state of the transactions used by the proposal flow
+// in memory, mirroring what the chaincode stores for each of them.
+type fakeLedger struct {
+	keys    map[string]KeyRecord
+	wallets map[string]Wallet
+	orders  map[string]*Order
+	ring    []string
+}
+
+func newFakeLedger(t *testing.T, ringSize int) *fakeLedger {
+	l := &fakeLedger{
+		keys:    map[string]KeyRecord{},
+		wallets: map[string]Wallet{},
+		orders:  map[string]*Order{},
+	}
+	for i := 0; i < ringSize; i++ {
+		_, pub, err := sm2.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub_bytes, _ := json.Marshal(pub)
+		l.ring = append(l.ring, base64.StdEncoding.EncodeToString(pub_bytes))
+	}
+	return l
+}
+
+func (l *fakeLedger) SubmitTransaction(name string, args ...string) ([]byte, error) {
+	switch name {
+	case "RegisterKey":
+		pub_bytes, err := base64.StdEncoding.DecodeString(args[0])
+		if err != nil {
+			return nil, err
+		}
+		var pub *sm2.PublicKey
+		if err := json.Unmarshal(pub_bytes, &pub); err != nil {
+			return nil, err
+		}
+		address, err := utils.AddressOf(pub)
+		if err != nil {
+			return nil, err
+		}
+		uid, _ := utils.UIDFromAddress(address)
+		record := KeyRecord{Address: address, Pub: args[0], UID: hex.EncodeToString(uid)}
+		l.keys[address] = record
+		return json.Marshal(record)
+	case "SetWallet":
+		l.wallets[args[0]] = Wallet{Address: args[0], Balance: args[1]}
+		return json.Marshal(l.wallets[args[0]])
+	case "SetProposal":
+		l.orders[args[0]] = &Order{OrderNum: args[0], Buyer: args[1], Seller: args[2], Enc_B_M: args[3], GoodId: args[4]}
+		return json.Marshal(l.orders[args[0]])
+	}
+	order, ok := l.orders[args[0]]
+	if !ok {
+		return nil, fmt.Errorf("the order %s does not exist", args[0])
+	}
+	switch name {
+	case "UpdateProposal":
+		order.Seller_Opt = 1
+	case "SetSignature":
+		order.Sign_Confirm, order.Enc_B_B = args[1], args[2]
+	case "SellerSetCommit":
+		order.CommB, order.Sign_CommB, order.Enc_R_B, order.Enc_A_M, order.Proof_KS = args[1], args[2], args[3], args[4], args[5]
+	case "BuyerSetCommit":
+		order.CommA, order.Sign_CommA, order.Proof_Eq = args[1], args[2], args[3]
+	case "SetOrder":
+		order.Enc_A_B, order.Enc_A_M, order.RP = args[1], args[2], args[3]
+		order.Link_sign_1, order.Link_sign_2 = args[4], args[5]
+		order.Enc_S_Add_B, order.Enc_S_Add_A, order.Pubs = args[6], args[7], args[8]
+		order.Enc_O_M, order.Proof_B = args[9], args[10]
+	default:
+		return nil, fmt.Errorf("unexpected transaction %s", name)
+	}
+	return json.Marshal(order)
+}
+
+func (l *fakeLedger) EvaluateTransaction(name string, args ...string) ([]byte, error) {
+	switch name {
+	case "GetKey":
+		record, ok := l.keys[args[0]]
+		if !ok {
+			return nil, fmt.Errorf("the key %s is not registered", args[0])
+		}
+		return json.Marshal(record)
+	case "GetWallet":
+		return json.Marshal(l.wallets[args[0]])
+	case "GetProposal":
+		return json.Marshal(l.orders[args[0]])
+	case "GetRingPublicKeys":
+		return json.Marshal(l.ring)
+	}
+	return nil, fmt.Errorf("unexpected transaction %s", name)
+}
+
+// TestProposalFlow drives the seller's acceptance and the buyer's order for a
+// buyer whose key is registered but is not one of the ledger's ring keys.
+func TestProposalFlow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proposal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}()
+	for _, user := range []string{"CA", "Operator"} {
+		if err := utils.KeyGen(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := newFakeLedger(t, 5)
+	c := &Contract{contract: l}
+	if _, err := c.SetWallet("seller", 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SetWallet("buyer", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SetProposal("buyer", "seller", "30", "good1"); err != nil {
+		t.Fatal(err)
+	}
+	var orderNum string
+	for id := range l.orders {
+		orderNum = id
+	}
+
+	if _, err := c.UpdateProposal("seller", orderNum, "1"); err != nil {
+		t.Fatalf("UpdateProposal: %v", err)
+	}
+	order := l.orders[orderNum]
+	pub_seller, pub_buyer := utils.ReadPubKey("seller"), utils.ReadPubKey("buyer")
+	if err := verifyKeySwitch(order, pub_seller, pub_buyer); err != nil {
+		t.Fatal(err)
+	}
+	Enc_A_M, _ := hex.DecodeString(order.Enc_A_M)
+	if ok, err := keyswitch.Opens(utils.ReadPriKey("buyer"), Enc_A_M, big.NewInt(30)); err != nil || !ok {
+		t.Fatalf("the key-switched price does not open to 30 under the buyer key: %v", err)
+	}
+
+	if _, err := c.SubmitProposal(orderNum, "buyer", "seller", "30"); err != nil {
+		t.Fatalf("SubmitProposal: %v", err)
+	}
+	ring_pubs, err := c.orderRing(pub_buyer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyLinkSigns(order, ring_pubs); err != nil {
+		t.Error(err)
+	}
+}
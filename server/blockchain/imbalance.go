@@ -0,0 +1,235 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"server/imbalance"
+	"server/utils"
+	"strconv"
+)
+
+// Imbalance 链上的偏差结算，见链码 ComputeImbalance、SettleImbalance
+type Imbalance struct {
+	OrderNum     string `json:"orderNum"`
+	Meter        string `json:"meter"`
+	Contracted   int64  `json:"contracted"`
+	Delivered    int64  `json:"delivered"`
+	Shortfall    int64  `json:"shortfall"`
+	Excess       int64  `json:"excess"`
+	Band         string `json:"band"`
+	Rate         int64  `json:"rate"`
+	Amount       int64  `json:"amount"`
+	Payer        string `json:"payer"`
+	Payee        string `json:"payee"`
+	PayerCipher  string `json:"payerCipher"`
+	PayerK       string `json:"payerK"`
+	PayeeCipher  string `json:"payeeCipher"`
+	PayeeK       string `json:"payeeK"`
+	PayerBalance string `json:"payerBalance"`
+	PayeeBalance string `json:"payeeBalance"`
+	Comm         string `json:"comm"`
+	Range        string `json:"range"`
+	Proof        string `json:"proof"`
+	TxID         string `json:"txId"`
+	Time         int64  `json:"time"`
+}
+
+// SetImbalanceTariff 设置分时时段band的偏差电价，band为空时为未单独定价时段的电价
+func (c *Contract) SetImbalanceTariff(band string, t imbalance.Tariff) ([]byte, error) {
+	if t.Shortfall < 0 || t.Excess < 0 || t.Shortfall > imbalance.MaxAmount || t.Excess > imbalance.MaxAmount {
+		return nil, fmt.Errorf("invalid tariff %d/%d", t.Shortfall, t.Excess)
+	}
+	res, err := c.contract.SubmitTransaction("SetImbalanceTariff", band,
+		strconv.FormatInt(t.Shortfall, 10), strconv.FormatInt(t.Excess, 10))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction SetImbalanceTariff:%v", err)
+	}
+	return res, nil
+}
+
+func (c *Contract) GetImbalanceTariff(band string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetImbalanceTariff", band)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+	return res, nil
+}
+
+// ComputeImbalance 预览订单的偏差结算，不改变钱包
+func (c *Contract) ComputeImbalance(orderNum string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("ComputeImbalance", orderNum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+	return res, nil
+}
+
+func (c *Contract) GetImbalance(orderNum string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetImbalance", orderNum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+	return res, nil
+}
+
+/*
+SettleImbalance 结算订单的偏差：金额由链码按电表读数和偏差电价计算，
+付款方用私钥打开余额后扣款并证明扣款后余额不为负，收款方入账，均在双方登记的公钥下同态进行。
+提交前先验证两笔记账，与其它结算依次进行，保证付款方的余额是最新的
+*/
+func (c *Contract) SettleImbalance(orderNum string) ([]byte, error) {
+	settleMu.Lock()
+	defer settleMu.Unlock()
+	res, err := c.ComputeImbalance(orderNum)
+	if err != nil {
+		return nil, err
+	}
+	var record Imbalance
+	if err := json.Unmarshal(res, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal imbalance:%v", err)
+	}
+	if record.Amount == 0 {
+		res, err := c.contract.SubmitTransaction("SettleImbalance", orderNum, "", "", "", "", "", "", "", "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to submit transaction SettleImbalance:%v", err)
+		}
+		return res, nil
+	}
+	payer, err := utils.FindUserByAddress(record.Payer)
+	if err != nil {
+		return nil, err
+	}
+	pri := utils.ReadPriKey(payer)
+	if pri == nil {
+		return nil, fmt.Errorf("failed to read the private key of %s", payer)
+	}
+	pub_payer, _, err := c.registeredKey(record.Payer)
+	if err != nil {
+		return nil, err
+	}
+	pub_payee, _, err := c.registeredKey(record.Payee)
+	if err != nil {
+		return nil, err
+	}
+	payer_balance, err := c.walletBalance(record.Payer)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := utils.HomoDecrypt(pri, payer_balance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Decrypt balance: %v", err)
+	}
+	value := new(big.Int).SetBytes(plaintext).Int64()
+	context := imbalance.Context(orderNum)
+	debit, err := imbalance.Debit(pri, payer_balance, value, record.Amount, context)
+	if errors.Is(err, imbalance.ErrInsufficientBalance) {
+		return nil, ErrInsufficientBalance
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to debit %s:%v", payer, err)
+	}
+	// 付款方与收款方为同一钱包时，在扣款后的余额上入账，与链码一致
+	payee_balance := debit.Balance
+	if record.Payee != record.Payer {
+		if payee_balance, err = c.walletBalance(record.Payee); err != nil {
+			return nil, err
+		}
+	}
+	credit, err := imbalance.Credit(pub_payee, payee_balance, record.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to credit %s:%v", record.Payee, err)
+	}
+	if err := debit.VerifyDebit(pub_payer, payer_balance, record.Amount, context); err != nil {
+		return nil, fmt.Errorf("failed to verify debit:%v", err)
+	}
+	if err := credit.VerifyCredit(pub_payee, payee_balance, record.Amount); err != nil {
+		return nil, fmt.Errorf("failed to verify credit:%v", err)
+	}
+	res, err = c.contract.SubmitTransaction("SettleImbalance", orderNum,
+		hex.EncodeToString(debit.Cipher), hex.EncodeToString(debit.K),
+		hex.EncodeToString(credit.Cipher), hex.EncodeToString(credit.K),
+		hex.EncodeToString(debit.Balance), hex.EncodeToString(debit.Proof.Comm),
+		hex.EncodeToString(debit.Proof.Range), hex.EncodeToString(debit.Proof.Proof))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction SettleImbalance:%v", err)
+	}
+	return res, nil
+}
+
+/*
+VerifyImbalance 从链上记录重新验证偏差结算：金额须为偏差电量乘以电价，付款方须为少发时的卖方或多发时的买方，
+付款方扣款后的余额须有不为负的证明。记账密文与余额的同态运算已由链码检查
+*/
+func (c *Contract) VerifyImbalance(orderNum string) error {
+	res, err := c.GetImbalance(orderNum)
+	if err != nil {
+		return err
+	}
+	var record Imbalance
+	if err := json.Unmarshal(res, &record); err != nil {
+		return fmt.Errorf("failed to unmarshal imbalance:%v", err)
+	}
+	s, err := imbalance.Tariff{Shortfall: record.Rate, Excess: record.Rate}.Settle(record.Contracted, record.Delivered)
+	if err != nil {
+		return err
+	}
+	if s.Shortfall != record.Shortfall || s.Excess != record.Excess || s.Amount != record.Amount {
+		return fmt.Errorf("the imbalance of order %s is not priced at its rate", orderNum)
+	}
+	res, err = c.contract.EvaluateTransaction("GetOrder", orderNum)
+	if err != nil {
+		return fmt.Errorf("failed to read state:%v", err)
+	}
+	var order Order
+	if err := json.Unmarshal(res, &order); err != nil {
+		return fmt.Errorf("failed to unmarshal order:%v", err)
+	}
+	payer, payee := order.Buyer, order.Seller
+	if s.SellerPays {
+		payer, payee = order.Seller, order.Buyer
+	}
+	if record.Payer != payer || record.Payee != payee {
+		return fmt.Errorf("the imbalance of order %s is paid by the wrong party", orderNum)
+	}
+	if record.Amount == 0 {
+		return nil
+	}
+	pub_payer, _, err := c.registeredKey(record.Payer)
+	if err != nil {
+		return err
+	}
+	var proof imbalance.NonNegative
+	for _, v := range []struct {
+		str string
+		to  *[]byte
+	}{{record.Comm, &proof.Comm}, {record.Range, &proof.Range}, {record.Proof, &proof.Proof}} {
+		if *v.to, err = hex.DecodeString(v.str); err != nil {
+			return fmt.Errorf("failed to decode proof:%v", err)
+		}
+	}
+	balance, err := hex.DecodeString(record.PayerBalance)
+	if err != nil {
+		return fmt.Errorf("failed to decode payer balance:%v", err)
+	}
+	return proof.Verify(pub_payer, balance, imbalance.Context(orderNum))
+}
+
+// walletBalance 读取钱包余额密文
+func (c *Contract) walletBalance(address string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetWallet", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to Evaluate transaction: %v", err)
+	}
+	var wallet Wallet
+	if err := json.Unmarshal(res, &wallet); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal wallet %v", err)
+	}
+	balance, err := hex.DecodeString(wallet.Balance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to DecodeString: %v", err)
+	}
+	return balance, nil
+}
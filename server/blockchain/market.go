@@ -0,0 +1,191 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"server/market"
+	"server/utils"
+	"strconv"
+	"sync"
+)
+
+// 撮合成交的结算依次进行，商品ID在账本上递增分配
+var settleMu sync.Mutex
+
+// MatchAnchor 链上的撮合事件锚定，见链码 AnchorMatch
+type MatchAnchor struct {
+	Hash  string `json:"hash"`
+	Prev  string `json:"prev"`
+	Event string `json:"event"`
+	Order string `json:"order"`
+	Fills int64  `json:"fills"`
+	TxID  string `json:"txId"`
+	Time  int64  `json:"time"`
+}
+
+// AnchorMatch 把撮合引擎的一个事件锚定到链上，作为 market.Book 的 Anchor
+func (c *Contract) AnchorMatch(r market.Result) error {
+	_, err := c.contract.SubmitTransaction("AnchorMatch", hex.EncodeToString(r.Prev), hex.EncodeToString(r.Hash),
+		r.Event, r.Order.ID, strconv.Itoa(len(r.Fills)))
+	if err != nil {
+		return fmt.Errorf("failed to submit transaction AnchorMatch:%v", err)
+	}
+	return nil
+}
+
+func (c *Contract) GetMatchAnchor(hash string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetMatchAnchor", hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+	return res, nil
+}
+
+// CheckAsk 卖单进入订单簿前检查电表属于卖方，且尚未挂牌的余电足够
+func (c *Contract) CheckAsk(o market.Order) error {
+	res, err := c.GetMeter(o.Meter)
+	if err != nil {
+		return err
+	}
+	var m Meter
+	if err := json.Unmarshal(res, &m); err != nil {
+		return fmt.Errorf("failed to unmarshal meter:%v", err)
+	}
+	if m.Owner != utils.GetAddress(o.Owner) {
+		return fmt.Errorf("%s is not the owner of meter %s", o.Owner, o.Meter)
+	}
+	if o.Quantity > m.Metered-m.Listed {
+		return fmt.Errorf("the ask of %d kWh exceeds the metered surplus %d of meter %s", o.Quantity, m.Metered-m.Listed, o.Meter)
+	}
+	return nil
+}
+
+/*
+SettleFill 把一笔撮合成交交给已有的保密交易流程：
+卖方用电表余电创建交割时段为该结算时段的商品，然后依次
+SetProposal → UpdateProposal → SubmitProposal → UpdateOrder，交易金额为单价×电量。
+返回订单号
+*/
+func (c *Contract) SettleFill(f market.Fill) (string, error) {
+	settleMu.Lock()
+	defer settleMu.Unlock()
+	id, err := c.nextGoodID()
+	if err != nil {
+		return "", err
+	}
+	start := f.Period * market.PeriodSeconds
+	if _, err := c.CreateGoods(f.Seller, id, f.Meter, f.Price, f.Quantity, start, start+market.PeriodSeconds, f.Zone); err != nil {
+		return "", err
+	}
+	return c.settle(f.Buyer, f.Seller, f.Notional(), id)
+}
+
+// settle 以金额amount走保密交易流程：SetProposal → UpdateProposal → SubmitProposal → UpdateOrder，返回订单号
+func (c *Contract) settle(buyer string, seller string, amount int64, goodId string) (string, error) {
+	amount_str := strconv.FormatInt(amount, 10)
+	res, err := c.SetProposal(buyer, seller, amount_str, goodId)
+	if err != nil {
+		return "", err
+	}
+	var order Order
+	if err := json.Unmarshal(res, &order); err != nil {
+		return "", fmt.Errorf("failed to unmarshal order:%v", err)
+	}
+	if _, err := c.UpdateProposal(seller, order.OrderNum, "1"); err != nil {
+		return order.OrderNum, err
+	}
+	if _, err := c.SubmitProposal(order.OrderNum, buyer, seller, amount_str); err != nil {
+		return order.OrderNum, err
+	}
+	if _, err := c.UpdateOrder(order.OrderNum, buyer, seller); err != nil {
+		return order.OrderNum, err
+	}
+	return order.OrderNum, nil
+}
+
+// nextGoodID 返回比账本上已有商品ID大1的ID，GetAllGoods的范围为["10000", "11111")
+func (c *Contract) nextGoodID() (string, error) {
+	res, err := c.GetAllGoods()
+	if err != nil {
+		return "", err
+	}
+	var goods []Goods
+	if len(res) != 0 {
+		if err := json.Unmarshal(res, &goods); err != nil {
+			return "", fmt.Errorf("failed to unmarshal goods:%v", err)
+		}
+	}
+	next := 10000
+	for _, g := range goods {
+		if n, err := strconv.Atoi(g.ID); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	if next >= 11111 {
+		return "", fmt.Errorf("no free good id")
+	}
+	return strconv.Itoa(next), nil
+}
+
+// Clearing 链上的集合竞价结果，见链码 SetClearing
+type Clearing struct {
+	Period      int64  `json:"period"`
+	Zone        string `json:"zone"`
+	Price       int64  `json:"price"`
+	Volume      int64  `json:"volume"`
+	Allocations string `json:"allocations"`
+	Hash        string `json:"hash"`
+	TxID        string `json:"txId"`
+	Time        int64  `json:"time"`
+}
+
+// PublishClearing 把集合竞价的出清价和每个订单的成交量写到链上，作为 market.Call 的 Publish
+func (c *Contract) PublishClearing(cl market.Clearing) error {
+	allocations, err := json.Marshal(cl.Allocations)
+	if err != nil {
+		return fmt.Errorf("failed to marshal allocations:%v", err)
+	}
+	_, err = c.contract.SubmitTransaction("SetClearing", strconv.FormatInt(cl.Period, 10), cl.Zone,
+		strconv.FormatInt(cl.Price, 10), strconv.FormatInt(cl.Volume, 10), string(allocations), hex.EncodeToString(cl.Hash))
+	if err != nil {
+		return fmt.Errorf("failed to submit transaction SetClearing:%v", err)
+	}
+	return nil
+}
+
+func (c *Contract) GetClearing(period int64, zone string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetClearing", strconv.FormatInt(period, 10), zone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+	return res, nil
+}
+
+// VerifyClearing 从链上的订单重放集合竞价，结果须与链上的出清价、成交量和哈希一致，返回重放的结果
+func (c *Contract) VerifyClearing(period int64, zone string) (market.Clearing, error) {
+	res, err := c.GetClearing(period, zone)
+	if err != nil {
+		return market.Clearing{}, err
+	}
+	var record Clearing
+	if err := json.Unmarshal(res, &record); err != nil {
+		return market.Clearing{}, fmt.Errorf("failed to unmarshal clearing:%v", err)
+	}
+	cl := market.Clearing{Period: record.Period, Zone: record.Zone, Price: record.Price, Volume: record.Volume}
+	if err := json.Unmarshal([]byte(record.Allocations), &cl.Allocations); err != nil {
+		return market.Clearing{}, fmt.Errorf("failed to unmarshal allocations:%v", err)
+	}
+	if cl.Hash, err = hex.DecodeString(record.Hash); err != nil {
+		return market.Clearing{}, fmt.Errorf("failed to decode clearing hash:%v", err)
+	}
+	orders := make([]market.Order, len(cl.Allocations))
+	for i, a := range cl.Allocations {
+		orders[i] = a.Order
+	}
+	cl.Fills = market.ClearOrders(period, zone, orders).Fills
+	if !market.VerifyClearing(cl) {
+		return market.Clearing{}, fmt.Errorf("the clearing of period %d in %s does not replay", period, zone)
+	}
+	return cl, nil
+}
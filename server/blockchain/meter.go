@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"server/meter"
+	"server/utils"
+	"strconv"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// Meter 链上登记的智能电表，见链码 RegisterMeter
+type Meter struct {
+	ID      string `json:"id"`
+	Owner   string `json:"owner"`
+	Pub     string `json:"pub"`
+	UID     string `json:"uid"`
+	Seq     int64  `json:"seq"`
+	End     int64  `json:"end"`
+	Metered int64  `json:"metered"`
+	Listed  int64  `json:"listed"`
+}
+
+/*
+RegisterMeter 登记电表公钥，owner为发电方用户名，电表绑定到其钱包地址
+pub为电表出厂时生成的公钥，电表私钥不经过服务端
+*/
+func (c *Contract) RegisterMeter(id string, owner string, pub *sm2.PublicKey) ([]byte, error) {
+	if !meter.ValidID(id) {
+		return nil, fmt.Errorf("invalid meter id %q", id)
+	}
+	owner_address := utils.GetAddress(owner)
+	if owner_address == "" {
+		return nil, fmt.Errorf("unknown owner %s", owner)
+	}
+	if pub == nil {
+		return nil, fmt.Errorf("missing meter public key")
+	}
+	pub_bytes, err := json.Marshal(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key:%v", err)
+	}
+	res, err := c.contract.SubmitTransaction("RegisterMeter", id, owner_address, base64.StdEncoding.EncodeToString(pub_bytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction RegisterMeter:%v", err)
+	}
+	return res, nil
+}
+
+// SubmitReading 转发电表签名的读数，签名、序号和区间由链码检查
+func (c *Contract) SubmitReading(r meter.Reading) ([]byte, error) {
+	res, err := c.contract.SubmitTransaction("SubmitReading", r.MeterID,
+		strconv.FormatInt(r.Start, 10), strconv.FormatInt(r.End, 10),
+		strconv.FormatInt(r.Kwh, 10), strconv.FormatInt(r.Seq, 10), r.Sign)
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction SubmitReading:%v", err)
+	}
+	return res, nil
+}
+
+func (c *Contract) GetMeter(id string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetMeter", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+	return res, nil
+}
+
+func (c *Contract) GetReadings(id string) ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetReadings", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate transaction: %v", err)
+	}
+	return res, nil
+}
+
+/*
+CreateGoods 发电方用电表的余电挂牌出售，在[start, end)内交割，zone为电网分区
+owner须是电表登记的拥有者，商品挂在其钱包地址名下，用其私钥签名 id|地址|meterId|price|amount|start|end|zone，链码用登记的公钥验证，
+并检查amount不超过电表累计上网电量中尚未挂牌的部分、交割时段在未来且落在同一个分时电价时段内
+*/
+func (c *Contract) CreateGoods(owner string, id string, meterId string, price int64, amount int64, start int64, end int64, zone string) ([]byte, error) {
+	res, err := c.GetMeter(meterId)
+	if err != nil {
+		return nil, err
+	}
+	var m Meter
+	if err := json.Unmarshal(res, &m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal meter:%v", err)
+	}
+	if m.Owner != utils.GetAddress(owner) {
+		return nil, fmt.Errorf("%s is not the owner of meter %s", owner, meterId)
+	}
+	if amount > m.Metered-m.Listed {
+		return nil, fmt.Errorf("%w: %d of %d", meter.ErrSurplus, amount, m.Metered-m.Listed)
+	}
+	pri := utils.ReadPriKey(owner)
+	if pri == nil {
+		return nil, fmt.Errorf("failed to read private key of %s", owner)
+	}
+	uid, err := utils.UIDFromAddress(m.Owner)
+	if err != nil {
+		return nil, err
+	}
+	msg := []byte(fmt.Sprintf("%s|%s|%s|%d|%d|%d|%d|%s", id, m.Owner, meterId, price, amount, start, end, zone))
+	sign, err := sm2.Sign(pri, uid, msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign lot:%v", err)
+	}
+	res, err = c.contract.SubmitTransaction("CreateGoods", id, m.Owner, meterId,
+		strconv.FormatInt(price, 10), strconv.FormatInt(amount, 10),
+		strconv.FormatInt(start, 10), strconv.FormatInt(end, 10), zone, hex.EncodeToString(sign))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction CreateGoods:%v", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,115 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"server/utils"
+	"strconv"
+)
+
+// AggregatePeriod 统计时段的长度(秒)，与链码一致
+const AggregatePeriod = 3600
+
+// Aggregate 链上的交易金额统计，见链码 AggregateVolume
+type Aggregate struct {
+	ID        string `json:"id"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+	Count     int64  `json:"count"`
+	Sum       string `json:"sum"`
+	Total     int64  `json:"total"`
+	Operator  string `json:"operator"`
+	Proof     string `json:"proof"`
+	Published bool   `json:"published"`
+}
+
+/*
+AggregateVolume 统计全市场一个时段[from, from+AggregatePeriod)内已完成订单的交易总额
+链码对各订单的Enc_O_M做同态求和，运营方只解密这个和，然后把总额和解密证明公布到账本
+*/
+func (c *Contract) AggregateVolume(from int64) ([]byte, error) {
+	to := from + AggregatePeriod
+	res, err := c.contract.SubmitTransaction("AggregateVolume", strconv.FormatInt(from, 10), strconv.FormatInt(to, 10))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction AggregateVolume:%v", err)
+	}
+	var aggregate Aggregate
+	if err := json.Unmarshal(res, &aggregate); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal aggregate:%v", err)
+	}
+
+	pri_operator := utils.ReadPriKey("Operator")
+	if pri_operator == nil {
+		return nil, fmt.Errorf("failed to read operator private key")
+	}
+	total_str, err := utils.DecryptAmount(aggregate.Sum, pri_operator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt aggregate:%v", err)
+	}
+	total, err := strconv.ParseInt(total_str, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse total:%v", err)
+	}
+	sum, err := hex.DecodeString(aggregate.Sum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sum:%v", err)
+	}
+	proof, err := utils.ProveDecryption(pri_operator, sum, total, []byte(aggregate.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prove decryption:%v", err)
+	}
+	//链码用登记的公钥验证解密证明，重复登记相同公钥时返回已有记录
+	record, err := c.RegisterKey(utils.ReadPubKey("Operator"))
+	if err != nil {
+		return nil, err
+	}
+	res, err = c.contract.SubmitTransaction("PublishAggregate", aggregate.ID, total_str, record.Address, hex.EncodeToString(proof))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit transaction PublishAggregate:%v", err)
+	}
+	return res, nil
+}
+
+// VerifyAggregate 任何人都可以验证已公布的统计：Total须是Sum在Operator登记的公钥下的解密
+func (c *Contract) VerifyAggregate(id string) error {
+	res, err := c.GetAggregates()
+	if err != nil {
+		return err
+	}
+	var aggregates []Aggregate
+	if err := json.Unmarshal(res, &aggregates); err != nil {
+		return fmt.Errorf("failed to unmarshal aggregates:%v", err)
+	}
+	for _, aggregate := range aggregates {
+		if aggregate.ID != id {
+			continue
+		}
+		if !aggregate.Published {
+			return fmt.Errorf("the aggregate %s is not published", id)
+		}
+		pub, _, err := c.registeredKey(aggregate.Operator)
+		if err != nil {
+			return err
+		}
+		sum, err := hex.DecodeString(aggregate.Sum)
+		if err != nil {
+			return fmt.Errorf("failed to decode sum:%v", err)
+		}
+		proof, err := hex.DecodeString(aggregate.Proof)
+		if err != nil {
+			return fmt.Errorf("failed to decode proof:%v", err)
+		}
+		return utils.VerifyDecryption(pub, sum, aggregate.Total, []byte(id), proof)
+	}
+	return fmt.Errorf("the aggregate %s is not exist", id)
+}
+
+// GetAggregates 查询已有的统计
+func (c *Contract) GetAggregates() ([]byte, error) {
+	res, err := c.contract.EvaluateTransaction("GetAggregates")
+	if err != nil {
+		return nil, fmt.Errorf("failed to Evaluate transaction: %v", err)
+	}
+	return res, nil
+}
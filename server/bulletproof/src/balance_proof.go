@@ -0,0 +1,109 @@
+package src
+
+import (
+	"fmt"
+	"math/big"
+)
+
+/*
+BalanceProof shows that a Pedersen commitment D = b*G + s*H commits to the
+value an SM2 homomorphic ciphertext (C1, C2) decrypts to, without opening
+either. With B the base point of the curve and P = x*B the key the
+ciphertext is under, decryption gives C2 - x*C1 = b*B, so the prover shows
+knowledge of b, s and x satisfying D = b*G + s*H, C2 = b*B + x*C1 and
+P = x*B with the same b and x throughout. Sb, Ss and Sx answer the random
+u_b, u_s and u_x behind R1, R2 and R3.
+*/
+type BalanceProof struct {
+	R1 ECPoint // u_b*G + u_s*H
+	R2 ECPoint // u_b*B + u_x*C1
+	R3 ECPoint // u_x*B
+	Sb *big.Int
+	Ss *big.Int
+	Sx *big.Int
+}
+
+/*
+ProveBalance proves that the commitment opened by o commits to the value
+the ciphertext (C1, C2) decrypts to under the private key x. It does not
+check the decryption; a proof for any other value does not verify. context
+is bound into the challenge like for ProveEqualCommitments.
+*/
+func (ec *CryptoParams) ProveBalance(x *big.Int, C1, C2 ECPoint, o Opening, context []byte) (BalanceProof, error) {
+	B := ec.basePoint()
+	P := ec.Mult(B, x)
+	D := ec.CommitWithBlinding(o.Value, o.Blinding)
+
+	var u [3]*big.Int
+	for i := range u {
+		k, err := ec.randScalar()
+		if err != nil {
+			return BalanceProof{}, err
+		}
+		u[i] = k
+	}
+
+	R1 := ec.CommitWithBlinding(u[0], u[1])
+	R2 := ec.Add(ec.Mult(B, u[0]), ec.Mult(C1, u[2]))
+	R3 := ec.Mult(B, u[2])
+	c := ec.balanceChallenge(P, C1, C2, D, R1, R2, R3, context)
+
+	return BalanceProof{R1, R2, R3,
+		ec.response(u[0], c, o.Value),
+		ec.response(u[1], c, o.Blinding),
+		ec.response(u[2], c, x)}, nil
+}
+
+// VerifyBalance checks that D commits to the value the ciphertext (C1, C2)
+// decrypts to under the key P.
+func (ec *CryptoParams) VerifyBalance(P, C1, C2, D ECPoint, proof BalanceProof, context []byte) (bool, error) {
+	for _, p := range []ECPoint{P, C1, C2, D, proof.R1, proof.R2, proof.R3} {
+		if !ec.onCurve(p) {
+			return false, fmt.Errorf("%w: point not on curve", ErrMalformedProof)
+		}
+	}
+	for _, s := range []*big.Int{proof.Sb, proof.Ss, proof.Sx} {
+		if !ec.validScalar(s) {
+			return false, fmt.Errorf("%w: scalar out of range", ErrMalformedProof)
+		}
+	}
+
+	B := ec.basePoint()
+	c := ec.balanceChallenge(P, C1, C2, D, proof.R1, proof.R2, proof.R3, context)
+
+	// Sb*G + Ss*H == R1 + c*D, Sb*B + Sx*C1 == R2 + c*C2, Sx*B == R3 + c*P
+	return ec.CommitWithBlinding(proof.Sb, proof.Ss).Equal(ec.Add(proof.R1, ec.Mult(D, c))) &&
+		ec.Add(ec.Mult(B, proof.Sb), ec.Mult(C1, proof.Sx)).Equal(ec.Add(proof.R2, ec.Mult(C2, c))) &&
+		ec.Mult(B, proof.Sx).Equal(ec.Add(proof.R3, ec.Mult(P, c))), nil
+}
+
+// basePoint returns the base point of the curve, which SM2 keys and
+// ciphertexts are over, as opposed to the generators G and H.
+func (ec *CryptoParams) basePoint() ECPoint {
+	p := ec.C.Params()
+	return ECPoint{p.Gx, p.Gy}
+}
+
+// response returns u + c*w mod N.
+func (ec *CryptoParams) response(u, c, w *big.Int) *big.Int {
+	s := new(big.Int).Mul(c, w)
+	s.Add(s, u)
+	return s.Mod(s, ec.N)
+}
+
+func (ec *CryptoParams) balanceChallenge(P, C1, C2, D, R1, R2, R3 ECPoint, context []byte) *big.Int {
+	t := NewTranscript("bulletproof/commitment-balance")
+	t.AppendMessage("curve", []byte(ec.C.Params().Name))
+	t.AppendPoint("G", ec.G)
+	t.AppendPoint("H", ec.H)
+	t.AppendPoint("B", ec.basePoint())
+	t.AppendPoint("P", P)
+	t.AppendPoint("C1", C1)
+	t.AppendPoint("C2", C2)
+	t.AppendPoint("D", D)
+	t.AppendMessage("context", context)
+	t.AppendPoint("R1", R1)
+	t.AppendPoint("R2", R2)
+	t.AppendPoint("R3", R3)
+	return t.ChallengeScalar("c", ec.N)
+}
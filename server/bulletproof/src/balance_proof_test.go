@@ -0,0 +1,78 @@
+package src
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+// balanceCipher encrypts value under a fresh key x as HomoEncrypt does:
+// (k*B, value*B + k*x*B).
+func balanceCipher(t *testing.T, ec *CryptoParams, value int64) (*big.Int, ECPoint, ECPoint, ECPoint) {
+	x, err := rand.Int(rand.Reader, ec.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := rand.Int(rand.Reader, ec.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	B := ec.basePoint()
+	P := ec.Mult(B, x)
+	return x, P, ec.Mult(B, k), ec.Add(ec.Mult(B, big.NewInt(value)), ec.Mult(P, k))
+}
+
+func TestBalanceProof(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+	x, P, C1, C2 := balanceCipher(t, ec, 70)
+	D, o := mustCommit(ec.PedersenCommit(big.NewInt(70)))
+
+	proof := mustBalance(ec.ProveBalance(x, C1, C2, o, []byte("order-6")))
+	if verified(ec.VerifyBalance(P, C1, C2, D, proof, []byte("order-6"))) {
+		fmt.Println("Balance proof works")
+	} else {
+		t.Error("*****Balance proof FAILURE")
+	}
+}
+
+func TestBalanceProofWrongValue(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+	x, P, C1, C2 := balanceCipher(t, ec, 70)
+	D, o := mustCommit(ec.PedersenCommit(big.NewInt(71)))
+
+	// a cheating prover commits to more than the balance holds
+	proof := mustBalance(ec.ProveBalance(x, C1, C2, o, []byte("order-6")))
+	if verified(ec.VerifyBalance(P, C1, C2, D, proof, []byte("order-6"))) {
+		t.Error("*****Balance proof accepted a commitment to another value")
+	}
+}
+
+func TestBalanceProofTampered(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+	x, P, C1, C2 := balanceCipher(t, ec, 70)
+	D, o := mustCommit(ec.PedersenCommit(big.NewInt(70)))
+	proof := mustBalance(ec.ProveBalance(x, C1, C2, o, []byte("order-6")))
+
+	if verified(ec.VerifyBalance(P, C1, C2, D, proof, []byte("order-7"))) {
+		t.Error("*****Balance proof replayed under another context")
+	}
+	other, _ := mustCommit(ec.PedersenCommit(big.NewInt(70)))
+	if verified(ec.VerifyBalance(P, C1, C2, other, proof, []byte("order-6"))) {
+		t.Error("*****Balance proof accepted a different commitment")
+	}
+	_, Q, _, _ := balanceCipher(t, ec, 70)
+	if verified(ec.VerifyBalance(Q, C1, C2, D, proof, []byte("order-6"))) {
+		t.Error("*****Balance proof accepted another key")
+	}
+	bad := proof
+	bad.Sx = new(big.Int).Add(proof.Sx, big.NewInt(1))
+	if verified(ec.VerifyBalance(P, C1, C2, D, bad, []byte("order-6"))) {
+		t.Error("*****Balance proof accepted a tampered response")
+	}
+	bad.Sx = nil
+	if _, err := ec.VerifyBalance(P, C1, C2, D, bad, []byte("order-6")); !errors.Is(err, ErrMalformedProof) {
+		t.Error("*****Balance proof without a response gave", err)
+	}
+}
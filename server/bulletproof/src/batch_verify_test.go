@@ -0,0 +1,79 @@
+package src
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestMultiScalarMult(t *testing.T) {
+	ec := NewSM2GroupKey(64)
+	scalars := mustVector(ec.RandVector(64))
+
+	expected := ec.Zero()
+	for i := range scalars {
+		expected = ec.Add(expected, ec.Mult(ec.BPG[i], scalars[i]))
+	}
+	if !mustPoint(ec.MultiScalarMult(ec.BPG, scalars)).Equal(expected) {
+		t.Error("*****MultiScalarMult does not match the naive sum")
+	}
+
+	// P + (-1)*P is the identity
+	minusOne := new(big.Int).Sub(ec.N, big.NewInt(1))
+	if !mustPoint(ec.MultiScalarMult([]ECPoint{ec.G, ec.G}, []*big.Int{big.NewInt(1), minusOne})).IsZero() {
+		t.Error("*****MultiScalarMult does not cancel opposite terms")
+	}
+}
+
+func batchOfProofs(ec *CryptoParams, count int) ([]RangeProof, [][]byte) {
+	proofs := make([]RangeProof, count)
+	contexts := make([][]byte, count)
+	for i := range proofs {
+		v, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), uint(ec.V)))
+		if err != nil {
+			panic(err)
+		}
+		contexts[i] = []byte(fmt.Sprintf("order-%d", i))
+		proofs[i] = mustRP(ec.RPProve(v, contexts[i]))
+	}
+	return proofs, contexts
+}
+
+func TestBatchVerify(t *testing.T) {
+	ec := NewSM2GroupKey(16)
+	proofs, contexts := batchOfProofs(ec, 4)
+
+	if verified(ec.BatchVerify(proofs, contexts)) {
+		fmt.Println("Batch Verification works")
+	} else {
+		t.Error("*****Batch Verification FAILURE")
+	}
+}
+
+func TestBatchVerifyRejectsBadProof(t *testing.T) {
+	ec := NewSM2GroupKey(16)
+	proofs, contexts := batchOfProofs(ec, 4)
+
+	tampered := make([]RangeProof, len(proofs))
+	copy(tampered, proofs)
+	tampered[2].Tau = new(big.Int).Add(tampered[2].Tau, big.NewInt(1))
+	if verified(ec.BatchVerify(tampered, contexts)) {
+		t.Error("*****Batch Verification accepted a tampered tau")
+	}
+
+	copy(tampered, proofs)
+	tampered[1].IPP.A = new(big.Int).Add(tampered[1].IPP.A, big.NewInt(1))
+	if verified(ec.BatchVerify(tampered, contexts)) {
+		t.Error("*****Batch Verification accepted a tampered inner product argument")
+	}
+
+	swapped := [][]byte{contexts[1], contexts[0], contexts[2], contexts[3]}
+	if verified(ec.BatchVerify(proofs, swapped)) {
+		t.Error("*****Batch Verification accepted proofs under the wrong contexts")
+	}
+
+	if verified(ec.BatchVerify(proofs, nil)) {
+		t.Error("*****Batch Verification accepted proofs without their contexts")
+	}
+}
@@ -1,59 +1,102 @@
 package src
 
 import (
-	"crypto/rand"
 	"fmt"
 	"math/big"
 )
 
-// PedersenCommit performs a Pedersen commitment on a given value.
-func PedersenCommit(value *big.Int) (ECPoint, *big.Int) {
-	// Generate a random value for the blinding factor.
-	r, err := rand.Int(rand.Reader, EC.N)
+/*
+Opening is what a committer keeps to later open a Pedersen commitment: the
+committed value and the blinding factor, both mod N.
+*/
+type Opening struct {
+	Value    *big.Int
+	Blinding *big.Int
+}
+
+// PedersenCommit commits to value as value*G + r*H with a fresh random r and
+// returns the commitment together with its opening.
+func (ec *CryptoParams) PedersenCommit(value *big.Int) (ECPoint, Opening, error) {
+	r, err := ec.randScalar()
 	if err != nil {
-		panic(err) // In a real-world scenario, you should handle the error gracefully.
+		return ECPoint{}, Opening{}, err
+	}
+
+	o := Opening{new(big.Int).Mod(value, ec.N), r}
+	return ec.CommitWithBlinding(o.Value, o.Blinding), o, nil
+}
+
+// CommitWithBlinding computes the Pedersen commitment value*G + r*H.
+func (ec *CryptoParams) CommitWithBlinding(value, r *big.Int) ECPoint {
+	return ec.Add(ec.Mult(ec.G, value), ec.Mult(ec.H, r))
+}
+
+// VerifyOpening reports whether o opens the commitment comm.
+func (ec *CryptoParams) VerifyOpening(comm ECPoint, o Opening) bool {
+	if o.Value == nil || o.Blinding == nil {
+		return false
 	}
+	return ec.CommitWithBlinding(o.Value, o.Blinding).Equal(comm)
+}
+
+// AddCommitments returns a commitment to the sum of the values committed to
+// by a and b, opened by AddOpenings.
+func (ec *CryptoParams) AddCommitments(a, b ECPoint) ECPoint {
+	return ec.Add(a, b)
+}
 
-	// Modulo operation to ensure the value is within the curve's order.
-	modValue := new(big.Int).Mod(value, EC.N)
+// SubCommitments returns a commitment to the difference of the values
+// committed to by a and b, opened by SubOpenings.
+func (ec *CryptoParams) SubCommitments(a, b ECPoint) ECPoint {
+	return ec.Add(a, ec.Neg(b))
+}
 
-	// Compute the Pedersen commitment.
-	// This is done by adding the result of scalar multiplication of the base point G with the value
-	// to the result of scalar multiplication of the base point H with the blinding factor r.
-	x1, y1 := EC.C.ScalarBaseMult(modValue.Bytes())
-	x2, y2 := EC.C.ScalarBaseMult(r.Bytes())
-	commitment := EC.Zero()
-	commitment = commitment.Add(ECPoint{x1, y1}).Add(ECPoint{x2, y2})
+// AddOpenings returns the opening of AddCommitments(a, b).
+func (ec *CryptoParams) AddOpenings(a, b Opening) Opening {
+	return Opening{
+		new(big.Int).Mod(new(big.Int).Add(a.Value, b.Value), ec.N),
+		new(big.Int).Mod(new(big.Int).Add(a.Blinding, b.Blinding), ec.N)}
+}
 
-	return commitment, r
+// SubOpenings returns the opening of SubCommitments(a, b).
+func (ec *CryptoParams) SubOpenings(a, b Opening) Opening {
+	return Opening{
+		new(big.Int).Mod(new(big.Int).Sub(a.Value, b.Value), ec.N),
+		new(big.Int).Mod(new(big.Int).Sub(a.Blinding, b.Blinding), ec.N)}
 }
+
 /*
 Vector Pedersen Commitment
 
 Given an array of values, we commit the array with different generators
 for each element and for each randomness.
 */
-func VectorPCommit(value []*big.Int) (ECPoint, []*big.Int) {
-	R := make([]*big.Int, EC.V)
+func (ec *CryptoParams) VectorPCommit(value []*big.Int) (ECPoint, []*big.Int, error) {
+	if len(value) != ec.V {
+		return ECPoint{}, nil, fmt.Errorf("%w: %d values for %d generators", ErrLengthMismatch, len(value), ec.V)
+	}
+	R := make([]*big.Int, ec.V)
 
-	commitment := EC.Zero()
+	commitment := ec.Zero()
 
-	for i := 0; i < EC.V; i++ {
-		r, err := rand.Int(rand.Reader, EC.N)
-		check(err)
+	for i := 0; i < ec.V; i++ {
+		r, err := ec.randScalar()
+		if err != nil {
+			return ECPoint{}, nil, err
+		}
 
 		R[i] = r
 
-		modValue := new(big.Int).Mod(value[i], EC.N)
+		modValue := new(big.Int).Mod(value[i], ec.N)
 
 		// mG, rH
-		lhsX, lhsY := EC.C.ScalarMult(EC.BPG[i].X, EC.BPG[i].Y, modValue.Bytes())
-		rhsX, rhsY := EC.C.ScalarMult(EC.BPH[i].X, EC.BPH[i].Y, r.Bytes())
+		lhsX, lhsY := ec.C.ScalarMult(ec.BPG[i].X, ec.BPG[i].Y, modValue.Bytes())
+		rhsX, rhsY := ec.C.ScalarMult(ec.BPH[i].X, ec.BPH[i].Y, r.Bytes())
 
-		commitment = commitment.Add(ECPoint{lhsX, lhsY}).Add(ECPoint{rhsX, rhsY})
+		commitment = ec.Add(commitment, ECPoint{lhsX, lhsY}, ECPoint{rhsX, rhsY})
 	}
 
-	return commitment, R
+	return commitment, R, nil
 }
 
 /*
@@ -62,20 +105,8 @@ Two Vector P Commit
 Given an array of values, we commit the array with different generators
 for each element and for each randomness.
 */
-func TwoVectorPCommit(a []*big.Int, b []*big.Int) ECPoint {
-	if len(a) != len(b) {
-		fmt.Println("TwoVectorPCommit: Uh oh! Arrays not of the same length")
-		fmt.Printf("len(a): %d\n", len(a))
-		fmt.Printf("len(b): %d\n", len(b))
-	}
-
-	commitment := EC.Zero()
-
-	for i := 0; i < EC.V; i++ {
-		commitment = commitment.Add(EC.BPG[i].Mult(a[i])).Add(EC.BPH[i].Mult(b[i]))
-	}
-
-	return commitment
+func (ec *CryptoParams) TwoVectorPCommit(a []*big.Int, b []*big.Int) (ECPoint, error) {
+	return ec.TwoVectorPCommitWithGens(ec.BPG, ec.BPH, a, b)
 }
 
 /*
@@ -86,22 +117,24 @@ for each element and for each randomness.
 
 We also pass in the Generators we want to use
 */
-func TwoVectorPCommitWithGens(G, H []ECPoint, a, b []*big.Int) ECPoint {
+func (ec *CryptoParams) TwoVectorPCommitWithGens(G, H []ECPoint, a, b []*big.Int) (ECPoint, error) {
 	if len(G) != len(H) || len(G) != len(a) || len(a) != len(b) {
-		fmt.Println("TwoVectorPCommitWithGens: Uh oh! Arrays not of the same length")
-		fmt.Printf("len(G): %d\n", len(G))
-		fmt.Printf("len(H): %d\n", len(H))
-		fmt.Printf("len(a): %d\n", len(a))
-		fmt.Printf("len(b): %d\n", len(b))
+		return ECPoint{}, fmt.Errorf("%w: len(G)=%d len(H)=%d len(a)=%d len(b)=%d",
+			ErrLengthMismatch, len(G), len(H), len(a), len(b))
 	}
+	return ec.vectorCommit(G, H, a, b), nil
+}
 
-	commitment := EC.Zero()
+// vectorCommit is TwoVectorPCommitWithGens for vectors the caller built
+// itself and knows to be of the same length.
+func (ec *CryptoParams) vectorCommit(G, H []ECPoint, a, b []*big.Int) ECPoint {
+	commitment := ec.Zero()
 
 	for i := 0; i < len(G); i++ {
-		modA := new(big.Int).Mod(a[i], EC.N)
-		modB := new(big.Int).Mod(b[i], EC.N)
+		modA := new(big.Int).Mod(a[i], ec.N)
+		modB := new(big.Int).Mod(b[i], ec.N)
 
-		commitment = commitment.Add(G[i].Mult(modA)).Add(H[i].Mult(modB))
+		commitment = ec.Add(commitment, ec.Mult(G[i], modA), ec.Mult(H[i], modB))
 	}
 
 	return commitment
@@ -1,6 +1,7 @@
 package src
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 	"testing"
@@ -8,23 +9,26 @@ import (
 
 func TestVectorPCommit3(t *testing.T) {
 	fmt.Println("TestVectorPCommit3")
-	EC = NewECPrimeGroupKey(3)
+	ec := NewECPrimeGroupKey(3)
 
 	v := make([]*big.Int, 3)
 	for j := range v {
 		v[j] = big.NewInt(2)
 	}
 
-	output, r := VectorPCommit(v)
+	output, r, err := ec.VectorPCommit(v)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if len(r) != 3 {
 		fmt.Println("Failure - rvalues doesn't match length of values")
 	}
 	// we will verify correctness by replicating locally and comparing output
 
-	GVal := EC.BPG[0].Mult(v[0]).Add(EC.BPG[1].Mult(v[1]).Add(EC.BPG[2].Mult(v[2])))
-	HVal := EC.BPH[0].Mult(r[0]).Add(EC.BPH[1].Mult(r[1]).Add(EC.BPH[2].Mult(r[2])))
-	Comm := GVal.Add(HVal)
+	GVal := ec.Add(ec.Mult(ec.BPG[0], v[0]), ec.Mult(ec.BPG[1], v[1]), ec.Mult(ec.BPG[2], v[2]))
+	HVal := ec.Add(ec.Mult(ec.BPH[0], r[0]), ec.Mult(ec.BPH[1], r[1]), ec.Mult(ec.BPH[2], r[2]))
+	Comm := ec.Add(GVal, HVal)
 
 	if output.Equal(Comm) {
 		fmt.Println("Commitment correct")
@@ -32,3 +36,58 @@ func TestVectorPCommit3(t *testing.T) {
 		t.Error("Commitment failed")
 	}
 }
+
+func TestPedersenCommitOpening(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+
+	comm, o := mustCommit(ec.PedersenCommit(big.NewInt(42)))
+	if !ec.VerifyOpening(comm, o) {
+		t.Error("*****Pedersen Commitment does not verify against its opening")
+	}
+	if o.Value.Cmp(big.NewInt(42)) != 0 {
+		t.Error("*****Pedersen Commitment opening holds the wrong value")
+	}
+
+	wrong := Opening{big.NewInt(43), o.Blinding}
+	if ec.VerifyOpening(comm, wrong) {
+		t.Error("*****Pedersen Commitment opened to a different value")
+	}
+
+	// value*G + r*G would open with value and blinding swapped
+	swapped := Opening{o.Blinding, o.Value}
+	if ec.VerifyOpening(comm, swapped) {
+		t.Error("*****Pedersen Commitment opened with value and blinding swapped")
+	}
+}
+
+func TestPedersenCommitHomomorphic(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+
+	a, oa := mustCommit(ec.PedersenCommit(big.NewInt(100)))
+	b, ob := mustCommit(ec.PedersenCommit(big.NewInt(30)))
+
+	sum := ec.AddCommitments(a, b)
+	osum := ec.AddOpenings(oa, ob)
+	if !ec.VerifyOpening(sum, osum) || osum.Value.Cmp(big.NewInt(130)) != 0 {
+		t.Error("*****Pedersen Commitment addition failed")
+	}
+
+	diff := ec.SubCommitments(a, b)
+	odiff := ec.SubOpenings(oa, ob)
+	if !ec.VerifyOpening(diff, odiff) || odiff.Value.Cmp(big.NewInt(70)) != 0 {
+		t.Error("*****Pedersen Commitment subtraction failed")
+	} else {
+		fmt.Println("Pedersen Commitment homomorphism works")
+	}
+}
+
+func TestTwoVectorPCommitLengthMismatch(t *testing.T) {
+	ec := NewSM2GroupKey(4)
+	a := []*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(4)}
+	if _, err := ec.TwoVectorPCommit(a, a[:3]); !errors.Is(err, ErrLengthMismatch) {
+		t.Error("*****TwoVectorPCommit accepted vectors of different lengths")
+	}
+	if _, _, err := ec.VectorPCommit(a[:3]); !errors.Is(err, ErrLengthMismatch) {
+		t.Error("*****VectorPCommit accepted a short vector")
+	}
+}
@@ -9,37 +9,39 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"math/big"
+	"strconv"
+	"sync"
 
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
 	"github.com/btcsuite/btcd/btcec"
 )
 
-var VecLength = 64
-
+/*
+CryptoParams holds everything a prover or verifier needs for one vector
+length: the curve, the generators and the bit size V. Every prove, verify and
+commit function is a method on it, so callers pass the parameters explicitly
+instead of sharing a package-level value. Any elliptic.Curve works; all point
+arithmetic goes through C.
+*/
 type CryptoParams struct {
-	C   elliptic.Curve      // curve
-	KC  *btcec.KoblitzCurve // curve
-	BPG []ECPoint           // slice of gen 1 for BP
-	BPH []ECPoint           // slice of gen 2 for BP
-	N   *big.Int            // scalar prime
-	U   ECPoint             // a point that is a fixed group element with an unknown discrete-log relative to g,h
-	V   int                 // Vector length
-	G   ECPoint             // G value for commitments of a single value
-	H   ECPoint             // H value for commitments of a single value
+	C   elliptic.Curve // curve
+	BPG []ECPoint      // slice of gen 1 for BP
+	BPH []ECPoint      // slice of gen 2 for BP
+	N   *big.Int       // scalar prime
+	U   ECPoint        // a point that is a fixed group element with an unknown discrete-log relative to g,h
+	V   int            // Vector length
+	G   ECPoint        // G value for commitments of a single value
+	H   ECPoint        // H value for commitments of a single value
 }
 
-func (c CryptoParams) Zero() ECPoint {
+func (ec *CryptoParams) Zero() ECPoint {
 	return ECPoint{big.NewInt(0), big.NewInt(0)}
 }
 
-func check(e error) {
-	if e != nil {
-		panic(e)
-	}
-}
-
 // NewECPrimeGroupKey returns the curve (field),
 // Generator 1 x&y, Generator 2 x&y, order of the generators
-func NewECPrimeGroupKey(n int) CryptoParams {
+func NewECPrimeGroupKey(n int) *CryptoParams {
 	curValue := btcec.S256().Gx
 	s256 := sha256.New()
 	gen1Vals := make([]ECPoint, n)
@@ -83,8 +85,7 @@ func NewECPrimeGroupKey(n int) CryptoParams {
 		j += 1
 	}
 
-	return CryptoParams{
-		btcec.S256(),
+	return &CryptoParams{
 		btcec.S256(),
 		gen1Vals,
 		gen2Vals,
@@ -95,7 +96,110 @@ func NewECPrimeGroupKey(n int) CryptoParams {
 		ch}
 }
 
-func init() {
-	EC = NewECPrimeGroupKey(VecLength)
-	//fmt.Println(EC)
+// NewCryptoParams returns the CryptoParams for vectors of length n on curve,
+// with every generator derived by HashToCurve from a fixed label. Nobody knows
+// a discrete log relation between them, and G, H and U do not depend on n, so
+// commitments made under different bit lengths are interchangeable.
+func NewCryptoParams(curve elliptic.Curve, n int) *CryptoParams {
+	gen1Vals := make([]ECPoint, n)
+	gen2Vals := make([]ECPoint, n)
+	for i := 0; i < n; i++ {
+		gen1Vals[i] = HashToCurve(curve, generatorLabel(curve, "BPG", i))
+		gen2Vals[i] = HashToCurve(curve, generatorLabel(curve, "BPH", i))
+	}
+
+	return &CryptoParams{
+		curve,
+		gen1Vals,
+		gen2Vals,
+		curve.Params().N,
+		HashToCurve(curve, generatorLabel(curve, "U", 0)),
+		n,
+		HashToCurve(curve, generatorLabel(curve, "G", 0)),
+		HashToCurve(curve, generatorLabel(curve, "H", 0))}
+}
+
+// NewSM2GroupKey returns the CryptoParams for vectors of length n on the
+// SM2-P-256-V1 curve used by the rest of the system.
+func NewSM2GroupKey(n int) *CryptoParams {
+	return NewCryptoParams(sm2.GetSm2P256V1(), n)
+}
+
+func generatorLabel(curve elliptic.Curve, name string, i int) []byte {
+	return []byte(curve.Params().Name + "/bulletproof/" + name + "/" + strconv.Itoa(i))
+}
+
+/*
+HashToCurve deterministically maps label to a point on curve by
+try-and-increment: SM3(label || counter) is taken as an x coordinate until
+x^3 - 3x + b is a square mod p, and the even root is used as y. Only curves
+with a = -3, like the NIST curves and SM2, are supported.
+*/
+func HashToCurve(curve elliptic.Curve, label []byte) ECPoint {
+	params := curve.Params()
+	three := big.NewInt(3)
+	ctr := make([]byte, 4)
+
+	for i := uint32(0); ; i++ {
+		binary.BigEndian.PutUint32(ctr, i)
+		h := sm3.New()
+		h.Write(label)
+		h.Write(ctr)
+		x := new(big.Int).SetBytes(h.Sum(nil))
+		if x.Cmp(params.P) >= 0 {
+			continue
+		}
+
+		// y^2 = x^3 - 3x + b
+		rhs := new(big.Int).Exp(x, three, params.P)
+		rhs.Sub(rhs, new(big.Int).Mul(x, three))
+		rhs.Add(rhs, params.B)
+		rhs.Mod(rhs, params.P)
+
+		y := new(big.Int).ModSqrt(rhs, params.P)
+		if y == nil {
+			continue
+		}
+		if y.Bit(0) == 1 {
+			y.Sub(params.P, y)
+		}
+		if curve.IsOnCurve(x, y) {
+			return ECPoint{x, y}
+		}
+	}
+}
+
+type paramsKey struct {
+	curve elliptic.Curve
+	n     int
+}
+
+var (
+	paramsMu    sync.Mutex
+	paramsCache = make(map[paramsKey]*CryptoParams)
+)
+
+func cachedParams(curve elliptic.Curve, n int, gen func(int) *CryptoParams) *CryptoParams {
+	paramsMu.Lock()
+	defer paramsMu.Unlock()
+
+	key := paramsKey{curve, n}
+	if p, ok := paramsCache[key]; ok {
+		return p
+	}
+	p := gen(n)
+	paramsCache[key] = p
+	return p
+}
+
+// Params returns the secp256k1 CryptoParams for vectors of length n.
+// Generating the generators is expensive, so the result is cached per bit
+// length and shared; the returned value must be treated as read-only.
+func Params(n int) *CryptoParams {
+	return cachedParams(btcec.S256(), n, NewECPrimeGroupKey)
+}
+
+// SM2Params is Params for the SM2 curve, see NewSM2GroupKey.
+func SM2Params(n int) *CryptoParams {
+	return cachedParams(sm2.GetSm2P256V1(), n, NewSM2GroupKey)
 }
@@ -4,8 +4,47 @@ import (
 	"fmt"
 	"math/big"
 	"testing"
+
+	"github.com/ZZMarquis/gm/sm2"
 )
 
+func TestHashToCurveSM2(t *testing.T) {
+	curve := sm2.GetSm2P256V1()
+	p1 := HashToCurve(curve, []byte("label"))
+	p2 := HashToCurve(curve, []byte("label"))
+	p3 := HashToCurve(curve, []byte("other label"))
+
+	if !curve.IsOnCurve(p1.X, p1.Y) || !curve.IsOnCurve(p3.X, p3.Y) {
+		t.Error("*****HashToCurve returned a point off the curve")
+	}
+	if p1.X.Cmp(p2.X) != 0 || p1.Y.Cmp(p2.Y) != 0 {
+		t.Error("*****HashToCurve is not deterministic")
+	}
+	if p1.X.Cmp(p3.X) == 0 {
+		t.Error("*****HashToCurve maps different labels to the same point")
+	}
+}
+
+func TestSM2ParamsGenerators(t *testing.T) {
+	small := NewSM2GroupKey(8)
+	large := SM2Params(64)
+
+	if small.G.X.Cmp(large.G.X) != 0 || small.H.X.Cmp(large.H.X) != 0 || small.U.X.Cmp(large.U.X) != 0 {
+		t.Error("*****SM2 G, H and U depend on the vector length")
+	}
+	for i := range small.BPG {
+		if small.BPG[i].X.Cmp(large.BPG[i].X) != 0 || small.BPH[i].X.Cmp(large.BPH[i].X) != 0 {
+			t.Error("*****SM2 vector generators depend on the vector length")
+		}
+	}
+	if small.G.X.Cmp(small.H.X) == 0 || small.BPG[0].X.Cmp(small.BPH[0].X) == 0 {
+		t.Error("*****SM2 generators are not distinct")
+	}
+	if SM2Params(64) != large {
+		t.Error("*****SM2Params is not cached")
+	}
+}
+
 func BenchmarkMRPVerifySize(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		for j := 1; j < 257; j *= 2 {
@@ -14,14 +53,14 @@ func BenchmarkMRPVerifySize(b *testing.B) {
 				values[k] = big.NewInt(0)
 			}
 
-			EC = NewECPrimeGroupKey(64 * len(values))
+			ec := NewECPrimeGroupKey(64 * len(values))
 			// Testing smallest number in range
-			proof := MRPProve(values)
-			proofString := fmt.Sprintf("%s", proof)
+			proof := mustMRP(ec.MRPProve(values, nil))
+			proofString := fmt.Sprintf("%v", proof)
 			//fmt.Println(proofString)
 			fmt.Printf("Size for %d values: %d bytes\n", j, len(proofString)) // length is good measure of bytes, correct?
 
-			if MRPVerify(proof) {
+			if verified(ec.MRPVerify(proof, nil)) {
 				fmt.Println("Multi Range Proof Verification works")
 			} else {
 				fmt.Println("***** Multi Range Proof FAILURE")
@@ -39,10 +78,10 @@ func BenchmarkMRPProve16(b *testing.B) {
 	for k := 0; k < j; k++ {
 		values[k] = big.NewInt(0)
 	}
-	EC = NewECPrimeGroupKey(64 * len(values))
+	ec := NewECPrimeGroupKey(64 * len(values))
 	var r MultiRangeProof
 	for i := 0; i < b.N; i++ {
-		r = MRPProve(values)
+		r = mustMRP(ec.MRPProve(values, nil))
 	}
 
 	result = r
@@ -54,12 +93,12 @@ func BenchmarkMRPVerify16(b *testing.B) {
 	for k := 0; k < j; k++ {
 		values[k] = big.NewInt(0)
 	}
-	EC = NewECPrimeGroupKey(64 * len(values))
-	proof := MRPProve(values)
+	ec := NewECPrimeGroupKey(64 * len(values))
+	proof := mustMRP(ec.MRPProve(values, nil))
 
 	var r bool
 	for i := 0; i < b.N; i++ {
-		r = MRPVerify(proof)
+		r = verified(ec.MRPVerify(proof, nil))
 	}
 	boores = r
 }
@@ -70,10 +109,10 @@ func BenchmarkMRPProve32(b *testing.B) {
 	for k := 0; k < j; k++ {
 		values[k] = big.NewInt(0)
 	}
-	EC = NewECPrimeGroupKey(64 * len(values))
+	ec := NewECPrimeGroupKey(64 * len(values))
 	var r MultiRangeProof
 	for i := 0; i < b.N; i++ {
-		r = MRPProve(values)
+		r = mustMRP(ec.MRPProve(values, nil))
 	}
 	result = r
 }
@@ -84,11 +123,144 @@ func BenchmarkMRPVerify32(b *testing.B) {
 	for k := 0; k < j; k++ {
 		values[k] = big.NewInt(0)
 	}
-	EC = NewECPrimeGroupKey(64 * len(values))
-	proof := MRPProve(values)
+	ec := NewECPrimeGroupKey(64 * len(values))
+	proof := mustMRP(ec.MRPProve(values, nil))
 	var r bool
 	for i := 0; i < b.N; i++ {
-		r = MRPVerify(proof)
+		r = verified(ec.MRPVerify(proof, nil))
 	}
 	boores = r
 }
+
+// proofSize returns the length of an encoded proof, failing b if encoding
+// failed.
+func proofSize(b *testing.B, enc []byte, err error) int {
+	if err != nil {
+		b.Fatal(err)
+	}
+	return len(enc)
+}
+
+// BenchmarkOrderRPPairVerify verifies an order's price and balance range
+// proofs as two separate proofs of 8 and 64 bits.
+func BenchmarkOrderRPPairVerify(b *testing.B) {
+	ec8 := SM2Params(8)
+	ec64 := SM2Params(64)
+	rpm := mustRP(ec8.RPProve(big.NewInt(200), nil))
+	rpb := mustRP(ec64.RPProve(big.NewInt(1000000), nil))
+	mEnc, err := ec8.EncodeRangeProof(rpm)
+	size := proofSize(b, mEnc, err)
+	bEnc, err := ec64.EncodeRangeProof(rpb)
+	size += proofSize(b, bEnc, err)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		boores = verified(ec8.RPVerify(rpm, nil)) && verified(ec64.RPVerify(rpb, nil))
+	}
+	b.ReportMetric(float64(size), "proof-bytes")
+}
+
+// BenchmarkOrderMRPVerify verifies the same two values as one aggregated
+// proof with bit lengths 8 and 64.
+func BenchmarkOrderMRPVerify(b *testing.B) {
+	ec := SM2Params(128)
+	bits := []int{8, 64}
+	mrp := mustMRP(ec.MRPProveBits([]*big.Int{big.NewInt(200), big.NewInt(1000000)}, bits, nil))
+	enc, err := ec.EncodeMultiRangeProof(mrp)
+	size := proofSize(b, enc, err)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		boores = verified(ec.MRPVerifyBits(mrp, bits, nil))
+	}
+	b.ReportMetric(float64(size), "proof-bytes")
+}
+
+// BenchmarkRPVerifyEach16 verifies 16 range proofs one by one, the way
+// settlement does today; compare with BenchmarkBatchVerify16.
+func BenchmarkRPVerifyEach16(b *testing.B) {
+	ec := SM2Params(64)
+	proofs, contexts := batchOfProofs(ec, 16)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for k := range proofs {
+			boores = verified(ec.RPVerify(proofs[k], contexts[k]))
+		}
+	}
+}
+
+func BenchmarkBatchVerify16(b *testing.B) {
+	ec := SM2Params(64)
+	proofs, contexts := batchOfProofs(ec, 16)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		boores = verified(ec.BatchVerify(proofs, contexts))
+	}
+}
+
+// The helpers below unwrap the (result, error) returns of the provers for
+// tests whose inputs are valid by construction.
+
+func mustRP(rp RangeProof, err error) RangeProof {
+	if err != nil {
+		panic(err)
+	}
+	return rp
+}
+
+func mustMRP(mrp MultiRangeProof, err error) MultiRangeProof {
+	if err != nil {
+		panic(err)
+	}
+	return mrp
+}
+
+func mustEq(proof EqualityProof, err error) EqualityProof {
+	if err != nil {
+		panic(err)
+	}
+	return proof
+}
+
+func mustBalance(proof BalanceProof, err error) BalanceProof {
+	if err != nil {
+		panic(err)
+	}
+	return proof
+}
+
+func mustIPP(ipp InnerProdArg, err error) InnerProdArg {
+	if err != nil {
+		panic(err)
+	}
+	return ipp
+}
+
+func mustPoint(p ECPoint, err error) ECPoint {
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func mustVector(v []*big.Int, err error) []*big.Int {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func mustCommit(comm ECPoint, o Opening, err error) (ECPoint, Opening) {
+	if err != nil {
+		panic(err)
+	}
+	return comm, o
+}
+
+// verified reports whether a verifier accepted, counting an error as a
+// rejection.
+func verified(ok bool, err error) bool {
+	return ok && err == nil
+}
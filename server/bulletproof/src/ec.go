@@ -2,36 +2,55 @@ package src
 
 import "math/big"
 
-var EC CryptoParams
-
 type ECPoint struct {
 	X, Y *big.Int
 }
 
 // Equal returns true if points p (self) and p2 (arg) are the same.
 func (p ECPoint) Equal(p2 ECPoint) bool {
-	if p.X.Cmp(p2.X) == 0 && p2.Y.Cmp(p2.Y) == 0 {
-		return true
-	}
-	return false
+	return p.X.Cmp(p2.X) == 0 && p.Y.Cmp(p2.Y) == 0
 }
 
 // Mult multiplies point p by scalar s and returns the resulting point
-func (p ECPoint) Mult(s *big.Int) ECPoint {
-	modS := new(big.Int).Mod(s, EC.N)
-	X, Y := EC.C.ScalarMult(p.X, p.Y, modS.Bytes())
+func (ec *CryptoParams) Mult(p ECPoint, s *big.Int) ECPoint {
+	modS := new(big.Int).Mod(s, ec.N)
+	X, Y := ec.C.ScalarMult(p.X, p.Y, modS.Bytes())
 	return ECPoint{X, Y}
 }
 
-// Add adds points p and p2 and returns the resulting point
-func (p ECPoint) Add(p2 ECPoint) ECPoint {
-	X, Y := EC.C.Add(p.X, p.Y, p2.X, p2.Y)
+// Add adds point p and every point in ps and returns the resulting point
+func (ec *CryptoParams) Add(p ECPoint, ps ...ECPoint) ECPoint {
+	X, Y := p.X, p.Y
+	for _, p2 := range ps {
+		X, Y = ec.C.Add(X, Y, p2.X, p2.Y)
+	}
 	return ECPoint{X, Y}
 }
 
 // Neg returns the additive inverse of point p
-func (p ECPoint) Neg() ECPoint {
+func (ec *CryptoParams) Neg(p ECPoint) ECPoint {
 	negY := new(big.Int).Neg(p.Y)
-	modValue := negY.Mod(negY, EC.C.Params().P) // mod P is fine here because we're describing a curve point
+	modValue := negY.Mod(negY, ec.C.Params().P) // mod P is fine here because we're describing a curve point
 	return ECPoint{p.X, modValue}
 }
+
+// Double returns 2*p
+func (ec *CryptoParams) Double(p ECPoint) ECPoint {
+	X, Y := ec.C.Double(p.X, p.Y)
+	return ECPoint{X, Y}
+}
+
+// IsZero reports whether p is the point at infinity.
+func (p ECPoint) IsZero() bool {
+	return p.X.Sign() == 0 && p.Y.Sign() == 0
+}
+
+// onCurve reports whether p is set and a point of the curve of ec.
+func (ec *CryptoParams) onCurve(p ECPoint) bool {
+	return p.X != nil && p.Y != nil && ec.C.IsOnCurve(p.X, p.Y)
+}
+
+// validScalar reports whether s is set and reduced mod N.
+func (ec *CryptoParams) validScalar(s *big.Int) bool {
+	return s != nil && s.Sign() >= 0 && s.Cmp(ec.N) < 0
+}
@@ -0,0 +1,480 @@
+package src
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/btcsuite/btcd/btcec"
+)
+
+/*
+Wire format
+
+Every proof starts with a 3 byte header
+
+	version (1) | kind (1) | curve (1)
+
+followed by its fields in declaration order. Points are 33 bytes in SEC1
+compressed form, 0x02 or 0x03 for an even or odd y followed by the 32 byte
+x, with the identity written as 33 zero bytes. Scalars are 32 bytes big
+endian and must be below the group order. Lengths are big endian.
+
+	RangeProof:      bits (2) | Comm | A | S | T1 | T2 | Tau | Th | Mu | IPP
+	MultiRangeProof: bits (2) | m (1) | m x bit length (2) | m x Comm |
+	                 A | S | T1 | T2 | Tau | Th | Mu | IPP
+	EqualityProof:   R | S
+	BalanceProof:    R1 | R2 | R3 | Sb | Ss | Sx
+	InnerProdArg:    log2(bits) x L | log2(bits) x R | a | b
+
+There is exactly one encoding of every proof: the number of inner product
+rounds follows from bits, every point is checked to be on the curve and
+every scalar to be reduced, and trailing bytes are rejected. Decoding then
+encoding a proof therefore gives back the same bytes, so the encoding can be
+hashed or signed as it is.
+*/
+const (
+	EncodingVersion = 1
+
+	kindRangeProof      = 1
+	kindMultiRangeProof = 2
+	kindEqualityProof   = 3
+	kindBalanceProof    = 4
+
+	curveSecp256k1 = 1
+	curveSM2       = 2
+
+	PointSize  = 33
+	ScalarSize = 32
+	headerSize = 3
+
+	// maxEncodedBits bounds the bit length of a decoded proof, far above any
+	// params this system builds.
+	maxEncodedBits = 1 << 12
+)
+
+// curveID returns the header byte for the curve of ec, or 0 if it has none.
+func (ec *CryptoParams) curveID() byte {
+	switch ec.C {
+	case btcec.S256():
+		return curveSecp256k1
+	case sm2.GetSm2P256V1():
+		return curveSM2
+	}
+	return 0
+}
+
+// curveName is the name of the curve of ec in the JSON mapping.
+func (ec *CryptoParams) curveName() string {
+	switch ec.curveID() {
+	case curveSecp256k1:
+		return "secp256k1"
+	case curveSM2:
+		return "sm2p256v1"
+	}
+	return ""
+}
+
+// EncodePoint returns the 33 byte compressed encoding of p.
+func (ec *CryptoParams) EncodePoint(p ECPoint) ([]byte, error) {
+	if p.X == nil || p.Y == nil {
+		return nil, fmt.Errorf("%w: missing point", ErrMalformedEncoding)
+	}
+	out := make([]byte, PointSize)
+	if p.IsZero() {
+		return out, nil
+	}
+	if !ec.C.IsOnCurve(p.X, p.Y) {
+		return nil, fmt.Errorf("%w: point not on curve", ErrMalformedEncoding)
+	}
+	out[0] = 0x02 | byte(p.Y.Bit(0))
+	putFixed(out[1:], p.X)
+	return out, nil
+}
+
+// DecodePoint parses a point written by EncodePoint.
+func (ec *CryptoParams) DecodePoint(b []byte) (ECPoint, error) {
+	if len(b) != PointSize {
+		return ECPoint{}, fmt.Errorf("%w: point is %d bytes", ErrMalformedEncoding, len(b))
+	}
+	x := new(big.Int).SetBytes(b[1:])
+	if b[0] == 0x00 {
+		if x.Sign() != 0 {
+			return ECPoint{}, fmt.Errorf("%w: bad identity encoding", ErrMalformedEncoding)
+		}
+		return ec.Zero(), nil
+	}
+	if b[0] != 0x02 && b[0] != 0x03 {
+		return ECPoint{}, fmt.Errorf("%w: bad point prefix %#x", ErrMalformedEncoding, b[0])
+	}
+
+	params := ec.C.Params()
+	if x.Cmp(params.P) >= 0 {
+		return ECPoint{}, fmt.Errorf("%w: x not reduced", ErrMalformedEncoding)
+	}
+	// y^2 = x^3 + a*x + b, a is 0 for secp256k1 and -3 for the others
+	rhs := new(big.Int).Exp(x, big.NewInt(3), params.P)
+	if ec.curveID() != curveSecp256k1 {
+		rhs.Sub(rhs, new(big.Int).Mul(x, big.NewInt(3)))
+	}
+	rhs.Add(rhs, params.B)
+	rhs.Mod(rhs, params.P)
+	y := new(big.Int).ModSqrt(rhs, params.P)
+	if y == nil {
+		return ECPoint{}, fmt.Errorf("%w: point not on curve", ErrMalformedEncoding)
+	}
+	if y.Bit(0) != uint(b[0]&1) {
+		y.Sub(params.P, y)
+	}
+	if !ec.C.IsOnCurve(x, y) {
+		return ECPoint{}, fmt.Errorf("%w: point not on curve", ErrMalformedEncoding)
+	}
+	return ECPoint{x, y}, nil
+}
+
+// EncodeScalar returns the 32 byte encoding of s, which must be in [0, N).
+func (ec *CryptoParams) EncodeScalar(s *big.Int) ([]byte, error) {
+	if s == nil || s.Sign() < 0 || s.Cmp(ec.N) >= 0 {
+		return nil, fmt.Errorf("%w: scalar out of range", ErrMalformedEncoding)
+	}
+	out := make([]byte, ScalarSize)
+	putFixed(out, s)
+	return out, nil
+}
+
+// DecodeScalar parses a scalar written by EncodeScalar.
+func (ec *CryptoParams) DecodeScalar(b []byte) (*big.Int, error) {
+	if len(b) != ScalarSize {
+		return nil, fmt.Errorf("%w: scalar is %d bytes", ErrMalformedEncoding, len(b))
+	}
+	s := new(big.Int).SetBytes(b)
+	if s.Cmp(ec.N) >= 0 {
+		return nil, fmt.Errorf("%w: scalar out of range", ErrMalformedEncoding)
+	}
+	return s, nil
+}
+
+// EncodeRangeProof returns the canonical encoding of rp.
+func (ec *CryptoParams) EncodeRangeProof(rp RangeProof) ([]byte, error) {
+	w := ec.newWriter(kindRangeProof)
+	w.u16(rp.Bits)
+	w.points(rp.Comm, rp.A, rp.S, rp.T1, rp.T2)
+	w.scalars(rp.Tau, rp.Th, rp.Mu)
+	w.innerProdArg(rp.IPP, rp.Bits)
+	return w.buf, w.err
+}
+
+// DecodeRangeProof parses a proof written by EncodeRangeProof.
+func (ec *CryptoParams) DecodeRangeProof(b []byte) (RangeProof, error) {
+	r := ec.newReader(b, kindRangeProof)
+	var rp RangeProof
+	rp.Bits = r.u16()
+	rounds, err := ipaRounds(rp.Bits)
+	if r.err == nil && err != nil {
+		r.err = err
+	}
+	rp.Comm = r.point()
+	rp.A = r.point()
+	rp.S = r.point()
+	rp.T1 = r.point()
+	rp.T2 = r.point()
+	rp.Tau = r.scalar()
+	rp.Th = r.scalar()
+	rp.Mu = r.scalar()
+	rp.IPP = r.innerProdArg(rounds)
+	if err := r.finish(); err != nil {
+		return RangeProof{}, err
+	}
+	return rp, nil
+}
+
+// EncodeMultiRangeProof returns the canonical encoding of mrp.
+func (ec *CryptoParams) EncodeMultiRangeProof(mrp MultiRangeProof) ([]byte, error) {
+	if err := checkBitLengths(mrp.Bits, mrp.BitLengths, len(mrp.Comms)); err != nil {
+		return nil, err
+	}
+	w := ec.newWriter(kindMultiRangeProof)
+	w.u16(mrp.Bits)
+	w.u8(len(mrp.Comms))
+	for _, bits := range mrp.BitLengths {
+		w.u16(bits)
+	}
+	w.points(mrp.Comms...)
+	w.points(mrp.A, mrp.S, mrp.T1, mrp.T2)
+	w.scalars(mrp.Tau, mrp.Th, mrp.Mu)
+	w.innerProdArg(mrp.IPP, mrp.Bits)
+	return w.buf, w.err
+}
+
+// DecodeMultiRangeProof parses a proof written by EncodeMultiRangeProof.
+func (ec *CryptoParams) DecodeMultiRangeProof(b []byte) (MultiRangeProof, error) {
+	r := ec.newReader(b, kindMultiRangeProof)
+	var mrp MultiRangeProof
+	mrp.Bits = r.u16()
+	m := r.u8()
+	if r.err != nil {
+		return MultiRangeProof{}, r.err
+	}
+	mrp.BitLengths = make([]int, m)
+	for j := range mrp.BitLengths {
+		mrp.BitLengths[j] = r.u16()
+	}
+	if r.err == nil {
+		r.err = checkBitLengths(mrp.Bits, mrp.BitLengths, m)
+	}
+	rounds, _ := ipaRounds(mrp.Bits)
+	mrp.Comms = make([]ECPoint, m)
+	for j := range mrp.Comms {
+		mrp.Comms[j] = r.point()
+	}
+	mrp.A = r.point()
+	mrp.S = r.point()
+	mrp.T1 = r.point()
+	mrp.T2 = r.point()
+	mrp.Tau = r.scalar()
+	mrp.Th = r.scalar()
+	mrp.Mu = r.scalar()
+	mrp.IPP = r.innerProdArg(rounds)
+	if err := r.finish(); err != nil {
+		return MultiRangeProof{}, err
+	}
+	return mrp, nil
+}
+
+// EncodeEqualityProof returns the canonical encoding of proof.
+func (ec *CryptoParams) EncodeEqualityProof(proof EqualityProof) ([]byte, error) {
+	w := ec.newWriter(kindEqualityProof)
+	w.points(proof.R)
+	w.scalars(proof.S)
+	return w.buf, w.err
+}
+
+// DecodeEqualityProof parses a proof written by EncodeEqualityProof.
+func (ec *CryptoParams) DecodeEqualityProof(b []byte) (EqualityProof, error) {
+	r := ec.newReader(b, kindEqualityProof)
+	var proof EqualityProof
+	proof.R = r.point()
+	proof.S = r.scalar()
+	if err := r.finish(); err != nil {
+		return EqualityProof{}, err
+	}
+	return proof, nil
+}
+
+// EncodeBalanceProof returns the canonical encoding of proof.
+func (ec *CryptoParams) EncodeBalanceProof(proof BalanceProof) ([]byte, error) {
+	w := ec.newWriter(kindBalanceProof)
+	w.points(proof.R1, proof.R2, proof.R3)
+	w.scalars(proof.Sb, proof.Ss, proof.Sx)
+	return w.buf, w.err
+}
+
+// DecodeBalanceProof parses a proof written by EncodeBalanceProof.
+func (ec *CryptoParams) DecodeBalanceProof(b []byte) (BalanceProof, error) {
+	r := ec.newReader(b, kindBalanceProof)
+	var proof BalanceProof
+	proof.R1 = r.point()
+	proof.R2 = r.point()
+	proof.R3 = r.point()
+	proof.Sb = r.scalar()
+	proof.Ss = r.scalar()
+	proof.Sx = r.scalar()
+	if err := r.finish(); err != nil {
+		return BalanceProof{}, err
+	}
+	return proof, nil
+}
+
+// ipaRounds returns log2(bits), the number of inner product rounds of a
+// proof over bits bits, which must be a power of two.
+func ipaRounds(bits int) (int, error) {
+	if bits < 1 || bits > maxEncodedBits || bits&(bits-1) != 0 {
+		return 0, fmt.Errorf("%w: bit length %d", ErrMalformedEncoding, bits)
+	}
+	rounds := 0
+	for 1<<uint(rounds) < bits {
+		rounds++
+	}
+	return rounds, nil
+}
+
+// checkBitLengths is validBitLengths for a proof over total bits.
+func checkBitLengths(total int, bits []int, m int) error {
+	if _, err := ipaRounds(total); err != nil {
+		return err
+	}
+	if m == 0 || m > 0xff || len(bits) != m || total%m != 0 {
+		return fmt.Errorf("%w: %d values over %d bits", ErrMalformedEncoding, m, total)
+	}
+	for _, b := range bits {
+		if b < 1 || b > total/m {
+			return fmt.Errorf("%w: bit length %d", ErrMalformedEncoding, b)
+		}
+	}
+	return nil
+}
+
+// writer appends encoded fields to buf and keeps the first error.
+type writer struct {
+	ec  *CryptoParams
+	buf []byte
+	err error
+}
+
+func (ec *CryptoParams) newWriter(kind byte) *writer {
+	w := &writer{ec: ec, buf: []byte{EncodingVersion, kind, ec.curveID()}}
+	if w.buf[2] == 0 {
+		w.err = fmt.Errorf("%w: unsupported curve", ErrMalformedEncoding)
+	}
+	return w
+}
+
+func (w *writer) u8(v int) {
+	w.buf = append(w.buf, byte(v))
+}
+
+func (w *writer) u16(v int) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *writer) points(ps ...ECPoint) {
+	for _, p := range ps {
+		if w.err != nil {
+			return
+		}
+		var b []byte
+		b, w.err = w.ec.EncodePoint(p)
+		w.buf = append(w.buf, b...)
+	}
+}
+
+func (w *writer) scalars(ss ...*big.Int) {
+	for _, s := range ss {
+		if w.err != nil {
+			return
+		}
+		var b []byte
+		b, w.err = w.ec.EncodeScalar(s)
+		w.buf = append(w.buf, b...)
+	}
+}
+
+func (w *writer) innerProdArg(ipp InnerProdArg, bits int) {
+	rounds, err := ipaRounds(bits)
+	if err != nil {
+		w.err = err
+		return
+	}
+	if len(ipp.L) != rounds || len(ipp.R) != rounds {
+		w.err = fmt.Errorf("%w: %d inner product rounds for %d bits", ErrMalformedEncoding, len(ipp.L), bits)
+		return
+	}
+	w.points(ipp.L...)
+	w.points(ipp.R...)
+	w.scalars(ipp.A, ipp.B)
+}
+
+// reader consumes encoded fields from b and keeps the first error; once it
+// has failed every further read returns a zero value.
+type reader struct {
+	ec  *CryptoParams
+	b   []byte
+	err error
+}
+
+func (ec *CryptoParams) newReader(b []byte, kind byte) *reader {
+	r := &reader{ec: ec, b: b}
+	hdr := r.next(headerSize)
+	switch {
+	case r.err != nil:
+	case hdr[0] != EncodingVersion:
+		r.err = fmt.Errorf("%w: %d", ErrUnsupportedVersion, hdr[0])
+	case hdr[1] != kind:
+		r.err = fmt.Errorf("%w: kind %d, want %d", ErrMalformedEncoding, hdr[1], kind)
+	case hdr[2] == 0 || hdr[2] != ec.curveID():
+		r.err = ErrCurveMismatch
+	}
+	return r
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = fmt.Errorf("%w: truncated", ErrMalformedEncoding)
+		return nil
+	}
+	out := r.b[:n]
+	r.b = r.b[n:]
+	return out
+}
+
+func (r *reader) u8() int {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])
+}
+
+func (r *reader) u16() int {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (r *reader) point() ECPoint {
+	b := r.next(PointSize)
+	if b == nil {
+		return ECPoint{}
+	}
+	p, err := r.ec.DecodePoint(b)
+	r.err = err
+	return p
+}
+
+func (r *reader) scalar() *big.Int {
+	b := r.next(ScalarSize)
+	if b == nil {
+		return nil
+	}
+	s, err := r.ec.DecodeScalar(b)
+	r.err = err
+	return s
+}
+
+func (r *reader) innerProdArg(rounds int) InnerProdArg {
+	if r.err != nil {
+		return InnerProdArg{}
+	}
+	ipp := InnerProdArg{L: make([]ECPoint, rounds), R: make([]ECPoint, rounds)}
+	for i := range ipp.L {
+		ipp.L[i] = r.point()
+	}
+	for i := range ipp.R {
+		ipp.R[i] = r.point()
+	}
+	ipp.A = r.scalar()
+	ipp.B = r.scalar()
+	return ipp
+}
+
+// finish reports the first error, or an error if bytes are left over.
+func (r *reader) finish() error {
+	if r.err == nil && len(r.b) != 0 {
+		r.err = fmt.Errorf("%w: %d trailing bytes", ErrMalformedEncoding, len(r.b))
+	}
+	return r.err
+}
+
+// putFixed writes x big endian into all of out.
+func putFixed(out []byte, x *big.Int) {
+	b := x.Bytes()
+	copy(out[len(out)-len(b):], b)
+}
@@ -0,0 +1,237 @@
+package src
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+/*
+The JSON mapping spells out the binary encoding field by field, with every
+point and scalar as the lowercase hex of its binary encoding:
+
+	{"version":1,"curve":"sm2p256v1","bits":64,"comm":"02…","a":"03…",
+	 "s":…,"t1":…,"t2":…,"tau":"…","th":"…","mu":"…",
+	 "ipp":{"l":["…"],"r":["…"],"a":"…","b":"…"}}
+
+A multi range proof has "bit_lengths" and "comms" in place of "comm".
+Decoding rebuilds the binary encoding and runs it through the same strict
+decoder, so both forms accept exactly the same proofs.
+*/
+type jsonInnerProdArg struct {
+	L []string `json:"l"`
+	R []string `json:"r"`
+	A string   `json:"a"`
+	B string   `json:"b"`
+}
+
+type jsonRangeProof struct {
+	Version int              `json:"version"`
+	Curve   string           `json:"curve"`
+	Bits    int              `json:"bits"`
+	Comm    string           `json:"comm"`
+	A       string           `json:"a"`
+	S       string           `json:"s"`
+	T1      string           `json:"t1"`
+	T2      string           `json:"t2"`
+	Tau     string           `json:"tau"`
+	Th      string           `json:"th"`
+	Mu      string           `json:"mu"`
+	IPP     jsonInnerProdArg `json:"ipp"`
+}
+
+type jsonMultiRangeProof struct {
+	Version    int              `json:"version"`
+	Curve      string           `json:"curve"`
+	Bits       int              `json:"bits"`
+	BitLengths []int            `json:"bit_lengths"`
+	Comms      []string         `json:"comms"`
+	A          string           `json:"a"`
+	S          string           `json:"s"`
+	T1         string           `json:"t1"`
+	T2         string           `json:"t2"`
+	Tau        string           `json:"tau"`
+	Th         string           `json:"th"`
+	Mu         string           `json:"mu"`
+	IPP        jsonInnerProdArg `json:"ipp"`
+}
+
+// RangeProofToJSON returns the JSON mapping of rp.
+func (ec *CryptoParams) RangeProofToJSON(rp RangeProof) ([]byte, error) {
+	b, err := ec.EncodeRangeProof(rp)
+	if err != nil {
+		return nil, err
+	}
+	f := fields{b[headerSize+2:]}
+	return json.Marshal(jsonRangeProof{
+		Version: EncodingVersion,
+		Curve:   ec.curveName(),
+		Bits:    rp.Bits,
+		Comm:    f.point(),
+		A:       f.point(),
+		S:       f.point(),
+		T1:      f.point(),
+		T2:      f.point(),
+		Tau:     f.scalar(),
+		Th:      f.scalar(),
+		Mu:      f.scalar(),
+		IPP:     f.innerProdArg(len(rp.IPP.L)),
+	})
+}
+
+// RangeProofFromJSON parses the JSON mapping of a range proof.
+func (ec *CryptoParams) RangeProofFromJSON(data []byte) (RangeProof, error) {
+	var j jsonRangeProof
+	if err := strictUnmarshal(data, &j); err != nil {
+		return RangeProof{}, err
+	}
+	if err := ec.checkJSONHeader(j.Version, j.Curve); err != nil {
+		return RangeProof{}, err
+	}
+	if _, err := ipaRounds(j.Bits); err != nil {
+		return RangeProof{}, err
+	}
+	w := ec.newWriter(kindRangeProof)
+	w.u16(j.Bits)
+	w.hex(PointSize, j.Comm, j.A, j.S, j.T1, j.T2)
+	w.hex(ScalarSize, j.Tau, j.Th, j.Mu)
+	w.jsonInnerProdArg(j.IPP)
+	if w.err != nil {
+		return RangeProof{}, w.err
+	}
+	return ec.DecodeRangeProof(w.buf)
+}
+
+// MultiRangeProofToJSON returns the JSON mapping of mrp.
+func (ec *CryptoParams) MultiRangeProofToJSON(mrp MultiRangeProof) ([]byte, error) {
+	b, err := ec.EncodeMultiRangeProof(mrp)
+	if err != nil {
+		return nil, err
+	}
+	m := len(mrp.Comms)
+	f := fields{b[headerSize+3+2*m:]}
+	comms := make([]string, m)
+	for i := range comms {
+		comms[i] = f.point()
+	}
+	return json.Marshal(jsonMultiRangeProof{
+		Version:    EncodingVersion,
+		Curve:      ec.curveName(),
+		Bits:       mrp.Bits,
+		BitLengths: mrp.BitLengths,
+		Comms:      comms,
+		A:          f.point(),
+		S:          f.point(),
+		T1:         f.point(),
+		T2:         f.point(),
+		Tau:        f.scalar(),
+		Th:         f.scalar(),
+		Mu:         f.scalar(),
+		IPP:        f.innerProdArg(len(mrp.IPP.L)),
+	})
+}
+
+// MultiRangeProofFromJSON parses the JSON mapping of a multi range proof.
+func (ec *CryptoParams) MultiRangeProofFromJSON(data []byte) (MultiRangeProof, error) {
+	var j jsonMultiRangeProof
+	if err := strictUnmarshal(data, &j); err != nil {
+		return MultiRangeProof{}, err
+	}
+	if err := ec.checkJSONHeader(j.Version, j.Curve); err != nil {
+		return MultiRangeProof{}, err
+	}
+	if err := checkBitLengths(j.Bits, j.BitLengths, len(j.Comms)); err != nil {
+		return MultiRangeProof{}, err
+	}
+	w := ec.newWriter(kindMultiRangeProof)
+	w.u16(j.Bits)
+	w.u8(len(j.Comms))
+	for _, bits := range j.BitLengths {
+		w.u16(bits)
+	}
+	w.hex(PointSize, j.Comms...)
+	w.hex(PointSize, j.A, j.S, j.T1, j.T2)
+	w.hex(ScalarSize, j.Tau, j.Th, j.Mu)
+	w.jsonInnerProdArg(j.IPP)
+	if w.err != nil {
+		return MultiRangeProof{}, w.err
+	}
+	return ec.DecodeMultiRangeProof(w.buf)
+}
+
+func (ec *CryptoParams) checkJSONHeader(version int, curve string) error {
+	if version != EncodingVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	if curve == "" || curve != ec.curveName() {
+		return ErrCurveMismatch
+	}
+	return nil
+}
+
+func strictUnmarshal(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedEncoding, err)
+	}
+	if dec.More() {
+		return fmt.Errorf("%w: trailing data", ErrMalformedEncoding)
+	}
+	return nil
+}
+
+// hex appends each field, which must be size bytes in lowercase hex, as raw
+// bytes for the binary decoder to check.
+func (w *writer) hex(size int, fields ...string) {
+	for _, s := range fields {
+		if w.err != nil {
+			return
+		}
+		b, err := hex.DecodeString(s)
+		if err != nil || len(b) != size || hex.EncodeToString(b) != s {
+			w.err = fmt.Errorf("%w: bad hex field %q", ErrMalformedEncoding, s)
+			return
+		}
+		w.buf = append(w.buf, b...)
+	}
+}
+
+func (w *writer) jsonInnerProdArg(ipp jsonInnerProdArg) {
+	if len(ipp.L) != len(ipp.R) {
+		w.err = fmt.Errorf("%w: L and R not of the same length", ErrMalformedEncoding)
+		return
+	}
+	w.hex(PointSize, ipp.L...)
+	w.hex(PointSize, ipp.R...)
+	w.hex(ScalarSize, ipp.A, ipp.B)
+}
+
+// fields walks an already validated binary encoding and hex encodes it
+// field by field.
+type fields struct {
+	b []byte
+}
+
+func (f *fields) take(n int) string {
+	s := hex.EncodeToString(f.b[:n])
+	f.b = f.b[n:]
+	return s
+}
+
+func (f *fields) point() string  { return f.take(PointSize) }
+func (f *fields) scalar() string { return f.take(ScalarSize) }
+
+func (f *fields) innerProdArg(rounds int) jsonInnerProdArg {
+	ipp := jsonInnerProdArg{L: make([]string, rounds), R: make([]string, rounds)}
+	for i := range ipp.L {
+		ipp.L[i] = f.point()
+	}
+	for i := range ipp.R {
+		ipp.R[i] = f.point()
+	}
+	ipp.A = f.scalar()
+	ipp.B = f.scalar()
+	return ipp
+}
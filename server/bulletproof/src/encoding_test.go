@@ -0,0 +1,233 @@
+package src
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestEncodePointRoundTrip(t *testing.T) {
+	for _, ec := range []*CryptoParams{Params(8), SM2Params(8)} {
+		for _, p := range []ECPoint{ec.G, ec.Neg(ec.G), ec.H, ec.Zero()} {
+			b, err := ec.EncodePoint(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := ec.DecodePoint(b)
+			if err != nil || !q.Equal(p) {
+				t.Error("*****Point round trip FAILURE", err)
+			}
+		}
+	}
+}
+
+func TestDecodePointRejectsBadPoints(t *testing.T) {
+	ec := SM2Params(8)
+	good, _ := ec.EncodePoint(ec.G)
+
+	bad := [][]byte{
+		good[:32],
+		append([]byte{0x04}, good[1:]...),
+		append([]byte{0x00}, good[1:]...),
+		append([]byte{0x02}, bytes.Repeat([]byte{0xff}, 32)...),
+	}
+	// an x with no point over it
+	for x := int64(1); ; x++ {
+		b := make([]byte, PointSize)
+		b[0] = 0x02
+		putFixed(b[1:], big.NewInt(x))
+		if _, err := ec.DecodePoint(b); err != nil {
+			bad = append(bad, b)
+			break
+		}
+	}
+	for i, b := range bad {
+		if _, err := ec.DecodePoint(b); !errors.Is(err, ErrMalformedEncoding) {
+			t.Error("*****DecodePoint accepted bad point", i)
+		}
+	}
+}
+
+func TestEncodeRangeProofRoundTrip(t *testing.T) {
+	ec := SM2Params(64)
+	rp := mustRP(ec.RPProve(big.NewInt(1234), []byte("order-1")))
+
+	b, err := ec.EncodeRangeProof(rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ec.DecodeRangeProof(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ec.EncodeRangeProof(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, again) {
+		t.Error("*****Range proof re-encoding FAILURE")
+	}
+	if verified(ec.RPVerify(decoded, []byte("order-1"))) {
+		fmt.Println("Range proof of", len(b), "bytes round trips")
+	} else {
+		t.Error("*****Decoded range proof does not verify")
+	}
+}
+
+func TestEncodeMultiRangeProofRoundTrip(t *testing.T) {
+	ec := SM2Params(16)
+	mrp := mustMRP(ec.MRPProveBits([]*big.Int{big.NewInt(200), big.NewInt(3)}, []int{8, 4}, []byte("order-1")))
+
+	b, err := ec.EncodeMultiRangeProof(mrp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ec.DecodeMultiRangeProof(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ec.EncodeMultiRangeProof(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, again) {
+		t.Error("*****Multi range proof re-encoding FAILURE")
+	}
+	if !verified(ec.MRPVerifyBits(decoded, []int{8, 4}, []byte("order-1"))) {
+		t.Error("*****Decoded multi range proof does not verify")
+	}
+}
+
+func TestEncodeEqualityProofRoundTrip(t *testing.T) {
+	ec := SM2Params(8)
+	ca, oa := mustCommit(ec.PedersenCommit(big.NewInt(25)))
+	cb, ob := mustCommit(ec.PedersenCommit(big.NewInt(25)))
+	proof := mustEq(ec.ProveEqualCommitments(oa, ob, []byte("order-1")))
+
+	b, err := ec.EncodeEqualityProof(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ec.DecodeEqualityProof(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := ec.EncodeEqualityProof(decoded)
+	if !bytes.Equal(b, again) || !verified(ec.VerifyEqualCommitments(ca, cb, decoded, []byte("order-1"))) {
+		t.Error("*****Equality proof round trip FAILURE")
+	}
+}
+
+func TestEncodeBalanceProofRoundTrip(t *testing.T) {
+	ec := SM2Params(8)
+	x, P, C1, C2 := balanceCipher(t, ec, 70)
+	D, o := mustCommit(ec.PedersenCommit(big.NewInt(70)))
+	proof := mustBalance(ec.ProveBalance(x, C1, C2, o, []byte("order-6")))
+
+	b, err := ec.EncodeBalanceProof(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != headerSize+3*PointSize+3*ScalarSize {
+		t.Errorf("*****Balance proof is %d bytes", len(b))
+	}
+	decoded, err := ec.DecodeBalanceProof(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := ec.EncodeBalanceProof(decoded)
+	if !bytes.Equal(b, again) || !verified(ec.VerifyBalance(P, C1, C2, D, decoded, []byte("order-6"))) {
+		t.Error("*****Balance proof round trip FAILURE")
+	}
+	if _, err := ec.DecodeBalanceProof(b[:len(b)-1]); !errors.Is(err, ErrMalformedEncoding) {
+		t.Errorf("*****Truncated balance proof: got %v, want ErrMalformedEncoding", err)
+	}
+}
+
+func TestDecodeRangeProofStrict(t *testing.T) {
+	ec := SM2Params(8)
+	rp := mustRP(ec.RPProve(big.NewInt(7), nil))
+	good, err := ec.EncodeRangeProof(rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mutate := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+	cases := map[string]struct {
+		b   []byte
+		err error
+	}{
+		"truncated":   {good[:len(good)-1], ErrMalformedEncoding},
+		"trailing":    {append(append([]byte(nil), good...), 0), ErrMalformedEncoding},
+		"version":     {mutate(func(b []byte) []byte { b[0] = 2; return b }), ErrUnsupportedVersion},
+		"kind":        {mutate(func(b []byte) []byte { b[1] = kindMultiRangeProof; return b }), ErrMalformedEncoding},
+		"curve":       {mutate(func(b []byte) []byte { b[2] = curveSecp256k1; return b }), ErrCurveMismatch},
+		"bits":        {mutate(func(b []byte) []byte { b[4] = 6; return b }), ErrMalformedEncoding},
+		"point":       {mutate(func(b []byte) []byte { b[headerSize+2] = 0x05; return b }), ErrMalformedEncoding},
+		"scalar >= N": {mutate(func(b []byte) []byte { copy(b[headerSize+2+5*PointSize:], ec.N.Bytes()); return b }), ErrMalformedEncoding},
+	}
+	for name, c := range cases {
+		if _, err := ec.DecodeRangeProof(c.b); !errors.Is(err, c.err) {
+			t.Errorf("*****DecodeRangeProof %s: got %v, want %v", name, err, c.err)
+		}
+	}
+
+	// a proof over the other curve is rejected rather than misread
+	if _, err := Params(8).DecodeRangeProof(good); !errors.Is(err, ErrCurveMismatch) {
+		t.Error("*****DecodeRangeProof accepted a proof for another curve")
+	}
+}
+
+func TestRangeProofJSONRoundTrip(t *testing.T) {
+	ec := SM2Params(8)
+	rp := mustRP(ec.RPProve(big.NewInt(7), nil))
+
+	j, err := ec.RangeProofToJSON(rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ec.RangeProofFromJSON(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := ec.EncodeRangeProof(rp)
+	got, _ := ec.EncodeRangeProof(decoded)
+	if !bytes.Equal(want, got) {
+		t.Error("*****Range proof JSON round trip FAILURE")
+	}
+
+	// hex must be lowercase so the JSON form is canonical too
+	comm, _ := ec.EncodePoint(rp.Comm)
+	lower := []byte(hex.EncodeToString(comm))
+	upper := bytes.Replace(j, lower, bytes.ToUpper(lower), 1)
+	if _, err := ec.RangeProofFromJSON(upper); !errors.Is(err, ErrMalformedEncoding) {
+		t.Error("*****RangeProofFromJSON accepted uppercase hex")
+	}
+	if _, err := ec.RangeProofFromJSON(append(j[:len(j)-1], []byte(`,"extra":1}`)...)); err == nil {
+		t.Error("*****RangeProofFromJSON accepted an unknown field")
+	}
+}
+
+func TestMultiRangeProofJSONRoundTrip(t *testing.T) {
+	ec := SM2Params(16)
+	mrp := mustMRP(ec.MRPProveBits([]*big.Int{big.NewInt(200), big.NewInt(3)}, []int{8, 4}, nil))
+
+	j, err := ec.MultiRangeProofToJSON(mrp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ec.MultiRangeProofFromJSON(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := ec.EncodeMultiRangeProof(mrp)
+	got, _ := ec.EncodeMultiRangeProof(decoded)
+	if !bytes.Equal(want, got) {
+		t.Error("*****Multi range proof JSON round trip FAILURE")
+	}
+}
@@ -0,0 +1,76 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestECPointEqual(t *testing.T) {
+	ec := NewSM2GroupKey(1)
+	if !ec.G.Equal(ec.G) {
+		t.Error("*****ECPoint.Equal rejects equal points")
+	}
+	if ec.G.Equal(ec.Neg(ec.G)) {
+		t.Error("*****ECPoint.Equal ignores the Y coordinate")
+	}
+}
+
+func TestEqualCommitments(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+	ca, oa := mustCommit(ec.PedersenCommit(big.NewInt(25)))
+	cb, ob := mustCommit(ec.PedersenCommit(big.NewInt(25)))
+
+	proof := mustEq(ec.ProveEqualCommitments(oa, ob, []byte("order-1")))
+	if verified(ec.VerifyEqualCommitments(ca, cb, proof, []byte("order-1"))) {
+		fmt.Println("Commitment equality proof works")
+	} else {
+		t.Error("*****Commitment equality proof FAILURE")
+	}
+}
+
+func TestEqualCommitmentsDifferentValues(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+	ca, oa := mustCommit(ec.PedersenCommit(big.NewInt(25)))
+	cb, ob := mustCommit(ec.PedersenCommit(big.NewInt(26)))
+
+	// a cheating prover claims cb opens to 25
+	lie := Opening{big.NewInt(25), ob.Blinding}
+	proof := mustEq(ec.ProveEqualCommitments(oa, lie, []byte("order-1")))
+	if verified(ec.VerifyEqualCommitments(ca, cb, proof, []byte("order-1"))) {
+		t.Error("*****Commitment equality proof accepted different values")
+	}
+}
+
+func TestEqualCommitmentsTampered(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+	ca, oa := mustCommit(ec.PedersenCommit(big.NewInt(25)))
+	cb, ob := mustCommit(ec.PedersenCommit(big.NewInt(25)))
+	proof := mustEq(ec.ProveEqualCommitments(oa, ob, []byte("order-1")))
+
+	if verified(ec.VerifyEqualCommitments(ca, cb, proof, []byte("order-2"))) {
+		t.Error("*****Commitment equality proof replayed under another context")
+	}
+	if verified(ec.VerifyEqualCommitments(cb, ca, proof, []byte("order-1"))) {
+		t.Error("*****Commitment equality proof accepted swapped commitments")
+	}
+	other, _ := mustCommit(ec.PedersenCommit(big.NewInt(25)))
+	if verified(ec.VerifyEqualCommitments(ca, other, proof, []byte("order-1"))) {
+		t.Error("*****Commitment equality proof accepted a different commitment")
+	}
+	bad := EqualityProof{proof.R, new(big.Int).Add(proof.S, big.NewInt(1))}
+	if verified(ec.VerifyEqualCommitments(ca, cb, bad, []byte("order-1"))) {
+		t.Error("*****Commitment equality proof accepted a tampered response")
+	}
+}
+
+func TestProveEqualCommitmentsValuesDiffer(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+	_, oa := mustCommit(ec.PedersenCommit(big.NewInt(25)))
+	_, ob := mustCommit(ec.PedersenCommit(big.NewInt(26)))
+
+	if _, err := ec.ProveEqualCommitments(oa, ob, nil); !errors.Is(err, ErrValuesDiffer) {
+		t.Error("*****Commitment equality proof of different values gave", err)
+	}
+}
@@ -0,0 +1,25 @@
+package src
+
+import "errors"
+
+/*
+Errors returned by the provers and verifiers. They are wrapped with details,
+so compare with errors.Is.
+
+A verifier returns an error when it cannot check a proof at all, because the
+proof is malformed, was made for other params, or is over another commitment
+or bit lengths than the caller asked for; a well-formed proof that fails its
+equations is (false, nil).
+*/
+var (
+	ErrOutOfRange      = errors.New("bulletproof: value out of range")
+	ErrLengthMismatch  = errors.New("bulletproof: lengths don't match")
+	ErrBitLengths      = errors.New("bulletproof: bit lengths don't fit the params")
+	ErrValuesDiffer    = errors.New("bulletproof: committed values differ")
+	ErrMalformedProof  = errors.New("bulletproof: malformed proof")
+	ErrWrongCommitment = errors.New("bulletproof: proof is over another commitment")
+
+	ErrMalformedEncoding  = errors.New("bulletproof: malformed encoding")
+	ErrUnsupportedVersion = errors.New("bulletproof: unsupported encoding version")
+	ErrCurveMismatch      = errors.New("bulletproof: proof is for another curve")
+)
@@ -1,11 +1,9 @@
 package src
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"math"
 	"math/big"
-	"strconv"
 )
 
 /*
@@ -19,31 +17,29 @@ type InnerProdArg struct {
 	R []ECPoint
 	A *big.Int
 	B *big.Int
-
-	Challenges []*big.Int
 }
 
-func GenerateNewParams(G, H []ECPoint, x *big.Int, L, R, P ECPoint) ([]ECPoint, []ECPoint, ECPoint) {
+func (ec *CryptoParams) GenerateNewParams(G, H []ECPoint, x *big.Int, L, R, P ECPoint) ([]ECPoint, []ECPoint, ECPoint) {
 	nprime := len(G) / 2
 
 	Gprime := make([]ECPoint, nprime)
 	Hprime := make([]ECPoint, nprime)
 
-	xinv := new(big.Int).ModInverse(x, EC.N)
+	xinv := new(big.Int).ModInverse(x, ec.N)
 
 	// Gprime = xinv * G[:nprime] + x*G[nprime:]
 	// Hprime = x * H[:nprime] + xinv*H[nprime:]
 
 	for i := range Gprime {
 		//fmt.Printf("i: %d && i+nprime: %d\n", i, i+nprime)
-		Gprime[i] = G[i].Mult(xinv).Add(G[i+nprime].Mult(x))
-		Hprime[i] = H[i].Mult(x).Add(H[i+nprime].Mult(xinv))
+		Gprime[i] = ec.Add(ec.Mult(G[i], xinv), ec.Mult(G[i+nprime], x))
+		Hprime[i] = ec.Add(ec.Mult(H[i], x), ec.Mult(H[i+nprime], xinv))
 	}
 
-	x2 := new(big.Int).Mod(new(big.Int).Mul(x, x), EC.N)
-	xinv2 := new(big.Int).ModInverse(x2, EC.N)
+	x2 := new(big.Int).Mod(new(big.Int).Mul(x, x), ec.N)
+	xinv2 := new(big.Int).ModInverse(x2, ec.N)
 
-	Pprime := L.Mult(x2).Add(P).Add(R.Mult(xinv2)) // x^2 * L + P + xinv^2 * R
+	Pprime := ec.Add(ec.Mult(L, x2), P, ec.Mult(R, xinv2)) // x^2 * L + P + xinv^2 * R
 
 	return Gprime, Hprime, Pprime
 }
@@ -55,7 +51,7 @@ Proves that <a,b>=c
 This is a building block for BulletProofs
 
 */
-func InnerProductProveSub(proof InnerProdArg, G, H []ECPoint, a []*big.Int, b []*big.Int, u ECPoint, P ECPoint) InnerProdArg {
+func (ec *CryptoParams) InnerProductProveSub(proof InnerProdArg, G, H []ECPoint, a []*big.Int, b []*big.Int, u ECPoint, P ECPoint, t *Transcript) InnerProdArg {
 	//fmt.Printf("Proof so far: %s\n", proof)
 	if len(a) == 1 {
 		// Prover sends a & b
@@ -70,42 +66,68 @@ func InnerProductProveSub(proof InnerProdArg, G, H []ECPoint, a []*big.Int, b []
 	nprime := len(a) / 2
 	//fmt.Println(nprime)
 	//fmt.Println(len(H))
-	cl := InnerProduct(a[:nprime], b[nprime:]) // either this line
-	cr := InnerProduct(a[nprime:], b[:nprime]) // or this line
-	L := TwoVectorPCommitWithGens(G[nprime:], H[:nprime], a[:nprime], b[nprime:]).Add(u.Mult(cl))
-	R := TwoVectorPCommitWithGens(G[:nprime], H[nprime:], a[nprime:], b[:nprime]).Add(u.Mult(cr))
+	cl := ec.InnerProduct(a[:nprime], b[nprime:]) // either this line
+	cr := ec.InnerProduct(a[nprime:], b[:nprime]) // or this line
+	L := ec.Add(ec.vectorCommit(G[nprime:], H[:nprime], a[:nprime], b[nprime:]), ec.Mult(u, cl))
+	R := ec.Add(ec.vectorCommit(G[:nprime], H[nprime:], a[nprime:], b[:nprime]), ec.Mult(u, cr))
 
 	proof.L[curIt] = L
 	proof.R[curIt] = R
 
 	// prover sends L & R and gets a challenge
-	s256 := sha256.Sum256([]byte(
-		L.X.String() + L.Y.String() +
-			R.X.String() + R.Y.String()))
-
-	x := new(big.Int).SetBytes(s256[:])
-
-	proof.Challenges[curIt] = x
+	t.AppendPoint("L", L)
+	t.AppendPoint("R", R)
+	x := t.ChallengeScalar("x", ec.N)
 
-	Gprime, Hprime, Pprime := GenerateNewParams(G, H, x, L, R, P)
+	Gprime, Hprime, Pprime := ec.GenerateNewParams(G, H, x, L, R, P)
 	//fmt.Printf("Prover - Intermediate Pprime value: %s \n", Pprime)
-	xinv := new(big.Int).ModInverse(x, EC.N)
+	xinv := new(big.Int).ModInverse(x, ec.N)
 
 	// or these two lines
-	aprime := VectorAdd(
-		ScalarVectorMul(a[:nprime], x),
-		ScalarVectorMul(a[nprime:], xinv))
-	bprime := VectorAdd(
-		ScalarVectorMul(b[:nprime], xinv),
-		ScalarVectorMul(b[nprime:], x))
-
-	return InnerProductProveSub(proof, Gprime, Hprime, aprime, bprime, u, Pprime)
+	aprime := ec.VectorAdd(
+		ec.ScalarVectorMul(a[:nprime], x),
+		ec.ScalarVectorMul(a[nprime:], xinv))
+	bprime := ec.VectorAdd(
+		ec.ScalarVectorMul(b[:nprime], xinv),
+		ec.ScalarVectorMul(b[nprime:], x))
+
+	return ec.InnerProductProveSub(proof, Gprime, Hprime, aprime, bprime, u, Pprime, t)
 }
 
-func InnerProductProve(a []*big.Int, b []*big.Int, c *big.Int, P, U ECPoint, G, H []ECPoint) InnerProdArg {
+/*
+InnerProductProve proves that P commits to a and b with <a,b>=c. The
+challenges are drawn from t after absorbing P, so t should already hold
+everything the surrounding protocol has absorbed; callers using the argument
+on its own pass a fresh transcript and the verifier must start from the same
+one.
+*/
+func (ec *CryptoParams) InnerProductProve(a []*big.Int, b []*big.Int, c *big.Int, P, U ECPoint, G, H []ECPoint, t *Transcript) (InnerProdArg, error) {
+	if err := checkInnerProductLengths(len(a), len(b), G, H); err != nil {
+		return InnerProdArg{}, err
+	}
+	t.AppendPoint("P", P)
+	return ec.innerProductProve(a, b, c, P, U, G, H, t), nil
+}
+
+// checkInnerProductLengths checks that a, b, G and H all have the same
+// power of two length, as the halving rounds require.
+func checkInnerProductLengths(la, lb int, G, H []ECPoint) error {
+	n := len(G)
+	if la != n || lb != n || len(H) != n {
+		return fmt.Errorf("%w: len(a)=%d len(b)=%d len(G)=%d len(H)=%d", ErrLengthMismatch, la, lb, n, len(H))
+	}
+	if n == 0 || n&(n-1) != 0 {
+		return fmt.Errorf("%w: length %d is not a power of two", ErrLengthMismatch, n)
+	}
+	return nil
+}
+
+// innerProductProve is InnerProductProve for protocols whose transcript
+// already determines P, as in the range proofs, so P need not be absorbed
+// and a batch verifier never has to compute it.
+func (ec *CryptoParams) innerProductProve(a []*big.Int, b []*big.Int, c *big.Int, P, U ECPoint, G, H []ECPoint, t *Transcript) InnerProdArg {
 	loglen := int(math.Log2(float64(len(a))))
 
-	challenges := make([]*big.Int, loglen+1)
 	Lvals := make([]ECPoint, loglen)
 	Rvals := make([]ECPoint, loglen)
 
@@ -113,18 +135,38 @@ func InnerProductProve(a []*big.Int, b []*big.Int, c *big.Int, P, U ECPoint, G,
 		Lvals,
 		Rvals,
 		big.NewInt(0),
-		big.NewInt(0),
-		challenges}
+		big.NewInt(0)}
 
-	// randomly generate an x value from public data
-	x := sha256.Sum256([]byte(P.X.String() + P.Y.String()))
+	// generate an x value from the transcript
+	x := ec.innerProductChallenge(t, c)
 
-	runningProof.Challenges[loglen] = new(big.Int).SetBytes(x[:])
-
-	Pprime := P.Add(U.Mult(new(big.Int).Mul(new(big.Int).SetBytes(x[:]), c)))
-	ux := U.Mult(new(big.Int).SetBytes(x[:]))
+	Pprime := ec.Add(P, ec.Mult(U, new(big.Int).Mul(x, c)))
+	ux := ec.Mult(U, x)
 	//fmt.Printf("Prover Pprime value to run sub off of: %s\n", Pprime)
-	return InnerProductProveSub(runningProof, G, H, a, b, ux, Pprime)
+	return ec.InnerProductProveSub(runningProof, G, H, a, b, ux, Pprime, t)
+}
+
+func (ec *CryptoParams) innerProductChallenge(t *Transcript, c *big.Int) *big.Int {
+	t.AppendMessage("dom-sep", []byte("inner-product"))
+	t.AppendScalar("c", c)
+	return t.ChallengeScalar("x", ec.N)
+}
+
+// innerProductChallenges replays the round challenges of ipp from t, in the
+// order the prover drew them. It returns nil if ipp has the wrong shape for
+// generators of length n.
+func (ec *CryptoParams) innerProductChallenges(t *Transcript, n int, ipp InnerProdArg) []*big.Int {
+	loglen := int(math.Log2(float64(n)))
+	if n <= 0 || 1<<uint(loglen) != n || len(ipp.L) != loglen || len(ipp.R) != loglen || ipp.A == nil || ipp.B == nil {
+		return nil
+	}
+	challenges := make([]*big.Int, loglen)
+	for j := loglen - 1; j >= 0; j-- {
+		t.AppendPoint("L", ipp.L[j])
+		t.AppendPoint("R", ipp.R[j])
+		challenges[j] = t.ChallengeScalar("x", ec.N)
+	}
+	return challenges
 }
 
 /* Inner Product Verify
@@ -137,60 +179,51 @@ P : the Pedersen commitment we are verifying is a commitment to the innner produ
 ipp : the proof
 
 */
-func InnerProductVerify(c *big.Int, P, U ECPoint, G, H []ECPoint, ipp InnerProdArg) bool {
+func (ec *CryptoParams) InnerProductVerify(c *big.Int, P, U ECPoint, G, H []ECPoint, ipp InnerProdArg, t *Transcript) (bool, error) {
 	//fmt.Println("Verifying Inner Product Argument")
 	//fmt.Printf("Commitment Value: %s \n", P)
-	s1 := sha256.Sum256([]byte(P.X.String() + P.Y.String()))
-	chal1 := new(big.Int).SetBytes(s1[:])
-	ux := U.Mult(chal1)
-	curIt := len(ipp.Challenges) - 1
-
-	if ipp.Challenges[curIt].Cmp(chal1) != 0 {
-		fmt.Println("IPVerify - Initial Challenge Failed")
-		return false
+	if err := checkInnerProductLengths(len(G), len(G), G, H); err != nil {
+		return false, err
 	}
+	t.AppendPoint("P", P)
+	chal1 := ec.innerProductChallenge(t, c)
+	ux := ec.Mult(U, chal1)
 
-	curIt -= 1
+	challenges := ec.innerProductChallenges(t, len(G), ipp)
+	if challenges == nil {
+		return false, fmt.Errorf("%w: inner product argument has the wrong shape", ErrMalformedProof)
+	}
+	curIt := len(challenges) - 1
 
 	Gprime := G
 	Hprime := H
-	Pprime := P.Add(ux.Mult(c)) // line 6 from protocol 1
+	Pprime := ec.Add(P, ec.Mult(ux, c)) // line 6 from protocol 1
 	//fmt.Printf("New Commitment value with u^cx: %s \n", Pprime)
 
 	for curIt >= 0 {
 		Lval := ipp.L[curIt]
 		Rval := ipp.R[curIt]
 
-		// prover sends L & R and gets a challenge
-		s256 := sha256.Sum256([]byte(
-			Lval.X.String() + Lval.Y.String() +
-				Rval.X.String() + Rval.Y.String()))
-
-		chal2 := new(big.Int).SetBytes(s256[:])
+		chal2 := challenges[curIt]
 
-		if ipp.Challenges[curIt].Cmp(chal2) != 0 {
-			fmt.Println("IPVerify - Challenge verification failed at index " + strconv.Itoa(curIt))
-			return false
-		}
-
-		Gprime, Hprime, Pprime = GenerateNewParams(Gprime, Hprime, chal2, Lval, Rval, Pprime)
+		Gprime, Hprime, Pprime = ec.GenerateNewParams(Gprime, Hprime, chal2, Lval, Rval, Pprime)
 		curIt -= 1
 	}
-	ccalc := new(big.Int).Mod(new(big.Int).Mul(ipp.A, ipp.B), EC.N)
+	ccalc := new(big.Int).Mod(new(big.Int).Mul(ipp.A, ipp.B), ec.N)
 
-	Pcalc1 := Gprime[0].Mult(ipp.A)
-	Pcalc2 := Hprime[0].Mult(ipp.B)
-	Pcalc3 := ux.Mult(ccalc)
-	Pcalc := Pcalc1.Add(Pcalc2).Add(Pcalc3)
+	Pcalc1 := ec.Mult(Gprime[0], ipp.A)
+	Pcalc2 := ec.Mult(Hprime[0], ipp.B)
+	Pcalc3 := ec.Mult(ux, ccalc)
+	Pcalc := ec.Add(Pcalc1, Pcalc2, Pcalc3)
 
 	if !Pprime.Equal(Pcalc) {
 		fmt.Println("IPVerify - Final Commitment checking failed")
 		fmt.Printf("Final Pprime value: %s \n", Pprime)
 		fmt.Printf("Calculated Pprime value to check against: %s \n", Pcalc)
-		return false
+		return false, nil
 	}
 
-	return true
+	return true, nil
 }
 
 /* Inner Product Verify Fast
@@ -198,72 +231,48 @@ Given a inner product proof, verifies the correctness of the proof. Does the sam
 we replace n separate exponentiations with a single multi-exponentiation.
 */
 
-func InnerProductVerifyFast(c *big.Int, P, U ECPoint, G, H []ECPoint, ipp InnerProdArg) bool {
-	//fmt.Println("Verifying Inner Product Argument")
-	//fmt.Printf("Commitment Value: %s \n", P)
-	s1 := sha256.Sum256([]byte(P.X.String() + P.Y.String()))
-	chal1 := new(big.Int).SetBytes(s1[:])
-	ux := U.Mult(chal1)
-	curIt := len(ipp.Challenges) - 1
-
-	// check all challenges
-	if ipp.Challenges[curIt].Cmp(chal1) != 0 {
-		fmt.Println("IPVerify - Initial Challenge Failed")
-		return false
+func (ec *CryptoParams) InnerProductVerifyFast(c *big.Int, P, U ECPoint, G, H []ECPoint, ipp InnerProdArg, t *Transcript) (bool, error) {
+	if err := checkInnerProductLengths(len(G), len(G), G, H); err != nil {
+		return false, err
 	}
+	t.AppendPoint("P", P)
+	if !ec.validInnerProdArg(ipp, len(G)) {
+		return false, fmt.Errorf("%w: inner product argument has the wrong shape", ErrMalformedProof)
+	}
+	return ec.innerProductVerifyFast(c, P, U, G, H, ipp, t), nil
+}
 
-	for j := curIt - 1; j >= 0; j-- {
-		Lval := ipp.L[j]
-		Rval := ipp.R[j]
-
-		// prover sends L & R and gets a challenge
-		s256 := sha256.Sum256([]byte(
-			Lval.X.String() + Lval.Y.String() +
-				Rval.X.String() + Rval.Y.String()))
-
-		chal2 := new(big.Int).SetBytes(s256[:])
+// innerProductVerifyFast is InnerProductVerifyFast for a P that t already
+// determines, see innerProductProve.
+func (ec *CryptoParams) innerProductVerifyFast(c *big.Int, P, U ECPoint, G, H []ECPoint, ipp InnerProdArg, t *Transcript) bool {
+	//fmt.Println("Verifying Inner Product Argument")
+	//fmt.Printf("Commitment Value: %s \n", P)
+	chal1 := ec.innerProductChallenge(t, c)
+	ux := ec.Mult(U, chal1)
 
-		if ipp.Challenges[j].Cmp(chal2) != 0 {
-			fmt.Println("IPVerify - Challenge verification failed at index " + strconv.Itoa(j))
-			return false
-		}
+	challenges := ec.innerProductChallenges(t, len(G), ipp)
+	if challenges == nil {
+		return false
 	}
 	// begin computing
 
-	curIt -= 1
-	Pprime := P.Add(ux.Mult(c)) // line 6 from protocol 1
+	curIt := len(challenges) - 1
+	Pprime := ec.Add(P, ec.Mult(ux, c)) // line 6 from protocol 1
 
-	tmp1 := EC.Zero()
+	tmp1 := ec.Zero()
 	for j := curIt; j >= 0; j-- {
-		x2 := new(big.Int).Exp(ipp.Challenges[j], big.NewInt(2), EC.N)
-		x2i := new(big.Int).ModInverse(x2, EC.N)
+		x2 := new(big.Int).Exp(challenges[j], big.NewInt(2), ec.N)
+		x2i := new(big.Int).ModInverse(x2, ec.N)
 		//fmt.Println(tmp1)
-		tmp1 = ipp.L[j].Mult(x2).Add(ipp.R[j].Mult(x2i)).Add(tmp1)
+		tmp1 = ec.Add(ec.Mult(ipp.L[j], x2), ec.Mult(ipp.R[j], x2i), tmp1)
 		//fmt.Println(tmp1)
 	}
-	rhs := Pprime.Add(tmp1)
+	rhs := ec.Add(Pprime, tmp1)
 
-	sScalars := make([]*big.Int, EC.V)
-	invsScalars := make([]*big.Int, EC.V)
+	sScalars, invsScalars := ec.innerProductScalars(challenges, len(G))
 
-	for i := 0; i < EC.V; i++ {
-		si := big.NewInt(1)
-		for j := curIt; j >= 0; j-- {
-			// original challenge if the jth bit of i is 1, inverse challenge otherwise
-			chal := ipp.Challenges[j]
-			if big.NewInt(int64(i)).Bit(j) == 0 {
-				chal = new(big.Int).ModInverse(chal, EC.N)
-			}
-			// fmt.Printf("Challenge raised to value: %d\n", chal)
-			si = new(big.Int).Mod(new(big.Int).Mul(si, chal), EC.N)
-		}
-		//fmt.Printf("Si value: %d\n", si)
-		sScalars[i] = si
-		invsScalars[i] = new(big.Int).ModInverse(si, EC.N)
-	}
-
-	ccalc := new(big.Int).Mod(new(big.Int).Mul(ipp.A, ipp.B), EC.N)
-	lhs := TwoVectorPCommitWithGens(G, H, ScalarVectorMul(sScalars, ipp.A), ScalarVectorMul(invsScalars, ipp.B)).Add(ux.Mult(ccalc))
+	ccalc := new(big.Int).Mod(new(big.Int).Mul(ipp.A, ipp.B), ec.N)
+	lhs := ec.Add(ec.vectorCommit(G, H, ec.ScalarVectorMul(sScalars, ipp.A), ec.ScalarVectorMul(invsScalars, ipp.B)), ec.Mult(ux, ccalc))
 
 	if !rhs.Equal(lhs) {
 		fmt.Println("IPVerify - Final Commitment checking failed")
@@ -274,3 +283,40 @@ func InnerProductVerifyFast(c *big.Int, P, U ECPoint, G, H []ECPoint, ipp InnerP
 
 	return true
 }
+
+// validInnerProdArg reports whether ipp has log2(n) rounds of points on the
+// curve and reduced final scalars.
+func (ec *CryptoParams) validInnerProdArg(ipp InnerProdArg, n int) bool {
+	loglen := int(math.Log2(float64(n)))
+	if len(ipp.L) != loglen || len(ipp.R) != loglen || !ec.validScalar(ipp.A) || !ec.validScalar(ipp.B) {
+		return false
+	}
+	for j := range ipp.L {
+		if !ec.onCurve(ipp.L[j]) || !ec.onCurve(ipp.R[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// innerProductScalars returns the scalars s_i by which the folded a and b are
+// spread back over the original n generators, and their inverses.
+func (ec *CryptoParams) innerProductScalars(challenges []*big.Int, n int) ([]*big.Int, []*big.Int) {
+	sScalars := make([]*big.Int, n)
+	invsScalars := make([]*big.Int, n)
+
+	for i := 0; i < n; i++ {
+		si := big.NewInt(1)
+		for j := len(challenges) - 1; j >= 0; j-- {
+			// original challenge if the jth bit of i is 1, inverse challenge otherwise
+			chal := challenges[j]
+			if big.NewInt(int64(i)).Bit(j) == 0 {
+				chal = new(big.Int).ModInverse(chal, ec.N)
+			}
+			si = new(big.Int).Mod(new(big.Int).Mul(si, chal), ec.N)
+		}
+		sScalars[i] = si
+		invsScalars[i] = new(big.Int).ModInverse(si, ec.N)
+	}
+	return sScalars, invsScalars
+}
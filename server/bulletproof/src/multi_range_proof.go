@@ -1,42 +1,83 @@
 package src
 
 import (
-	"crypto/rand"
-	"crypto/sha256"
 	"fmt"
 	"math/big"
 )
 
 type MultiRangeProof struct {
-	Comms []ECPoint
-	A     ECPoint
-	S     ECPoint
-	T1    ECPoint
-	T2    ECPoint
-	Tau   *big.Int
-	Th    *big.Int
-	Mu    *big.Int
-	IPP   InnerProdArg
-
-	// challenges
-	Cy *big.Int
-	Cz *big.Int
-	Cx *big.Int
+	Bits       int   // total bit length of all values, i.e. the V of its CryptoParams
+	BitLengths []int // bit length of each value, at most Bits/len(Comms)
+	Comms      []ECPoint
+	A          ECPoint
+	S          ECPoint
+	T1         ECPoint
+	T2         ECPoint
+	Tau        *big.Int
+	Th         *big.Int
+	Mu         *big.Int
+	IPP        InnerProdArg
+}
+
+// multiRangeProofTranscript is rangeProofTranscript for all of Comms and
+// their bit lengths.
+func (ec *CryptoParams) multiRangeProofTranscript(comms []ECPoint, bits []int, context []byte) *Transcript {
+	t := NewTranscript("bulletproof/multi-range-proof")
+	t.AppendParams(ec)
+	t.AppendMessage("m", fixedBytes(big.NewInt(int64(len(comms)))))
+	for j, c := range comms {
+		t.AppendMessage("bits", fixedBytes(big.NewInt(int64(bits[j]))))
+		t.AppendPoint("V", c)
+	}
+	t.AppendMessage("context", context)
+	return t
+}
+
+/*
+slotPowersOfTwo returns the vector (1, 2, ..., 2^(bits-1), 0, ..., 0) of
+length width. A value with fewer bits than its slot is padded with bits that
+are still proven to be 0 or 1 but carry no weight, so only the low bits make
+up the value.
+*/
+func slotPowersOfTwo(width, bits int) []*big.Int {
+	result := make([]*big.Int, width)
+	for i := range result {
+		if i < bits {
+			result[i] = new(big.Int).Lsh(big.NewInt(1), uint(i))
+		} else {
+			result[i] = big.NewInt(0)
+		}
+	}
+	return result
+}
+
+// validBitLengths reports whether bits gives every one of m values a bit
+// length that fits its slot of ec.V/m bits.
+func (ec *CryptoParams) validBitLengths(bits []int, m int) bool {
+	if m == 0 || len(bits) != m || ec.V%m != 0 {
+		return false
+	}
+	for _, b := range bits {
+		if b < 1 || b > ec.V/m {
+			return false
+		}
+	}
+	return true
 }
 
 // Calculates (aL - z*1^n) + sL*x
-func CalculateLMRP(aL, sL []*big.Int, z, x *big.Int) []*big.Int {
+func (ec *CryptoParams) CalculateLMRP(aL, sL []*big.Int, z, x *big.Int) []*big.Int {
 	result := make([]*big.Int, len(aL))
 
-	tmp1 := VectorAddScalar(aL, new(big.Int).Neg(z))
-	tmp2 := ScalarVectorMul(sL, x)
+	tmp1 := ec.VectorAddScalar(aL, new(big.Int).Neg(z))
+	tmp2 := ec.ScalarVectorMul(sL, x)
 
-	result = VectorAdd(tmp1, tmp2)
+	result = ec.VectorAdd(tmp1, tmp2)
 
 	return result
 }
 
-func CalculateRMRP(aR, sR, y, zTimesTwo []*big.Int, z, x *big.Int) []*big.Int {
+func (ec *CryptoParams) CalculateRMRP(aR, sR, y, zTimesTwo []*big.Int, z, x *big.Int) []*big.Int {
 	if len(aR) != len(sR) || len(aR) != len(y) || len(y) != len(zTimesTwo) {
 		fmt.Println("CalculateR: Uh oh! Arrays not of the same length")
 		fmt.Printf("len(aR): %d\n", len(aR))
@@ -47,11 +88,11 @@ func CalculateRMRP(aR, sR, y, zTimesTwo []*big.Int, z, x *big.Int) []*big.Int {
 
 	result := make([]*big.Int, len(aR))
 
-	tmp11 := VectorAddScalar(aR, z)
-	tmp12 := ScalarVectorMul(sR, x)
-	tmp1 := VectorHadamard(y, VectorAdd(tmp11, tmp12))
+	tmp11 := ec.VectorAddScalar(aR, z)
+	tmp12 := ec.ScalarVectorMul(sR, x)
+	tmp1 := ec.VectorHadamard(y, ec.VectorAdd(tmp11, tmp12))
 
-	result = VectorAdd(tmp1, zTimesTwo)
+	result = ec.VectorAdd(tmp1, zTimesTwo)
 
 	return result
 }
@@ -59,29 +100,31 @@ func CalculateRMRP(aR, sR, y, zTimesTwo []*big.Int, z, x *big.Int) []*big.Int {
 /*
 DeltaMRP is a helper function that is used in the multi range proof
 
-\delta(y, z) = (z-z^2)<1^n, y^n> - \sum_j z^3+j<1^n, 2^n>
+\delta(y, z) = (z-z^2)<1^n, y^n> - \sum_j z^3+j<1^n, 2^n_j>
+
+where n_j is the bit length of value j
 */
 
-func DeltaMRP(y []*big.Int, z *big.Int, m int) *big.Int {
+func (ec *CryptoParams) DeltaMRP(y []*big.Int, z *big.Int, bits []int) *big.Int {
 	result := big.NewInt(0)
 
 	// (z-z^2)<1^n, y^n>
-	z2 := new(big.Int).Mod(new(big.Int).Mul(z, z), EC.N)
-	t1 := new(big.Int).Mod(new(big.Int).Sub(z, z2), EC.N)
-	t2 := new(big.Int).Mod(new(big.Int).Mul(t1, VectorSum(y)), EC.N)
+	z2 := new(big.Int).Mod(new(big.Int).Mul(z, z), ec.N)
+	t1 := new(big.Int).Mod(new(big.Int).Sub(z, z2), ec.N)
+	t2 := new(big.Int).Mod(new(big.Int).Mul(t1, ec.VectorSum(y)), ec.N)
 
-	// \sum_j z^3+j<1^n, 2^n>
-	// <1^n, 2^n> = 2^n - 1
-	po2sum := new(big.Int).Sub(new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(EC.V/m)), EC.N), big.NewInt(1))
+	// \sum_j z^3+j<1^n, 2^n_j>
+	// <1^n, 2^n_j> = 2^n_j - 1
 	t3 := big.NewInt(0)
 
-	for j := 0; j < m; j++ {
-		zp := new(big.Int).Exp(z, big.NewInt(3+int64(j)), EC.N)
-		tmp1 := new(big.Int).Mod(new(big.Int).Mul(zp, po2sum), EC.N)
-		t3 = new(big.Int).Mod(new(big.Int).Add(t3, tmp1), EC.N)
+	for j := range bits {
+		po2sum := new(big.Int).Sub(new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(bits[j])), ec.N), big.NewInt(1))
+		zp := new(big.Int).Exp(z, big.NewInt(3+int64(j)), ec.N)
+		tmp1 := new(big.Int).Mod(new(big.Int).Mul(zp, po2sum), ec.N)
+		t3 = new(big.Int).Mod(new(big.Int).Add(t3, tmp1), ec.N)
 	}
 
-	result = new(big.Int).Mod(new(big.Int).Sub(t2, t3), EC.N)
+	result = new(big.Int).Mod(new(big.Int).Sub(t2, t3), ec.N)
 
 	return result
 }
@@ -100,41 +143,66 @@ changes:
 {(g, h \in G, \textbf{V} \in G^m ; \textbf{v, \gamma} \in Z_p^m) :
 	V_j = h^{\gamma_j}g^{v_j} \wedge v_j \in [0, 2^n - 1] \forall j \in [1, m]}
 */
-func MRPProve(values []*big.Int) MultiRangeProof {
-	// EC.V has the total number of values and bits we can support
+func (ec *CryptoParams) MRPProve(values []*big.Int, context []byte) (MultiRangeProof, error) {
+	if len(values) == 0 {
+		return MultiRangeProof{}, fmt.Errorf("%w: no values", ErrBitLengths)
+	}
+	bits := make([]int, len(values))
+	for j := range bits {
+		bits[j] = ec.V / len(values)
+	}
+	return ec.MRPProveBits(values, bits, context)
+}
 
-	MRPResult := MultiRangeProof{}
+/*
+MRPProveBits is MRPProve with a bit length per value: value j is proven to be
+in [0, 2^bits[j] - 1]. Every value still takes a slot of ec.V/m bits, so
+values of different sizes (say an 8 bit price and a 64 bit balance) share
+one proof of a single size.
+*/
+func (ec *CryptoParams) MRPProveBits(values []*big.Int, bits []int, context []byte) (MultiRangeProof, error) {
+	gammas, err := ec.RandVector(len(values))
+	if err != nil {
+		return MultiRangeProof{}, err
+	}
+	return ec.MRPProveWithBlinding(values, gammas, bits, context)
+}
+
+/*
+MRPProveWithBlinding is MRPProveBits over commitments the caller already
+holds: Comms[j] = values[j]*G + gammas[j]*H.
+*/
+func (ec *CryptoParams) MRPProveWithBlinding(values, gammas []*big.Int, bits []int, context []byte) (MultiRangeProof, error) {
+	// ec.V has the total number of values and bits we can support
 
 	m := len(values)
-	bitsPerValue := EC.V / m
+	if len(gammas) != m {
+		return MultiRangeProof{}, fmt.Errorf("%w: %d values, %d blindings", ErrLengthMismatch, m, len(gammas))
+	}
+	if !ec.validBitLengths(bits, m) {
+		return MultiRangeProof{}, fmt.Errorf("%w: %v for %d values over %d bits", ErrBitLengths, bits, m, ec.V)
+	}
+	bitsPerValue := ec.V / m
 
-	// we concatenate the binary representation of the values
+	MRPResult := MultiRangeProof{Bits: ec.V, BitLengths: append([]int(nil), bits...)}
 
-	PowerOfTwos := PowerVector(bitsPerValue, big.NewInt(2))
+	// we concatenate the binary representation of the values
 
 	Comms := make([]ECPoint, m)
-	gammas := make([]*big.Int, m)
-	aLConcat := make([]*big.Int, EC.V)
-	aRConcat := make([]*big.Int, EC.V)
+	aLConcat := make([]*big.Int, ec.V)
+	aRConcat := make([]*big.Int, ec.V)
 
 	for j := range values {
 		v := values[j]
-		if v.Cmp(big.NewInt(0)) == -1 {
-			panic("Value is below range! Not proving")
-		}
-
-		if v.Cmp(new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(bitsPerValue)), EC.N)) == 1 {
-			panic("Value is above range! Not proving.")
+		if err := checkRange(v, bits[j]); err != nil {
+			return MultiRangeProof{}, fmt.Errorf("value %d: %w", j, err)
 		}
 
-		gamma, err := rand.Int(rand.Reader, EC.N)
-		check(err)
-		Comms[j] = EC.G.Mult(v).Add(EC.H.Mult(gamma))
-		gammas[j] = gamma
+		Comms[j] = ec.CommitWithBlinding(v, gammas[j])
 
 		// break up v into its bitwise representation
 		aL := reverse(StrToBigIntArray(PadLeft(fmt.Sprintf("%b", v), "0", bitsPerValue)))
-		aR := VectorAddScalar(aL, big.NewInt(-1))
+		aR := ec.VectorAddScalar(aL, big.NewInt(-1))
 
 		for i := range aR {
 			aLConcat[bitsPerValue*j+i] = aL[i]
@@ -143,35 +211,44 @@ func MRPProve(values []*big.Int) MultiRangeProof {
 	}
 
 	MRPResult.Comms = Comms
+	t := ec.multiRangeProofTranscript(Comms, bits, context)
 
-	alpha, err := rand.Int(rand.Reader, EC.N)
-	check(err)
+	alpha, err := ec.randScalar()
+	if err != nil {
+		return MultiRangeProof{}, err
+	}
 
-	A := TwoVectorPCommitWithGens(EC.BPG, EC.BPH, aLConcat, aRConcat).Add(EC.H.Mult(alpha))
+	A := ec.Add(ec.vectorCommit(ec.BPG, ec.BPH, aLConcat, aRConcat), ec.Mult(ec.H, alpha))
 	MRPResult.A = A
 
-	sL := RandVector(EC.V)
-	sR := RandVector(EC.V)
+	sL, err := ec.RandVector(ec.V)
+	if err != nil {
+		return MultiRangeProof{}, err
+	}
+	sR, err := ec.RandVector(ec.V)
+	if err != nil {
+		return MultiRangeProof{}, err
+	}
 
-	rho, err := rand.Int(rand.Reader, EC.N)
-	check(err)
+	rho, err := ec.randScalar()
+	if err != nil {
+		return MultiRangeProof{}, err
+	}
 
-	S := TwoVectorPCommitWithGens(EC.BPG, EC.BPH, sL, sR).Add(EC.H.Mult(rho))
+	S := ec.Add(ec.vectorCommit(ec.BPG, ec.BPH, sL, sR), ec.Mult(ec.H, rho))
 	MRPResult.S = S
 
-	chal1s256 := sha256.Sum256([]byte(A.X.String() + A.Y.String()))
-	cy := new(big.Int).SetBytes(chal1s256[:])
-	MRPResult.Cy = cy
+	t.AppendPoint("A", A)
+	t.AppendPoint("S", S)
+	cy := t.ChallengeScalar("y", ec.N)
+	cz := t.ChallengeScalar("z", ec.N)
 
-	chal2s256 := sha256.Sum256([]byte(S.X.String() + S.Y.String()))
-	cz := new(big.Int).SetBytes(chal2s256[:])
-	MRPResult.Cz = cz
-
-	zPowersTimesTwoVec := make([]*big.Int, EC.V)
+	zPowersTimesTwoVec := make([]*big.Int, ec.V)
 	for j := 0; j < m; j++ {
-		zp := new(big.Int).Exp(cz, big.NewInt(2+int64(j)), EC.N)
+		zp := new(big.Int).Exp(cz, big.NewInt(2+int64(j)), ec.N)
+		PowerOfTwos := slotPowersOfTwo(bitsPerValue, bits[j])
 		for i := 0; i < bitsPerValue; i++ {
-			zPowersTimesTwoVec[j*bitsPerValue+i] = new(big.Int).Mod(new(big.Int).Mul(PowerOfTwos[i], zp), EC.N)
+			zPowersTimesTwoVec[j*bitsPerValue+i] = new(big.Int).Mod(new(big.Int).Mul(PowerOfTwos[i], zp), ec.N)
 		}
 	}
 
@@ -199,58 +276,61 @@ func MRPProve(values []*big.Int) MultiRangeProof {
 				FieldVectorPolynomial rPoly = new FieldVectorPolynomial(r0, r1);
 
 	*/
-	PowerOfCY := PowerVector(EC.V, cy)
+	PowerOfCY := ec.PowerVector(ec.V, cy)
 	// fmt.Println(PowerOfCY)
-	l0 := VectorAddScalar(aLConcat, new(big.Int).Neg(cz))
+	l0 := ec.VectorAddScalar(aLConcat, new(big.Int).Neg(cz))
 	l1 := sL
-	r0 := VectorAdd(
-		VectorHadamard(
+	r0 := ec.VectorAdd(
+		ec.VectorHadamard(
 			PowerOfCY,
-			VectorAddScalar(aRConcat, cz)),
+			ec.VectorAddScalar(aRConcat, cz)),
 		zPowersTimesTwoVec)
-	r1 := VectorHadamard(sR, PowerOfCY)
+	r1 := ec.VectorHadamard(sR, PowerOfCY)
 
 	//calculate t0
 	vz2 := big.NewInt(0)
-	z2 := new(big.Int).Mod(new(big.Int).Mul(cz, cz), EC.N)
-	PowerOfCZ := PowerVector(m, cz)
+	z2 := new(big.Int).Mod(new(big.Int).Mul(cz, cz), ec.N)
+	PowerOfCZ := ec.PowerVector(m, cz)
 	for j := 0; j < m; j++ {
 		vz2 = new(big.Int).Add(vz2,
 			new(big.Int).Mul(
 				PowerOfCZ[j],
 				new(big.Int).Mul(values[j], z2)))
-		vz2 = new(big.Int).Mod(vz2, EC.N)
+		vz2 = new(big.Int).Mod(vz2, ec.N)
 	}
 
-	t0 := new(big.Int).Mod(new(big.Int).Add(vz2, DeltaMRP(PowerOfCY, cz, m)), EC.N)
+	t0 := new(big.Int).Mod(new(big.Int).Add(vz2, ec.DeltaMRP(PowerOfCY, cz, bits)), ec.N)
 
-	t1 := new(big.Int).Mod(new(big.Int).Add(InnerProduct(l1, r0), InnerProduct(l0, r1)), EC.N)
-	t2 := InnerProduct(l1, r1)
+	t1 := new(big.Int).Mod(new(big.Int).Add(ec.InnerProduct(l1, r0), ec.InnerProduct(l0, r1)), ec.N)
+	t2 := ec.InnerProduct(l1, r1)
 
 	// given the t_i values, we can generate commitments to them
-	tau1, err := rand.Int(rand.Reader, EC.N)
-	check(err)
-	tau2, err := rand.Int(rand.Reader, EC.N)
-	check(err)
+	tau1, err := ec.randScalar()
+	if err != nil {
+		return MultiRangeProof{}, err
+	}
+	tau2, err := ec.randScalar()
+	if err != nil {
+		return MultiRangeProof{}, err
+	}
 
-	T1 := EC.G.Mult(t1).Add(EC.H.Mult(tau1)) //commitment to t1
-	T2 := EC.G.Mult(t2).Add(EC.H.Mult(tau2)) //commitment to t2
+	T1 := ec.Add(ec.Mult(ec.G, t1), ec.Mult(ec.H, tau1)) //commitment to t1
+	T2 := ec.Add(ec.Mult(ec.G, t2), ec.Mult(ec.H, tau2)) //commitment to t2
 
 	MRPResult.T1 = T1
 	MRPResult.T2 = T2
 
-	chal3s256 := sha256.Sum256([]byte(T1.X.String() + T1.Y.String() + T2.X.String() + T2.Y.String()))
-	cx := new(big.Int).SetBytes(chal3s256[:])
-
-	MRPResult.Cx = cx
+	t.AppendPoint("T1", T1)
+	t.AppendPoint("T2", T2)
+	cx := t.ChallengeScalar("x", ec.N)
 
-	left := CalculateLMRP(aLConcat, sL, cz, cx)
-	right := CalculateRMRP(aRConcat, sR, PowerOfCY, zPowersTimesTwoVec, cz, cx)
+	left := ec.CalculateLMRP(aLConcat, sL, cz, cx)
+	right := ec.CalculateRMRP(aRConcat, sR, PowerOfCY, zPowersTimesTwoVec, cz, cx)
 
 	thatPrime := new(big.Int).Mod( // t0 + t1*x + t2*x^2
-		new(big.Int).Add(t0, new(big.Int).Add(new(big.Int).Mul(t1, cx), new(big.Int).Mul(new(big.Int).Mul(cx, cx), t2))), EC.N)
+		new(big.Int).Add(t0, new(big.Int).Add(new(big.Int).Mul(t1, cx), new(big.Int).Mul(new(big.Int).Mul(cx, cx), t2))), ec.N)
 
-	that := InnerProduct(left, right) // NOTE: BP Java implementation calculates this from the t_i
+	that := ec.InnerProduct(left, right) // NOTE: BP Java implementation calculates this from the t_i
 
 	// thatPrime and that should be equal
 	if thatPrime.Cmp(that) != 0 {
@@ -263,127 +343,167 @@ func MRPProve(values []*big.Int) MultiRangeProof {
 
 	vecRandomnessTotal := big.NewInt(0)
 	for j := 0; j < m; j++ {
-		zp := new(big.Int).Exp(cz, big.NewInt(2+int64(j)), EC.N)
+		zp := new(big.Int).Exp(cz, big.NewInt(2+int64(j)), ec.N)
 		tmp1 := new(big.Int).Mul(gammas[j], zp)
-		vecRandomnessTotal = new(big.Int).Mod(new(big.Int).Add(vecRandomnessTotal, tmp1), EC.N)
+		vecRandomnessTotal = new(big.Int).Mod(new(big.Int).Add(vecRandomnessTotal, tmp1), ec.N)
 	}
 	//fmt.Println(vecRandomnessTotal)
-	taux1 := new(big.Int).Mod(new(big.Int).Mul(tau2, new(big.Int).Mul(cx, cx)), EC.N)
-	taux2 := new(big.Int).Mod(new(big.Int).Mul(tau1, cx), EC.N)
-	taux := new(big.Int).Mod(new(big.Int).Add(taux1, new(big.Int).Add(taux2, vecRandomnessTotal)), EC.N)
+	taux1 := new(big.Int).Mod(new(big.Int).Mul(tau2, new(big.Int).Mul(cx, cx)), ec.N)
+	taux2 := new(big.Int).Mod(new(big.Int).Mul(tau1, cx), ec.N)
+	taux := new(big.Int).Mod(new(big.Int).Add(taux1, new(big.Int).Add(taux2, vecRandomnessTotal)), ec.N)
 
 	MRPResult.Tau = taux
 
-	mu := new(big.Int).Mod(new(big.Int).Add(alpha, new(big.Int).Mul(rho, cx)), EC.N)
+	mu := new(big.Int).Mod(new(big.Int).Add(alpha, new(big.Int).Mul(rho, cx)), ec.N)
 	MRPResult.Mu = mu
 
-	HPrime := make([]ECPoint, len(EC.BPH))
+	HPrime := make([]ECPoint, len(ec.BPH))
 
 	for i := range HPrime {
-		HPrime[i] = EC.BPH[i].Mult(new(big.Int).ModInverse(PowerOfCY[i], EC.N))
+		HPrime[i] = ec.Mult(ec.BPH[i], new(big.Int).ModInverse(PowerOfCY[i], ec.N))
 	}
 
-	P := TwoVectorPCommitWithGens(EC.BPG, HPrime, left, right)
+	P := ec.vectorCommit(ec.BPG, HPrime, left, right)
 	//fmt.Println(P)
 
-	MRPResult.IPP = InnerProductProve(left, right, that, P, EC.U, EC.BPG, HPrime)
+	t.AppendScalar("tau", taux)
+	t.AppendScalar("mu", mu)
+	t.AppendScalar("t", that)
+	MRPResult.IPP = ec.innerProductProve(left, right, that, P, ec.U, ec.BPG, HPrime, t)
 
-	return MRPResult
+	return MRPResult, nil
+}
+
+// checkMultiRangeProof is checkRangeProof for a multi range proof.
+func (ec *CryptoParams) checkMultiRangeProof(mrp MultiRangeProof) error {
+	if mrp.Bits != ec.V {
+		return fmt.Errorf("%w: proof is for %d bits, params for %d", ErrMalformedProof, mrp.Bits, ec.V)
+	}
+	if !ec.validBitLengths(mrp.BitLengths, len(mrp.Comms)) {
+		return fmt.Errorf("%w: %v for %d values over %d bits", ErrBitLengths, mrp.BitLengths, len(mrp.Comms), ec.V)
+	}
+	for _, p := range append([]ECPoint{mrp.A, mrp.S, mrp.T1, mrp.T2}, mrp.Comms...) {
+		if !ec.onCurve(p) {
+			return fmt.Errorf("%w: point not on curve", ErrMalformedProof)
+		}
+	}
+	for _, s := range []*big.Int{mrp.Tau, mrp.Th, mrp.Mu} {
+		if !ec.validScalar(s) {
+			return fmt.Errorf("%w: scalar out of range", ErrMalformedProof)
+		}
+	}
+	if !ec.validInnerProdArg(mrp.IPP, ec.V) {
+		return fmt.Errorf("%w: inner product argument has the wrong shape", ErrMalformedProof)
+	}
+	return nil
 }
 
 /*
 MultiRangeProof Verify
 Takes in a MultiRangeProof and verifies its correctness
 
+The bit length of each value is taken from the proof; use MRPVerifyBits to
+also require particular bit lengths.
 */
-func MRPVerify(mrp MultiRangeProof) bool {
+func (ec *CryptoParams) MRPVerify(mrp MultiRangeProof, context []byte) (bool, error) {
+	if err := ec.checkMultiRangeProof(mrp); err != nil {
+		return false, err
+	}
 	m := len(mrp.Comms)
-	bitsPerValue := EC.V / m
+	bitsPerValue := ec.V / m
 
 	//changes:
 	// check 1 changes since it includes all commitments
 	// check 2 commitment generation is also different
 
-	// verify the challenges
-	chal1s256 := sha256.Sum256([]byte(mrp.A.X.String() + mrp.A.Y.String()))
-	cy := new(big.Int).SetBytes(chal1s256[:])
-	if cy.Cmp(mrp.Cy) != 0 {
-		fmt.Println("MRPVerify - Challenge Cy failing!")
-		return false
-	}
-	chal2s256 := sha256.Sum256([]byte(mrp.S.X.String() + mrp.S.Y.String()))
-	cz := new(big.Int).SetBytes(chal2s256[:])
-	if cz.Cmp(mrp.Cz) != 0 {
-		fmt.Println("MRPVerify - Challenge Cz failing!")
-		return false
-	}
-	chal3s256 := sha256.Sum256([]byte(mrp.T1.X.String() + mrp.T1.Y.String() + mrp.T2.X.String() + mrp.T2.Y.String()))
-	cx := new(big.Int).SetBytes(chal3s256[:])
-	if cx.Cmp(mrp.Cx) != 0 {
-		fmt.Println("RPVerify - Challenge Cx failing!")
-		return false
-	}
-
-	// given challenges are correct, very range proof
-	PowersOfY := PowerVector(EC.V, cy)
+	// recompute the challenges
+	t := ec.multiRangeProofTranscript(mrp.Comms, mrp.BitLengths, context)
+	t.AppendPoint("A", mrp.A)
+	t.AppendPoint("S", mrp.S)
+	cy := t.ChallengeScalar("y", ec.N)
+	cz := t.ChallengeScalar("z", ec.N)
+	t.AppendPoint("T1", mrp.T1)
+	t.AppendPoint("T2", mrp.T2)
+	cx := t.ChallengeScalar("x", ec.N)
+	t.AppendScalar("tau", mrp.Tau)
+	t.AppendScalar("mu", mrp.Mu)
+	t.AppendScalar("t", mrp.Th)
+
+	// verify range proof
+	PowersOfY := ec.PowerVector(ec.V, cy)
 
 	// t_hat * G + tau * H
-	lhs := EC.G.Mult(mrp.Th).Add(EC.H.Mult(mrp.Tau))
+	lhs := ec.Add(ec.Mult(ec.G, mrp.Th), ec.Mult(ec.H, mrp.Tau))
 
 	// z^2 * \bold{z}^m \bold{V} + delta(y,z) * G + x * T1 + x^2 * T2
-	CommPowers := EC.Zero()
-	PowersOfZ := PowerVector(m, cz)
-	z2 := new(big.Int).Mod(new(big.Int).Mul(cz, cz), EC.N)
+	CommPowers := ec.Zero()
+	PowersOfZ := ec.PowerVector(m, cz)
+	z2 := new(big.Int).Mod(new(big.Int).Mul(cz, cz), ec.N)
 
 	for j := 0; j < m; j++ {
-		CommPowers = CommPowers.Add(mrp.Comms[j].Mult(new(big.Int).Mul(z2, PowersOfZ[j])))
+		CommPowers = ec.Add(CommPowers, ec.Mult(mrp.Comms[j], new(big.Int).Mul(z2, PowersOfZ[j])))
 	}
 
-	rhs := EC.G.Mult(DeltaMRP(PowersOfY, cz, m)).Add(
-		mrp.T1.Mult(cx)).Add(
-		mrp.T2.Mult(new(big.Int).Mul(cx, cx))).Add(CommPowers)
+	rhs := ec.Add(ec.Mult(ec.G, ec.DeltaMRP(PowersOfY, cz, mrp.BitLengths)),
+		ec.Mult(mrp.T1, cx),
+		ec.Mult(mrp.T2, new(big.Int).Mul(cx, cx)),
+		CommPowers)
 
 	if !lhs.Equal(rhs) {
 		fmt.Println("MRPVerify - Uh oh! Check line (63) of verification")
 		fmt.Println(rhs)
 		fmt.Println(lhs)
-		return false
+		return false, nil
 	}
 
-	tmp1 := EC.Zero()
-	zneg := new(big.Int).Mod(new(big.Int).Neg(cz), EC.N)
-	for i := range EC.BPG {
-		tmp1 = tmp1.Add(EC.BPG[i].Mult(zneg))
+	tmp1 := ec.Zero()
+	zneg := new(big.Int).Mod(new(big.Int).Neg(cz), ec.N)
+	for i := range ec.BPG {
+		tmp1 = ec.Add(tmp1, ec.Mult(ec.BPG[i], zneg))
 	}
 
-	PowerOfTwos := PowerVector(bitsPerValue, big.NewInt(2))
-	tmp2 := EC.Zero()
+	tmp2 := ec.Zero()
 	// generate h'
-	HPrime := make([]ECPoint, len(EC.BPH))
+	HPrime := make([]ECPoint, len(ec.BPH))
 
 	for i := range HPrime {
-		mi := new(big.Int).ModInverse(PowersOfY[i], EC.N)
-		HPrime[i] = EC.BPH[i].Mult(mi)
+		mi := new(big.Int).ModInverse(PowersOfY[i], ec.N)
+		HPrime[i] = ec.Mult(ec.BPH[i], mi)
 	}
 
 	for j := 0; j < m; j++ {
+		PowerOfTwos := slotPowersOfTwo(bitsPerValue, mrp.BitLengths[j])
 		for i := 0; i < bitsPerValue; i++ {
 			val1 := new(big.Int).Mul(cz, PowersOfY[j*bitsPerValue+i])
-			zp := new(big.Int).Exp(cz, big.NewInt(2+int64(j)), EC.N)
-			val2 := new(big.Int).Mod(new(big.Int).Mul(zp, PowerOfTwos[i]), EC.N)
-			tmp2 = tmp2.Add(HPrime[j*bitsPerValue+i].Mult(new(big.Int).Add(val1, val2)))
+			zp := new(big.Int).Exp(cz, big.NewInt(2+int64(j)), ec.N)
+			val2 := new(big.Int).Mod(new(big.Int).Mul(zp, PowerOfTwos[i]), ec.N)
+			tmp2 = ec.Add(tmp2, ec.Mult(HPrime[j*bitsPerValue+i], new(big.Int).Add(val1, val2)))
 		}
 	}
 
 	// without subtracting this value should equal muCH + l[i]G[i] + r[i]H'[i]
 	// we want to make sure that the innerproduct checks out, so we subtract it
-	P := mrp.A.Add(mrp.S.Mult(cx)).Add(tmp1).Add(tmp2).Add(EC.H.Mult(mrp.Mu).Neg())
+	P := ec.Add(mrp.A, ec.Mult(mrp.S, cx), tmp1, tmp2, ec.Neg(ec.Mult(ec.H, mrp.Mu)))
 	//fmt.Println(P)
 
-	if !InnerProductVerifyFast(mrp.Th, P, EC.U, EC.BPG, HPrime, mrp.IPP) {
+	if !ec.innerProductVerifyFast(mrp.Th, P, ec.U, ec.BPG, HPrime, mrp.IPP, t) {
 		fmt.Println("MRPVerify - Uh oh! Check line (65) of verification!")
-		return false
+		return false, nil
 	}
 
-	return true
+	return true, nil
+}
+
+// MRPVerifyBits is MRPVerify that also requires value j to have been proven
+// to be in [0, 2^bits[j] - 1].
+func (ec *CryptoParams) MRPVerifyBits(mrp MultiRangeProof, bits []int, context []byte) (bool, error) {
+	if len(bits) != len(mrp.BitLengths) {
+		return false, fmt.Errorf("%w: proof has %d values, want %d", ErrBitLengths, len(mrp.BitLengths), len(bits))
+	}
+	for j := range bits {
+		if bits[j] != mrp.BitLengths[j] {
+			return false, fmt.Errorf("%w: value %d is proven to %d bits, want %d", ErrBitLengths, j, mrp.BitLengths[j], bits[j])
+		}
+	}
+	return ec.MRPVerify(mrp, context)
 }
@@ -1,6 +1,7 @@
 package src
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 	"testing"
@@ -8,14 +9,14 @@ import (
 
 func TestMultiRPVerify1(t *testing.T) {
 	values := []*big.Int{big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0)}
-	EC = NewECPrimeGroupKey(64 * len(values))
+	ec := NewECPrimeGroupKey(64 * len(values))
 	// Testing smallest number in range
-	proof := MRPProve(values)
-	proofString := fmt.Sprintf("%s", proof)
+	proof := mustMRP(ec.MRPProve(values, nil))
+	proofString := fmt.Sprintf("%v", proof)
 
 	fmt.Println(len(proofString)) // length is good measure of bytes, correct?
 
-	if MRPVerify(proof) {
+	if verified(ec.MRPVerify(proof, nil)) {
 		fmt.Println("Multi Range Proof Verification works")
 	} else {
 		t.Error("***** Multi Range Proof FAILURE")
@@ -24,9 +25,9 @@ func TestMultiRPVerify1(t *testing.T) {
 
 func TestMultiRPVerify2(t *testing.T) {
 	values := []*big.Int{big.NewInt(0)}
-	EC = NewECPrimeGroupKey(64 * len(values))
+	ec := NewECPrimeGroupKey(64 * len(values))
 	// Testing smallest number in range
-	if MRPVerify(MRPProve(values)) {
+	if verified(ec.MRPVerify(mustMRP(ec.MRPProve(values, nil)), nil)) {
 		fmt.Println("Multi Range Proof Verification works")
 	} else {
 		t.Error("***** Multi Range Proof FAILURE")
@@ -35,9 +36,9 @@ func TestMultiRPVerify2(t *testing.T) {
 
 func TestMultiRPVerify3(t *testing.T) {
 	values := []*big.Int{big.NewInt(0), big.NewInt(1)}
-	EC = NewECPrimeGroupKey(64 * len(values))
+	ec := NewECPrimeGroupKey(64 * len(values))
 	// Testing smallest number in range
-	if MRPVerify(MRPProve(values)) {
+	if verified(ec.MRPVerify(mustMRP(ec.MRPProve(values, nil)), nil)) {
 		fmt.Println("Multi Range Proof Verification works")
 	} else {
 		t.Error("***** Multi Range Proof FAILURE")
@@ -51,17 +52,105 @@ func TestMultiRPVerify4(t *testing.T) {
 			values[k] = big.NewInt(0)
 		}
 
-		EC = NewECPrimeGroupKey(64 * len(values))
+		ec := NewECPrimeGroupKey(64 * len(values))
 		// Testing smallest number in range
-		proof := MRPProve(values)
-		proofString := fmt.Sprintf("%s", proof)
+		proof := mustMRP(ec.MRPProve(values, nil))
+		proofString := fmt.Sprintf("%v", proof)
 
 		fmt.Println(len(proofString)) // length is good measure of bytes, correct?
 
-		if MRPVerify(proof) {
+		if verified(ec.MRPVerify(proof, nil)) {
 			fmt.Println("Multi Range Proof Verification works")
 		} else {
 			t.Error("***** Multi Range Proof FAILURE")
 		}
 	}
 }
+
+func TestMultiRPVerifySM2(t *testing.T) {
+	values := []*big.Int{big.NewInt(0), big.NewInt(5), big.NewInt(255), big.NewInt(128)}
+	ec := NewSM2GroupKey(8 * len(values))
+	if verified(ec.MRPVerify(mustMRP(ec.MRPProve(values, nil)), nil)) {
+		fmt.Println("SM2 Multi Range Proof Verification works")
+	} else {
+		t.Error("***** SM2 Multi Range Proof FAILURE")
+	}
+}
+
+func TestMultiRPVerifyWrongContext(t *testing.T) {
+	values := []*big.Int{big.NewInt(3), big.NewInt(9)}
+	ec := NewSM2GroupKey(8 * len(values))
+	proof := mustMRP(ec.MRPProve(values, []byte("order-1")))
+
+	if verified(ec.MRPVerify(proof, []byte("order-2"))) {
+		t.Error("***** Multi Range Proof replayed under another context")
+	}
+	proof.Comms[0], proof.Comms[1] = proof.Comms[1], proof.Comms[0]
+	if verified(ec.MRPVerify(proof, []byte("order-1"))) {
+		t.Error("***** Multi Range Proof accepted reordered commitments")
+	}
+}
+
+func TestMultiRPVerifyMixedBits(t *testing.T) {
+	ec := NewSM2GroupKey(128)
+	price := big.NewInt(255)
+	balance := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 64), big.NewInt(1))
+	bits := []int{8, 64}
+
+	proof := mustMRP(ec.MRPProveBits([]*big.Int{price, balance}, bits, []byte("order-1")))
+	if verified(ec.MRPVerifyBits(proof, bits, []byte("order-1"))) {
+		fmt.Println("Mixed bit length Multi Range Proof Verification works")
+	} else {
+		t.Error("***** Mixed bit length Multi Range Proof FAILURE")
+	}
+
+	if verified(ec.MRPVerifyBits(proof, []int{64, 64}, []byte("order-1"))) {
+		t.Error("***** Mixed bit length Multi Range Proof accepted for other bit lengths")
+	}
+	proof.BitLengths = []int{64, 64}
+	if verified(ec.MRPVerify(proof, []byte("order-1"))) {
+		t.Error("***** Multi Range Proof accepted widened bit lengths")
+	}
+}
+
+func TestMultiRPProveWithBlinding(t *testing.T) {
+	ec := NewSM2GroupKey(128)
+	comm, o := mustCommit(ec.PedersenCommit(big.NewInt(100)))
+	_, o2 := mustCommit(ec.PedersenCommit(big.NewInt(5000)))
+
+	proof := mustMRP(ec.MRPProveWithBlinding(
+		[]*big.Int{o.Value, o2.Value},
+		[]*big.Int{o.Blinding, o2.Blinding},
+		[]int{8, 64}, nil))
+	if !proof.Comms[0].Equal(comm) {
+		t.Error("***** Multi Range Proof is not over the given commitment")
+	}
+	if !verified(ec.MRPVerifyBits(proof, []int{8, 64}, nil)) {
+		t.Error("***** Multi Range Proof with blinding FAILURE")
+	}
+}
+
+func TestMultiRPProveErrors(t *testing.T) {
+	ec := NewSM2GroupKey(128)
+	bits := []int{8, 64}
+
+	// a buyer whose balance is below the price
+	_, err := ec.MRPProveBits([]*big.Int{big.NewInt(200), big.NewInt(-50)}, bits, nil)
+	if !errors.Is(err, ErrOutOfRange) {
+		t.Error("***** Multi Range Proof of a negative value gave", err)
+	}
+
+	_, err = ec.MRPProveWithBlinding([]*big.Int{big.NewInt(1), big.NewInt(2)}, []*big.Int{big.NewInt(3)}, bits, nil)
+	if !errors.Is(err, ErrLengthMismatch) {
+		t.Error("***** Multi Range Proof with missing blindings gave", err)
+	}
+
+	_, err = ec.MRPProveBits([]*big.Int{big.NewInt(1), big.NewInt(2)}, []int{8, 65}, nil)
+	if !errors.Is(err, ErrBitLengths) {
+		t.Error("***** Multi Range Proof with oversized bit lengths gave", err)
+	}
+
+	if _, err := ec.MRPProve(nil, nil); !errors.Is(err, ErrBitLengths) {
+		t.Error("***** Multi Range Proof of no values gave", err)
+	}
+}
@@ -0,0 +1,71 @@
+package src
+
+import (
+	"fmt"
+	"math/big"
+)
+
+/*
+MultiScalarMult computes sum(scalars[i] * points[i]) with Pippenger's bucket
+method. Each c-bit window of the scalars costs one pass over the points and
+2^c bucket additions, instead of one full scalar multiplication per point,
+which pays off once there are more than a handful of points.
+*/
+func (ec *CryptoParams) MultiScalarMult(points []ECPoint, scalars []*big.Int) (ECPoint, error) {
+	if len(points) != len(scalars) {
+		return ECPoint{}, fmt.Errorf("%w: %d points, %d scalars", ErrLengthMismatch, len(points), len(scalars))
+	}
+
+	reduced := make([]*big.Int, len(scalars))
+	for i, s := range scalars {
+		reduced[i] = new(big.Int).Mod(s, ec.N)
+	}
+
+	c := pippengerWindow(len(points))
+	windows := (ec.N.BitLen() + c - 1) / c
+	buckets := make([]ECPoint, 1<<uint(c)-1)
+
+	result := ec.Zero()
+	for w := windows - 1; w >= 0; w-- {
+		for k := 0; k < c; k++ {
+			result = ec.Double(result)
+		}
+
+		for k := range buckets {
+			buckets[k] = ec.Zero()
+		}
+		for i, s := range reduced {
+			idx := 0
+			for k := c - 1; k >= 0; k-- {
+				idx = idx<<1 | int(s.Bit(w*c+k))
+			}
+			if idx > 0 {
+				buckets[idx-1] = ec.Add(buckets[idx-1], points[i])
+			}
+		}
+
+		// sum_k (k+1) * buckets[k] as a running sum from the top bucket down
+		running := ec.Zero()
+		windowSum := ec.Zero()
+		for k := len(buckets) - 1; k >= 0; k-- {
+			running = ec.Add(running, buckets[k])
+			windowSum = ec.Add(windowSum, running)
+		}
+		result = ec.Add(result, windowSum)
+	}
+	return result, nil
+}
+
+// pippengerWindow picks the window size in bits for n points.
+func pippengerWindow(n int) int {
+	switch {
+	case n < 32:
+		return 3
+	case n < 256:
+		return 5
+	case n < 2048:
+		return 7
+	default:
+		return 9
+	}
+}
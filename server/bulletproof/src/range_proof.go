@@ -1,13 +1,12 @@
 package src
 
 import (
-	"crypto/rand"
-	"crypto/sha256"
 	"fmt"
 	"math/big"
 )
 
 type RangeProof struct {
+	Bits int // bit length n the proof was made for, i.e. the V of its CryptoParams
 	Comm ECPoint
 	A    ECPoint
 	S    ECPoint
@@ -17,11 +16,17 @@ type RangeProof struct {
 	Th   *big.Int
 	Mu   *big.Int
 	IPP  InnerProdArg
+}
 
-	// challenges
-	Cy *big.Int
-	Cz *big.Int
-	Cx *big.Int
+// rangeProofTranscript starts the transcript shared by RPProve and RPVerify:
+// the params, the commitment and the caller's context go in before any
+// prover message.
+func (ec *CryptoParams) rangeProofTranscript(comm ECPoint, context []byte) *Transcript {
+	t := NewTranscript("bulletproof/range-proof")
+	t.AppendParams(ec)
+	t.AppendPoint("V", comm)
+	t.AppendMessage("context", context)
+	return t
 }
 
 /*
@@ -30,37 +35,37 @@ Delta is a helper function that is used in the range proof
 \delta(y, z) = (z-z^2)<1^n, y^n> - z^3<1^n, 2^n>
 */
 
-func Delta(y []*big.Int, z *big.Int) *big.Int {
+func (ec *CryptoParams) Delta(y []*big.Int, z *big.Int) *big.Int {
 	result := big.NewInt(0)
 
 	// (z-z^2)<1^n, y^n>
-	z2 := new(big.Int).Mod(new(big.Int).Mul(z, z), EC.N)
-	t1 := new(big.Int).Mod(new(big.Int).Sub(z, z2), EC.N)
-	t2 := new(big.Int).Mod(new(big.Int).Mul(t1, VectorSum(y)), EC.N)
+	z2 := new(big.Int).Mod(new(big.Int).Mul(z, z), ec.N)
+	t1 := new(big.Int).Mod(new(big.Int).Sub(z, z2), ec.N)
+	t2 := new(big.Int).Mod(new(big.Int).Mul(t1, ec.VectorSum(y)), ec.N)
 
 	// z^3<1^n, 2^n>
-	z3 := new(big.Int).Mod(new(big.Int).Mul(z2, z), EC.N)
-	po2sum := new(big.Int).Sub(new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(EC.V)), EC.N), big.NewInt(1))
-	t3 := new(big.Int).Mod(new(big.Int).Mul(z3, po2sum), EC.N)
+	z3 := new(big.Int).Mod(new(big.Int).Mul(z2, z), ec.N)
+	po2sum := new(big.Int).Sub(new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(ec.V)), ec.N), big.NewInt(1))
+	t3 := new(big.Int).Mod(new(big.Int).Mul(z3, po2sum), ec.N)
 
-	result = new(big.Int).Mod(new(big.Int).Sub(t2, t3), EC.N)
+	result = new(big.Int).Mod(new(big.Int).Sub(t2, t3), ec.N)
 
 	return result
 }
 
 // Calculates (aL - z*1^n) + sL*x
-func CalculateL(aL, sL []*big.Int, z, x *big.Int) []*big.Int {
+func (ec *CryptoParams) CalculateL(aL, sL []*big.Int, z, x *big.Int) []*big.Int {
 	result := make([]*big.Int, len(aL))
 
-	tmp1 := VectorAddScalar(aL, new(big.Int).Neg(z))
-	tmp2 := ScalarVectorMul(sL, x)
+	tmp1 := ec.VectorAddScalar(aL, new(big.Int).Neg(z))
+	tmp2 := ec.ScalarVectorMul(sL, x)
 
-	result = VectorAdd(tmp1, tmp2)
+	result = ec.VectorAdd(tmp1, tmp2)
 
 	return result
 }
 
-func CalculateR(aR, sR, y, po2 []*big.Int, z, x *big.Int) []*big.Int {
+func (ec *CryptoParams) CalculateR(aR, sR, y, po2 []*big.Int, z, x *big.Int) []*big.Int {
 	if len(aR) != len(sR) || len(aR) != len(y) || len(y) != len(po2) {
 		fmt.Println("CalculateR: Uh oh! Arrays not of the same length")
 		fmt.Printf("len(aR): %d\n", len(aR))
@@ -71,13 +76,13 @@ func CalculateR(aR, sR, y, po2 []*big.Int, z, x *big.Int) []*big.Int {
 
 	result := make([]*big.Int, len(aR))
 
-	z2 := new(big.Int).Exp(z, big.NewInt(2), EC.N)
-	tmp11 := VectorAddScalar(aR, z)
-	tmp12 := ScalarVectorMul(sR, x)
-	tmp1 := VectorHadamard(y, VectorAdd(tmp11, tmp12))
-	tmp2 := ScalarVectorMul(po2, z2)
+	z2 := new(big.Int).Exp(z, big.NewInt(2), ec.N)
+	tmp11 := ec.VectorAddScalar(aR, z)
+	tmp12 := ec.ScalarVectorMul(sR, x)
+	tmp1 := ec.VectorHadamard(y, ec.VectorAdd(tmp11, tmp12))
+	tmp2 := ec.ScalarVectorMul(po2, z2)
 
-	result = VectorAdd(tmp1, tmp2)
+	result = ec.VectorAdd(tmp1, tmp2)
 
 	return result
 }
@@ -85,57 +90,79 @@ func CalculateR(aR, sR, y, po2 []*big.Int, z, x *big.Int) []*big.Int {
 /*
 RPProver : Range Proof Prove
 
-Given a value v, provides a range proof that v is inside 0 to 2^64-1
+Given a value v, provides a range proof that v is inside 0 to 2^n-1,
+where n is the bit length V of the params. context (e.g. an order number) is
+bound into every challenge and must be given again to RPVerify. A value
+outside the range gives ErrOutOfRange.
 */
-func RPProve(v *big.Int) RangeProof {
+func (ec *CryptoParams) RPProve(v *big.Int, context []byte) (RangeProof, error) {
+	gamma, err := ec.randScalar()
+	if err != nil {
+		return RangeProof{}, err
+	}
+
+	return ec.RPProveWithBlinding(v, gamma, context)
+}
 
-	rpresult := RangeProof{}
+/*
+RPProveWithBlinding : Range Proof Prove over an existing commitment
+
+Given a value v and the blinding factor gamma of a commitment
+Comm = v*G + gamma*H that the caller already holds, provides a range proof
+that the value behind Comm is inside 0 to 2^n-1. The returned proof carries
+exactly that Comm, so a verifier can check it against a commitment published
+elsewhere (see RPVerifyCommitment).
+*/
+func (ec *CryptoParams) RPProveWithBlinding(v, gamma *big.Int, context []byte) (RangeProof, error) {
 
-	PowerOfTwos := PowerVector(EC.V, big.NewInt(2))
+	rpresult := RangeProof{Bits: ec.V}
 
-	if v.Cmp(big.NewInt(0)) == -1 {
-		panic("Value is below range! Not proving")
-	}
+	PowerOfTwos := ec.PowerVector(ec.V, big.NewInt(2))
 
-	if v.Cmp(new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(EC.V)), EC.N)) == 1 {
-		panic("Value is above range! Not proving.")
+	if err := checkRange(v, ec.V); err != nil {
+		return RangeProof{}, err
 	}
 
-	gamma, err := rand.Int(rand.Reader, EC.N)
-	check(err)
-	comm := EC.G.Mult(v).Add(EC.H.Mult(gamma))
+	comm := ec.CommitWithBlinding(v, gamma)
 	rpresult.Comm = comm
+	t := ec.rangeProofTranscript(comm, context)
 
 	// break up v into its bitwise representation
 	//aL := 0
-	aL := reverse(StrToBigIntArray(PadLeft(fmt.Sprintf("%b", v), "0", EC.V)))
-	aR := VectorAddScalar(aL, big.NewInt(-1))
+	aL := reverse(StrToBigIntArray(PadLeft(fmt.Sprintf("%b", v), "0", ec.V)))
+	aR := ec.VectorAddScalar(aL, big.NewInt(-1))
 
-	alpha, err := rand.Int(rand.Reader, EC.N)
-	check(err)
+	alpha, err := ec.randScalar()
+	if err != nil {
+		return RangeProof{}, err
+	}
 
-	A := TwoVectorPCommitWithGens(EC.BPG, EC.BPH, aL, aR).Add(EC.H.Mult(alpha))
+	A := ec.Add(ec.vectorCommit(ec.BPG, ec.BPH, aL, aR), ec.Mult(ec.H, alpha))
 	rpresult.A = A
 
-	sL := RandVector(EC.V)
-	sR := RandVector(EC.V)
+	sL, err := ec.RandVector(ec.V)
+	if err != nil {
+		return RangeProof{}, err
+	}
+	sR, err := ec.RandVector(ec.V)
+	if err != nil {
+		return RangeProof{}, err
+	}
 
-	rho, err := rand.Int(rand.Reader, EC.N)
-	check(err)
+	rho, err := ec.randScalar()
+	if err != nil {
+		return RangeProof{}, err
+	}
 
-	S := TwoVectorPCommitWithGens(EC.BPG, EC.BPH, sL, sR).Add(EC.H.Mult(rho))
+	S := ec.Add(ec.vectorCommit(ec.BPG, ec.BPH, sL, sR), ec.Mult(ec.H, rho))
 	rpresult.S = S
 
-	chal1s256 := sha256.Sum256([]byte(A.X.String() + A.Y.String()))
-	cy := new(big.Int).SetBytes(chal1s256[:])
+	t.AppendPoint("A", A)
+	t.AppendPoint("S", S)
+	cy := t.ChallengeScalar("y", ec.N)
+	cz := t.ChallengeScalar("z", ec.N)
 
-	rpresult.Cy = cy
-
-	chal2s256 := sha256.Sum256([]byte(S.X.String() + S.Y.String()))
-	cz := new(big.Int).SetBytes(chal2s256[:])
-
-	rpresult.Cz = cz
-	z2 := new(big.Int).Exp(cz, big.NewInt(2), EC.N)
+	z2 := new(big.Int).Exp(cz, big.NewInt(2), ec.N)
 	// need to generate l(X), r(X), and t(X)=<l(X),r(X)>
 
 	/*
@@ -155,44 +182,47 @@ func RPProve(v *big.Int) RangeProof {
 
 
 	*/
-	PowerOfCY := PowerVector(EC.V, cy)
+	PowerOfCY := ec.PowerVector(ec.V, cy)
 	// fmt.Println(PowerOfCY)
-	l0 := VectorAddScalar(aL, new(big.Int).Neg(cz))
+	l0 := ec.VectorAddScalar(aL, new(big.Int).Neg(cz))
 	// l1 := sL
-	r0 := VectorAdd(
-		VectorHadamard(
+	r0 := ec.VectorAdd(
+		ec.VectorHadamard(
 			PowerOfCY,
-			VectorAddScalar(aR, cz)),
-		ScalarVectorMul(
+			ec.VectorAddScalar(aR, cz)),
+		ec.ScalarVectorMul(
 			PowerOfTwos,
 			z2))
-	r1 := VectorHadamard(sR, PowerOfCY)
+	r1 := ec.VectorHadamard(sR, PowerOfCY)
 
 	//calculate t0
-	t0 := new(big.Int).Mod(new(big.Int).Add(new(big.Int).Mul(v, z2), Delta(PowerOfCY, cz)), EC.N)
+	t0 := new(big.Int).Mod(new(big.Int).Add(new(big.Int).Mul(v, z2), ec.Delta(PowerOfCY, cz)), ec.N)
 
-	t1 := new(big.Int).Mod(new(big.Int).Add(InnerProduct(sL, r0), InnerProduct(l0, r1)), EC.N)
-	t2 := InnerProduct(sL, r1)
+	t1 := new(big.Int).Mod(new(big.Int).Add(ec.InnerProduct(sL, r0), ec.InnerProduct(l0, r1)), ec.N)
+	t2 := ec.InnerProduct(sL, r1)
 
 	// given the t_i values, we can generate commitments to them
-	tau1, err := rand.Int(rand.Reader, EC.N)
-	check(err)
-	tau2, err := rand.Int(rand.Reader, EC.N)
-	check(err)
+	tau1, err := ec.randScalar()
+	if err != nil {
+		return RangeProof{}, err
+	}
+	tau2, err := ec.randScalar()
+	if err != nil {
+		return RangeProof{}, err
+	}
 
-	T1 := EC.G.Mult(t1).Add(EC.H.Mult(tau1)) //commitment to t1
-	T2 := EC.G.Mult(t2).Add(EC.H.Mult(tau2)) //commitment to t2
+	T1 := ec.Add(ec.Mult(ec.G, t1), ec.Mult(ec.H, tau1)) //commitment to t1
+	T2 := ec.Add(ec.Mult(ec.G, t2), ec.Mult(ec.H, tau2)) //commitment to t2
 
 	rpresult.T1 = T1
 	rpresult.T2 = T2
 
-	chal3s256 := sha256.Sum256([]byte(T1.X.String() + T1.Y.String() + T2.X.String() + T2.Y.String()))
-	cx := new(big.Int).SetBytes(chal3s256[:])
-
-	rpresult.Cx = cx
+	t.AppendPoint("T1", T1)
+	t.AppendPoint("T2", T2)
+	cx := t.ChallengeScalar("x", ec.N)
 
-	left := CalculateL(aL, sL, cz, cx)
-	right := CalculateR(aR, sR, PowerOfCY, PowerOfTwos, cz, cx)
+	left := ec.CalculateL(aL, sL, cz, cx)
+	right := ec.CalculateR(aR, sR, PowerOfCY, PowerOfTwos, cz, cx)
 
 	thatPrime := new(big.Int).Mod( // t0 + t1*x + t2*x^2
 		new(big.Int).Add(
@@ -202,9 +232,9 @@ func RPProve(v *big.Int) RangeProof {
 					t1, cx),
 				new(big.Int).Mul(
 					new(big.Int).Mul(cx, cx),
-					t2))), EC.N)
+					t2))), ec.N)
 
-	that := InnerProduct(left, right) // NOTE: BP Java implementation calculates this from the t_i
+	that := ec.InnerProduct(left, right) // NOTE: BP Java implementation calculates this from the t_i
 
 	// thatPrime and that should be equal
 	if thatPrime.Cmp(that) != 0 {
@@ -215,118 +245,167 @@ func RPProve(v *big.Int) RangeProof {
 
 	rpresult.Th = thatPrime
 
-	taux1 := new(big.Int).Mod(new(big.Int).Mul(tau2, new(big.Int).Mul(cx, cx)), EC.N)
-	taux2 := new(big.Int).Mod(new(big.Int).Mul(tau1, cx), EC.N)
-	taux3 := new(big.Int).Mod(new(big.Int).Mul(z2, gamma), EC.N)
-	taux := new(big.Int).Mod(new(big.Int).Add(taux1, new(big.Int).Add(taux2, taux3)), EC.N)
+	taux1 := new(big.Int).Mod(new(big.Int).Mul(tau2, new(big.Int).Mul(cx, cx)), ec.N)
+	taux2 := new(big.Int).Mod(new(big.Int).Mul(tau1, cx), ec.N)
+	taux3 := new(big.Int).Mod(new(big.Int).Mul(z2, gamma), ec.N)
+	taux := new(big.Int).Mod(new(big.Int).Add(taux1, new(big.Int).Add(taux2, taux3)), ec.N)
 
 	rpresult.Tau = taux
 
-	mu := new(big.Int).Mod(new(big.Int).Add(alpha, new(big.Int).Mul(rho, cx)), EC.N)
+	mu := new(big.Int).Mod(new(big.Int).Add(alpha, new(big.Int).Mul(rho, cx)), ec.N)
 	rpresult.Mu = mu
 
-	HPrime := make([]ECPoint, len(EC.BPH))
+	HPrime := make([]ECPoint, len(ec.BPH))
 
 	for i := range HPrime {
-		HPrime[i] = EC.BPH[i].Mult(new(big.Int).ModInverse(PowerOfCY[i], EC.N))
+		HPrime[i] = ec.Mult(ec.BPH[i], new(big.Int).ModInverse(PowerOfCY[i], ec.N))
 	}
 
 	// for testing
-	tmp1 := EC.Zero()
-	zneg := new(big.Int).Mod(new(big.Int).Neg(cz), EC.N)
-	for i := range EC.BPG {
-		tmp1 = tmp1.Add(EC.BPG[i].Mult(zneg))
+	tmp1 := ec.Zero()
+	zneg := new(big.Int).Mod(new(big.Int).Neg(cz), ec.N)
+	for i := range ec.BPG {
+		tmp1 = ec.Add(tmp1, ec.Mult(ec.BPG[i], zneg))
 	}
 
-	tmp2 := EC.Zero()
+	tmp2 := ec.Zero()
 	for i := range HPrime {
 		val1 := new(big.Int).Mul(cz, PowerOfCY[i])
 		val2 := new(big.Int).Mul(new(big.Int).Mul(cz, cz), PowerOfTwos[i])
-		tmp2 = tmp2.Add(HPrime[i].Mult(new(big.Int).Add(val1, val2)))
+		tmp2 = ec.Add(tmp2, ec.Mult(HPrime[i], new(big.Int).Add(val1, val2)))
 	}
 
-	//P1 := A.Add(S.Mult(cx)).Add(tmp1).Add(tmp2).Add(EC.U.Mult(that)).Add(EC.H.Mult(mu).Neg())
+	//P1 := A.Add(S.Mult(cx)).Add(tmp1).Add(tmp2).Add(ec.U.Mult(that)).Add(ec.H.Mult(mu).Neg())
 
-	P := TwoVectorPCommitWithGens(EC.BPG, HPrime, left, right)
+	P := ec.vectorCommit(ec.BPG, HPrime, left, right)
 	//fmt.Println(P1)
 	//fmt.Println(P2)
 
-	rpresult.IPP = InnerProductProve(left, right, that, P, EC.U, EC.BPG, HPrime)
+	t.AppendScalar("tau", taux)
+	t.AppendScalar("mu", mu)
+	t.AppendScalar("t", thatPrime)
+	rpresult.IPP = ec.innerProductProve(left, right, that, P, ec.U, ec.BPG, HPrime, t)
+
+	return rpresult, nil
+}
 
-	return rpresult
+// checkRange returns ErrOutOfRange unless v is in [0, 2^bits).
+func checkRange(v *big.Int, bits int) error {
+	if v == nil || v.Sign() < 0 || v.Cmp(new(big.Int).Lsh(big.NewInt(1), uint(bits))) >= 0 {
+		return fmt.Errorf("%w: %v is not in [0, 2^%d)", ErrOutOfRange, v, bits)
+	}
+	return nil
 }
 
-func RPVerify(rp RangeProof) bool {
-	// verify the challenges
-	chal1s256 := sha256.Sum256([]byte(rp.A.X.String() + rp.A.Y.String()))
-	cy := new(big.Int).SetBytes(chal1s256[:])
-	if cy.Cmp(rp.Cy) != 0 {
-		fmt.Println("RPVerify - Challenge Cy failing!")
-		return false
+// checkRangeProof checks that rp was made for these params and that every
+// point is on the curve and every scalar reduced, so the verifier can run on
+// it.
+func (ec *CryptoParams) checkRangeProof(rp RangeProof) error {
+	if rp.Bits != ec.V {
+		return fmt.Errorf("%w: proof is for %d bits, params for %d", ErrMalformedProof, rp.Bits, ec.V)
+	}
+	for _, p := range []ECPoint{rp.Comm, rp.A, rp.S, rp.T1, rp.T2} {
+		if !ec.onCurve(p) {
+			return fmt.Errorf("%w: point not on curve", ErrMalformedProof)
+		}
 	}
-	chal2s256 := sha256.Sum256([]byte(rp.S.X.String() + rp.S.Y.String()))
-	cz := new(big.Int).SetBytes(chal2s256[:])
-	if cz.Cmp(rp.Cz) != 0 {
-		fmt.Println("RPVerify - Challenge Cz failing!")
-		return false
+	for _, s := range []*big.Int{rp.Tau, rp.Th, rp.Mu} {
+		if !ec.validScalar(s) {
+			return fmt.Errorf("%w: scalar out of range", ErrMalformedProof)
+		}
 	}
-	chal3s256 := sha256.Sum256([]byte(rp.T1.X.String() + rp.T1.Y.String() + rp.T2.X.String() + rp.T2.Y.String()))
-	cx := new(big.Int).SetBytes(chal3s256[:])
-	if cx.Cmp(rp.Cx) != 0 {
-		fmt.Println("RPVerify - Challenge Cx failing!")
-		return false
+	if !ec.validInnerProdArg(rp.IPP, ec.V) {
+		return fmt.Errorf("%w: inner product argument has the wrong shape", ErrMalformedProof)
 	}
+	return nil
+}
 
-	// given challenges are correct, very range proof
-	PowersOfY := PowerVector(EC.V, cy)
+// RPVerify checks rp against the params and the context it was made with.
+func (ec *CryptoParams) RPVerify(rp RangeProof, context []byte) (bool, error) {
+	// the proof must have been made for these params, otherwise its
+	// generators and bit length don't line up with ours
+	if err := ec.checkRangeProof(rp); err != nil {
+		return false, err
+	}
+
+	// recompute the challenges
+	t := ec.rangeProofTranscript(rp.Comm, context)
+	t.AppendPoint("A", rp.A)
+	t.AppendPoint("S", rp.S)
+	cy := t.ChallengeScalar("y", ec.N)
+	cz := t.ChallengeScalar("z", ec.N)
+	t.AppendPoint("T1", rp.T1)
+	t.AppendPoint("T2", rp.T2)
+	cx := t.ChallengeScalar("x", ec.N)
+	t.AppendScalar("tau", rp.Tau)
+	t.AppendScalar("mu", rp.Mu)
+	t.AppendScalar("t", rp.Th)
+
+	// verify range proof
+	PowersOfY := ec.PowerVector(ec.V, cy)
 
 	// t_hat * G + tau * H
-	lhs := EC.G.Mult(rp.Th).Add(EC.H.Mult(rp.Tau))
+	lhs := ec.Add(ec.Mult(ec.G, rp.Th), ec.Mult(ec.H, rp.Tau))
 
 	// z^2 * V + delta(y,z) * G + x * T1 + x^2 * T2
-	rhs := rp.Comm.Mult(new(big.Int).Mul(cz, cz)).Add(
-		EC.G.Mult(Delta(PowersOfY, cz))).Add(
-		rp.T1.Mult(cx)).Add(
-		rp.T2.Mult(new(big.Int).Mul(cx, cx)))
+	rhs := ec.Add(ec.Mult(rp.Comm, new(big.Int).Mul(cz, cz)),
+		ec.Mult(ec.G, ec.Delta(PowersOfY, cz)),
+		ec.Mult(rp.T1, cx),
+		ec.Mult(rp.T2, new(big.Int).Mul(cx, cx)))
 
 	if !lhs.Equal(rhs) {
 		fmt.Println("RPVerify - Uh oh! Check line (63) of verification")
 		fmt.Println(rhs)
 		fmt.Println(lhs)
-		return false
+		return false, nil
 	}
 
-	tmp1 := EC.Zero()
-	zneg := new(big.Int).Mod(new(big.Int).Neg(cz), EC.N)
-	for i := range EC.BPG {
-		tmp1 = tmp1.Add(EC.BPG[i].Mult(zneg))
+	tmp1 := ec.Zero()
+	zneg := new(big.Int).Mod(new(big.Int).Neg(cz), ec.N)
+	for i := range ec.BPG {
+		tmp1 = ec.Add(tmp1, ec.Mult(ec.BPG[i], zneg))
 	}
 
-	PowerOfTwos := PowerVector(EC.V, big.NewInt(2))
-	tmp2 := EC.Zero()
+	PowerOfTwos := ec.PowerVector(ec.V, big.NewInt(2))
+	tmp2 := ec.Zero()
 	// generate h'
-	HPrime := make([]ECPoint, len(EC.BPH))
+	HPrime := make([]ECPoint, len(ec.BPH))
 
 	for i := range HPrime {
-		mi := new(big.Int).ModInverse(PowersOfY[i], EC.N)
-		HPrime[i] = EC.BPH[i].Mult(mi)
+		mi := new(big.Int).ModInverse(PowersOfY[i], ec.N)
+		HPrime[i] = ec.Mult(ec.BPH[i], mi)
 	}
 
 	for i := range HPrime {
 		val1 := new(big.Int).Mul(cz, PowersOfY[i])
 		val2 := new(big.Int).Mul(new(big.Int).Mul(cz, cz), PowerOfTwos[i])
-		tmp2 = tmp2.Add(HPrime[i].Mult(new(big.Int).Add(val1, val2)))
+		tmp2 = ec.Add(tmp2, ec.Mult(HPrime[i], new(big.Int).Add(val1, val2)))
 	}
 
 	// without subtracting this value should equal muCH + l[i]G[i] + r[i]H'[i]
 	// we want to make sure that the innerproduct checks out, so we subtract it
-	P := rp.A.Add(rp.S.Mult(cx)).Add(tmp1).Add(tmp2).Add(EC.H.Mult(rp.Mu).Neg())
+	P := ec.Add(rp.A, ec.Mult(rp.S, cx), tmp1, tmp2, ec.Neg(ec.Mult(ec.H, rp.Mu)))
 	//fmt.Println(P)
 
-	if !InnerProductVerifyFast(rp.Th, P, EC.U, EC.BPG, HPrime, rp.IPP) {
+	if !ec.innerProductVerifyFast(rp.Th, P, ec.U, ec.BPG, HPrime, rp.IPP, t) {
 		fmt.Println("RPVerify - Uh oh! Check line (65) of verification!")
-		return false
+		return false, nil
 	}
 
-	return true
+	return true, nil
+}
+
+/*
+RPVerifyCommitment verifies a range proof and additionally checks that it was
+made over the given commitment, rather than over a fresh one chosen by the
+prover.
+*/
+func (ec *CryptoParams) RPVerifyCommitment(rp RangeProof, comm ECPoint, context []byte) (bool, error) {
+	if err := ec.checkRangeProof(rp); err != nil {
+		return false, err
+	}
+	if !rp.Comm.Equal(comm) {
+		return false, ErrWrongCommitment
+	}
+	return ec.RPVerify(rp, context)
 }
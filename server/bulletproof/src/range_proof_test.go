@@ -2,15 +2,16 @@ package src
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"math/big"
 	"testing"
 )
 
 func TestRPVerify1(t *testing.T) {
-	EC = NewECPrimeGroupKey(64)
+	ec := NewECPrimeGroupKey(64)
 	// Testing smallest number in range
-	if RPVerify(RPProve(big.NewInt(0))) {
+	if verified(ec.RPVerify(mustRP(ec.RPProve(big.NewInt(0), nil)), nil)) {
 		fmt.Println("Range Proof Verification works")
 	} else {
 		t.Error("*****Range Proof FAILURE")
@@ -18,9 +19,9 @@ func TestRPVerify1(t *testing.T) {
 }
 
 func TestRPVerify2(t *testing.T) {
-	EC = NewECPrimeGroupKey(64)
+	ec := NewECPrimeGroupKey(64)
 	// Testing largest number in range
-	if RPVerify(RPProve(new(big.Int).Sub(new(big.Int).Exp(big.NewInt(2), big.NewInt(63), EC.N), big.NewInt(1)))) {
+	if verified(ec.RPVerify(mustRP(ec.RPProve(new(big.Int).Sub(new(big.Int).Exp(big.NewInt(2), big.NewInt(63), ec.N), big.NewInt(1)), nil)), nil)) {
 		fmt.Println("Range Proof Verification works")
 	} else {
 		t.Error("*****Range Proof FAILURE")
@@ -28,9 +29,9 @@ func TestRPVerify2(t *testing.T) {
 }
 
 func TestRPVerify3(t *testing.T) {
-	EC = NewECPrimeGroupKey(64)
+	ec := NewECPrimeGroupKey(64)
 	// Testing the value 3
-	if RPVerify(RPProve(big.NewInt(3))) {
+	if verified(ec.RPVerify(mustRP(ec.RPProve(big.NewInt(3), nil)), nil)) {
 		fmt.Println("Range Proof Verification works")
 	} else {
 		t.Error("*****Range Proof FAILURE")
@@ -38,9 +39,9 @@ func TestRPVerify3(t *testing.T) {
 }
 
 func TestRPVerify4(t *testing.T) {
-	EC = NewECPrimeGroupKey(32)
+	ec := NewECPrimeGroupKey(32)
 	// Testing smallest number in range
-	if RPVerify(RPProve(big.NewInt(0))) {
+	if verified(ec.RPVerify(mustRP(ec.RPProve(big.NewInt(0), nil)), nil)) {
 		fmt.Println("Range Proof Verification works")
 	} else {
 		t.Error("*****Range Proof FAILURE")
@@ -48,16 +49,123 @@ func TestRPVerify4(t *testing.T) {
 }
 
 func TestRPVerifyRand(t *testing.T) {
-	EC = NewECPrimeGroupKey(64)
+	ec := NewECPrimeGroupKey(64)
 
-	ran, err := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(2), big.NewInt(64), EC.N))
-	check(err)
+	ran, err := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(2), big.NewInt(64), ec.N))
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// Testing the value 3
-	if RPVerify(RPProve(ran)) {
+	if verified(ec.RPVerify(mustRP(ec.RPProve(ran, nil)), nil)) {
 		fmt.Println("Range Proof Verification works")
 	} else {
 		t.Error("*****Range Proof FAILURE")
 		fmt.Printf("Random Value: %s", ran.String())
 	}
 }
+
+func TestRPProveWithBlinding(t *testing.T) {
+	ec := NewECPrimeGroupKey(64)
+
+	v := big.NewInt(42)
+	gamma, err := rand.Int(rand.Reader, ec.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comm := ec.Add(ec.Mult(ec.G, v), ec.Mult(ec.H, gamma))
+
+	proof := mustRP(ec.RPProveWithBlinding(v, gamma, nil))
+	if !proof.Comm.Equal(comm) {
+		t.Error("*****Range Proof is not over the given commitment")
+	}
+	if verified(ec.RPVerifyCommitment(proof, comm, nil)) {
+		fmt.Println("Range Proof over existing commitment works")
+	} else {
+		t.Error("*****Range Proof FAILURE")
+	}
+}
+
+func TestRPVerifyCommitmentWrongComm(t *testing.T) {
+	ec := NewECPrimeGroupKey(64)
+
+	gamma, err := rand.Int(rand.Reader, ec.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := mustRP(ec.RPProveWithBlinding(big.NewInt(42), gamma, nil))
+
+	other := ec.Add(ec.Mult(ec.G, big.NewInt(43)), ec.Mult(ec.H, gamma))
+	if verified(ec.RPVerifyCommitment(proof, other, nil)) {
+		t.Error("*****Range Proof accepted against a different commitment")
+	}
+}
+
+func TestRPVerifySM2(t *testing.T) {
+	ec := NewSM2GroupKey(64)
+	// Testing the largest number in range
+	v := new(big.Int).Sub(new(big.Int).Exp(big.NewInt(2), big.NewInt(64), nil), big.NewInt(1))
+	if verified(ec.RPVerify(mustRP(ec.RPProve(v, nil)), nil)) {
+		fmt.Println("SM2 Range Proof Verification works")
+	} else {
+		t.Error("*****SM2 Range Proof FAILURE")
+	}
+}
+
+func TestRPVerifyWrongContext(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+	proof := mustRP(ec.RPProve(big.NewInt(7), []byte("order-1")))
+
+	if !verified(ec.RPVerify(proof, []byte("order-1"))) {
+		t.Error("*****Range Proof FAILURE under its own context")
+	}
+	if verified(ec.RPVerify(proof, []byte("order-2"))) {
+		t.Error("*****Range Proof replayed under another context")
+	}
+}
+
+func TestRPVerifyMovedCommitment(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+	proof := mustRP(ec.RPProve(big.NewInt(7), nil))
+
+	// shifting V by G would claim a range for v+1 with the same proof
+	proof.Comm = ec.Add(proof.Comm, ec.G)
+	if verified(ec.RPVerify(proof, nil)) {
+		t.Error("*****Range Proof accepted for a different commitment")
+	} else {
+		fmt.Println("Range Proof is bound to its commitment")
+	}
+}
+
+func TestRPProveOutOfRange(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+	for _, v := range []*big.Int{big.NewInt(-1), big.NewInt(256), big.NewInt(1000)} {
+		if _, err := ec.RPProve(v, nil); !errors.Is(err, ErrOutOfRange) {
+			t.Error("*****Range Proof of", v, "gave", err)
+		}
+	}
+	if _, err := ec.RPProve(big.NewInt(255), nil); err != nil {
+		t.Error("*****Range Proof of the largest value failed:", err)
+	}
+}
+
+func TestRPVerifyMalformed(t *testing.T) {
+	ec := NewSM2GroupKey(8)
+	proof := mustRP(ec.RPProve(big.NewInt(7), nil))
+
+	short := proof
+	short.IPP.L = short.IPP.L[1:]
+	if _, err := ec.RPVerify(short, nil); !errors.Is(err, ErrMalformedProof) {
+		t.Error("*****Range Proof with a short inner product argument gave", err)
+	}
+
+	missing := proof
+	missing.Tau = nil
+	if _, err := ec.RPVerify(missing, nil); !errors.Is(err, ErrMalformedProof) {
+		t.Error("*****Range Proof without tau gave", err)
+	}
+
+	if _, err := NewSM2GroupKey(16).RPVerify(proof, nil); !errors.Is(err, ErrMalformedProof) {
+		t.Error("*****Range Proof verified under other params gave", err)
+	}
+}
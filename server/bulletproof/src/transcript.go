@@ -0,0 +1,110 @@
+package src
+
+import (
+	"encoding/binary"
+	"io"
+	"math/big"
+
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+/*
+Transcript is a Merlin-style Fiat-Shamir transcript over SM3.
+
+Prover and verifier feed it the same labelled messages in the same order:
+a domain separator, the params, the statement, the caller's context and
+every prover message, and draw each challenge from everything absorbed so
+far. A challenge therefore depends on the whole proof up to that point, so
+proofs cannot be re-targeted to another commitment, bit length or context.
+*/
+type Transcript struct {
+	state []byte
+}
+
+// NewTranscript starts a transcript for the protocol named by label.
+func NewTranscript(label string) *Transcript {
+	t := &Transcript{make([]byte, sm3.DigestLength)}
+	t.AppendMessage("dom-sep", []byte(label))
+	return t
+}
+
+// AppendMessage absorbs msg under label.
+func (t *Transcript) AppendMessage(label string, msg []byte) {
+	h := sm3.New()
+	h.Write(t.state)
+	writeWithLength(h, []byte(label))
+	writeWithLength(h, msg)
+	t.state = h.Sum(nil)
+}
+
+// AppendPoint absorbs p under label, with coordinates padded to a fixed width.
+func (t *Transcript) AppendPoint(label string, p ECPoint) {
+	t.AppendMessage(label, append(fixedBytes(p.X), fixedBytes(p.Y)...))
+}
+
+// AppendScalar absorbs s under label.
+func (t *Transcript) AppendScalar(label string, s *big.Int) {
+	t.AppendMessage(label, fixedBytes(s))
+}
+
+// AppendParams absorbs the curve, the bit length and every generator of ec.
+func (t *Transcript) AppendParams(ec *CryptoParams) {
+	t.AppendMessage("curve", []byte(ec.C.Params().Name))
+	t.AppendMessage("n", fixedBytes(big.NewInt(int64(ec.V))))
+	t.AppendPoint("G", ec.G)
+	t.AppendPoint("H", ec.H)
+	t.AppendPoint("U", ec.U)
+	for i := range ec.BPG {
+		t.AppendPoint("BPG", ec.BPG[i])
+		t.AppendPoint("BPH", ec.BPH[i])
+	}
+}
+
+/*
+ChallengeScalar derives a non-zero challenge mod n from the transcript and
+absorbs it, so the next challenge depends on this one. 64 bytes of output
+are reduced mod n to keep the bias negligible.
+*/
+func (t *Transcript) ChallengeScalar(label string, n *big.Int) *big.Int {
+	for ctr := byte(0); ; ctr++ {
+		wide := make([]byte, 0, 64)
+		for i := byte(0); i < 2; i++ {
+			h := sm3.New()
+			h.Write(t.state)
+			writeWithLength(h, []byte("challenge"))
+			writeWithLength(h, []byte(label))
+			h.Write([]byte{ctr, i})
+			wide = h.Sum(wide)
+		}
+		c := new(big.Int).Mod(new(big.Int).SetBytes(wide), n)
+		if c.Sign() != 0 {
+			t.AppendScalar(label, c)
+			return c
+		}
+	}
+}
+
+func writeWithLength(w io.Writer, b []byte) {
+	l := make([]byte, 4)
+	binary.BigEndian.PutUint32(l, uint32(len(b)))
+	w.Write(l)
+	// the SM3 implementation panics on empty writes
+	if len(b) > 0 {
+		w.Write(b)
+	}
+}
+
+// fixedBytes returns the big-endian 32-byte encoding of x, or a single 0xff
+// byte for nil so a missing value cannot collide with zero.
+func fixedBytes(x *big.Int) []byte {
+	if x == nil {
+		return []byte{0xff}
+	}
+	b := x.Bytes()
+	if len(b) >= 32 {
+		return b
+	}
+	out := make([]byte, 32)
+	copy(out[32-len(b):], b)
+	return out
+}
@@ -7,7 +7,7 @@ import (
 )
 
 // The length here always has to be a power of two
-func InnerProduct(a []*big.Int, b []*big.Int) *big.Int {
+func (ec *CryptoParams) InnerProduct(a []*big.Int, b []*big.Int) *big.Int {
 	if len(a) != len(b) {
 		fmt.Println("InnerProduct: Uh oh! Arrays not of the same length")
 		fmt.Printf("len(a): %d\n", len(a))
@@ -18,13 +18,13 @@ func InnerProduct(a []*big.Int, b []*big.Int) *big.Int {
 
 	for i := range a {
 		tmp1 := new(big.Int).Mul(a[i], b[i])
-		c = new(big.Int).Add(c, new(big.Int).Mod(tmp1, EC.N))
+		c = new(big.Int).Add(c, new(big.Int).Mod(tmp1, ec.N))
 	}
 
-	return new(big.Int).Mod(c, EC.N)
+	return new(big.Int).Mod(c, ec.N)
 }
 
-func VectorAdd(v []*big.Int, w []*big.Int) []*big.Int {
+func (ec *CryptoParams) VectorAdd(v []*big.Int, w []*big.Int) []*big.Int {
 	if len(v) != len(w) {
 		fmt.Println("VectorAdd: Uh oh! Arrays not of the same length")
 		fmt.Printf("len(v): %d\n", len(v))
@@ -33,13 +33,13 @@ func VectorAdd(v []*big.Int, w []*big.Int) []*big.Int {
 	result := make([]*big.Int, len(v))
 
 	for i := range v {
-		result[i] = new(big.Int).Mod(new(big.Int).Add(v[i], w[i]), EC.N)
+		result[i] = new(big.Int).Mod(new(big.Int).Add(v[i], w[i]), ec.N)
 	}
 
 	return result
 }
 
-func VectorHadamard(v, w []*big.Int) []*big.Int {
+func (ec *CryptoParams) VectorHadamard(v, w []*big.Int) []*big.Int {
 	if len(v) != len(w) {
 		fmt.Println("VectorHadamard: Uh oh! Arrays not of the same length")
 		fmt.Printf("len(v): %d\n", len(w))
@@ -49,27 +49,27 @@ func VectorHadamard(v, w []*big.Int) []*big.Int {
 	result := make([]*big.Int, len(v))
 
 	for i := range v {
-		result[i] = new(big.Int).Mod(new(big.Int).Mul(v[i], w[i]), EC.N)
+		result[i] = new(big.Int).Mod(new(big.Int).Mul(v[i], w[i]), ec.N)
 	}
 
 	return result
 }
 
-func VectorAddScalar(v []*big.Int, s *big.Int) []*big.Int {
+func (ec *CryptoParams) VectorAddScalar(v []*big.Int, s *big.Int) []*big.Int {
 	result := make([]*big.Int, len(v))
 
 	for i := range v {
-		result[i] = new(big.Int).Mod(new(big.Int).Add(v[i], s), EC.N)
+		result[i] = new(big.Int).Mod(new(big.Int).Add(v[i], s), ec.N)
 	}
 
 	return result
 }
 
-func ScalarVectorMul(v []*big.Int, s *big.Int) []*big.Int {
+func (ec *CryptoParams) ScalarVectorMul(v []*big.Int, s *big.Int) []*big.Int {
 	result := make([]*big.Int, len(v))
 
 	for i := range v {
-		result[i] = new(big.Int).Mod(new(big.Int).Mul(v[i], s), EC.N)
+		result[i] = new(big.Int).Mod(new(big.Int).Mul(v[i], s), ec.N)
 	}
 
 	return result
@@ -121,33 +121,40 @@ func reverse(l []*big.Int) []*big.Int {
 	return result
 }
 
-func PowerVector(l int, base *big.Int) []*big.Int {
+func (ec *CryptoParams) PowerVector(l int, base *big.Int) []*big.Int {
 	result := make([]*big.Int, l)
 
 	for i := 0; i < l; i++ {
-		result[i] = new(big.Int).Exp(base, big.NewInt(int64(i)), EC.N)
+		result[i] = new(big.Int).Exp(base, big.NewInt(int64(i)), ec.N)
 	}
 
 	return result
 }
 
-func RandVector(l int) []*big.Int {
+// randScalar returns a uniformly random scalar mod N.
+func (ec *CryptoParams) randScalar() (*big.Int, error) {
+	return rand.Int(rand.Reader, ec.N)
+}
+
+func (ec *CryptoParams) RandVector(l int) ([]*big.Int, error) {
 	result := make([]*big.Int, l)
 
 	for i := 0; i < l; i++ {
-		x, err := rand.Int(rand.Reader, EC.N)
-		check(err)
+		x, err := ec.randScalar()
+		if err != nil {
+			return nil, err
+		}
 		result[i] = x
 	}
 
-	return result
+	return result, nil
 }
 
-func VectorSum(y []*big.Int) *big.Int {
+func (ec *CryptoParams) VectorSum(y []*big.Int) *big.Int {
 	result := big.NewInt(0)
 
 	for _, j := range y {
-		result = new(big.Int).Mod(new(big.Int).Add(result, j), EC.N)
+		result = new(big.Int).Mod(new(big.Int).Add(result, j), ec.N)
 	}
 
 	return result
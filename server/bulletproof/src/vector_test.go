@@ -8,11 +8,12 @@ import (
 )
 
 func TestValueBreakdown(t *testing.T) {
+	ec := Params(64)
 	v := big.NewInt(20)
 	yes := reverse(StrToBigIntArray(PadLeft(fmt.Sprintf("%b", v), "0", 64)))
-	vec2 := PowerVector(64, big.NewInt(2))
+	vec2 := ec.PowerVector(64, big.NewInt(2))
 
-	calc := InnerProduct(yes, vec2)
+	calc := ec.InnerProduct(yes, vec2)
 
 	if v.Cmp(calc) != 0 {
 		t.Error("Binary Value Breakdown - Failure :(")
@@ -28,13 +29,16 @@ func TestValueBreakdown(t *testing.T) {
 }
 
 func TestValueBreakdownRand(t *testing.T) {
-	v, err := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(2), big.NewInt(64), EC.N))
-	check(err)
+	ec := Params(64)
+	v, err := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(2), big.NewInt(64), ec.N))
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	yes := reverse(StrToBigIntArray(PadLeft(fmt.Sprintf("%b", v), "0", 64)))
-	vec2 := PowerVector(64, big.NewInt(2))
+	vec2 := ec.PowerVector(64, big.NewInt(2))
 
-	calc := InnerProduct(yes, vec2)
+	calc := ec.InnerProduct(yes, vec2)
 
 	if v.Cmp(calc) != 0 {
 		t.Error("Binary Value Breakdown - Failure :(")
@@ -48,6 +52,7 @@ func TestValueBreakdownRand(t *testing.T) {
 }
 
 func TestVectorHadamard(t *testing.T) {
+	ec := Params(64)
 	a := make([]*big.Int, 5)
 	a[0] = big.NewInt(1)
 	a[1] = big.NewInt(1)
@@ -55,7 +60,7 @@ func TestVectorHadamard(t *testing.T) {
 	a[3] = big.NewInt(1)
 	a[4] = big.NewInt(1)
 
-	c := VectorHadamard(a, a)
+	c := ec.VectorHadamard(a, a)
 
 	success := true
 
@@ -72,6 +77,7 @@ func TestVectorHadamard(t *testing.T) {
 }
 
 func TestInnerProduct(t *testing.T) {
+	ec := Params(64)
 	fmt.Println("TestInnerProduct")
 	a := make([]*big.Int, 4)
 	b := make([]*big.Int, 4)
@@ -86,7 +92,7 @@ func TestInnerProduct(t *testing.T) {
 	b[2] = big.NewInt(2)
 	b[3] = big.NewInt(2)
 
-	c := InnerProduct(a, b)
+	c := ec.InnerProduct(a, b)
 
 	if c.Cmp(big.NewInt(16)) == 0 {
 		fmt.Println("Success - Innerproduct works with 2")
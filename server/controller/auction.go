@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"fmt"
+	"server/blockchain"
+	"server/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuctionController struct{}
+
+// CreateAuction 市场运营方为商品创建密封拍卖，出价用运营方自己的公钥加密，verifier为验证结果的监管方
+func (a AuctionController) CreateAuction(ctx *gin.Context) {
+	var body struct {
+		ID       string `json:"id"`
+		GoodId   string `json:"goodId"`
+		Reserve  int64  `json:"reserve"`
+		Deadline int64  `json:"deadline"`
+		Verifier string `json:"verifier"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	key := utils.ReadPubKey(ctx.GetString(IdentityKey))
+	if key == nil {
+		Error(ctx, 400, "failed to read auctioneer key")
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.CreateAuction(body.ID, body.GoodId, key, body.Reserve, body.Deadline, body.Verifier)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to create auction:%v", err))
+}
+
+// SubmitBid 用户密封出价，出价明文只在本次请求中使用
+func (a AuctionController) SubmitBid(ctx *gin.Context) {
+	var body struct {
+		ID     string `json:"id"`
+		Bidder string `json:"bidder"`
+		Value  int64  `json:"value"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.SubmitBid(body.ID, body.Bidder, body.Value)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to submit bid:%v", err))
+}
+
+// CloseAuction 截止后市场运营方用私钥打开出价，公布中标者和证明
+func (a AuctionController) CloseAuction(ctx *gin.Context) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	pri := utils.ReadPriKey(ctx.GetString(IdentityKey))
+	if pri == nil {
+		Error(ctx, 400, "failed to read auctioneer key")
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.CloseAuction(body.ID, pri)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to close auction:%v", err))
+}
+
+// AttestAuction 拍卖指定的监管方重新验证结果并签名，之后才能付款
+func (a AuctionController) AttestAuction(ctx *gin.Context) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.AttestAuction(body.ID, ctx.GetString(IdentityKey))
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to attest auction:%v", err))
+}
+
+// RejectAuction 拍卖指定的监管方重新验证结果不通过时驳回，拍卖方重新公布结果
+func (a AuctionController) RejectAuction(ctx *gin.Context) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.RejectAuction(body.ID, ctx.GetString(IdentityKey))
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to reject auction:%v", err))
+}
+
+// SettleAuction 中标者以中标价向卖方付款，返回订单号
+func (a AuctionController) SettleAuction(ctx *gin.Context) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	orderNum, err := contractInstance.SettleAuction(body.ID)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", orderNum, 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to settle auction:%v", err))
+}
+
+// VerifyAuction 任何人都可以从链上数据重新验证拍卖结果
+func (a AuctionController) VerifyAuction(ctx *gin.Context) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	if err := contractInstance.VerifyAuction(body.ID); err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to verify auction:%v", err))
+		return
+	}
+	Success(ctx, 200, "SUCCESS", "the auction outcome verifies", 1)
+}
+
+func (a AuctionController) GetAuction(ctx *gin.Context) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.GetAuction(body.ID)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
+
+func (a AuctionController) GetBids(ctx *gin.Context) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.GetBids(body.ID)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"fmt"
+	"server/blockchain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdentityKey 签名认证通过后用户名在gin.Context中的键，由middleware.RoleAuth设置
+const IdentityKey = "identity"
+
+type AuditController struct{}
+
+// AuditOrder 监管方对订单去匿名化，审计原因必填，审计记录写入账本
+func (a AuditController) AuditOrder(ctx *gin.Context) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	if body.Reason == "" {
+		Error(ctx, 400, "reason is required")
+		return
+	}
+	orderNum := ctx.Param("id")
+	auditor := ctx.GetString(IdentityKey)
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.AuditOrder(orderNum, auditor, body.Reason)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to audit order:%v", err))
+}
+
+// GetAuditRecords 查询订单的审计记录
+func (a AuditController) GetAuditRecords(ctx *gin.Context) {
+	orderNum := ctx.Param("id")
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.GetAuditRecords(orderNum)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
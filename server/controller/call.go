@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"fmt"
+	"server/blockchain"
+	"server/market"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 集合竞价的订单在内存中，出清结果先写到链上再生效
+var (
+	callAuction *market.Call
+	callOnce    sync.Once
+)
+
+func callMarket() *market.Call {
+	callOnce.Do(func() {
+		callAuction = market.NewCall()
+		callAuction.Publish = blockchain.GetContractInstance().PublishClearing
+	})
+	return callAuction
+}
+
+type CallController struct{}
+
+// SubmitOrder 向一个结算时段和分区的集合竞价提交买单或卖单，闸门关闭前有效
+func (c CallController) SubmitOrder(ctx *gin.Context) {
+	var body struct {
+		ID       string `json:"id"`
+		Owner    string `json:"owner"`
+		Side     string `json:"side"` //bid买单、ask卖单
+		Price    int64  `json:"price"`
+		Quantity int64  `json:"quantity"`
+		Period   int64  `json:"period"`
+		Zone     string `json:"zone"`
+		Meter    string `json:"meter"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	o := market.Order{
+		ID:       body.ID,
+		Owner:    body.Owner,
+		Price:    body.Price,
+		Quantity: body.Quantity,
+		Period:   body.Period,
+		Zone:     body.Zone,
+		Meter:    body.Meter,
+	}
+	switch body.Side {
+	case "bid":
+		o.Side = market.Bid
+	case "ask":
+		o.Side = market.Ask
+		if err := blockchain.GetContractInstance().CheckAsk(o); err != nil {
+			Error(ctx, 400, fmt.Sprintf("failed to check ask:%v", err))
+			return
+		}
+	default:
+		Error(ctx, 400, fmt.Sprintf("unknown side %q", body.Side))
+		return
+	}
+	o, err := callMarket().Submit(o, time.Now().Unix())
+	if err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to submit order:%v", err))
+		return
+	}
+	Success(ctx, 200, "SUCCESS", o, 1)
+}
+
+// Orders 查询一个结算时段和分区尚未出清的订单
+func (c CallController) Orders(ctx *gin.Context) {
+	var body struct {
+		Period int64  `json:"period"`
+		Zone   string `json:"zone"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	orders := callMarket().Orders(body.Period, body.Zone)
+	Success(ctx, 200, "SUCCESS", orders, int64(len(orders)))
+}
+
+/*
+Clear 闸门关闭后由市场运营方出清一个结算时段和分区，出清结果写到链上后
+每笔成交按出清价走保密交易流程结算，结算失败的成交在返回中给出错误
+*/
+func (c CallController) Clear(ctx *gin.Context) {
+	var body struct {
+		Period int64  `json:"period"`
+		Zone   string `json:"zone"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	cl, err := callMarket().Clear(body.Period, body.Zone, time.Now().Unix())
+	if err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to clear:%v", err))
+		return
+	}
+	settlements := []Settlement{}
+	for _, f := range cl.Fills {
+		s := Settlement{Fill: f}
+		s.OrderNum, err = blockchain.GetContractInstance().SettleFill(f)
+		if err != nil {
+			s.Error = err.Error()
+		}
+		settlements = append(settlements, s)
+	}
+	Success(ctx, 200, "SUCCESS", gin.H{"clearing": cl, "settlements": settlements}, int64(len(cl.Fills)))
+}
+
+// GetClearing 查询链上的出清结果
+func (c CallController) GetClearing(ctx *gin.Context) {
+	var body struct {
+		Period int64  `json:"period"`
+		Zone   string `json:"zone"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	res, err := blockchain.GetContractInstance().GetClearing(body.Period, body.Zone)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
+
+// VerifyClearing 从链上的订单重放出清，任何人都可以检查出清价和成交量
+func (c CallController) VerifyClearing(ctx *gin.Context) {
+	var body struct {
+		Period int64  `json:"period"`
+		Zone   string `json:"zone"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	cl, err := blockchain.GetContractInstance().VerifyClearing(body.Period, body.Zone)
+	if err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to verify clearing:%v", err))
+		return
+	}
+	Success(ctx, 200, "SUCCESS", cl, int64(len(cl.Fills)))
+}
@@ -85,4 +85,49 @@ func (g GoodController)UpdateGoodPrice(ctx *gin.Context){
 	}
 	Error(ctx,400,fmt.Sprintf("Failed to Submit transaction: %v", err))
 	return
-}
\ No newline at end of file
+}
+// CreateGood 用电表的余电挂牌，数量不能超过电表尚未挂牌的上网电量
+func (g GoodController) CreateGood(ctx *gin.Context) {
+	var body struct {
+		Owner  string `json:"owner"`
+		Id     string `json:"id"`
+		Meter  string `json:"meter"`
+		Price  int64  `json:"price"`
+		Amount int64  `json:"amount"`
+		Start  int64  `json:"deliveryStart"`
+		End    int64  `json:"deliveryEnd"`
+		Zone   string `json:"zone"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to bind body json: %v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.CreateGoods(body.Owner, body.Id, body.Meter, body.Price, body.Amount, body.Start, body.End, body.Zone)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Submit transaction: %v", err))
+}
+
+// SearchGoods 按交割时段、电网分区和分时电价时段查询商品，zone、band为空时不筛选
+func (g GoodController) SearchGoods(ctx *gin.Context) {
+	var body struct {
+		From int64  `json:"from"`
+		To   int64  `json:"to"`
+		Zone string `json:"zone"`
+		Band string `json:"band"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to bind body json: %v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.GetGoodsByDelivery(body.From, body.To, body.Zone, body.Band)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
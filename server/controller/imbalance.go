@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"server/blockchain"
+	"server/imbalance"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ImbalanceController struct{}
+
+// SetTariff 市场运营方设置分时时段的偏差电价，band为空时为未单独定价时段的电价
+func (i ImbalanceController) SetTariff(ctx *gin.Context) {
+	var body struct {
+		Band      string `json:"band"`
+		Shortfall int64  `json:"shortfall"`
+		Excess    int64  `json:"excess"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.SetImbalanceTariff(body.Band, imbalance.Tariff{Shortfall: body.Shortfall, Excess: body.Excess})
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to set tariff:%v", err))
+}
+
+func (i ImbalanceController) GetTariff(ctx *gin.Context) {
+	var body struct {
+		Band string `json:"band"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.GetImbalanceTariff(body.Band)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
+
+// Compute 预览已完成订单的偏差电量和金额
+func (i ImbalanceController) Compute(ctx *gin.Context) {
+	var body struct {
+		OrderNum string `json:"orderNum"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.ComputeImbalance(body.OrderNum)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to compute imbalance:%v", err))
+}
+
+// Settle 结算订单的偏差，调整买卖双方的加密钱包，每个订单只能结算一次
+func (i ImbalanceController) Settle(ctx *gin.Context) {
+	var body struct {
+		OrderNum string `json:"orderNum"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.SettleImbalance(body.OrderNum)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	if errors.Is(err, blockchain.ErrInsufficientBalance) {
+		Error(ctx, 402, "insufficient balance")
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to settle imbalance:%v", err))
+}
+
+// Verify 任何人都可以从链上数据重新验证偏差结算
+func (i ImbalanceController) Verify(ctx *gin.Context) {
+	var body struct {
+		OrderNum string `json:"orderNum"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	if err := contractInstance.VerifyImbalance(body.OrderNum); err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to verify imbalance:%v", err))
+		return
+	}
+	Success(ctx, 200, "SUCCESS", "the imbalance settlement verifies", 1)
+}
+
+func (i ImbalanceController) GetImbalance(ctx *gin.Context) {
+	var body struct {
+		OrderNum string `json:"orderNum"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.GetImbalance(body.OrderNum)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
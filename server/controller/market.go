@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"encoding/hex"
+	"fmt"
+	"server/blockchain"
+	"server/market"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 订单簿在内存中，每个事件先锚定到链上再生效
+var (
+	book     *market.Book
+	bookOnce sync.Once
+)
+
+func orderBook() *market.Book {
+	bookOnce.Do(func() {
+		book = market.NewBook()
+		book.Anchor = blockchain.GetContractInstance().AnchorMatch
+	})
+	return book
+}
+
+type MarketController struct{}
+
+// Settlement 一笔成交的结算结果
+type Settlement struct {
+	Fill     market.Fill `json:"fill"`
+	OrderNum string      `json:"orderNum"`
+	Error    string      `json:"error"`
+}
+
+/*
+SubmitOrder 提交买单或卖单，按价格优先、时间优先撮合，未成交部分留在订单簿中
+每笔成交随后走保密交易流程结算，结算失败的成交在返回中给出错误，成交本身已锚定在链上
+*/
+func (m MarketController) SubmitOrder(ctx *gin.Context) {
+	var body struct {
+		ID       string `json:"id"`
+		Owner    string `json:"owner"`
+		Side     string `json:"side"` //bid买单、ask卖单
+		Price    int64  `json:"price"`
+		Quantity int64  `json:"quantity"`
+		Period   int64  `json:"period"`
+		Zone     string `json:"zone"`
+		Meter    string `json:"meter"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	o := market.Order{
+		ID:       body.ID,
+		Owner:    body.Owner,
+		Price:    body.Price,
+		Quantity: body.Quantity,
+		Period:   body.Period,
+		Zone:     body.Zone,
+		Meter:    body.Meter,
+	}
+	switch body.Side {
+	case "bid":
+		o.Side = market.Bid
+	case "ask":
+		o.Side = market.Ask
+		if err := blockchain.GetContractInstance().CheckAsk(o); err != nil {
+			Error(ctx, 400, fmt.Sprintf("failed to check ask:%v", err))
+			return
+		}
+	default:
+		Error(ctx, 400, fmt.Sprintf("unknown side %q", body.Side))
+		return
+	}
+	r, err := orderBook().Submit(o, time.Now().Unix())
+	if err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to submit order:%v", err))
+		return
+	}
+	settlements := []Settlement{}
+	for _, f := range r.Fills {
+		s := Settlement{Fill: f}
+		s.OrderNum, err = blockchain.GetContractInstance().SettleFill(f)
+		if err != nil {
+			s.Error = err.Error()
+		}
+		settlements = append(settlements, s)
+	}
+	Success(ctx, 200, "SUCCESS", gin.H{"result": r, "settlements": settlements}, int64(len(r.Fills)))
+}
+
+// CancelOrder 撤销订单簿中的订单
+func (m MarketController) CancelOrder(ctx *gin.Context) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	r, err := orderBook().Cancel(body.ID)
+	if err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to cancel order:%v", err))
+		return
+	}
+	Success(ctx, 200, "SUCCESS", r, 1)
+}
+
+// Depth 查询一个结算时段和分区的订单簿
+func (m MarketController) Depth(ctx *gin.Context) {
+	var body struct {
+		Period int64  `json:"period"`
+		Zone   string `json:"zone"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	bids, asks := orderBook().Depth(body.Period, body.Zone)
+	Success(ctx, 200, "SUCCESS", gin.H{"bids": bids, "asks": asks, "head": hex.EncodeToString(orderBook().Head())}, int64(len(bids)+len(asks)))
+}
+
+// GetMatchAnchor 按哈希查询撮合事件在链上的锚定
+func (m MarketController) GetMatchAnchor(ctx *gin.Context) {
+	var body struct {
+		Hash string `json:"hash"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	res, err := blockchain.GetContractInstance().GetMatchAnchor(body.Hash)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"server/blockchain"
+	"server/meter"
+
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/gin-gonic/gin"
+)
+
+type MeterController struct{}
+
+// RegisterMeter 市场运营方登记电表，pub为电表公钥JSON的base64
+func (m MeterController) RegisterMeter(ctx *gin.Context) {
+	var body struct {
+		ID    string `json:"id"`
+		Owner string `json:"owner"`
+		Pub   string `json:"pub"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	pub_bytes, err := base64.StdEncoding.DecodeString(body.Pub)
+	if err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to decode public key:%v", err))
+		return
+	}
+	var pub *sm2.PublicKey
+	if err := json.Unmarshal(pub_bytes, &pub); err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to unmarshal public key:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.RegisterMeter(body.ID, body.Owner, pub)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to register meter:%v", err))
+}
+
+// SubmitReading 电表上报签名的区间读数，读数由电表签名认证，无需用户登录
+func (m MeterController) SubmitReading(ctx *gin.Context) {
+	var body meter.Reading
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.SubmitReading(body)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to submit reading:%v", err))
+}
+
+func (m MeterController) GetMeter(ctx *gin.Context) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.GetMeter(body.ID)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
+
+func (m MeterController) GetReadings(ctx *gin.Context) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.GetReadings(body.ID)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
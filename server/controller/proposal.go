@@ -205,3 +205,24 @@ func (p ProposalController) UpdateProposal(ctx *gin.Context) {
 	}
 	Error(ctx, 400, fmt.Sprintf("Failed to Submit transaction: %v", err))
 }
+
+// GetProposalByDelivery 按交割时段、电网分区和分时电价时段查询订单
+func (p ProposalController) GetProposalByDelivery(ctx *gin.Context) {
+	var body struct {
+		From int64  `json:"from"`
+		To   int64  `json:"to"`
+		Zone string `json:"zone"`
+		Band string `json:"band"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.GetOrdersByDelivery(body.From, body.To, body.Zone, body.Band)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
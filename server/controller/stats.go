@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"fmt"
+	"server/blockchain"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StatsController struct{}
+
+// AggregateVolume 市场运营方统计全市场从from开始的一个时段的交易总额并公布
+func (s StatsController) AggregateVolume(ctx *gin.Context) {
+	var body struct {
+		From int64 `json:"from"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.AggregateVolume(body.From)
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("failed to aggregate volume:%v", err))
+}
+
+// VerifyAggregate 任何人都可以验证公布的总额是统计密文的正确解密
+func (s StatsController) VerifyAggregate(ctx *gin.Context) {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := ctx.BindJSON(&body); err != nil {
+		Error(ctx, 400, fmt.Sprintf("faild to bind body json:%v", err))
+		return
+	}
+	contractInstance := blockchain.GetContractInstance()
+	if err := contractInstance.VerifyAggregate(body.ID); err != nil {
+		Error(ctx, 400, fmt.Sprintf("failed to verify aggregate:%v", err))
+		return
+	}
+	Success(ctx, 200, "SUCCESS", "the aggregate verifies", 1)
+}
+
+// GetAggregates 查询已公布的统计，供电网报送
+func (s StatsController) GetAggregates(ctx *gin.Context) {
+	contractInstance := blockchain.GetContractInstance()
+	res, err := contractInstance.GetAggregates()
+	if err == nil {
+		Success(ctx, 200, "SUCCESS", string(res), 1)
+		return
+	}
+	Error(ctx, 400, fmt.Sprintf("Failed to Evaluate transaction: %v", err))
+}
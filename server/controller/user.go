@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"server/blockchain"
 
@@ -82,6 +83,10 @@ func (u UserController) SubmitProposal(ctx *gin.Context) {
 		Success(ctx, 200, "SUCCESS", string(res), 1)
 		return
 	}
+	if errors.Is(err, blockchain.ErrInsufficientBalance) {
+		Error(ctx, 402, "insufficient balance")
+		return
+	}
 	Error(ctx, 400, fmt.Sprintf("Failed to Submit: %v", err))
 }
 
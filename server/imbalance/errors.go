@@ -0,0 +1,24 @@
+package imbalance
+
+import "errors"
+
+var (
+	// ErrBadTariff is returned for a negative tariff.
+	ErrBadTariff = errors.New("imbalance: invalid tariff")
+
+	// ErrBadAmount is returned for a non-positive contract, a negative
+	// delivery, an amount above MaxAmount, or posting nothing.
+	ErrBadAmount = errors.New("imbalance: invalid amount")
+
+	// ErrBadKey is returned for a wallet key that is missing or not a
+	// point of the curve, or a private key that does not open the balance.
+	ErrBadKey = errors.New("imbalance: bad wallet key")
+
+	// ErrInsufficientBalance is returned when a debit would leave the
+	// payer's balance negative.
+	ErrInsufficientBalance = errors.New("imbalance: insufficient balance")
+
+	// ErrBadPosting is returned when a posting or its non-negativity proof
+	// is malformed or does not verify.
+	ErrBadPosting = errors.New("imbalance: invalid posting")
+)
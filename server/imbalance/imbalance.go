@@ -0,0 +1,79 @@
+/*
+Package imbalance settles the difference between the energy an order
+contracted and the energy the seller's meter delivered in the order's
+window.
+
+A shortfall is paid by the seller to the buyer and an excess by the buyer
+to the seller, each at a public per-kWh Tariff, so the amount that moves is
+public while both wallets stay encrypted. The amount is posted to the
+wallets homomorphically, as the order flow does: the payee's balance
+ciphertext gains Enc(amount) with utils.CiperAdd and the payer's loses it
+with utils.CiperSub. The randomness of Enc(amount) is revealed, so anyone
+can check that the posting moves exactly the amount.
+
+The payer also shows that its new balance is not negative without opening
+it: a Pedersen commitment D to the balance, a range proof of D over
+BalanceBits, and a proof that D commits to the value the new balance
+ciphertext decrypts to under the payer's key.
+*/
+package imbalance
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// BalanceBits is the bit length of a wallet balance, as in the order flow.
+const BalanceBits = 64
+
+// MaxAmount bounds the amount of one settlement, like a price in the
+// order flow, so a balance stays quick to decrypt.
+const MaxAmount = 1<<32 - 1
+
+// Tariff is what one kWh of imbalance costs.
+type Tariff struct {
+	Shortfall int64 `json:"shortfall"` // paid by the seller per kWh not delivered
+	Excess    int64 `json:"excess"`    // paid by the buyer per kWh delivered beyond the contract
+}
+
+// Settlement is the outcome of comparing the contracted and the delivered
+// energy of one order.
+type Settlement struct {
+	Contracted int64 `json:"contracted"`
+	Delivered  int64 `json:"delivered"`
+	Shortfall  int64 `json:"shortfall"`
+	Excess     int64 `json:"excess"`
+	Rate       int64 `json:"rate"`       // the tariff applied per kWh
+	Amount     int64 `json:"amount"`     // Rate times the shortfall or the excess
+	SellerPays bool  `json:"sellerPays"` // the seller pays for a shortfall, the buyer for an excess
+}
+
+// Settle compares contracted and delivered kWh and prices the difference
+// with t.
+func (t Tariff) Settle(contracted, delivered int64) (Settlement, error) {
+	if t.Shortfall < 0 || t.Excess < 0 {
+		return Settlement{}, fmt.Errorf("%w: negative tariff", ErrBadTariff)
+	}
+	if contracted <= 0 || delivered < 0 {
+		return Settlement{}, fmt.Errorf("%w: contracted %d, delivered %d", ErrBadAmount, contracted, delivered)
+	}
+	s := Settlement{Contracted: contracted, Delivered: delivered}
+	kwh := delivered - contracted
+	if kwh < 0 {
+		s.Shortfall, s.Rate, s.SellerPays = -kwh, t.Shortfall, true
+		kwh = -kwh
+	} else {
+		s.Excess, s.Rate = kwh, t.Excess
+	}
+	hi, lo := bits.Mul64(uint64(kwh), uint64(s.Rate))
+	if hi != 0 || lo > MaxAmount {
+		return Settlement{}, fmt.Errorf("%w: %d kWh at %d", ErrBadAmount, kwh, s.Rate)
+	}
+	s.Amount = int64(lo)
+	return s, nil
+}
+
+// Context binds the postings and proofs of a settlement to its order.
+func Context(orderNum string) []byte {
+	return []byte("imbalance|" + orderNum)
+}
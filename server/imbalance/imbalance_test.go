@@ -0,0 +1,176 @@
+package imbalance
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"server/utils"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+func TestSettle(t *testing.T) {
+	tariff := Tariff{Shortfall: 30, Excess: 12}
+	cases := []struct {
+		contracted, delivered int64
+		want                  Settlement
+	}{
+		{100, 80, Settlement{Contracted: 100, Delivered: 80, Shortfall: 20, Rate: 30, Amount: 600, SellerPays: true}},
+		{100, 125, Settlement{Contracted: 100, Delivered: 125, Excess: 25, Rate: 12, Amount: 300}},
+		{100, 100, Settlement{Contracted: 100, Delivered: 100, Rate: 12}},
+		{100, 0, Settlement{Contracted: 100, Shortfall: 100, Rate: 30, Amount: 3000, SellerPays: true}},
+	}
+	for _, c := range cases {
+		s, err := tariff.Settle(c.contracted, c.delivered)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != c.want {
+			t.Errorf("*****Settle(%d, %d) = %+v, want %+v", c.contracted, c.delivered, s, c.want)
+		}
+	}
+	if _, err := (Tariff{Shortfall: -1}).Settle(10, 5); !errors.Is(err, ErrBadTariff) {
+		t.Errorf("*****Negative tariff: got %v, want ErrBadTariff", err)
+	}
+	if _, err := tariff.Settle(0, 5); !errors.Is(err, ErrBadAmount) {
+		t.Errorf("*****Empty contract: got %v, want ErrBadAmount", err)
+	}
+	if _, err := (Tariff{Shortfall: 1 << 40}).Settle(1<<30, 0); !errors.Is(err, ErrBadAmount) {
+		t.Errorf("*****Amount above MaxAmount: got %v, want ErrBadAmount", err)
+	}
+}
+
+func wallet(t *testing.T, value int64) (*sm2.PrivateKey, []byte) {
+	pri, pub, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := utils.HomoEncrypt(pub, big.NewInt(value).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pri, balance
+}
+
+func decrypt(t *testing.T, pri *sm2.PrivateKey, balance []byte) int64 {
+	m, err := utils.HomoDecrypt(pri, balance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return new(big.Int).SetBytes(m).Int64()
+}
+
+func TestPostings(t *testing.T) {
+	seller, sellerBalance := wallet(t, 500)
+	buyer, buyerBalance := wallet(t, 200)
+	context := Context("order-1")
+
+	// a shortfall of 4 kWh at 30: the seller pays the buyer 120
+	debit, err := Debit(seller, sellerBalance, 500, 120, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := debit.VerifyDebit(sm2.CalculatePubKey(seller), sellerBalance, 120, context); err != nil {
+		t.Fatal("*****Debit FAILURE:", err)
+	}
+	credit, err := Credit(sm2.CalculatePubKey(buyer), buyerBalance, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := credit.VerifyCredit(sm2.CalculatePubKey(buyer), buyerBalance, 120); err != nil {
+		t.Fatal("*****Credit FAILURE:", err)
+	}
+	if v := decrypt(t, seller, debit.Balance); v != 380 {
+		t.Errorf("*****Seller balance %d, want 380", v)
+	}
+	if v := decrypt(t, buyer, credit.Balance); v != 320 {
+		t.Errorf("*****Buyer balance %d, want 320", v)
+	}
+
+	// the whole balance can be paid out
+	all, err := Debit(buyer, credit.Balance, 320, 320, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := all.VerifyDebit(sm2.CalculatePubKey(buyer), credit.Balance, 320, context); err != nil {
+		t.Error("*****Debit to zero FAILURE:", err)
+	}
+	fmt.Println("Imbalance postings verify without opening either balance")
+}
+
+func TestDebitRejected(t *testing.T) {
+	pri, balance := wallet(t, 50)
+	context := Context("order-2")
+	if _, err := Debit(pri, balance, 50, 51, context); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("*****Overdraft: got %v, want ErrInsufficientBalance", err)
+	}
+	if _, err := Debit(pri, balance, 60, 51, context); !errors.Is(err, ErrBadKey) {
+		t.Errorf("*****Wrong balance value: got %v, want ErrBadKey", err)
+	}
+	if _, err := Debit(pri, balance, 50, 0, context); !errors.Is(err, ErrBadAmount) {
+		t.Errorf("*****Empty posting: got %v, want ErrBadAmount", err)
+	}
+}
+
+func TestPostingTampered(t *testing.T) {
+	pri, balance := wallet(t, 90)
+	pub := sm2.CalculatePubKey(pri)
+	context := Context("order-3")
+	debit, err := Debit(pri, balance, 90, 40, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := debit.VerifyDebit(pub, balance, 39, context); !errors.Is(err, ErrBadPosting) {
+		t.Errorf("*****Debit of another amount: got %v, want ErrBadPosting", err)
+	}
+	if err := debit.VerifyDebit(pub, balance, 40, Context("order-4")); !errors.Is(err, ErrBadPosting) {
+		t.Errorf("*****Proof of another order: got %v, want ErrBadPosting", err)
+	}
+	other, _ := wallet(t, 90)
+	if err := debit.VerifyDebit(sm2.CalculatePubKey(other), balance, 40, context); !errors.Is(err, ErrBadPosting) {
+		t.Errorf("*****Debit of another wallet: got %v, want ErrBadPosting", err)
+	}
+	noProof := debit
+	noProof.Proof = nil
+	if err := noProof.VerifyDebit(pub, balance, 40, context); !errors.Is(err, ErrBadPosting) {
+		t.Errorf("*****Debit without proof: got %v, want ErrBadPosting", err)
+	}
+	// a posting that takes more than the cipher says is caught
+	credit, _ := Credit(pub, balance, 10)
+	stolen := debit
+	stolen.Balance, _ = utils.CiperSub(sm2.GetSm2P256V1(), debit.Balance, credit.Cipher)
+	if err := stolen.VerifyDebit(pub, balance, 40, context); !errors.Is(err, ErrBadPosting) {
+		t.Errorf("*****Debit of more than the amount: got %v, want ErrBadPosting", err)
+	}
+	// and a proof cannot be moved to another balance
+	if err := debit.Proof.Verify(pub, stolen.Balance, context); !errors.Is(err, ErrBadPosting) {
+		t.Errorf("*****Proof of another balance: got %v, want ErrBadPosting", err)
+	}
+	if err := credit.VerifyCredit(pub, balance, 11); !errors.Is(err, ErrBadPosting) {
+		t.Errorf("*****Credit of another amount: got %v, want ErrBadPosting", err)
+	}
+}
+
+// a balance that went negative cannot be shown non-negative: the range
+// proof of the value it decrypts to, N - 5, does not exist, and a proof of
+// any other value does not match the ciphertext.
+func TestNegativeBalance(t *testing.T) {
+	pri, balance := wallet(t, 5)
+	pub := sm2.CalculatePubKey(pri)
+	credit, _ := Credit(pub, balance, 10)
+	negative, err := utils.CiperSub(sm2.GetSm2P256V1(), balance, credit.Cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := Context("order-5")
+	proof, err := proveNonNegative(pri, negative, 0, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proof.Verify(pub, negative, context); !errors.Is(err, ErrBadPosting) {
+		t.Errorf("*****Negative balance shown non-negative: got %v, want ErrBadPosting", err)
+	}
+}
@@ -0,0 +1,295 @@
+package imbalance
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	bullet "server/bulletproof/src"
+	"server/utils"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// pointLen is the length of an uncompressed SM2 point; a wallet balance is
+// a HomoEncrypt ciphertext C1 || C2.
+const (
+	pointLen  = 1 + 2*32
+	cipherLen = 2 * pointLen
+)
+
+/*
+Posting moves a public amount into or out of one encrypted wallet. Cipher
+is Enc(amount) = (K*G, amount*G + K*P) under the wallet key P, and Balance
+is the wallet's balance ciphertext after the posting: the old one plus
+Cipher for a credit, minus Cipher for a debit. A debit carries the proof
+that Balance is not negative.
+*/
+type Posting struct {
+	Cipher  []byte       `json:"cipher"`
+	K       []byte       `json:"k"`
+	Balance []byte       `json:"balance"`
+	Proof   *NonNegative `json:"proof,omitempty"`
+}
+
+// NonNegative shows that a balance ciphertext decrypts to a value in
+// [0, 2^BalanceBits) under the wallet key.
+type NonNegative struct {
+	Comm  []byte `json:"comm"`  // D = b*G' + s*H', compressed
+	Range []byte `json:"range"` // range proof of D over BalanceBits
+	Proof []byte `json:"proof"` // bullet.BalanceProof that D commits to the balance
+}
+
+func params() *bullet.CryptoParams {
+	return bullet.SM2Params(BalanceBits)
+}
+
+func basePoint() bullet.ECPoint {
+	p := sm2.GetSm2P256V1().Params()
+	return bullet.ECPoint{X: p.Gx, Y: p.Gy}
+}
+
+// Credit adds amount to the wallet of pub whose balance ciphertext is
+// balance.
+func Credit(pub *sm2.PublicKey, balance []byte, amount int64) (Posting, error) {
+	p, err := encrypt(pub, amount)
+	if err != nil {
+		return Posting{}, err
+	}
+	if p.Balance, err = utils.CiperAdd(sm2.GetSm2P256V1(), balance, p.Cipher); err != nil {
+		return Posting{}, fmt.Errorf("%w: %v", ErrBadPosting, err)
+	}
+	return p, nil
+}
+
+/*
+Debit takes amount out of the wallet of priv whose balance ciphertext is
+balance and decrypts to value, and proves under context that the balance
+left is not negative.
+*/
+func Debit(priv *sm2.PrivateKey, balance []byte, value, amount int64, context []byte) (Posting, error) {
+	if priv == nil || priv.D == nil {
+		return Posting{}, fmt.Errorf("%w: missing private key", ErrBadKey)
+	}
+	c1, c2, err := parseCipher(balance)
+	if err != nil {
+		return Posting{}, err
+	}
+	if value < 0 || !opens(c1, c2, priv.D, value) {
+		return Posting{}, fmt.Errorf("%w: balance does not decrypt to %d", ErrBadKey, value)
+	}
+	if value < amount {
+		return Posting{}, ErrInsufficientBalance
+	}
+	pub := sm2.CalculatePubKey(priv)
+	p, err := encrypt(pub, amount)
+	if err != nil {
+		return Posting{}, err
+	}
+	if p.Balance, err = utils.CiperSub(sm2.GetSm2P256V1(), balance, p.Cipher); err != nil {
+		return Posting{}, fmt.Errorf("%w: %v", ErrBadPosting, err)
+	}
+	proof, err := proveNonNegative(priv, p.Balance, value-amount, context)
+	if err != nil {
+		return Posting{}, err
+	}
+	p.Proof = &proof
+	return p, nil
+}
+
+// VerifyCredit checks that p adds amount to the balance ciphertext
+// balance of the wallet of pub.
+func (p Posting) VerifyCredit(pub *sm2.PublicKey, balance []byte, amount int64) error {
+	if err := p.verifyCipher(pub, amount); err != nil {
+		return err
+	}
+	want, err := utils.CiperAdd(sm2.GetSm2P256V1(), balance, p.Cipher)
+	if err != nil || !bytes.Equal(want, p.Balance) {
+		return fmt.Errorf("%w: credited balance does not match", ErrBadPosting)
+	}
+	return nil
+}
+
+// VerifyDebit checks that p takes amount out of the balance ciphertext
+// balance of the wallet of pub and leaves it non-negative.
+func (p Posting) VerifyDebit(pub *sm2.PublicKey, balance []byte, amount int64, context []byte) error {
+	if err := p.verifyCipher(pub, amount); err != nil {
+		return err
+	}
+	want, err := utils.CiperSub(sm2.GetSm2P256V1(), balance, p.Cipher)
+	if err != nil || !bytes.Equal(want, p.Balance) {
+		return fmt.Errorf("%w: debited balance does not match", ErrBadPosting)
+	}
+	if p.Proof == nil {
+		return fmt.Errorf("%w: debit without a non-negativity proof", ErrBadPosting)
+	}
+	return p.Proof.Verify(pub, p.Balance, context)
+}
+
+// Verify checks that the balance ciphertext balance of the wallet of pub
+// is not negative.
+func (n NonNegative) Verify(pub *sm2.PublicKey, balance []byte, context []byte) error {
+	P, err := publicPoint(pub)
+	if err != nil {
+		return err
+	}
+	c1, c2, err := parseCipher(balance)
+	if err != nil {
+		return err
+	}
+	ec := params()
+	D, err := ec.DecodePoint(n.Comm)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadPosting, err)
+	}
+	rp, err := ec.DecodeRangeProof(n.Range)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadPosting, err)
+	}
+	proof, err := ec.DecodeBalanceProof(n.Proof)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadPosting, err)
+	}
+	if ok, err := ec.RPVerifyCommitment(rp, D, context); err != nil || !ok {
+		return fmt.Errorf("%w: range proof does not verify", ErrBadPosting)
+	}
+	if ok, err := ec.VerifyBalance(P, c1, c2, D, proof, context); err != nil || !ok {
+		return fmt.Errorf("%w: balance proof does not verify", ErrBadPosting)
+	}
+	return nil
+}
+
+// encrypt returns Enc(amount) under pub with its randomness.
+func encrypt(pub *sm2.PublicKey, amount int64) (Posting, error) {
+	P, err := publicPoint(pub)
+	if err != nil {
+		return Posting{}, err
+	}
+	if amount < 1 || amount > MaxAmount {
+		return Posting{}, fmt.Errorf("%w: posting %d", ErrBadAmount, amount)
+	}
+	k, err := randScalar()
+	if err != nil {
+		return Posting{}, err
+	}
+	ec := params()
+	G := basePoint()
+	kb, err := ec.EncodeScalar(k)
+	if err != nil {
+		return Posting{}, err
+	}
+	c1 := ec.Mult(G, k)
+	c2 := ec.Add(ec.Mult(G, big.NewInt(amount)), ec.Mult(P, k))
+	return Posting{Cipher: marshalCipher(c1, c2), K: kb}, nil
+}
+
+// verifyCipher checks that p.Cipher is Enc(amount) under pub with the
+// randomness p.K.
+func (p Posting) verifyCipher(pub *sm2.PublicKey, amount int64) error {
+	P, err := publicPoint(pub)
+	if err != nil {
+		return err
+	}
+	if amount < 1 || amount > MaxAmount {
+		return fmt.Errorf("%w: posting %d", ErrBadAmount, amount)
+	}
+	ec := params()
+	k, err := ec.DecodeScalar(p.K)
+	if err != nil || k.Sign() == 0 {
+		return fmt.Errorf("%w: bad randomness", ErrBadPosting)
+	}
+	G := basePoint()
+	want := marshalCipher(ec.Mult(G, k), ec.Add(ec.Mult(G, big.NewInt(amount)), ec.Mult(P, k)))
+	if !bytes.Equal(want, p.Cipher) {
+		return fmt.Errorf("%w: cipher does not encrypt %d", ErrBadPosting, amount)
+	}
+	return nil
+}
+
+func proveNonNegative(priv *sm2.PrivateKey, balance []byte, value int64, context []byte) (NonNegative, error) {
+	c1, c2, err := parseCipher(balance)
+	if err != nil {
+		return NonNegative{}, err
+	}
+	ec := params()
+	b := big.NewInt(value)
+	s, err := randScalar()
+	if err != nil {
+		return NonNegative{}, err
+	}
+	rp, err := ec.RPProveWithBlinding(b, s, context)
+	if err != nil {
+		return NonNegative{}, err
+	}
+	proof, err := ec.ProveBalance(priv.D, c1, c2, bullet.Opening{Value: b, Blinding: s}, context)
+	if err != nil {
+		return NonNegative{}, err
+	}
+
+	var out NonNegative
+	if out.Comm, err = ec.EncodePoint(rp.Comm); err != nil {
+		return NonNegative{}, err
+	}
+	if out.Range, err = ec.EncodeRangeProof(rp); err != nil {
+		return NonNegative{}, err
+	}
+	if out.Proof, err = ec.EncodeBalanceProof(proof); err != nil {
+		return NonNegative{}, err
+	}
+	return out, nil
+}
+
+// opens reports whether C2 - x*C1 == value*G.
+func opens(c1, c2 bullet.ECPoint, x *big.Int, value int64) bool {
+	ec := params()
+	xc1 := ec.Mult(c1, x)
+	if value == 0 {
+		return xc1.Equal(c2)
+	}
+	return ec.Add(xc1, ec.Mult(basePoint(), big.NewInt(value))).Equal(c2)
+}
+
+// parseCipher splits a HomoEncrypt ciphertext into its two points.
+func parseCipher(cipher []byte) (bullet.ECPoint, bullet.ECPoint, error) {
+	if len(cipher) != cipherLen {
+		return bullet.ECPoint{}, bullet.ECPoint{}, fmt.Errorf("%w: ciphertext length %d", ErrBadPosting, len(cipher))
+	}
+	curve := sm2.GetSm2P256V1()
+	x1, y1 := elliptic.Unmarshal(curve, cipher[:pointLen])
+	x2, y2 := elliptic.Unmarshal(curve, cipher[pointLen:])
+	if x1 == nil || x2 == nil {
+		return bullet.ECPoint{}, bullet.ECPoint{}, fmt.Errorf("%w: ciphertext point not on curve", ErrBadPosting)
+	}
+	return bullet.ECPoint{X: x1, Y: y1}, bullet.ECPoint{X: x2, Y: y2}, nil
+}
+
+func marshalCipher(c1, c2 bullet.ECPoint) []byte {
+	curve := sm2.GetSm2P256V1()
+	return append(elliptic.Marshal(curve, c1.X, c1.Y), elliptic.Marshal(curve, c2.X, c2.Y)...)
+}
+
+func publicPoint(pub *sm2.PublicKey) (bullet.ECPoint, error) {
+	if pub == nil || pub.X == nil || pub.Y == nil {
+		return bullet.ECPoint{}, fmt.Errorf("%w: missing public key", ErrBadKey)
+	}
+	p := bullet.ECPoint{X: pub.X, Y: pub.Y}
+	if p.IsZero() || !sm2.GetSm2P256V1().IsOnCurve(p.X, p.Y) {
+		return bullet.ECPoint{}, fmt.Errorf("%w: public key not on curve", ErrBadKey)
+	}
+	return p, nil
+}
+
+func randScalar() (*big.Int, error) {
+	N := params().N
+	for {
+		a, err := rand.Int(rand.Reader, N)
+		if err != nil {
+			return nil, err
+		}
+		if a.Sign() != 0 {
+			return a, nil
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package keyswitch
+
+import (
+	"fmt"
+	"math/big"
+
+	bullet "server/bulletproof/src"
+)
+
+// proofLen is the encoded length of a Proof: three compressed points and
+// two scalars.
+const proofLen = 3*bullet.PointSize + 2*bullet.ScalarSize
+
+// Bytes returns the fixed length encoding R1 || R2 || R3 || S1 || S2.
+func (p Proof) Bytes() ([]byte, error) {
+	ec := group()
+	out := make([]byte, 0, proofLen)
+	for _, pt := range []bullet.ECPoint{p.R1, p.R2, p.R3} {
+		b, err := ec.EncodePoint(pt)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadProof, err)
+		}
+		out = append(out, b...)
+	}
+	for _, s := range []*big.Int{p.S1, p.S2} {
+		b, err := ec.EncodeScalar(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadProof, err)
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// ParseProof parses a proof written by Proof.Bytes. Trailing or missing
+// bytes and non-canonical points or scalars are rejected.
+func ParseProof(b []byte) (Proof, error) {
+	if len(b) != proofLen {
+		return Proof{}, fmt.Errorf("%w: proof is %d bytes", ErrBadProof, len(b))
+	}
+	ec := group()
+	var pts [3]bullet.ECPoint
+	for i := range pts {
+		p, err := ec.DecodePoint(b[:bullet.PointSize])
+		if err != nil {
+			return Proof{}, fmt.Errorf("%w: %v", ErrBadProof, err)
+		}
+		pts[i], b = p, b[bullet.PointSize:]
+	}
+	s1, err := ec.DecodeScalar(b[:bullet.ScalarSize])
+	if err != nil {
+		return Proof{}, fmt.Errorf("%w: %v", ErrBadProof, err)
+	}
+	s2, err := ec.DecodeScalar(b[bullet.ScalarSize:])
+	if err != nil {
+		return Proof{}, fmt.Errorf("%w: %v", ErrBadProof, err)
+	}
+	return Proof{pts[0], pts[1], pts[2], s1, s2}, nil
+}
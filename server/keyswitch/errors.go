@@ -0,0 +1,17 @@
+package keyswitch
+
+import "errors"
+
+var (
+	// ErrMalformedCiphertext is returned for a ciphertext that is not two
+	// uncompressed points of the curve.
+	ErrMalformedCiphertext = errors.New("keyswitch: malformed ciphertext")
+
+	// ErrBadKey is returned for a public key that is missing or not a
+	// point of the curve, or a private key out of range.
+	ErrBadKey = errors.New("keyswitch: bad key")
+
+	// ErrBadProof is returned when a key-switch or re-randomisation proof
+	// is malformed or does not verify.
+	ErrBadProof = errors.New("keyswitch: invalid proof")
+)
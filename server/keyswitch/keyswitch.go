@@ -0,0 +1,323 @@
+/*
+Package keyswitch moves SM2 additive ElGamal ciphertexts, as made by
+utils.HomoEncrypt, between keys with a proof of correctness, so one
+encrypted price can be handed from the seller to the buyer instead of being
+encrypted twice with nothing tying the two together.
+
+A ciphertext of m under P = x*G is (C1, C2) = (k*G, m*G + k*P). The holder
+of x switches it to the key Q by stripping its own mask and adding a fresh
+one:
+
+	C1' = k'*G
+	C2' = C2 - x*C1 + k'*Q = m*G + k'*Q
+
+and proves in zero knowledge that it knows x and k' with P = x*G,
+C1' = k'*G and C2' - C2 = k'*Q - x*C1, which holds exactly when both
+ciphertexts encrypt the same m. Anyone with the two public keys can check
+the proof; nobody learns m.
+
+Rerandomize is the keyless variant: it adds an encryption of zero under the
+same key and proves it, which unlinks a ciphertext from earlier copies.
+*/
+package keyswitch
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	bullet "server/bulletproof/src"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// pointLen is the length of an uncompressed SM2 point; a ciphertext is
+// C1 || C2.
+const (
+	pointLen  = 1 + 2*32
+	cipherLen = 2 * pointLen
+)
+
+/*
+Proof shows that a key switch is correct. R1, R2 and R3 commit to the
+random a and b and S1 = a + c*x, S2 = b + c*k'.
+*/
+type Proof struct {
+	R1 bullet.ECPoint // a*G
+	R2 bullet.ECPoint // b*G
+	R3 bullet.ECPoint // b*Q - a*C1
+	S1 *big.Int
+	S2 *big.Int
+}
+
+// RerandProof is a Chaum-Pedersen proof that a re-randomised ciphertext
+// differs from the original by (r*G, r*P).
+type RerandProof struct {
+	R1 bullet.ECPoint // a*G
+	R2 bullet.ECPoint // a*P
+	S  *big.Int       // a + c*r
+}
+
+func group() *bullet.CryptoParams {
+	return bullet.SM2Params(1)
+}
+
+func basePoint() bullet.ECPoint {
+	params := sm2.GetSm2P256V1().Params()
+	return bullet.ECPoint{X: params.Gx, Y: params.Gy}
+}
+
+/*
+Switch re-encrypts cipher, which must be under priv's key, to the key to,
+and proves it. context is bound into the proof, so a switch made for one
+order cannot be presented for another.
+*/
+func Switch(priv *sm2.PrivateKey, to *sm2.PublicKey, cipher []byte, context []byte) ([]byte, Proof, error) {
+	c1, c2, err := parseCipher(cipher)
+	if err != nil {
+		return nil, Proof{}, err
+	}
+	P, err := publicPoint(nil, priv)
+	if err != nil {
+		return nil, Proof{}, err
+	}
+	Q, err := publicPoint(to, nil)
+	if err != nil {
+		return nil, Proof{}, err
+	}
+
+	ec := group()
+	G := basePoint()
+	x := priv.D
+	k, err := randScalar()
+	if err != nil {
+		return nil, Proof{}, err
+	}
+	c1n := ec.Mult(G, k)
+	c2n := ec.Add(c2, ec.Neg(ec.Mult(c1, x)), ec.Mult(Q, k))
+
+	a, err := randScalar()
+	if err != nil {
+		return nil, Proof{}, err
+	}
+	b, err := randScalar()
+	if err != nil {
+		return nil, Proof{}, err
+	}
+	R1 := ec.Mult(G, a)
+	R2 := ec.Mult(G, b)
+	R3 := ec.Add(ec.Mult(Q, b), ec.Neg(ec.Mult(c1, a)))
+
+	c := switchChallenge(P, Q, c1, c2, c1n, c2n, R1, R2, R3, context)
+	s1 := new(big.Int).Mod(new(big.Int).Add(a, new(big.Int).Mul(c, x)), ec.N)
+	s2 := new(big.Int).Mod(new(big.Int).Add(b, new(big.Int).Mul(c, k)), ec.N)
+
+	return marshalCipher(c1n, c2n), Proof{R1, R2, R3, s1, s2}, nil
+}
+
+// Verify checks that switched encrypts under to what cipher encrypts under
+// from.
+func Verify(from, to *sm2.PublicKey, cipher, switched []byte, proof Proof, context []byte) error {
+	c1, c2, err := parseCipher(cipher)
+	if err != nil {
+		return err
+	}
+	c1n, c2n, err := parseCipher(switched)
+	if err != nil {
+		return err
+	}
+	P, err := publicPoint(from, nil)
+	if err != nil {
+		return err
+	}
+	Q, err := publicPoint(to, nil)
+	if err != nil {
+		return err
+	}
+	if !onCurve(proof.R1, proof.R2, proof.R3) || !validScalar(proof.S1) || !validScalar(proof.S2) {
+		return fmt.Errorf("%w: malformed proof", ErrBadProof)
+	}
+
+	ec := group()
+	G := basePoint()
+	c := switchChallenge(P, Q, c1, c2, c1n, c2n, proof.R1, proof.R2, proof.R3, context)
+	D := ec.Add(c2n, ec.Neg(c2))
+	// s1*G == R1 + c*P, s2*G == R2 + c*C1', s2*Q - s1*C1 == R3 + c*(C2' - C2)
+	if !ec.Mult(G, proof.S1).Equal(ec.Add(proof.R1, ec.Mult(P, c))) ||
+		!ec.Mult(G, proof.S2).Equal(ec.Add(proof.R2, ec.Mult(c1n, c))) ||
+		!ec.Add(ec.Mult(Q, proof.S2), ec.Neg(ec.Mult(c1, proof.S1))).Equal(ec.Add(proof.R3, ec.Mult(D, c))) {
+		return ErrBadProof
+	}
+	return nil
+}
+
+// Rerandomize returns cipher plus a fresh encryption of zero under pub,
+// and a proof that nothing else was added.
+func Rerandomize(pub *sm2.PublicKey, cipher []byte, context []byte) ([]byte, RerandProof, error) {
+	c1, c2, err := parseCipher(cipher)
+	if err != nil {
+		return nil, RerandProof{}, err
+	}
+	P, err := publicPoint(pub, nil)
+	if err != nil {
+		return nil, RerandProof{}, err
+	}
+	ec := group()
+	G := basePoint()
+	r, err := randScalar()
+	if err != nil {
+		return nil, RerandProof{}, err
+	}
+	c1n := ec.Add(c1, ec.Mult(G, r))
+	c2n := ec.Add(c2, ec.Mult(P, r))
+
+	a, err := randScalar()
+	if err != nil {
+		return nil, RerandProof{}, err
+	}
+	R1 := ec.Mult(G, a)
+	R2 := ec.Mult(P, a)
+	c := rerandChallenge(P, c1, c2, c1n, c2n, R1, R2, context)
+	s := new(big.Int).Mod(new(big.Int).Add(a, new(big.Int).Mul(c, r)), ec.N)
+	return marshalCipher(c1n, c2n), RerandProof{R1, R2, s}, nil
+}
+
+// VerifyRerandomize checks that rerand is cipher re-randomised under pub.
+func VerifyRerandomize(pub *sm2.PublicKey, cipher, rerand []byte, proof RerandProof, context []byte) error {
+	c1, c2, err := parseCipher(cipher)
+	if err != nil {
+		return err
+	}
+	c1n, c2n, err := parseCipher(rerand)
+	if err != nil {
+		return err
+	}
+	P, err := publicPoint(pub, nil)
+	if err != nil {
+		return err
+	}
+	if !onCurve(proof.R1, proof.R2) || !validScalar(proof.S) {
+		return fmt.Errorf("%w: malformed proof", ErrBadProof)
+	}
+	ec := group()
+	G := basePoint()
+	c := rerandChallenge(P, c1, c2, c1n, c2n, proof.R1, proof.R2, context)
+	// s*G == R1 + c*(C1' - C1), s*P == R2 + c*(C2' - C2)
+	if !ec.Mult(G, proof.S).Equal(ec.Add(proof.R1, ec.Mult(ec.Add(c1n, ec.Neg(c1)), c))) ||
+		!ec.Mult(P, proof.S).Equal(ec.Add(proof.R2, ec.Mult(ec.Add(c2n, ec.Neg(c2)), c))) {
+		return ErrBadProof
+	}
+	return nil
+}
+
+/*
+Opens reports whether cipher encrypts m under priv's key. It checks
+C2 - x*C1 == m*G directly, so unlike utils.HomoDecrypt it needs no discrete
+log search and works for any m.
+*/
+func Opens(priv *sm2.PrivateKey, cipher []byte, m *big.Int) (bool, error) {
+	c1, c2, err := parseCipher(cipher)
+	if err != nil {
+		return false, err
+	}
+	ec := group()
+	mG := ec.Add(c2, ec.Neg(ec.Mult(c1, priv.D)))
+	return mG.Equal(ec.Mult(basePoint(), m)), nil
+}
+
+func switchChallenge(P, Q, c1, c2, c1n, c2n, R1, R2, R3 bullet.ECPoint, context []byte) *big.Int {
+	t := bullet.NewTranscript("keyswitch/switch")
+	t.AppendMessage("curve", []byte(sm2.GetSm2P256V1().Params().Name))
+	t.AppendPoint("G", basePoint())
+	t.AppendPoint("P", P)
+	t.AppendPoint("Q", Q)
+	t.AppendPoint("C1", c1)
+	t.AppendPoint("C2", c2)
+	t.AppendPoint("C1'", c1n)
+	t.AppendPoint("C2'", c2n)
+	t.AppendMessage("context", context)
+	t.AppendPoint("R1", R1)
+	t.AppendPoint("R2", R2)
+	t.AppendPoint("R3", R3)
+	return t.ChallengeScalar("c", group().N)
+}
+
+func rerandChallenge(P, c1, c2, c1n, c2n, R1, R2 bullet.ECPoint, context []byte) *big.Int {
+	t := bullet.NewTranscript("keyswitch/rerandomize")
+	t.AppendMessage("curve", []byte(sm2.GetSm2P256V1().Params().Name))
+	t.AppendPoint("G", basePoint())
+	t.AppendPoint("P", P)
+	t.AppendPoint("C1", c1)
+	t.AppendPoint("C2", c2)
+	t.AppendPoint("C1'", c1n)
+	t.AppendPoint("C2'", c2n)
+	t.AppendMessage("context", context)
+	t.AppendPoint("R1", R1)
+	t.AppendPoint("R2", R2)
+	return t.ChallengeScalar("c", group().N)
+}
+
+// parseCipher splits a HomoEncrypt ciphertext into its two points.
+func parseCipher(cipher []byte) (bullet.ECPoint, bullet.ECPoint, error) {
+	if len(cipher) != cipherLen {
+		return bullet.ECPoint{}, bullet.ECPoint{}, fmt.Errorf("%w: length %d", ErrMalformedCiphertext, len(cipher))
+	}
+	curve := sm2.GetSm2P256V1()
+	x1, y1 := elliptic.Unmarshal(curve, cipher[:pointLen])
+	x2, y2 := elliptic.Unmarshal(curve, cipher[pointLen:])
+	if x1 == nil || x2 == nil {
+		return bullet.ECPoint{}, bullet.ECPoint{}, fmt.Errorf("%w: point not on curve", ErrMalformedCiphertext)
+	}
+	return bullet.ECPoint{X: x1, Y: y1}, bullet.ECPoint{X: x2, Y: y2}, nil
+}
+
+func marshalCipher(c1, c2 bullet.ECPoint) []byte {
+	curve := sm2.GetSm2P256V1()
+	return append(elliptic.Marshal(curve, c1.X, c1.Y), elliptic.Marshal(curve, c2.X, c2.Y)...)
+}
+
+// publicPoint returns the point of pub, or of priv if it is given.
+func publicPoint(pub *sm2.PublicKey, priv *sm2.PrivateKey) (bullet.ECPoint, error) {
+	if priv != nil {
+		if !validScalar(priv.D) || priv.D.Sign() == 0 {
+			return bullet.ECPoint{}, fmt.Errorf("%w: private key out of range", ErrBadKey)
+		}
+		return group().Mult(basePoint(), priv.D), nil
+	}
+	if pub == nil {
+		return bullet.ECPoint{}, fmt.Errorf("%w: missing public key", ErrBadKey)
+	}
+	p := bullet.ECPoint{X: pub.X, Y: pub.Y}
+	if !onCurve(p) || p.IsZero() {
+		return bullet.ECPoint{}, fmt.Errorf("%w: public key not on curve", ErrBadKey)
+	}
+	return p, nil
+}
+
+func onCurve(ps ...bullet.ECPoint) bool {
+	curve := sm2.GetSm2P256V1()
+	for _, p := range ps {
+		if p.X == nil || p.Y == nil || !curve.IsOnCurve(p.X, p.Y) {
+			return false
+		}
+	}
+	return true
+}
+
+func validScalar(s *big.Int) bool {
+	return s != nil && s.Sign() >= 0 && s.Cmp(group().N) < 0
+}
+
+func randScalar() (*big.Int, error) {
+	N := group().N
+	for {
+		a, err := rand.Int(rand.Reader, N)
+		if err != nil {
+			return nil, err
+		}
+		if a.Sign() != 0 {
+			return a, nil
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package keyswitch
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"server/utils"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+func keyPair(t *testing.T) (*sm2.PrivateKey, *sm2.PublicKey) {
+	pri, pub, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pri, pub
+}
+
+func TestSwitchSellerToBuyer(t *testing.T) {
+	priB, pubB := keyPair(t)
+	priA, pubA := keyPair(t)
+	price := big.NewInt(1234)
+	cipher, err := utils.HomoEncrypt(pubB, price.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	context := []byte("order-1")
+	switched, proof, err := Switch(priB, pubA, cipher, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(pubB, pubA, cipher, switched, proof, context); err != nil {
+		t.Fatal("*****Key switch proof FAILURE:", err)
+	}
+	if ok, _ := Opens(priA, switched, price); !ok {
+		t.Error("*****Switched ciphertext does not open to the price under the buyer key")
+	}
+	if ok, _ := Opens(priB, switched, price); ok {
+		t.Error("*****Switched ciphertext still opens under the seller key")
+	}
+
+	// the switched ciphertext keeps working with the homomorphic wallet
+	m, err := utils.HomoDecrypt(priA, switched)
+	if err != nil || new(big.Int).SetBytes(m).Cmp(price) != 0 {
+		t.Error("*****HomoDecrypt of a switched ciphertext FAILURE", err)
+	}
+	fmt.Println("Key switch from seller to buyer works")
+}
+
+func TestSwitchRejectsWrongStatements(t *testing.T) {
+	priB, pubB := keyPair(t)
+	_, pubA := keyPair(t)
+	_, pubC := keyPair(t)
+	cipher, _ := utils.HomoEncrypt(pubB, big.NewInt(50).Bytes())
+	context := []byte("order-1")
+	switched, proof, err := Switch(priB, pubA, cipher, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := utils.HomoEncrypt(pubA, big.NewInt(51).Bytes())
+	cases := []struct {
+		name     string
+		from, to *sm2.PublicKey
+		switched []byte
+		context  []byte
+	}{
+		{"other plaintext", pubB, pubA, other, context},
+		{"other receiver", pubB, pubC, switched, context},
+		{"other sender", pubC, pubA, switched, context},
+		{"other order", pubB, pubA, switched, []byte("order-2")},
+	}
+	for _, tc := range cases {
+		if err := Verify(tc.from, tc.to, cipher, tc.switched, proof, tc.context); !errors.Is(err, ErrBadProof) {
+			t.Error("*****Key switch verified with", tc.name, err)
+		}
+	}
+
+	bad := proof
+	bad.S1 = new(big.Int).Add(proof.S1, big.NewInt(1))
+	if err := Verify(pubB, pubA, cipher, switched, bad, context); !errors.Is(err, ErrBadProof) {
+		t.Error("*****Tampered proof verified:", err)
+	}
+	if err := Verify(pubB, pubA, cipher[1:], switched, proof, context); !errors.Is(err, ErrMalformedCiphertext) {
+		t.Error("*****Truncated ciphertext accepted:", err)
+	}
+}
+
+func TestRerandomize(t *testing.T) {
+	pri, pub := keyPair(t)
+	_, other := keyPair(t)
+	cipher, _ := utils.HomoEncrypt(pub, big.NewInt(9).Bytes())
+	context := []byte("order-1")
+
+	rerand, proof, err := Rerandomize(pub, cipher, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rerand) == string(cipher) {
+		t.Fatal("*****Re-randomised ciphertext is unchanged")
+	}
+	if err := VerifyRerandomize(pub, cipher, rerand, proof, context); err != nil {
+		t.Error("*****Re-randomisation proof FAILURE:", err)
+	}
+	if ok, _ := Opens(pri, rerand, big.NewInt(9)); !ok {
+		t.Error("*****Re-randomisation changed the plaintext")
+	}
+	if err := VerifyRerandomize(other, cipher, rerand, proof, context); !errors.Is(err, ErrBadProof) {
+		t.Error("*****Re-randomisation verified under another key:", err)
+	}
+	shifted, _ := utils.CiperAdd(sm2.GetSm2P256V1(), rerand, mustEncrypt(t, pub, 1))
+	if err := VerifyRerandomize(pub, cipher, shifted, proof, context); !errors.Is(err, ErrBadProof) {
+		t.Error("*****Re-randomisation that changes the plaintext verified:", err)
+	}
+}
+
+func TestProofEncoding(t *testing.T) {
+	priB, pubB := keyPair(t)
+	_, pubA := keyPair(t)
+	cipher := mustEncrypt(t, pubB, 7)
+	switched, proof, err := Switch(priB, pubA, cipher, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := proof.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseProof(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(pubB, pubA, cipher, switched, parsed, nil); err != nil {
+		t.Error("*****Decoded proof does not verify:", err)
+	}
+	if _, err := ParseProof(append(b, 0)); !errors.Is(err, ErrBadProof) {
+		t.Error("*****Trailing bytes accepted:", err)
+	}
+	b[0] = 0x05
+	if _, err := ParseProof(b); !errors.Is(err, ErrBadProof) {
+		t.Error("*****Bad point prefix accepted:", err)
+	}
+}
+
+func mustEncrypt(t *testing.T, pub *sm2.PublicKey, m int64) []byte {
+	c, err := utils.HomoEncrypt(pub, big.NewInt(m).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
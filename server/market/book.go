@@ -0,0 +1,317 @@
+/*
+Package market is a continuous double auction for energy delivered in one
+settlement period of one grid zone.
+
+Bids and asks rest in a book per (period, zone) and are matched with
+price-time priority: an incoming order trades against the best opposite
+price first and, at one price, against the order that arrived first. Trades
+happen at the resting order's price, an order may be filled in parts, and
+what is left of it rests in the book. Orders of the same owner never trade
+with each other.
+
+Every event that changes the book, a submitted or a cancelled order, gives
+a Result whose Hash chains it to the previous one, so the sequence of
+events and fills can be anchored on chain and replayed. If the book has an
+Anchor, the event only takes effect once Anchor accepts its Result.
+
+Call is the periodic alternative: it collects the orders of a period and
+zone until the gate closes and clears them together at one price (see
+ClearOrders).
+*/
+package market
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// PeriodSeconds is the length of a settlement period, as in the chaincode;
+// period p delivers in [p*PeriodSeconds, (p+1)*PeriodSeconds).
+const PeriodSeconds = 900
+
+// Side of an order.
+type Side int
+
+const (
+	Bid Side = iota
+	Ask
+)
+
+func (s Side) String() string {
+	if s == Bid {
+		return "bid"
+	}
+	return "ask"
+}
+
+// Order is a bid or an ask for Quantity kWh at Price per kWh. Meter names
+// the meter an ask's energy comes from.
+type Order struct {
+	ID       string `json:"id"`
+	Owner    string `json:"owner"`
+	Side     Side   `json:"side"`
+	Price    int64  `json:"price"`
+	Quantity int64  `json:"quantity"`
+	Period   int64  `json:"period"`
+	Zone     string `json:"zone"`
+	Meter    string `json:"meter"`
+	Seq      uint64 `json:"seq"` // arrival order, set by the book
+}
+
+// Fill is one trade between a bid and an ask.
+type Fill struct {
+	Seq      uint64 `json:"seq"`
+	Period   int64  `json:"period"`
+	Zone     string `json:"zone"`
+	Price    int64  `json:"price"`
+	Quantity int64  `json:"quantity"`
+	Bid      string `json:"bid"`
+	Ask      string `json:"ask"`
+	Buyer    string `json:"buyer"`
+	Seller   string `json:"seller"`
+	Meter    string `json:"meter"`
+}
+
+// Notional returns what the buyer pays for f.
+func (f Fill) Notional() int64 {
+	return f.Price * f.Quantity
+}
+
+type key struct {
+	period int64
+	zone   string
+}
+
+// ladder holds the resting orders of one period and zone, bids by price
+// descending and asks by price ascending, each then by arrival.
+type ladder struct {
+	bids, asks []*Order
+}
+
+func (l *ladder) side(s Side) *[]*Order {
+	if s == Bid {
+		return &l.bids
+	}
+	return &l.asks
+}
+
+// Book is an order book over all periods and zones. It is safe for
+// concurrent use.
+type Book struct {
+	// Anchor, if set, is called with the Result of every event before the
+	// event changes the book; an error leaves the book as it was.
+	Anchor func(Result) error
+
+	mu      sync.Mutex
+	seq     uint64
+	head    []byte
+	ladders map[key]*ladder
+	resting map[string]*Order
+	ids     map[string]bool
+}
+
+// NewBook returns an empty book.
+func NewBook() *Book {
+	return &Book{
+		head:    genesis(),
+		ladders: make(map[key]*ladder),
+		resting: make(map[string]*Order),
+		ids:     make(map[string]bool),
+	}
+}
+
+// Head returns the hash of the last event.
+func (b *Book) Head() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.head...)
+}
+
+/*
+Submit matches o against the book at time now (Unix seconds) and rests what
+is left of it. It returns the event's Result with the fills in the order
+they were made.
+*/
+func (b *Book) Submit(o Order, now int64) (Result, error) {
+	if o.ID == "" || o.Owner == "" || o.Zone == "" || o.Price <= 0 || o.Quantity <= 0 || o.Period < 0 ||
+		(o.Side != Bid && o.Side != Ask) || (o.Side == Ask && o.Meter == "") {
+		return Result{}, ErrBadOrder
+	}
+	if o.Period*PeriodSeconds <= now {
+		return Result{}, fmt.Errorf("%w: period %d at %d", ErrPeriodClosed, o.Period, now)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ids[o.ID] {
+		return Result{}, fmt.Errorf("%w: order %s already submitted", ErrBadOrder, o.ID)
+	}
+	o.Seq = b.seq + 1
+	if o.Side == Bid {
+		o.Meter = ""
+	}
+	l := b.ladders[key{o.Period, o.Zone}]
+	if l == nil {
+		l = &ladder{}
+	}
+	fills := b.match(l, o)
+	r := Result{Event: EventSubmit, Order: o, Fills: fills, Prev: b.head}
+	r.Hash = r.hash()
+	if b.Anchor != nil {
+		if err := b.Anchor(r); err != nil {
+			return Result{}, err
+		}
+	}
+	b.apply(l, o, fills)
+	b.ladders[key{o.Period, o.Zone}] = l
+	b.ids[o.ID] = true
+	b.seq = o.Seq + uint64(len(fills))
+	b.head = r.Hash
+	return r, nil
+}
+
+// Cancel removes the resting order id from the book.
+func (b *Book) Cancel(id string) (Result, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	o, ok := b.resting[id]
+	if !ok {
+		return Result{}, fmt.Errorf("%w: %s", ErrUnknownOrder, id)
+	}
+	r := Result{Event: EventCancel, Order: *o, Prev: b.head}
+	r.Hash = r.hash()
+	if b.Anchor != nil {
+		if err := b.Anchor(r); err != nil {
+			return Result{}, err
+		}
+	}
+	k := key{o.Period, o.Zone}
+	side := b.ladders[k].side(o.Side)
+	for i, v := range *side {
+		if v == o {
+			*side = append((*side)[:i], (*side)[i+1:]...)
+			break
+		}
+	}
+	if l := b.ladders[k]; len(l.bids) == 0 && len(l.asks) == 0 {
+		delete(b.ladders, k)
+	}
+	delete(b.resting, id)
+	b.head = r.Hash
+	return r, nil
+}
+
+// Depth returns copies of the resting bids and asks of a period and zone
+// in priority order.
+func (b *Book) Depth(period int64, zone string) (bids, asks []Order) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l := b.ladders[key{period, zone}]
+	if l == nil {
+		return nil, nil
+	}
+	for _, o := range l.bids {
+		bids = append(bids, *o)
+	}
+	for _, o := range l.asks {
+		asks = append(asks, *o)
+	}
+	return bids, asks
+}
+
+// match returns the fills of o against l without changing l.
+func (b *Book) match(l *ladder, o Order) []Fill {
+	var fills []Fill
+	left := o.Quantity
+	opposite := l.asks
+	if o.Side == Ask {
+		opposite = l.bids
+	}
+	for _, r := range opposite {
+		if left == 0 || !crosses(o, r) {
+			break
+		}
+		if r.Owner == o.Owner {
+			continue
+		}
+		q := r.Quantity
+		if left < q {
+			q = left
+		}
+		f := Fill{
+			Seq:      o.Seq + uint64(len(fills)) + 1,
+			Period:   o.Period,
+			Zone:     o.Zone,
+			Price:    r.Price,
+			Quantity: q,
+		}
+		bid, ask := o, *r
+		if o.Side == Ask {
+			bid, ask = ask, bid
+		}
+		f.Bid, f.Buyer = bid.ID, bid.Owner
+		f.Ask, f.Seller, f.Meter = ask.ID, ask.Owner, ask.Meter
+		fills = append(fills, f)
+		left -= q
+	}
+	return fills
+}
+
+// apply takes the fills out of l and rests what is left of o.
+func (b *Book) apply(l *ladder, o Order, fills []Fill) {
+	left := o.Quantity
+	opposite := l.side(Ask)
+	if o.Side == Ask {
+		opposite = l.side(Bid)
+	}
+	filled := make(map[string]int64)
+	for _, f := range fills {
+		id := f.Ask
+		if o.Side == Ask {
+			id = f.Bid
+		}
+		filled[id] += f.Quantity
+		left -= f.Quantity
+	}
+	kept := (*opposite)[:0]
+	for _, r := range *opposite {
+		r.Quantity -= filled[r.ID]
+		if r.Quantity > 0 {
+			kept = append(kept, r)
+		} else {
+			delete(b.resting, r.ID)
+		}
+	}
+	*opposite = kept
+	if left == 0 {
+		return
+	}
+	o.Quantity = left
+	rest := &o
+	own := l.side(o.Side)
+	i := sort.Search(len(*own), func(i int) bool { return before(rest, (*own)[i]) })
+	*own = append(*own, nil)
+	copy((*own)[i+1:], (*own)[i:])
+	(*own)[i] = rest
+	b.resting[o.ID] = rest
+}
+
+// crosses reports whether the incoming order o trades at the price of the
+// resting order r.
+func crosses(o Order, r *Order) bool {
+	if o.Side == Bid {
+		return o.Price >= r.Price
+	}
+	return o.Price <= r.Price
+}
+
+// before reports whether o has priority over r on the same side.
+func before(o, r *Order) bool {
+	if o.Price != r.Price {
+		if o.Side == Bid {
+			return o.Price > r.Price
+		}
+		return o.Price < r.Price
+	}
+	return o.Seq < r.Seq
+}
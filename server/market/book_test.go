@@ -0,0 +1,257 @@
+package market
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+const (
+	period = 1908000 // 2024-05-31 00:00 UTC / PeriodSeconds
+	now    = period*PeriodSeconds - 3600
+)
+
+func bid(id, owner string, price, qty int64) Order {
+	return Order{ID: id, Owner: owner, Side: Bid, Price: price, Quantity: qty, Period: period, Zone: "north"}
+}
+
+func ask(id, owner string, price, qty int64) Order {
+	return Order{ID: id, Owner: owner, Side: Ask, Price: price, Quantity: qty, Period: period, Zone: "north", Meter: "PV-" + owner}
+}
+
+func submit(t *testing.T, b *Book, o Order) Result {
+	r, err := b.Submit(o, now)
+	if err != nil {
+		t.Fatalf("*****Submit %s: %v", o.ID, err)
+	}
+	return r
+}
+
+func quantities(orders []Order) []int64 {
+	q := make([]int64, len(orders))
+	for i, o := range orders {
+		q[i] = o.Quantity
+	}
+	return q
+}
+
+func TestPriceTimePriority(t *testing.T) {
+	b := NewBook()
+	submit(t, b, ask("a1", "Bob", 52, 10))
+	submit(t, b, ask("a2", "Carol", 50, 10))
+	submit(t, b, ask("a3", "Dave", 50, 10))
+	submit(t, b, ask("a4", "Erin", 55, 10))
+
+	// the best price first, and the earlier of a2 and a3 at 50
+	r := submit(t, b, bid("b1", "Alice", 52, 25))
+	want := []struct {
+		ask   string
+		price int64
+		qty   int64
+	}{{"a2", 50, 10}, {"a3", 50, 10}, {"a1", 52, 5}}
+	if len(r.Fills) != len(want) {
+		t.Fatalf("*****%d fills, want %d", len(r.Fills), len(want))
+	}
+	for i, w := range want {
+		f := r.Fills[i]
+		if f.Ask != w.ask || f.Price != w.price || f.Quantity != w.qty || f.Bid != "b1" || f.Buyer != "Alice" {
+			t.Errorf("*****Fill %d = %+v, want %s %d@%d", i, f, w.ask, w.qty, w.price)
+		}
+	}
+	bids, asks := b.Depth(period, "north")
+	if len(bids) != 0 || !reflect.DeepEqual(quantities(asks), []int64{5, 10}) || asks[0].ID != "a1" || asks[1].ID != "a4" {
+		t.Errorf("*****Depth after the bid: bids %v asks %v", bids, asks)
+	}
+}
+
+func TestRestingAndPartialFills(t *testing.T) {
+	b := NewBook()
+	submit(t, b, bid("b1", "Alice", 48, 10))
+	submit(t, b, bid("b2", "Carol", 49, 5))
+	if r := submit(t, b, ask("a1", "Bob", 50, 8)); len(r.Fills) != 0 {
+		t.Fatalf("*****Ask above every bid traded: %+v", r.Fills)
+	}
+	// a sell at 48 trades at the resting prices, best bid first
+	r := submit(t, b, ask("a2", "Dave", 48, 12))
+	if len(r.Fills) != 2 || r.Fills[0].Bid != "b2" || r.Fills[0].Price != 49 || r.Fills[0].Quantity != 5 ||
+		r.Fills[1].Bid != "b1" || r.Fills[1].Price != 48 || r.Fills[1].Quantity != 7 {
+		t.Fatalf("*****Fills %+v", r.Fills)
+	}
+	if r.Fills[0].Seller != "Dave" || r.Fills[0].Meter != "PV-Dave" {
+		t.Errorf("*****Seller side of the fill: %+v", r.Fills[0])
+	}
+	bids, asks := b.Depth(period, "north")
+	if !reflect.DeepEqual(quantities(bids), []int64{3}) || !reflect.DeepEqual(quantities(asks), []int64{8}) {
+		t.Errorf("*****Depth: bids %v asks %v", quantities(bids), quantities(asks))
+	}
+}
+
+func TestBooksAreSeparate(t *testing.T) {
+	b := NewBook()
+	submit(t, b, ask("a1", "Bob", 50, 10))
+	other := bid("b1", "Alice", 60, 10)
+	other.Zone = "south"
+	if r := submit(t, b, other); len(r.Fills) != 0 {
+		t.Error("*****Orders of different zones traded")
+	}
+	later := bid("b2", "Alice", 60, 10)
+	later.Period++
+	if r := submit(t, b, later); len(r.Fills) != 0 {
+		t.Error("*****Orders of different periods traded")
+	}
+}
+
+func TestNoSelfTrade(t *testing.T) {
+	b := NewBook()
+	submit(t, b, ask("a1", "Bob", 50, 10))
+	submit(t, b, ask("a2", "Carol", 51, 10))
+	r := submit(t, b, bid("b1", "Bob", 55, 10))
+	if len(r.Fills) != 1 || r.Fills[0].Ask != "a2" {
+		t.Fatalf("*****Fills %+v, want only a2", r.Fills)
+	}
+	_, asks := b.Depth(period, "north")
+	if len(asks) != 1 || asks[0].ID != "a1" {
+		t.Errorf("*****Own ask lost its place: %+v", asks)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	b := NewBook()
+	submit(t, b, ask("a1", "Bob", 50, 10))
+	submit(t, b, ask("a2", "Carol", 50, 10))
+	if _, err := b.Cancel("a1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Cancel("a1"); !errors.Is(err, ErrUnknownOrder) {
+		t.Errorf("*****Second cancel: got %v, want ErrUnknownOrder", err)
+	}
+	r := submit(t, b, bid("b1", "Alice", 50, 10))
+	if len(r.Fills) != 1 || r.Fills[0].Ask != "a2" {
+		t.Errorf("*****Cancelled order traded: %+v", r.Fills)
+	}
+	if _, err := b.Cancel("a2"); !errors.Is(err, ErrUnknownOrder) {
+		t.Errorf("*****Cancel of a filled order: got %v, want ErrUnknownOrder", err)
+	}
+}
+
+func TestRejectedOrders(t *testing.T) {
+	b := NewBook()
+	submit(t, b, bid("b1", "Alice", 50, 10))
+	noMeter := ask("a1", "Bob", 50, 10)
+	noMeter.Meter = ""
+	for _, o := range []Order{
+		bid("", "Alice", 50, 10),
+		bid("b2", "", 50, 10),
+		bid("b3", "Alice", 0, 10),
+		bid("b4", "Alice", 50, 0),
+		{ID: "b6", Owner: "Alice", Side: Bid, Price: 50, Quantity: 10, Period: period},
+		noMeter,
+		bid("b1", "Carol", 50, 10),
+	} {
+		if _, err := b.Submit(o, now); !errors.Is(err, ErrBadOrder) {
+			t.Errorf("*****Order %+v: got %v, want ErrBadOrder", o, err)
+		}
+	}
+	if _, err := b.Submit(bid("b5", "Alice", 50, 10), period*PeriodSeconds); !errors.Is(err, ErrPeriodClosed) {
+		t.Errorf("*****Order for a started period: got %v, want ErrPeriodClosed", err)
+	}
+}
+
+func TestAnchorFailureLeavesBook(t *testing.T) {
+	b := NewBook()
+	submit(t, b, ask("a1", "Bob", 50, 10))
+	head := b.Head()
+	b.Anchor = func(Result) error { return fmt.Errorf("ledger unavailable") }
+	if _, err := b.Submit(bid("b1", "Alice", 50, 4), now); err == nil {
+		t.Fatal("*****Submit succeeded without an anchor")
+	}
+	if _, err := b.Cancel("a1"); err == nil {
+		t.Fatal("*****Cancel succeeded without an anchor")
+	}
+	_, asks := b.Depth(period, "north")
+	if len(asks) != 1 || asks[0].Quantity != 10 || string(b.Head()) != string(head) {
+		t.Errorf("*****Book changed by an event that was not anchored")
+	}
+	var anchored []Result
+	b.Anchor = func(r Result) error { anchored = append(anchored, r); return nil }
+	// b1 was never accepted, so its ID is still free
+	r := submit(t, b, bid("b1", "Alice", 50, 4))
+	if len(anchored) != 1 || len(r.Fills) != 1 || string(anchored[0].Prev) != string(head) {
+		t.Errorf("*****Anchored %+v", anchored)
+	}
+}
+
+// randomOrders returns the same stream of orders for the same seed.
+func randomOrders(seed int64, n int) []Order {
+	rnd := rand.New(rand.NewSource(seed))
+	owners := []string{"Alice", "Bob", "Carol", "Dave"}
+	orders := make([]Order, n)
+	for i := range orders {
+		owner := owners[rnd.Intn(len(owners))]
+		price, qty := 40+rnd.Int63n(20), 1+rnd.Int63n(20)
+		if rnd.Intn(2) == 0 {
+			orders[i] = bid(fmt.Sprintf("o%d", i), owner, price, qty)
+		} else {
+			orders[i] = ask(fmt.Sprintf("o%d", i), owner, price, qty)
+		}
+	}
+	return orders
+}
+
+func TestReplayAndInvariants(t *testing.T) {
+	run := func() ([]Result, *Book) {
+		b := NewBook()
+		var results []Result
+		for _, o := range randomOrders(3, 400) {
+			results = append(results, submit(t, b, o))
+		}
+		return results, b
+	}
+	results, b := run()
+	again, _ := run()
+	if !reflect.DeepEqual(results, again) {
+		t.Fatal("*****The same orders gave different results")
+	}
+	if !Verify(results) {
+		t.Fatal("*****Hash chain does not verify")
+	}
+	changed := append([]Result(nil), results...)
+	for i, r := range changed {
+		if len(r.Fills) > 0 {
+			changed[i].Fills = append([]Fill(nil), r.Fills...)
+			changed[i].Fills[0].Quantity++
+			break
+		}
+	}
+	if Verify(changed) {
+		t.Error("*****Hash chain verified a changed fill")
+	}
+
+	// quantity is conserved and the book is never crossed
+	var submitted, filled, resting int64
+	for _, r := range results {
+		submitted += r.Order.Quantity
+		for _, f := range r.Fills {
+			filled += 2 * f.Quantity
+			if f.Buyer == f.Seller {
+				t.Fatalf("*****Self trade %+v", f)
+			}
+		}
+	}
+	bids, asks := b.Depth(period, "north")
+	for _, o := range append(bids, asks...) {
+		resting += o.Quantity
+	}
+	if submitted != filled+resting {
+		t.Errorf("*****Submitted %d kWh, filled %d and resting %d", submitted, filled, resting)
+	}
+	for _, bb := range bids {
+		for _, a := range asks {
+			if bb.Price >= a.Price && bb.Owner != a.Owner {
+				t.Fatalf("*****Crossed book: bid %+v ask %+v", bb, a)
+			}
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package market
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+/*
+CallGateSeconds is how long before delivery the gate of a call auction
+closes: orders for period p are taken until p*PeriodSeconds -
+CallGateSeconds, and the period is cleared between then and the start of
+delivery, so the fills can still be paid before delivery starts.
+*/
+const CallGateSeconds = PeriodSeconds
+
+// GateClosure returns the time at which a call auction stops taking orders
+// for period.
+func GateClosure(period int64) int64 {
+	return period*PeriodSeconds - CallGateSeconds
+}
+
+// Allocation is an order of a call auction and how much of it was filled
+// at the clearing price.
+type Allocation struct {
+	Order
+	Filled int64 `json:"filled"`
+}
+
+/*
+Clearing is the result of a call auction for one period and zone. Every
+filled bid pays Price and every filled ask receives Price, Volume kWh trade
+in all, and Allocations holds every order of the auction in arrival order,
+filled or not, so the clearing can be replayed from it. Fills pair the
+filled bids and asks for payment. Hash is the SM3 digest of the clearing.
+*/
+type Clearing struct {
+	Period      int64        `json:"period"`
+	Zone        string       `json:"zone"`
+	Price       int64        `json:"price"`
+	Volume      int64        `json:"volume"`
+	Allocations []Allocation `json:"allocations"`
+	Fills       []Fill       `json:"fills"`
+	Hash        []byte       `json:"hash"`
+}
+
+/*
+Call is a uniform-price call auction: it collects the bids and asks of each
+period and zone until the gate closes and then clears them all at once at a
+single price. It is safe for concurrent use.
+*/
+type Call struct {
+	// Publish, if set, is called with every Clearing before the orders are
+	// taken out of the auction; an error leaves the auction as it was.
+	Publish func(Clearing) error
+
+	mu      sync.Mutex
+	seq     uint64
+	orders  map[key][]Order
+	ids     map[string]bool
+	cleared map[key]bool
+}
+
+// NewCall returns an empty call auction.
+func NewCall() *Call {
+	return &Call{
+		orders:  make(map[key][]Order),
+		ids:     make(map[string]bool),
+		cleared: make(map[key]bool),
+	}
+}
+
+// Submit adds o to the auction of its period and zone at time now (Unix
+// seconds) and returns it with its arrival order set. An owner may either
+// buy or sell in one auction, not both.
+func (c *Call) Submit(o Order, now int64) (Order, error) {
+	if o.ID == "" || o.Owner == "" || o.Zone == "" || o.Price <= 0 || o.Quantity <= 0 || o.Period < 0 ||
+		(o.Side != Bid && o.Side != Ask) || (o.Side == Ask && o.Meter == "") {
+		return Order{}, ErrBadOrder
+	}
+	if now >= GateClosure(o.Period) {
+		return Order{}, fmt.Errorf("%w: period %d at %d", ErrGateClosed, o.Period, now)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := key{o.Period, o.Zone}
+	if c.ids[o.ID] {
+		return Order{}, fmt.Errorf("%w: order %s already submitted", ErrBadOrder, o.ID)
+	}
+	if c.cleared[k] {
+		return Order{}, fmt.Errorf("%w: period %d in %s already cleared", ErrGateClosed, o.Period, o.Zone)
+	}
+	for _, v := range c.orders[k] {
+		if v.Owner == o.Owner && v.Side != o.Side {
+			return Order{}, fmt.Errorf("%w: %s both buys and sells", ErrBadOrder, o.Owner)
+		}
+	}
+	if o.Side == Bid {
+		o.Meter = ""
+	}
+	c.seq++
+	o.Seq = c.seq
+	c.orders[k] = append(c.orders[k], o)
+	c.ids[o.ID] = true
+	return o, nil
+}
+
+// Orders returns copies of the orders of a period and zone in arrival
+// order.
+func (c *Call) Orders(period int64, zone string) []Order {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Order(nil), c.orders[key{period, zone}]...)
+}
+
+// Clear clears the auction of a period and zone at time now, which must be
+// after the gate has closed and before delivery starts. A period and zone
+// is cleared once.
+func (c *Call) Clear(period int64, zone string, now int64) (Clearing, error) {
+	if now < GateClosure(period) {
+		return Clearing{}, fmt.Errorf("%w: period %d at %d", ErrGateOpen, period, now)
+	}
+	if period*PeriodSeconds <= now {
+		return Clearing{}, fmt.Errorf("%w: period %d at %d", ErrPeriodClosed, period, now)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	k := key{period, zone}
+	if c.cleared[k] {
+		return Clearing{}, fmt.Errorf("%w: period %d in %s already cleared", ErrGateClosed, period, zone)
+	}
+	cl := ClearOrders(period, zone, c.orders[k])
+	if c.Publish != nil {
+		if err := c.Publish(cl); err != nil {
+			return Clearing{}, err
+		}
+	}
+	delete(c.orders, k)
+	c.cleared[k] = true
+	return cl, nil
+}
+
+/*
+ClearOrders clears orders, all of one period and zone, at a single price.
+It only depends on the orders and their arrival order, so anyone holding
+them can replay it.
+
+The clearing price is the order price at which the most energy trades; of
+those it is the one where demand and supply are closest, and of those the
+highest if demand still exceeds supply at every one of them, otherwise the
+lowest. Bids at or above the price and asks at or below it are filled in
+price order. When a price level can only be filled in part, every order at
+that level gets its pro-rata share rounded down, and the units left over go
+one each to the orders of the level in arrival order.
+*/
+func ClearOrders(period int64, zone string, orders []Order) Clearing {
+	cl := Clearing{Period: period, Zone: zone, Allocations: make([]Allocation, len(orders))}
+	sorted := append([]Order(nil), orders...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Seq < sorted[j].Seq })
+	var bids, asks []int
+	for i, o := range sorted {
+		cl.Allocations[i] = Allocation{Order: o}
+		if o.Side == Bid {
+			bids = append(bids, i)
+		} else {
+			asks = append(asks, i)
+		}
+	}
+	byPriority := func(idx []int) {
+		sort.SliceStable(idx, func(i, j int) bool {
+			a, b := sorted[idx[i]], sorted[idx[j]]
+			return before(&a, &b)
+		})
+	}
+	byPriority(bids)
+	byPriority(asks)
+
+	cl.Price, cl.Volume = clearingPrice(sorted)
+	if cl.Volume > 0 {
+		eligible := func(idx []int) []int {
+			var out []int
+			for _, i := range idx {
+				if o := sorted[i]; (o.Side == Bid && o.Price >= cl.Price) || (o.Side == Ask && o.Price <= cl.Price) {
+					out = append(out, i)
+				}
+			}
+			return out
+		}
+		bids, asks = eligible(bids), eligible(asks)
+		allocate(cl.Allocations, bids, cl.Volume)
+		allocate(cl.Allocations, asks, cl.Volume)
+		cl.Fills = pair(cl, bids, asks)
+	}
+	cl.Hash = cl.hash()
+	return cl
+}
+
+// VerifyClearing replays cl from its allocations and reports whether the
+// replay gives the same clearing.
+func VerifyClearing(cl Clearing) bool {
+	orders := make([]Order, len(cl.Allocations))
+	ids := make(map[string]bool)
+	for i, a := range cl.Allocations {
+		if a.Period != cl.Period || a.Zone != cl.Zone || ids[a.ID] || (i > 0 && a.Seq <= orders[i-1].Seq) {
+			return false
+		}
+		ids[a.ID] = true
+		orders[i] = a.Order
+	}
+	r := ClearOrders(cl.Period, cl.Zone, orders)
+	if string(r.Hash) != string(cl.Hash) || r.Price != cl.Price || r.Volume != cl.Volume ||
+		len(r.Allocations) != len(cl.Allocations) || len(r.Fills) != len(cl.Fills) {
+		return false
+	}
+	for i := range r.Allocations {
+		if r.Allocations[i] != cl.Allocations[i] {
+			return false
+		}
+	}
+	for i := range r.Fills {
+		if r.Fills[i] != cl.Fills[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// clearingPrice returns the clearing price and volume of orders, or zero
+// and zero when no bid crosses an ask.
+func clearingPrice(orders []Order) (int64, int64) {
+	var prices []int64
+	seen := make(map[int64]bool)
+	for _, o := range orders {
+		if !seen[o.Price] {
+			seen[o.Price] = true
+			prices = append(prices, o.Price)
+		}
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i] < prices[j] })
+
+	var volume, imbalance int64
+	var ties []int64
+	surplus := true // demand exceeds supply at every tied price
+	for _, p := range prices {
+		var demand, supply int64
+		for _, o := range orders {
+			if o.Side == Bid && o.Price >= p {
+				demand += o.Quantity
+			} else if o.Side == Ask && o.Price <= p {
+				supply += o.Quantity
+			}
+		}
+		v, d := demand, demand-supply
+		if supply < v {
+			v = supply
+		}
+		if d < 0 {
+			d = -d
+		}
+		switch {
+		case v == 0 || v < volume || (v == volume && d > imbalance):
+			continue
+		case v > volume || d < imbalance:
+			volume, imbalance, ties, surplus = v, d, nil, true
+		}
+		ties = append(ties, p)
+		surplus = surplus && demand > supply
+	}
+	if volume == 0 {
+		return 0, 0
+	}
+	if surplus {
+		return ties[len(ties)-1], volume
+	}
+	return ties[0], volume
+}
+
+// allocate fills volume from the orders idx, in priority order, level by
+// level, sharing a level that only fills in part pro rata.
+func allocate(allocs []Allocation, idx []int, volume int64) {
+	left := volume
+	for start := 0; start < len(idx) && left > 0; {
+		price := allocs[idx[start]].Price
+		end := start
+		var total int64
+		for end < len(idx) && allocs[idx[end]].Price == price {
+			total += allocs[idx[end]].Quantity
+			end++
+		}
+		level := append([]int(nil), idx[start:end]...)
+		start = end
+		if total <= left {
+			for _, i := range level {
+				allocs[i].Filled = allocs[i].Quantity
+			}
+			left -= total
+			continue
+		}
+		given := int64(0)
+		for _, i := range level {
+			allocs[i].Filled = proRata(allocs[i].Quantity, left, total)
+			given += allocs[i].Filled
+		}
+		sort.Slice(level, func(a, b int) bool { return allocs[level[a]].Seq < allocs[level[b]].Seq })
+		for _, i := range level {
+			if given == left {
+				break
+			}
+			allocs[i].Filled++
+			given++
+		}
+		left = 0
+	}
+}
+
+// proRata returns q*left/total rounded down without overflowing.
+func proRata(q, left, total int64) int64 {
+	hi, lo := bits.Mul64(uint64(q), uint64(left))
+	share, _ := bits.Div64(hi, lo, uint64(total))
+	return int64(share)
+}
+
+// pair matches the filled bids with the filled asks, both in priority
+// order, into fills at the clearing price.
+func pair(cl Clearing, bids, asks []int) []Fill {
+	var fills []Fill
+	left := func(i int) int64 { return cl.Allocations[i].Filled }
+	var used [2]int64
+	for b, a := 0, 0; b < len(bids) && a < len(asks); {
+		if left(bids[b]) == used[0] {
+			b, used[0] = b+1, 0
+			continue
+		}
+		if left(asks[a]) == used[1] {
+			a, used[1] = a+1, 0
+			continue
+		}
+		q := left(bids[b]) - used[0]
+		if r := left(asks[a]) - used[1]; r < q {
+			q = r
+		}
+		bid, ask := cl.Allocations[bids[b]], cl.Allocations[asks[a]]
+		fills = append(fills, Fill{
+			Seq:      uint64(len(fills)) + 1,
+			Period:   cl.Period,
+			Zone:     cl.Zone,
+			Price:    cl.Price,
+			Quantity: q,
+			Bid:      bid.ID,
+			Ask:      ask.ID,
+			Buyer:    bid.Owner,
+			Seller:   ask.Owner,
+			Meter:    ask.Meter,
+		})
+		used[0] += q
+		used[1] += q
+	}
+	return fills
+}
+
+// hash encodes the clearing and every allocation the way Result.hash
+// does; the fills follow from the allocations.
+func (cl Clearing) hash() []byte {
+	var buf []byte
+	var n [8]byte
+	str := func(s string) {
+		binary.BigEndian.PutUint32(n[:4], uint32(len(s)))
+		buf = append(append(buf, n[:4]...), s...)
+	}
+	num := func(v int64) {
+		binary.BigEndian.PutUint64(n[:], uint64(v))
+		buf = append(buf, n[:]...)
+	}
+	str("call")
+	num(cl.Period)
+	str(cl.Zone)
+	num(cl.Price)
+	num(cl.Volume)
+	num(int64(len(cl.Allocations)))
+	for _, a := range cl.Allocations {
+		str(a.ID)
+		str(a.Owner)
+		num(int64(a.Side))
+		num(a.Price)
+		num(a.Quantity)
+		num(a.Period)
+		str(a.Zone)
+		str(a.Meter)
+		num(int64(a.Seq))
+		num(a.Filled)
+	}
+	h := sm3.New()
+	h.Write(buf)
+	return h.Sum(nil)
+}
@@ -0,0 +1,239 @@
+package market
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// cleared is a time between the gate closure and the delivery of period.
+const cleared = period*PeriodSeconds - CallGateSeconds/2
+
+func call(t *testing.T, orders ...Order) *Call {
+	c := NewCall()
+	for _, o := range orders {
+		if _, err := c.Submit(o, now); err != nil {
+			t.Fatalf("*****Submit %s: %v", o.ID, err)
+		}
+	}
+	return c
+}
+
+func filled(cl Clearing) map[string]int64 {
+	m := make(map[string]int64)
+	for _, a := range cl.Allocations {
+		m[a.ID] = a.Filled
+	}
+	return m
+}
+
+func TestUniformClearingPrice(t *testing.T) {
+	c := call(t,
+		bid("b1", "Alice", 60, 10), ask("a1", "Bob", 45, 10),
+		bid("b2", "Carol", 55, 10), ask("a2", "Dave", 52, 10),
+		bid("b3", "Erin", 50, 10), ask("a3", "Frank", 58, 10))
+	cl, err := c.Clear(period, "north", cleared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 20 kWh trade, balanced, at 52 and at 55: the lower one
+	if cl.Price != 52 || cl.Volume != 20 {
+		t.Fatalf("*****Cleared %d kWh at %d, want 20 at 52", cl.Volume, cl.Price)
+	}
+	want := map[string]int64{"b1": 10, "b2": 10, "b3": 0, "a1": 10, "a2": 10, "a3": 0}
+	if got := filled(cl); !reflect.DeepEqual(got, want) {
+		t.Errorf("*****Allocations %v, want %v", got, want)
+	}
+	if len(cl.Fills) != 2 || cl.Fills[0].Bid != "b1" || cl.Fills[0].Ask != "a1" ||
+		cl.Fills[1].Bid != "b2" || cl.Fills[1].Ask != "a2" {
+		t.Errorf("*****Fills %+v", cl.Fills)
+	}
+	for _, f := range cl.Fills {
+		if f.Price != 52 || f.Quantity != 10 || f.Period != period || f.Zone != "north" {
+			t.Errorf("*****Fill %+v not at the clearing price", f)
+		}
+	}
+	if cl.Fills[0].Meter != "PV-Bob" {
+		t.Errorf("*****Fill meter %q, want the ask's", cl.Fills[0].Meter)
+	}
+}
+
+func TestClearingProRata(t *testing.T) {
+	c := call(t,
+		ask("a1", "Bob", 40, 30),
+		bid("b1", "Alice", 50, 10),
+		bid("b2", "Carol", 50, 20),
+		bid("b3", "Erin", 50, 5),
+		bid("b4", "Dave", 45, 10))
+	cl, err := c.Clear(period, "north", cleared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// demand exceeds supply at every price with the most volume: the highest
+	if cl.Price != 50 || cl.Volume != 30 {
+		t.Fatalf("*****Cleared %d kWh at %d, want 30 at 50", cl.Volume, cl.Price)
+	}
+	// 30 of 35 at 50: 8.57, 17.14 and 4.29 rounded down, the unit left to
+	// the earliest order
+	want := map[string]int64{"a1": 30, "b1": 9, "b2": 17, "b3": 4, "b4": 0}
+	if got := filled(cl); !reflect.DeepEqual(got, want) {
+		t.Errorf("*****Allocations %v, want %v", got, want)
+	}
+	var sum int64
+	for _, f := range cl.Fills {
+		if f.Ask != "a1" || f.Seller != "Bob" {
+			t.Errorf("*****Fill %+v", f)
+		}
+		sum += f.Quantity
+	}
+	if sum != 30 {
+		t.Errorf("*****Fills trade %d kWh, want 30", sum)
+	}
+}
+
+func TestClearingWithoutCross(t *testing.T) {
+	c := call(t, bid("b1", "Alice", 40, 10), ask("a1", "Bob", 41, 10))
+	cl, err := c.Clear(period, "north", cleared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.Price != 0 || cl.Volume != 0 || len(cl.Fills) != 0 || len(cl.Allocations) != 2 {
+		t.Errorf("*****Clearing without a cross: %+v", cl)
+	}
+	if !VerifyClearing(cl) {
+		t.Error("*****Empty clearing does not replay")
+	}
+}
+
+func TestCallGate(t *testing.T) {
+	c := NewCall()
+	if _, err := c.Submit(bid("b1", "Alice", 50, 10), GateClosure(period)); !errors.Is(err, ErrGateClosed) {
+		t.Errorf("*****Order at the gate closure: got %v, want ErrGateClosed", err)
+	}
+	if _, err := c.Submit(bid("b1", "Alice", 50, 10), now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Submit(ask("a1", "Alice", 40, 10), now); !errors.Is(err, ErrBadOrder) {
+		t.Errorf("*****Owner on both sides: got %v, want ErrBadOrder", err)
+	}
+	if _, err := c.Clear(period, "north", now); !errors.Is(err, ErrGateOpen) {
+		t.Errorf("*****Clear with the gate open: got %v, want ErrGateOpen", err)
+	}
+	if _, err := c.Clear(period, "north", period*PeriodSeconds); !errors.Is(err, ErrPeriodClosed) {
+		t.Errorf("*****Clear after delivery started: got %v, want ErrPeriodClosed", err)
+	}
+
+	// a clearing that cannot be published leaves the orders in place
+	c.Publish = func(Clearing) error { return fmt.Errorf("ledger down") }
+	if _, err := c.Clear(period, "north", cleared); err == nil {
+		t.Fatal("*****Clear with a failing Publish succeeded")
+	}
+	if len(c.Orders(period, "north")) != 1 {
+		t.Fatal("*****Orders lost after a failed publish")
+	}
+	var published []Clearing
+	c.Publish = func(cl Clearing) error { published = append(published, cl); return nil }
+	cl, err := c.Clear(period, "north", cleared)
+	if err != nil || len(published) != 1 || string(published[0].Hash) != string(cl.Hash) {
+		t.Fatalf("*****Published %d clearings: %v", len(published), err)
+	}
+	if _, err := c.Clear(period, "north", cleared); !errors.Is(err, ErrGateClosed) {
+		t.Errorf("*****Second clear: got %v, want ErrGateClosed", err)
+	}
+	if len(c.Orders(period, "north")) != 0 {
+		t.Error("*****Orders left after clearing")
+	}
+}
+
+// callOrders returns n orders of random owners, prices and quantities,
+// prices bunched so that many orders tie. Even owners buy, odd ones sell.
+func callOrders(rng *rand.Rand, n int) []Order {
+	orders := make([]Order, 0, n)
+	for i := 0; i < n; i++ {
+		u := rng.Intn(n)
+		owner := "u" + strconv.Itoa(u)
+		id := "o" + strconv.Itoa(i)
+		price, qty := int64(40+rng.Intn(10)), int64(1+rng.Intn(50))
+		if u%2 == 0 {
+			orders = append(orders, bid(id, owner, price, qty))
+		} else {
+			orders = append(orders, ask(id, owner, price, qty))
+		}
+	}
+	return orders
+}
+
+func TestClearingReplay(t *testing.T) {
+	rng := rand.New(rand.NewSource(49))
+	for round := 0; round < 50; round++ {
+		c := call(t, callOrders(rng, 2+rng.Intn(40))...)
+		orders := c.Orders(period, "north")
+		cl, err := c.Clear(period, "north", cleared)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the same orders in any order clear the same
+		shuffled := append([]Order(nil), orders...)
+		rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		if again := ClearOrders(period, "north", shuffled); !reflect.DeepEqual(again, cl) {
+			t.Fatalf("*****Round %d: replay differs\n%+v\n%+v", round, again, cl)
+		}
+		// and so does the clearing read back from its JSON
+		b, _ := json.Marshal(cl)
+		var read Clearing
+		if err := json.Unmarshal(b, &read); err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyClearing(read) {
+			t.Fatalf("*****Round %d: clearing does not replay from JSON", round)
+		}
+
+		var bought, sold, traded int64
+		for _, a := range cl.Allocations {
+			if a.Filled < 0 || a.Filled > a.Quantity {
+				t.Fatalf("*****Round %d: %s filled %d of %d", round, a.ID, a.Filled, a.Quantity)
+			}
+			if a.Filled > 0 && ((a.Side == Bid && a.Price < cl.Price) || (a.Side == Ask && a.Price > cl.Price)) {
+				t.Fatalf("*****Round %d: %s filled at %d beyond its limit %d", round, a.ID, cl.Price, a.Price)
+			}
+			if a.Side == Bid {
+				bought += a.Filled
+			} else {
+				sold += a.Filled
+			}
+		}
+		for _, f := range cl.Fills {
+			if f.Buyer == f.Seller || f.Price != cl.Price {
+				t.Fatalf("*****Round %d: fill %+v", round, f)
+			}
+			traded += f.Quantity
+		}
+		if bought != cl.Volume || sold != cl.Volume || traded != cl.Volume {
+			t.Fatalf("*****Round %d: bought %d sold %d traded %d of %d", round, bought, sold, traded, cl.Volume)
+		}
+
+		if cl.Volume > 0 {
+			tampered := read
+			tampered.Allocations = append([]Allocation(nil), read.Allocations...)
+			for i, a := range tampered.Allocations {
+				if a.Filled > 0 {
+					tampered.Allocations[i].Filled--
+					break
+				}
+			}
+			if VerifyClearing(tampered) {
+				t.Fatalf("*****Round %d: tampered allocation verified", round)
+			}
+			tampered = read
+			tampered.Price++
+			if VerifyClearing(tampered) {
+				t.Fatalf("*****Round %d: tampered price verified", round)
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package market
+
+import "errors"
+
+var (
+	// ErrBadOrder is returned for an order with a missing or reused ID, a
+	// missing owner or zone, a non-positive price or quantity, or an ask
+	// without a meter.
+	ErrBadOrder = errors.New("market: malformed order")
+
+	// ErrPeriodClosed is returned for an order whose delivery period has
+	// already started.
+	ErrPeriodClosed = errors.New("market: delivery period closed")
+
+	// ErrUnknownOrder is returned when cancelling an order that is not
+	// resting in the book.
+	ErrUnknownOrder = errors.New("market: no such resting order")
+
+	// ErrGateClosed is returned for an order submitted to a call auction
+	// after its gate has closed, and for clearing a period twice.
+	ErrGateClosed = errors.New("market: call auction gate closed")
+
+	// ErrGateOpen is returned for clearing a call auction before its gate
+	// has closed.
+	ErrGateOpen = errors.New("market: call auction gate still open")
+)
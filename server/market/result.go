@@ -0,0 +1,81 @@
+package market
+
+import (
+	"encoding/binary"
+
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+// Event kinds of a Result.
+const (
+	EventSubmit = "submit"
+	EventCancel = "cancel"
+)
+
+// Result is one event of the book: the submitted or cancelled order and
+// the fills it made. Hash is the SM3 digest of Prev and the event.
+type Result struct {
+	Event string `json:"event"`
+	Order Order  `json:"order"`
+	Fills []Fill `json:"fills"`
+	Prev  []byte `json:"prev"`
+	Hash  []byte `json:"hash"`
+}
+
+// genesis is the Prev of a new book's first event.
+func genesis() []byte {
+	h := sm3.New()
+	h.Write([]byte("market-genesis"))
+	return h.Sum(nil)
+}
+
+// hash encodes every field with a length or a fixed width, so two
+// different events never encode the same.
+func (r Result) hash() []byte {
+	var buf []byte
+	var n [8]byte
+	str := func(s string) {
+		binary.BigEndian.PutUint32(n[:4], uint32(len(s)))
+		buf = append(append(buf, n[:4]...), s...)
+	}
+	num := func(v int64) {
+		binary.BigEndian.PutUint64(n[:], uint64(v))
+		buf = append(buf, n[:]...)
+	}
+	str(string(r.Prev))
+	str(r.Event)
+	o := r.Order
+	str(o.ID)
+	str(o.Owner)
+	num(int64(o.Side))
+	num(o.Price)
+	num(o.Quantity)
+	num(o.Period)
+	str(o.Zone)
+	str(o.Meter)
+	num(int64(o.Seq))
+	num(int64(len(r.Fills)))
+	for _, f := range r.Fills {
+		num(int64(f.Seq))
+		num(f.Price)
+		num(f.Quantity)
+		str(f.Bid)
+		str(f.Ask)
+	}
+	h := sm3.New()
+	h.Write(buf)
+	return h.Sum(nil)
+}
+
+// Verify recomputes the hash chain of results from a new book and reports
+// whether every result links to the one before it.
+func Verify(results []Result) bool {
+	prev := genesis()
+	for _, r := range results {
+		if string(r.Prev) != string(prev) || string(r.hash()) != string(r.Hash) {
+			return false
+		}
+		prev = r.Hash
+	}
+	return true
+}
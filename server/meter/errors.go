@@ -0,0 +1,25 @@
+package meter
+
+import "errors"
+
+var (
+	// ErrBadReading is returned for a reading with an invalid meter ID, an
+	// empty or reversed interval or a negative energy.
+	ErrBadReading = errors.New("meter: malformed reading")
+
+	// ErrBadSignature is returned when a reading is not signed by the key
+	// registered for its meter.
+	ErrBadSignature = errors.New("meter: invalid reading signature")
+
+	// ErrSequence is returned for a reading whose sequence number is not
+	// the next one of its meter, which covers replayed readings.
+	ErrSequence = errors.New("meter: reading replayed or out of sequence")
+
+	// ErrInterval is returned for a reading that starts before the end of
+	// the meter's previous reading or ends in the future.
+	ErrInterval = errors.New("meter: reading interval not monotonic")
+
+	// ErrSurplus is returned when a lot would sell more energy than the
+	// meter has recorded and not yet listed.
+	ErrSurplus = errors.New("meter: amount exceeds metered surplus")
+)
@@ -0,0 +1,133 @@
+/*
+Package meter handles signed interval readings of smart meters, which back
+the Amount of a Goods lot with energy that was actually exported.
+
+Every meter holds an SM2 key registered on chain with its owner's wallet
+address. A reading covers [Start, End) in Unix seconds and carries a
+sequence number; the meter signs Message() with the UID derived from its
+own key, as wallet keys do (see utils.UIDOf). The ledger accepts a reading
+only if its sequence number is the next one of the meter and its interval
+starts no earlier than the previous one ended, so a reading can be neither
+replayed nor counted twice. State mirrors the checks the chaincode makes
+in SubmitReading and CreateGoods.
+*/
+package meter
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+
+	"server/utils"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// Reading is one signed interval reading, with the JSON names used on
+// chain. Kwh is the energy exported in the interval.
+type Reading struct {
+	MeterID string `json:"meterId"`
+	Start   int64  `json:"start"`
+	End     int64  `json:"end"`
+	Kwh     int64  `json:"kwh"`
+	Seq     int64  `json:"seq"`
+	Sign    string `json:"sign"` // hex of the SM2 signature of Message()
+}
+
+var meterID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidID reports whether id can name a meter; it must not contain the
+// separator of Message() or characters a composite key rejects.
+func ValidID(id string) bool {
+	return meterID.MatchString(id)
+}
+
+// Message returns the bytes a meter signs for r.
+func (r Reading) Message() []byte {
+	return []byte(fmt.Sprintf("%s|%d|%d|%d|%d", r.MeterID, r.Start, r.End, r.Kwh, r.Seq))
+}
+
+func (r Reading) check() error {
+	if !ValidID(r.MeterID) || r.Start < 0 || r.End <= r.Start || r.Kwh < 0 || r.Seq < 1 {
+		return ErrBadReading
+	}
+	return nil
+}
+
+// Sign signs r with the meter key pri and returns it with Sign set.
+func Sign(pri *sm2.PrivateKey, r Reading) (Reading, error) {
+	if err := r.check(); err != nil {
+		return Reading{}, err
+	}
+	uid, err := utils.UIDOf(sm2.CalculatePubKey(pri))
+	if err != nil {
+		return Reading{}, err
+	}
+	sign, err := sm2.Sign(pri, uid, r.Message())
+	if err != nil {
+		return Reading{}, fmt.Errorf("failed to sign reading:%v", err)
+	}
+	r.Sign = hex.EncodeToString(sign)
+	return r, nil
+}
+
+// Verify checks that r is well formed and signed by the meter key pub.
+func Verify(pub *sm2.PublicKey, r Reading) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	uid, err := utils.UIDOf(pub)
+	if err != nil {
+		return err
+	}
+	sign, err := hex.DecodeString(r.Sign)
+	if err != nil || len(sign) == 0 || !sm2.Verify(pub, uid, r.Message(), sign) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// State is what the ledger keeps for a meter: the last accepted reading,
+// the energy metered in total and how much of it is already listed in lots.
+type State struct {
+	ID      string
+	Pub     *sm2.PublicKey
+	Seq     int64
+	End     int64
+	Metered int64
+	Listed  int64
+}
+
+// Accept checks r against the meter at time now and adds it to s.
+func (s *State) Accept(r Reading, now int64) error {
+	if r.MeterID != s.ID {
+		return fmt.Errorf("%w: reading of meter %s", ErrBadReading, r.MeterID)
+	}
+	if err := Verify(s.Pub, r); err != nil {
+		return err
+	}
+	if r.Seq != s.Seq+1 {
+		return fmt.Errorf("%w: got %d, want %d", ErrSequence, r.Seq, s.Seq+1)
+	}
+	if r.Start < s.End || r.End > now {
+		return fmt.Errorf("%w: [%d, %d) after %d at %d", ErrInterval, r.Start, r.End, s.End, now)
+	}
+	s.Seq = r.Seq
+	s.End = r.End
+	s.Metered += r.Kwh
+	return nil
+}
+
+// Surplus returns the metered energy not yet listed.
+func (s *State) Surplus() int64 {
+	return s.Metered - s.Listed
+}
+
+// List reserves amount of the surplus for a new lot.
+func (s *State) List(amount int64) error {
+	if amount <= 0 || amount > s.Surplus() {
+		return fmt.Errorf("%w: %d of %d", ErrSurplus, amount, s.Surplus())
+	}
+	s.Listed += amount
+	return nil
+}
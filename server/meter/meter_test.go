@@ -0,0 +1,156 @@
+package meter
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// 2024-06-01 00:00 UTC+8
+const day = 1717171200
+
+func newMeter(t *testing.T, id string, seed int64) (*Simulator, *State) {
+	pri, _, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := NewSimulator(id, pri, day, 3600, 50, seed)
+	return sim, &State{ID: id, Pub: sim.PublicKey()}
+}
+
+func TestSimulatorReadingsAccepted(t *testing.T) {
+	sim, s := newMeter(t, "PV-0001", 1)
+	var total, night int64
+	for i := 0; i < 24; i++ {
+		r, err := sim.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Accept(r, r.End); err != nil {
+			t.Fatalf("*****Reading %d rejected: %v", r.Seq, err)
+		}
+		total += r.Kwh
+		if i < 6 || i >= 18 {
+			night += r.Kwh
+		}
+	}
+	if s.Seq != 24 || s.End != day+24*3600 || s.Metered != total {
+		t.Errorf("*****State after a day: seq %d end %d metered %d, want 24 %d %d", s.Seq, s.End, s.Metered, day+24*3600, total)
+	}
+	if total == 0 || night != 0 {
+		t.Errorf("*****Exported %d kWh in a day and %d at night", total, night)
+	}
+}
+
+func TestSimulatorDeterministic(t *testing.T) {
+	a, _ := newMeter(t, "PV-0001", 7)
+	b, _ := newMeter(t, "PV-0002", 7)
+	for i := 0; i < 24; i++ {
+		ra, _ := a.Next()
+		rb, _ := b.Next()
+		if ra.Kwh != rb.Kwh {
+			t.Fatalf("*****Same seed exported %d and %d kWh", ra.Kwh, rb.Kwh)
+		}
+	}
+}
+
+func TestReplayRejected(t *testing.T) {
+	sim, s := newMeter(t, "PV-0001", 1)
+	r1, _ := sim.Next()
+	r2, _ := sim.Next()
+	r3, _ := sim.Next()
+	now := r3.End
+	if err := s.Accept(r1, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Accept(r1, now); !errors.Is(err, ErrSequence) {
+		t.Errorf("*****Replayed reading: got %v, want ErrSequence", err)
+	}
+	if err := s.Accept(r3, now); !errors.Is(err, ErrSequence) {
+		t.Errorf("*****Skipped reading: got %v, want ErrSequence", err)
+	}
+	if err := s.Accept(r2, now); err != nil {
+		t.Fatal(err)
+	}
+	if s.Metered != r1.Kwh+r2.Kwh {
+		t.Errorf("*****Metered %d, want %d", s.Metered, r1.Kwh+r2.Kwh)
+	}
+}
+
+func TestIntervalMonotonic(t *testing.T) {
+	sim, s := newMeter(t, "PV-0001", 1)
+	r1, _ := sim.Next()
+	if err := s.Accept(r1, r1.End); err != nil {
+		t.Fatal(err)
+	}
+	// the next reading counts part of the previous interval again
+	overlap, err := Sign(sim.key, Reading{MeterID: sim.ID, Start: r1.End - 600, End: r1.End + 3600, Kwh: 10, Seq: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Accept(overlap, overlap.End); !errors.Is(err, ErrInterval) {
+		t.Errorf("*****Overlapping interval: got %v, want ErrInterval", err)
+	}
+	r2, _ := sim.Next()
+	if err := s.Accept(r2, r2.End-1); !errors.Is(err, ErrInterval) {
+		t.Errorf("*****Interval ending in the future: got %v, want ErrInterval", err)
+	}
+	// a gap, such as an outage, is allowed
+	gap, _ := Sign(sim.key, Reading{MeterID: sim.ID, Start: r1.End + 7200, End: r1.End + 10800, Kwh: 5, Seq: 2})
+	if err := s.Accept(gap, gap.End); err != nil {
+		t.Errorf("*****Reading after a gap: %v", err)
+	}
+}
+
+func TestBadReadings(t *testing.T) {
+	sim, s := newMeter(t, "PV-0001", 1)
+	r, _ := sim.Next()
+	changed := r
+	changed.Kwh++
+	if err := s.Accept(changed, r.End); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("*****Changed reading: got %v, want ErrBadSignature", err)
+	}
+	other, _ := newMeter(t, "PV-0001", 1)
+	forged, _ := other.Next()
+	if err := s.Accept(forged, r.End); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("*****Reading of another key: got %v, want ErrBadSignature", err)
+	}
+	for _, bad := range []Reading{
+		{MeterID: "PV|1", Start: day, End: day + 3600, Seq: 1},
+		{MeterID: "PV-0001", Start: day, End: day, Seq: 1},
+		{MeterID: "PV-0001", Start: day, End: day + 3600, Kwh: -1, Seq: 1},
+		{MeterID: "PV-0001", Start: day, End: day + 3600, Seq: 0},
+	} {
+		if _, err := Sign(sim.key, bad); !errors.Is(err, ErrBadReading) {
+			t.Errorf("*****Signed malformed reading %+v: %v", bad, err)
+		}
+	}
+	if err := s.Accept(r, r.End); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListSurplus(t *testing.T) {
+	sim, s := newMeter(t, "PV-0001", 1)
+	for i := 0; i < 24; i++ {
+		r, _ := sim.Next()
+		if err := s.Accept(r, r.End); err != nil {
+			t.Fatal(err)
+		}
+	}
+	total := s.Metered
+	if err := s.List(total / 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.List(total - total/2 + 1); !errors.Is(err, ErrSurplus) {
+		t.Errorf("*****Listing more than the surplus: got %v, want ErrSurplus", err)
+	}
+	if err := s.List(0); !errors.Is(err, ErrSurplus) {
+		t.Errorf("*****Empty lot: got %v, want ErrSurplus", err)
+	}
+	if err := s.List(total - total/2); err != nil || s.Surplus() != 0 {
+		t.Errorf("*****Listing the rest: %v, surplus %d", err, s.Surplus())
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"server/controller"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+RateLimit 对每个用户做令牌桶限流：桶容量为burst，每every补充一个令牌
+须放在RoleAuth之后，按认证得到的用户名计数；未认证的请求按客户端IP计数
+*/
+func RateLimit(every time.Duration, burst int) gin.HandlerFunc {
+	type bucket struct {
+		tokens float64
+		last   time.Time
+	}
+	var mu sync.Mutex
+	buckets := make(map[string]*bucket)
+
+	return func(ctx *gin.Context) {
+		key := ctx.GetString(controller.IdentityKey)
+		if key == "" {
+			key = "ip:" + ctx.ClientIP()
+		}
+		now := time.Now()
+
+		mu.Lock()
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{float64(burst), now}
+			buckets[key] = b
+		}
+		b.tokens += float64(now.Sub(b.last)) / float64(every)
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+		b.last = now
+		allowed := b.tokens >= 1
+		if allowed {
+			b.tokens--
+		}
+		mu.Unlock()
+
+		if !allowed {
+			abort(ctx, 429, "too many requests")
+			return
+		}
+		ctx.Next()
+	}
+}
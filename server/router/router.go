@@ -2,6 +2,8 @@ package router
 
 import (
 	"server/controller"
+	"server/middleware"
+	"time"
 
 	"github.com/gin-gonic/gin"
     "github.com/gin-contrib/cors"
@@ -37,6 +39,16 @@ func Router() *gin.Engine {
 		good.POST("/getGood", controller.GoodController{}.GetGood)
 		good.POST("/getGoodByOwner", controller.GoodController{}.GetGoodByOwner)
 		good.POST("/updateGoodPrice", controller.GoodController{}.UpdateGoodPrice)
+		good.POST("/createGood", controller.GoodController{}.CreateGood)
+		good.POST("/search", controller.GoodController{}.SearchGoods)
+	}
+	//智能电表：登记须市场运营方签名认证，读数由电表自己签名
+	meter := router.Group("meter")
+	{
+		meter.POST("/register", middleware.OperatorAuth(), controller.MeterController{}.RegisterMeter)
+		meter.POST("/reading", controller.MeterController{}.SubmitReading)
+		meter.POST("/getMeter", controller.MeterController{}.GetMeter)
+		meter.POST("/getReadings", controller.MeterController{}.GetReadings)
 	}
 	proposal := router.Group("proposal")
 	{
@@ -46,6 +58,60 @@ func Router() *gin.Engine {
 		proposal.POST("/getProposalByOrderNum", controller.ProposalController{}.GetProposalByOrderNum)
 		proposal.POST("/setProposal", controller.ProposalController{}.SetProposal)
 		proposal.POST("/updateProposal", controller.ProposalController{}.UpdateProposal)
+		proposal.POST("/getProposalByDelivery", controller.ProposalController{}.GetProposalByDelivery)
+	}
+	//连续双边撮合：订单簿在服务端内存中，下单、撤单事件锚定在链上
+	market := router.Group("market")
+	{
+		market.POST("/order", controller.MarketController{}.SubmitOrder)
+		market.POST("/cancel", controller.MarketController{}.CancelOrder)
+		market.POST("/depth", controller.MarketController{}.Depth)
+		market.POST("/anchor", controller.MarketController{}.GetMatchAnchor)
+	}
+	//集合竞价：每个结算时段按统一价格出清，出清须市场运营方签名认证
+	call := router.Group("call")
+	{
+		call.POST("/order", controller.CallController{}.SubmitOrder)
+		call.POST("/orders", controller.CallController{}.Orders)
+		call.POST("/clear", middleware.OperatorAuth(), controller.CallController{}.Clear)
+		call.POST("/getClearing", controller.CallController{}.GetClearing)
+		call.POST("/verify", controller.CallController{}.VerifyClearing)
+	}
+	//密封拍卖：创建和开标须市场运营方签名认证，运营方即拍卖方；结果须由拍卖指定的监管方验证签名后才能付款
+	auction := router.Group("auction")
+	{
+		auction.POST("/create", middleware.OperatorAuth(), controller.AuctionController{}.CreateAuction)
+		auction.POST("/bid", controller.AuctionController{}.SubmitBid)
+		auction.POST("/close", middleware.OperatorAuth(), controller.AuctionController{}.CloseAuction)
+		auction.POST("/attest", middleware.RegulatorAuth(), controller.AuctionController{}.AttestAuction)
+		auction.POST("/reject", middleware.RegulatorAuth(), controller.AuctionController{}.RejectAuction)
+		auction.POST("/settle", controller.AuctionController{}.SettleAuction)
+		auction.POST("/verify", controller.AuctionController{}.VerifyAuction)
+		auction.POST("/getAuction", controller.AuctionController{}.GetAuction)
+		auction.POST("/getBids", controller.AuctionController{}.GetBids)
+	}
+	//偏差结算：交割后按电表读数结算合同电量的偏差，偏差电价须市场运营方签名认证
+	imbalance := router.Group("imbalance")
+	{
+		imbalance.POST("/tariff", middleware.OperatorAuth(), controller.ImbalanceController{}.SetTariff)
+		imbalance.POST("/getTariff", controller.ImbalanceController{}.GetTariff)
+		imbalance.POST("/compute", controller.ImbalanceController{}.Compute)
+		imbalance.POST("/settle", controller.ImbalanceController{}.Settle)
+		imbalance.POST("/verify", controller.ImbalanceController{}.Verify)
+		imbalance.POST("/getImbalance", controller.ImbalanceController{}.GetImbalance)
+	}
+	//监管方审计接口：须监管方签名认证，每个监管方平均每分钟一次，最多连续5次
+	audit := router.Group("audit", middleware.RegulatorAuth(), middleware.RateLimit(time.Minute, 5))
+	{
+		audit.POST("/order/:id", controller.AuditController{}.AuditOrder)
+		audit.GET("/order/:id", controller.AuditController{}.GetAuditRecords)
+	}
+	//交易金额统计：统计须市场运营方签名认证，查询公开
+	stats := router.Group("stats")
+	{
+		stats.POST("/aggregate", middleware.OperatorAuth(), middleware.RateLimit(time.Minute, 5), controller.StatsController{}.AggregateVolume)
+		stats.GET("/aggregates", controller.StatsController{}.GetAggregates)
+		stats.POST("/verify", controller.StatsController{}.VerifyAggregate)
 	}
 	return router
 }
@@ -0,0 +1,255 @@
+package threshold
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	bullet "server/bulletproof/src"
+	"server/utils"
+
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+// c1Len is the length of the uncompressed C1 that both HomoEncrypt and
+// sm2.Encrypt ciphertexts start with.
+const c1Len = 1 + 2*32
+
+/*
+ShareProof is a Chaum-Pedersen proof that log_G(x_i*G) == log_C1(x_i*C1),
+i.e. that a partial decryption was made with the share behind the party's
+verification key.
+*/
+type ShareProof struct {
+	R1 bullet.ECPoint // k*G
+	R2 bullet.ECPoint // k*C1
+	S  *big.Int       // k + c*x_i
+}
+
+// PartialDecryption is party ID's share x_i*C1 of the decryption of one
+// ciphertext.
+type PartialDecryption struct {
+	ID    int
+	D     bullet.ECPoint
+	Proof ShareProof
+}
+
+/*
+PartialDecrypt returns ks's partial decryption of cipher, which may be a
+HomoEncrypt or an sm2.Encrypt ciphertext. context, e.g. the order number of
+an audit, is bound into the proof so a partial decryption released for one
+request cannot be presented for another.
+*/
+func (ks KeyShare) PartialDecrypt(cipher []byte, context []byte) (PartialDecryption, error) {
+	c1, err := parseC1(cipher)
+	if err != nil {
+		return PartialDecryption{}, err
+	}
+	ec := group()
+	k, err := randScalar()
+	if err != nil {
+		return PartialDecryption{}, err
+	}
+	D := ec.Mult(c1, ks.X)
+	R1 := ec.Mult(basePoint(), k)
+	R2 := ec.Mult(c1, k)
+	c := shareChallenge(ks.ID, ks.Shares[ks.ID-1], c1, D, R1, R2, context)
+	s := new(big.Int).Mod(new(big.Int).Add(k, new(big.Int).Mul(c, ks.X)), ec.N)
+	return PartialDecryption{ks.ID, D, ShareProof{R1, R2, s}}, nil
+}
+
+// VerifyPartial checks the proof of pd against the verification key of its
+// party.
+func (g GroupKey) VerifyPartial(cipher []byte, pd PartialDecryption, context []byte) error {
+	c1, err := parseC1(cipher)
+	if err != nil {
+		return err
+	}
+	return g.verifyPartial(c1, pd, context)
+}
+
+func (g GroupKey) verifyPartial(c1 bullet.ECPoint, pd PartialDecryption, context []byte) error {
+	if pd.ID < 1 || pd.ID > g.N {
+		return fmt.Errorf("%w: unknown party %d", ErrBadPartial, pd.ID)
+	}
+	ec := group()
+	for _, p := range []bullet.ECPoint{pd.D, pd.Proof.R1, pd.Proof.R2} {
+		if p.X == nil || p.Y == nil || !ec.C.IsOnCurve(p.X, p.Y) {
+			return fmt.Errorf("%w: point of party %d not on curve", ErrBadPartial, pd.ID)
+		}
+	}
+	s := pd.Proof.S
+	if s == nil || s.Sign() < 0 || s.Cmp(ec.N) >= 0 {
+		return fmt.Errorf("%w: scalar of party %d out of range", ErrBadPartial, pd.ID)
+	}
+
+	Y := g.Shares[pd.ID-1]
+	c := shareChallenge(pd.ID, Y, c1, pd.D, pd.Proof.R1, pd.Proof.R2, context)
+	// s*G == R1 + c*Y and s*C1 == R2 + c*D
+	if !ec.Mult(basePoint(), s).Equal(ec.Add(pd.Proof.R1, ec.Mult(Y, c))) ||
+		!ec.Mult(c1, s).Equal(ec.Add(pd.Proof.R2, ec.Mult(pd.D, c))) {
+		return fmt.Errorf("%w: from party %d", ErrBadPartial, pd.ID)
+	}
+	return nil
+}
+
+/*
+Combine verifies parts and interpolates d*C1 from the first t valid ones
+from distinct parties. Invalid parts are skipped, so a misbehaving node can
+only withhold its share, not corrupt the result; ErrNotEnoughShares is
+returned if fewer than t valid parts remain.
+*/
+func (g GroupKey) Combine(cipher []byte, parts []PartialDecryption, context []byte) (bullet.ECPoint, error) {
+	c1, err := parseC1(cipher)
+	if err != nil {
+		return bullet.ECPoint{}, err
+	}
+
+	var valid []PartialDecryption
+	seen := make(map[int]bool)
+	for _, pd := range parts {
+		if len(valid) == g.T {
+			break
+		}
+		if seen[pd.ID] || g.verifyPartial(c1, pd, context) != nil {
+			continue
+		}
+		seen[pd.ID] = true
+		valid = append(valid, pd)
+	}
+	if len(valid) < g.T {
+		return bullet.ECPoint{}, fmt.Errorf("%w: %d valid of %d needed", ErrNotEnoughShares, len(valid), g.T)
+	}
+
+	ec := group()
+	ids := make([]int, len(valid))
+	for i, pd := range valid {
+		ids[i] = pd.ID
+	}
+	var dC1 bullet.ECPoint
+	for i, pd := range valid {
+		term := ec.Mult(pd.D, lagrange(ids, ids[i], ec.N))
+		if i == 0 {
+			dC1 = term
+		} else {
+			dC1 = ec.Add(dC1, term)
+		}
+	}
+	return dC1, nil
+}
+
+// DecryptHomo combines parts to decrypt a HomoEncrypt ciphertext, or a sum
+// of them from CiperAdd, and recovers m from [m]G = C2 - d*C1.
+func (g GroupKey) DecryptHomo(cipher []byte, parts []PartialDecryption, context []byte) ([]byte, error) {
+	if len(cipher) != 2*c1Len {
+		return nil, fmt.Errorf("%w: length %d", ErrMalformedCiphertext, len(cipher))
+	}
+	curve := sm2.GetSm2P256V1()
+	c2x, c2y := elliptic.Unmarshal(curve, cipher[c1Len:])
+	if c2x == nil {
+		return nil, fmt.Errorf("%w: C2 not on curve", ErrMalformedCiphertext)
+	}
+	dC1, err := g.Combine(cipher, parts, context)
+	if err != nil {
+		return nil, err
+	}
+	ec := group()
+	mG := ec.Add(bullet.ECPoint{X: c2x, Y: c2y}, ec.Neg(dC1))
+	return utils.RecoverPlaintext(curve, mG.X, mG.Y)
+}
+
+// DecryptSM2 combines parts to decrypt a C1C3C2 ciphertext from
+// sm2.Encrypt, as sm2.Decrypt would with the joint key.
+func (g GroupKey) DecryptSM2(cipher []byte, parts []PartialDecryption, context []byte) ([]byte, error) {
+	if len(cipher) <= c1Len+sm3.DigestLength {
+		return nil, fmt.Errorf("%w: length %d", ErrMalformedCiphertext, len(cipher))
+	}
+	dC1, err := g.Combine(cipher, parts, context)
+	if err != nil {
+		return nil, err
+	}
+	c3 := cipher[c1Len : c1Len+sm3.DigestLength]
+	msg := append([]byte(nil), cipher[c1Len+sm3.DigestLength:]...)
+
+	x2, y2 := fixed32(dC1.X), fixed32(dC1.Y)
+	kdfXor(x2, y2, msg)
+
+	h := sm3.New()
+	h.Write(x2)
+	h.Write(msg)
+	h.Write(y2)
+	if !bytes.Equal(h.Sum(nil), c3) {
+		return nil, ErrDecryption
+	}
+	return msg, nil
+}
+
+// parseC1 reads the C1 both ciphertext formats start with.
+func parseC1(cipher []byte) (bullet.ECPoint, error) {
+	if len(cipher) < c1Len {
+		return bullet.ECPoint{}, fmt.Errorf("%w: length %d", ErrMalformedCiphertext, len(cipher))
+	}
+	x, y := elliptic.Unmarshal(sm2.GetSm2P256V1(), cipher[:c1Len])
+	if x == nil {
+		return bullet.ECPoint{}, fmt.Errorf("%w: C1 not on curve", ErrMalformedCiphertext)
+	}
+	return bullet.ECPoint{X: x, Y: y}, nil
+}
+
+func shareChallenge(id int, Y, c1, D, R1, R2 bullet.ECPoint, context []byte) *big.Int {
+	t := bullet.NewTranscript("threshold/partial-decryption")
+	t.AppendMessage("curve", []byte(sm2.GetSm2P256V1().Params().Name))
+	t.AppendPoint("G", basePoint())
+	t.AppendScalar("id", big.NewInt(int64(id)))
+	t.AppendPoint("Y", Y)
+	t.AppendPoint("C1", c1)
+	t.AppendPoint("D", D)
+	t.AppendMessage("context", context)
+	t.AppendPoint("R1", R1)
+	t.AppendPoint("R2", R2)
+	return t.ChallengeScalar("c", group().N)
+}
+
+// lagrange returns the coefficient of party i for interpolating at 0 from
+// the parties ids: prod_{j != i} j / (j - i) mod N.
+func lagrange(ids []int, i int, N *big.Int) *big.Int {
+	num, den := big.NewInt(1), big.NewInt(1)
+	for _, j := range ids {
+		if j == i {
+			continue
+		}
+		num.Mul(num, big.NewInt(int64(j)))
+		den.Mul(den, big.NewInt(int64(j-i)))
+	}
+	den.Mod(den, N)
+	return num.Mul(num, den.ModInverse(den, N)).Mod(num, N)
+}
+
+// kdfXor XORs msg with the SM2 key derivation KDF(x2 || y2, len(msg)).
+func kdfXor(x2, y2, msg []byte) {
+	ct := make([]byte, 4)
+	for off, i := 0, uint32(1); off < len(msg); i++ {
+		binary.BigEndian.PutUint32(ct, i)
+		h := sm3.New()
+		h.Write(x2)
+		h.Write(y2)
+		h.Write(ct)
+		for _, b := range h.Sum(nil) {
+			if off == len(msg) {
+				break
+			}
+			msg[off] ^= b
+			off++
+		}
+	}
+}
+
+func fixed32(x *big.Int) []byte {
+	out := make([]byte, 32)
+	b := x.Bytes()
+	copy(out[32-len(b):], b)
+	return out
+}
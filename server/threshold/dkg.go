@@ -0,0 +1,355 @@
+/*
+Package threshold splits the regulator's SM2 decryption key between n nodes
+so that any t of them can decrypt together and no t-1 of them learn
+anything, as in the threshold decryption of the SM2 additive homomorphic
+scheme the wallet balances are built on.
+
+The key is generated by a joint Feldman VSS: every node deals a random
+polynomial of degree t-1, broadcasts commitments to its coefficients and
+sends each other node its evaluation at that node's index. Each node checks
+what it received against the commitments, and its key share is the sum of
+the evaluations. The joint key d is the sum of the constant terms, which no
+node ever sees; only Y = d*G is public.
+
+Both kinds of ciphertext in the system start with C1 = k*G and are opened by
+d*C1: the HomoEncrypt balance ciphertexts and the sm2.Encrypt address
+ciphertexts of the CA. A node's partial decryption is x_i*C1 with a
+Chaum-Pedersen proof that it used the share behind its public verification
+key x_i*G, and t partial decryptions are combined by Lagrange interpolation
+in the exponent.
+
+The package also signs with a shared key, for lots that several co-owners
+approve together: see signkey.go for how the SM2 signature is made linear
+in the shares and Signer for the signing session.
+*/
+package threshold
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	bullet "server/bulletproof/src"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// group returns the params used for SM2 point arithmetic and encoding. Only
+// the curve is used; keys and ciphertexts are over the standard base point.
+func group() *bullet.CryptoParams {
+	return bullet.SM2Params(1)
+}
+
+func basePoint() bullet.ECPoint {
+	params := sm2.GetSm2P256V1().Params()
+	return bullet.ECPoint{X: params.Gx, Y: params.Gy}
+}
+
+/*
+GroupKey is the public outcome of the key generation: the threshold, the
+joint public key and the verification key x_i*G of every party, which is
+what partial decryptions are checked against. Parties are numbered 1..N and
+Shares[i-1] belongs to party i.
+*/
+type GroupKey struct {
+	T, N   int
+	Y      bullet.ECPoint
+	Shares []bullet.ECPoint
+}
+
+// PublicKey returns the joint key as an SM2 public key for HomoEncrypt and
+// sm2.Encrypt.
+func (g GroupKey) PublicKey() *sm2.PublicKey {
+	return &sm2.PublicKey{X: g.Y.X, Y: g.Y.Y, Curve: sm2.GetSm2P256V1()}
+}
+
+// KeyShare is one party's secret share of the joint key.
+type KeyShare struct {
+	ID int
+	X  *big.Int
+	GroupKey
+}
+
+// Dealing is what a dealer broadcasts: commitments a_k*G to the
+// coefficients of its polynomial, constant term first.
+type Dealing struct {
+	From        int
+	Commitments []bullet.ECPoint
+}
+
+/*
+Participant runs one node's side of the key generation. The steps are
+
+	d := p.Dealing()          // broadcast
+	s, _ := p.ShareFor(j)     // send privately to party j, for every j
+	err := p.Receive(d_i, s_i) // for every dealing and share received
+	ks, err := p.Finish()
+
+Receive gives ErrBadShare when a share does not match its dealing; the
+caller should then complain against the dealer, who is disqualified unless
+it publishes a share that does match.
+*/
+type Participant struct {
+	ID, T, N int
+	poly     []*big.Int
+	dealings map[int]Dealing
+	shares   map[int]*big.Int
+}
+
+// NewParticipant returns party id of a t-of-n key generation with a fresh
+// random polynomial.
+func NewParticipant(id, t, n int) (*Participant, error) {
+	if err := checkParams(t, n); err != nil {
+		return nil, err
+	}
+	if id < 1 || id > n {
+		return nil, fmt.Errorf("%w: party %d of %d", ErrBadParams, id, n)
+	}
+	poly, err := randomPoly(nil, t)
+	if err != nil {
+		return nil, err
+	}
+	return &Participant{
+		ID:       id,
+		T:        t,
+		N:        n,
+		poly:     poly,
+		dealings: make(map[int]Dealing),
+		shares:   make(map[int]*big.Int),
+	}, nil
+}
+
+// Dealing returns the commitments to p's polynomial.
+func (p *Participant) Dealing() Dealing {
+	return Dealing{p.ID, commitPoly(p.poly)}
+}
+
+// ShareFor returns p's share for party j, which must be sent to j over a
+// private channel.
+func (p *Participant) ShareFor(j int) (*big.Int, error) {
+	if j < 1 || j > p.N {
+		return nil, fmt.Errorf("%w: party %d of %d", ErrBadParams, j, p.N)
+	}
+	return evalPoly(p.poly, j), nil
+}
+
+// Receive checks the share dealt to p against d and keeps both.
+func (p *Participant) Receive(d Dealing, share *big.Int) error {
+	if d.From < 1 || d.From > p.N {
+		return fmt.Errorf("%w: dealer %d of %d", ErrBadParams, d.From, p.N)
+	}
+	if len(d.Commitments) != p.T {
+		return fmt.Errorf("%w: dealer %d committed to %d coefficients, want %d",
+			ErrBadShare, d.From, len(d.Commitments), p.T)
+	}
+	if err := VerifyShare(d, p.ID, share); err != nil {
+		return err
+	}
+	p.dealings[d.From] = d
+	p.shares[d.From] = share
+	return nil
+}
+
+// Finish returns p's key share once it holds a valid share from every
+// dealer.
+func (p *Participant) Finish() (KeyShare, error) {
+	for i := 1; i <= p.N; i++ {
+		if _, ok := p.dealings[i]; !ok {
+			return KeyShare{}, fmt.Errorf("%w: from party %d", ErrMissingDealing, i)
+		}
+	}
+
+	ec := group()
+	x := new(big.Int)
+	sum := make([]bullet.ECPoint, p.T)
+	for i := 1; i <= p.N; i++ {
+		x.Add(x, p.shares[i])
+		for k, c := range p.dealings[i].Commitments {
+			if i == 1 {
+				sum[k] = c
+			} else {
+				sum[k] = ec.Add(sum[k], c)
+			}
+		}
+	}
+	x.Mod(x, ec.N)
+
+	return KeyShare{p.ID, x, groupKey(sum, p.T, p.N)}, nil
+}
+
+/*
+Split deals an existing key d, such as the current CA key, to n parties
+with threshold t. Unlike the joint generation the dealer knows d, so it is
+only meant for migrating a key that already exists and the dealer must
+delete d afterwards.
+*/
+func Split(d *big.Int, t, n int) ([]KeyShare, error) {
+	if err := checkParams(t, n); err != nil {
+		return nil, err
+	}
+	ec := group()
+	if d == nil || d.Sign() <= 0 || d.Cmp(ec.N) >= 0 {
+		return nil, fmt.Errorf("%w: key out of range", ErrBadParams)
+	}
+	poly, err := randomPoly(d, t)
+	if err != nil {
+		return nil, err
+	}
+	gk := groupKey(commitPoly(poly), t, n)
+	shares := make([]KeyShare, n)
+	for j := 1; j <= n; j++ {
+		shares[j-1] = KeyShare{j, evalPoly(poly, j), gk}
+	}
+	return shares, nil
+}
+
+/*
+Reconstruct recovers the joint key from t shares of g. It is only for keys
+whose secrecy ends at a known time, such as a key sealed auction bids are
+encrypted under, which the parties open together once bidding has closed.
+Every share is checked against its verification key, so a wrong share gives
+ErrBadShare rather than a wrong key.
+*/
+func Reconstruct(g GroupKey, shares []KeyShare) (*sm2.PrivateKey, error) {
+	ec := group()
+	var ids []int
+	xs := make(map[int]*big.Int)
+	for _, ks := range shares {
+		if len(ids) == g.T {
+			break
+		}
+		if ks.ID < 1 || ks.ID > g.N || len(g.Shares) != g.N {
+			return nil, fmt.Errorf("%w: party %d of %d", ErrBadParams, ks.ID, g.N)
+		}
+		if _, ok := xs[ks.ID]; ok {
+			continue
+		}
+		if ks.X == nil || ks.X.Sign() < 0 || ks.X.Cmp(ec.N) >= 0 ||
+			!ec.Mult(basePoint(), ks.X).Equal(g.Shares[ks.ID-1]) {
+			return nil, fmt.Errorf("%w: share of party %d", ErrBadShare, ks.ID)
+		}
+		ids = append(ids, ks.ID)
+		xs[ks.ID] = ks.X
+	}
+	if len(ids) < g.T {
+		return nil, fmt.Errorf("%w: %d shares of %d needed", ErrNotEnoughShares, len(ids), g.T)
+	}
+	d := new(big.Int)
+	for _, id := range ids {
+		d.Add(d, new(big.Int).Mul(xs[id], lagrange(ids, id, ec.N)))
+	}
+	d.Mod(d, ec.N)
+	if !ec.Mult(basePoint(), d).Equal(g.Y) {
+		return nil, fmt.Errorf("%w: shares do not give the joint key", ErrBadShare)
+	}
+	return &sm2.PrivateKey{D: d, Curve: sm2.GetSm2P256V1()}, nil
+}
+
+// VerifyShare checks share*G == sum_k j^k * C_k for the dealing d to party j.
+func VerifyShare(d Dealing, j int, share *big.Int) error {
+	return verifyShareOn(basePoint(), d, j, share)
+}
+
+// verifyShareOn is VerifyShare for a dealing committed on base instead of G.
+func verifyShareOn(base bullet.ECPoint, d Dealing, j int, share *big.Int) error {
+	ec := group()
+	if share == nil || share.Sign() < 0 || share.Cmp(ec.N) >= 0 {
+		return fmt.Errorf("%w: share from %d out of range", ErrBadShare, d.From)
+	}
+	for _, c := range d.Commitments {
+		if c.X == nil || c.Y == nil || !ec.C.IsOnCurve(c.X, c.Y) {
+			return fmt.Errorf("%w: commitment of %d not on curve", ErrBadShare, d.From)
+		}
+	}
+	if !ec.Mult(base, share).Equal(evalCommitments(d.Commitments, j)) {
+		return fmt.Errorf("%w: from party %d", ErrBadShare, d.From)
+	}
+	return nil
+}
+
+func checkParams(t, n int) error {
+	if t < 1 || t > n {
+		return fmt.Errorf("%w: %d of %d", ErrBadParams, t, n)
+	}
+	return nil
+}
+
+// groupKey derives the joint key and every verification key from the
+// commitments to the (summed) polynomial.
+func groupKey(comms []bullet.ECPoint, t, n int) GroupKey {
+	shares := make([]bullet.ECPoint, n)
+	for j := 1; j <= n; j++ {
+		shares[j-1] = evalCommitments(comms, j)
+	}
+	return GroupKey{t, n, comms[0], shares}
+}
+
+// randomPoly returns t random coefficients, with the constant term set to
+// secret if it is given.
+func randomPoly(secret *big.Int, t int) ([]*big.Int, error) {
+	poly := make([]*big.Int, t)
+	for k := range poly {
+		if k == 0 && secret != nil {
+			poly[k] = new(big.Int).Set(secret)
+			continue
+		}
+		a, err := randScalar()
+		if err != nil {
+			return nil, err
+		}
+		poly[k] = a
+	}
+	return poly, nil
+}
+
+// randScalar returns a uniformly random non-zero scalar mod N.
+func randScalar() (*big.Int, error) {
+	N := group().N
+	for {
+		a, err := rand.Int(rand.Reader, N)
+		if err != nil {
+			return nil, err
+		}
+		if a.Sign() != 0 {
+			return a, nil
+		}
+	}
+}
+
+func commitPoly(poly []*big.Int) []bullet.ECPoint {
+	return commitPolyOn(basePoint(), poly)
+}
+
+// commitPolyOn commits to the coefficients of poly as a_k*base.
+func commitPolyOn(base bullet.ECPoint, poly []*big.Int) []bullet.ECPoint {
+	ec := group()
+	comms := make([]bullet.ECPoint, len(poly))
+	for k, a := range poly {
+		comms[k] = ec.Mult(base, a)
+	}
+	return comms
+}
+
+// evalPoly returns f(x) mod N by Horner's rule.
+func evalPoly(poly []*big.Int, x int) *big.Int {
+	N := group().N
+	bx := big.NewInt(int64(x))
+	y := new(big.Int)
+	for k := len(poly) - 1; k >= 0; k-- {
+		y.Mul(y, bx)
+		y.Add(y, poly[k])
+		y.Mod(y, N)
+	}
+	return y
+}
+
+// evalCommitments returns f(x)*G from the commitments to f by Horner's rule.
+func evalCommitments(comms []bullet.ECPoint, x int) bullet.ECPoint {
+	ec := group()
+	bx := big.NewInt(int64(x))
+	y := comms[len(comms)-1]
+	for k := len(comms) - 2; k >= 0; k-- {
+		y = ec.Add(ec.Mult(y, bx), comms[k])
+	}
+	return y
+}
@@ -0,0 +1,52 @@
+package threshold
+
+import "errors"
+
+var (
+	// ErrBadParams is returned for a threshold t outside 1..n or a party
+	// index outside 1..n.
+	ErrBadParams = errors.New("threshold: bad threshold parameters")
+
+	// ErrBadShare is returned when a dealt share does not match the
+	// dealer's public commitments. The receiver should broadcast a
+	// complaint against the dealer.
+	ErrBadShare = errors.New("threshold: share does not match dealing")
+
+	// ErrMissingDealing is returned when a party finishes the key
+	// generation before it has received a valid share from every dealer.
+	ErrMissingDealing = errors.New("threshold: missing dealing")
+
+	// ErrBadPartial is returned for a partial decryption whose proof of
+	// correct share does not verify.
+	ErrBadPartial = errors.New("threshold: invalid partial decryption")
+
+	// ErrNotEnoughShares is returned when fewer than t valid partial
+	// decryptions from distinct parties are available.
+	ErrNotEnoughShares = errors.New("threshold: not enough partial decryptions")
+
+	// ErrMalformedCiphertext is returned for a ciphertext that cannot be
+	// parsed or whose C1 is not a point of the curve.
+	ErrMalformedCiphertext = errors.New("threshold: malformed ciphertext")
+
+	// ErrDecryption is returned when the combined decryption of an
+	// sm2.Encrypt ciphertext fails its C3 check.
+	ErrDecryption = errors.New("threshold: decryption failed")
+
+	// ErrBadNonce is returned for a nonce point that does not match the
+	// commitment its signer sent in the first round, or that is not a
+	// point of the curve.
+	ErrBadNonce = errors.New("threshold: nonce does not match commitment")
+
+	// ErrBadPartialSignature is returned for a partial signature that does
+	// not match the signer's nonce and verification key.
+	ErrBadPartialSignature = errors.New("threshold: invalid partial signature")
+
+	// ErrSession is returned when a signing session is used out of order:
+	// before every signer's commitment or nonce is in, for a second
+	// signature, or with a signer set that is not valid for the key.
+	ErrSession = errors.New("threshold: signing session out of order")
+
+	// ErrSetup is returned when the inversion step of the dealerless
+	// signing key generation does not produce a consistent key.
+	ErrSetup = errors.New("threshold: signing key setup failed")
+)
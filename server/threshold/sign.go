@@ -0,0 +1,439 @@
+package threshold
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+
+	bullet "server/bulletproof/src"
+	"server/utils"
+
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+// NonceCommitment is a signer's first-round message, a hash of the nonce
+// point it reveals in the second round.
+type NonceCommitment struct {
+	ID     int
+	Digest []byte
+}
+
+// NonceReveal is a signer's second-round message, its nonce point a_i*H.
+type NonceReveal struct {
+	ID int
+	A  bullet.ECPoint
+}
+
+// PartialSignature is signer ID's share s_i = a_i + c*l_i*w_i of the
+// response to the challenge c.
+type PartialSignature struct {
+	ID int
+	S  *big.Int
+}
+
+/*
+Signer runs one co-owner's side of a signing session among the parties in
+signers, at least T of them. The steps are
+
+	c := s.Commitment()            // broadcast
+	err := s.ReceiveCommitment(c_j) // from every other signer
+	r, err := s.Reveal()           // broadcast once every commitment is in
+	err := s.ReceiveReveal(r_j)    // from every other signer
+	ps, err := s.SignSM2(msg)      // or s.SignRing(pubs, msg)
+
+and anyone combines the partial signatures with SigningGroup.CombineSM2 or
+CombineRing. Committing to the nonces first keeps the last signer from
+choosing its nonce after seeing the others. A Signer answers one challenge
+only; every signature needs a new session.
+*/
+type Signer struct {
+	SigningShare
+	signers []int
+	a       *big.Int
+	A       bullet.ECPoint
+	commits map[int][]byte
+	nonces  map[int]bullet.ECPoint
+}
+
+// NewSigner starts a signing session of ss among signers, which must
+// include ss.ID.
+func (ss SigningShare) NewSigner(signers []int) (*Signer, error) {
+	ids, err := ss.checkSigners(signers)
+	if err != nil {
+		return nil, err
+	}
+	s := &Signer{
+		SigningShare: ss,
+		signers:      ids,
+		commits:      make(map[int][]byte),
+		nonces:       make(map[int]bullet.ECPoint),
+	}
+	if !s.isSigner(ss.ID) {
+		return nil, fmt.Errorf("%w: party %d is not a signer", ErrSession, ss.ID)
+	}
+	if s.a, err = randScalar(); err != nil {
+		return nil, err
+	}
+	s.A = group().Mult(ss.base(), s.a)
+	s.commits[ss.ID] = nonceDigest(ss.ID, s.A)
+	s.nonces[ss.ID] = s.A
+	return s, nil
+}
+
+// Commitment returns s's first-round message.
+func (s *Signer) Commitment() NonceCommitment {
+	return NonceCommitment{s.ID, s.commits[s.ID]}
+}
+
+// ReceiveCommitment keeps another signer's first-round message.
+func (s *Signer) ReceiveCommitment(c NonceCommitment) error {
+	if !s.isSigner(c.ID) || len(c.Digest) != sm3.DigestLength {
+		return fmt.Errorf("%w: commitment from party %d", ErrSession, c.ID)
+	}
+	if _, ok := s.commits[c.ID]; ok {
+		return fmt.Errorf("%w: second commitment from party %d", ErrSession, c.ID)
+	}
+	s.commits[c.ID] = c.Digest
+	return nil
+}
+
+// Reveal returns s's nonce point once it holds every signer's commitment.
+func (s *Signer) Reveal() (NonceReveal, error) {
+	if len(s.commits) != len(s.signers) {
+		return NonceReveal{}, fmt.Errorf("%w: %d of %d commitments", ErrSession, len(s.commits), len(s.signers))
+	}
+	return NonceReveal{s.ID, s.A}, nil
+}
+
+// ReceiveReveal checks another signer's nonce point against its
+// commitment.
+func (s *Signer) ReceiveReveal(r NonceReveal) error {
+	digest, ok := s.commits[r.ID]
+	if !ok {
+		return fmt.Errorf("%w: nonce from party %d before its commitment", ErrSession, r.ID)
+	}
+	if err := checkNonce(r); err != nil {
+		return err
+	}
+	if !bytes.Equal(digest, nonceDigest(r.ID, r.A)) {
+		return fmt.Errorf("%w: from party %d", ErrBadNonce, r.ID)
+	}
+	s.nonces[r.ID] = r.A
+	return nil
+}
+
+// SignSM2 returns s's partial SM2 signature of msg under the group key.
+func (s *Signer) SignSM2(msg []byte) (PartialSignature, error) {
+	R, err := s.noncePoint()
+	if err != nil {
+		return PartialSignature{}, err
+	}
+	r, err := s.sm2Challenge(R, msg)
+	if err != nil {
+		return PartialSignature{}, err
+	}
+	return s.respond(r)
+}
+
+// SignRing returns s's partial ring signature of msg over pubs, which must
+// contain the group key. Which co-owners signed, and that the group signed
+// at all rather than another member of the ring, stays hidden.
+func (s *Signer) SignRing(pubs []*sm2.PublicKey, msg []byte) (PartialSignature, error) {
+	if _, err := s.noncePoint(); err != nil {
+		return PartialSignature{}, err
+	}
+	c, _, err := s.ringChallenge(pubs, msg, s.reveals())
+	if err != nil {
+		return PartialSignature{}, err
+	}
+	return s.respond(c)
+}
+
+// respond returns s_i = a_i + c*l_i*w_i and forgets a_i, so the nonce
+// cannot answer a second challenge.
+func (s *Signer) respond(c *big.Int) (PartialSignature, error) {
+	if s.a == nil {
+		return PartialSignature{}, fmt.Errorf("%w: nonce already used", ErrSession)
+	}
+	N := group().N
+	si := new(big.Int).Mul(c, lagrange(s.signers, s.ID, N))
+	si.Mul(si, s.W)
+	si.Add(si, s.a)
+	si.Mod(si, N)
+	s.a = nil
+	return PartialSignature{s.ID, si}, nil
+}
+
+func (s *Signer) isSigner(id int) bool {
+	i := sort.SearchInts(s.signers, id)
+	return i < len(s.signers) && s.signers[i] == id
+}
+
+func (s *Signer) noncePoint() (bullet.ECPoint, error) {
+	if len(s.nonces) != len(s.signers) {
+		return bullet.ECPoint{}, fmt.Errorf("%w: %d of %d nonces", ErrSession, len(s.nonces), len(s.signers))
+	}
+	_, R, err := s.session(s.reveals())
+	return R, err
+}
+
+func (s *Signer) reveals() []NonceReveal {
+	out := make([]NonceReveal, 0, len(s.nonces))
+	for id, A := range s.nonces {
+		out = append(out, NonceReveal{id, A})
+	}
+	return out
+}
+
+/*
+CombineSM2 checks every partial signature against its signer's nonce and
+verification key and returns the SM2 signature of msg, in the encoding of
+sm2.Sign, which sm2.Verify accepts under the group key with the UID derived
+from its wallet address. All signers of the session must answer; an
+invalid part is reported with ErrBadPartialSignature naming its party.
+*/
+func (g SigningGroup) CombineSM2(msg []byte, reveals []NonceReveal, parts []PartialSignature) ([]byte, error) {
+	_, R, err := g.session(reveals)
+	if err != nil {
+		return nil, err
+	}
+	r, err := g.sm2Challenge(R, msg)
+	if err != nil {
+		return nil, err
+	}
+	s, err := g.combine(reveals, parts, r)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sm2.MarshalSign(r, s)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := utils.UIDOf(g.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	if !sm2.Verify(g.PublicKey(), uid, msg, sig) {
+		return nil, fmt.Errorf("%w: combined signature does not verify", ErrBadPartialSignature)
+	}
+	return sig, nil
+}
+
+// CombineRing is CombineSM2 for SignRing and returns the ring signature in
+// the form of utils.Sign, which utils.Verify accepts over pubs.
+func (g SigningGroup) CombineRing(pubs []*sm2.PublicKey, msg []byte, reveals []NonceReveal, parts []PartialSignature) ([]*big.Int, error) {
+	c, sig, err := g.ringChallenge(pubs, msg, reveals)
+	if err != nil {
+		return nil, err
+	}
+	s, err := g.combine(reveals, parts, c)
+	if err != nil {
+		return nil, err
+	}
+	pai, _ := g.ringPosition(pubs)
+	sig[pai+1] = s
+	if !utils.Verify(pubs, msg, sig) {
+		return nil, fmt.Errorf("%w: combined ring signature does not verify", ErrBadPartialSignature)
+	}
+	return sig, nil
+}
+
+// combine verifies parts against the challenge c and returns
+// s = sum s_i - c.
+func (g SigningGroup) combine(reveals []NonceReveal, parts []PartialSignature, c *big.Int) (*big.Int, error) {
+	nonces, _, err := g.session(reveals)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) != len(nonces) {
+		return nil, fmt.Errorf("%w: %d partial signatures for %d signers", ErrNotEnoughShares, len(parts), len(nonces))
+	}
+	ids := make([]int, 0, len(nonces))
+	for id := range nonces {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	ec := group()
+	H := g.base()
+	s := new(big.Int).Neg(c)
+	seen := make(map[int]bool)
+	for _, ps := range parts {
+		A, ok := nonces[ps.ID]
+		if !ok || seen[ps.ID] {
+			return nil, fmt.Errorf("%w: unexpected part from party %d", ErrBadPartialSignature, ps.ID)
+		}
+		seen[ps.ID] = true
+		if ps.S == nil || ps.S.Sign() < 0 || ps.S.Cmp(ec.N) >= 0 {
+			return nil, fmt.Errorf("%w: scalar of party %d out of range", ErrBadPartialSignature, ps.ID)
+		}
+		// s_i*H == A_i + c*l_i*(w_i*H)
+		cl := new(big.Int).Mul(c, lagrange(ids, ps.ID, ec.N))
+		if !ec.Mult(H, ps.S).Equal(ec.Add(A, ec.Mult(g.Shares[ps.ID-1], cl))) {
+			return nil, fmt.Errorf("%w: from party %d", ErrBadPartialSignature, ps.ID)
+		}
+		s.Add(s, ps.S)
+	}
+	return s.Mod(s, ec.N), nil
+}
+
+// session checks the nonces of a signing session and returns them by
+// party with their sum R = a*H.
+func (g SigningGroup) session(reveals []NonceReveal) (map[int]bullet.ECPoint, bullet.ECPoint, error) {
+	ids := make([]int, len(reveals))
+	nonces := make(map[int]bullet.ECPoint, len(reveals))
+	for i, r := range reveals {
+		if err := checkNonce(r); err != nil {
+			return nil, bullet.ECPoint{}, err
+		}
+		ids[i] = r.ID
+		nonces[r.ID] = r.A
+	}
+	if _, err := g.checkSigners(ids); err != nil {
+		return nil, bullet.ECPoint{}, err
+	}
+	ec := group()
+	var R bullet.ECPoint
+	for i, r := range reveals {
+		if i == 0 {
+			R = r.A
+		} else {
+			R = ec.Add(R, r.A)
+		}
+	}
+	if R.IsZero() {
+		return nil, bullet.ECPoint{}, fmt.Errorf("%w: nonces sum to the identity, start a new session", ErrSession)
+	}
+	return nonces, R, nil
+}
+
+// sm2Challenge returns r = e + x(R) mod N for e = SM3(ZA || msg), with the
+// UID of the group key derived from its wallet address.
+func (g SigningGroup) sm2Challenge(R bullet.ECPoint, msg []byte) (*big.Int, error) {
+	pub := g.PublicKey()
+	uid, err := utils.UIDOf(pub)
+	if err != nil {
+		return nil, err
+	}
+	za, err := utils.CalculateZA(pub, uid)
+	if err != nil {
+		return nil, err
+	}
+	h := sm3.New()
+	h.Write(za)
+	if len(msg) > 0 {
+		h.Write(msg)
+	}
+	ec := group()
+	r := new(big.Int).SetBytes(h.Sum(nil))
+	r.Add(r, R.X)
+	r.Mod(r, ec.N)
+	// as in sm2.Sign: r != 0 and r + k != N, i.e. R + r*G is not the identity
+	if r.Sign() == 0 || ec.Add(R, ec.Mult(basePoint(), r)).IsZero() {
+		return nil, fmt.Errorf("%w: degenerate nonce, start a new session", ErrSession)
+	}
+	return r, nil
+}
+
+/*
+ringChallenge runs the public part of the ring signature from R. The
+responses of the other ring members are drawn from a stream seeded by the
+session's nonces and the message, so every signer computes the same
+challenge for its position without trusting a coordinator.
+*/
+func (g SigningGroup) ringChallenge(pubs []*sm2.PublicKey, msg []byte, reveals []NonceReveal) (*big.Int, []*big.Int, error) {
+	pai, err := g.ringPosition(pubs)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, R, err := g.session(reveals)
+	if err != nil {
+		return nil, nil, err
+	}
+	sorted := append([]NonceReveal(nil), reveals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	t := bullet.NewTranscript("threshold/ring-decoys")
+	for _, r := range sorted {
+		t.AppendScalar("id", big.NewInt(int64(r.ID)))
+		t.AppendPoint("A", r.A)
+	}
+	t.AppendMessage("msg", msg)
+	seed := t.ChallengeScalar("seed", group().N)
+	return utils.RingChallenge(newStream(seed.Bytes()), utils.SimpleParticipantRandInt, pubs, pai, msg, R.X, R.Y)
+}
+
+func (g SigningGroup) ringPosition(pubs []*sm2.PublicKey) (int, error) {
+	for i, pub := range pubs {
+		if pub != nil && pub.X != nil && pub.Y != nil && pub.X.Cmp(g.P.X) == 0 && pub.Y.Cmp(g.P.Y) == 0 {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: the group key is not in the ring", ErrBadParams)
+}
+
+// checkSigners returns signers sorted after checking they are at least T
+// distinct parties of the group.
+func (g SigningGroup) checkSigners(signers []int) ([]int, error) {
+	ids := append([]int(nil), signers...)
+	sort.Ints(ids)
+	for i, id := range ids {
+		if id < 1 || id > g.N || (i > 0 && ids[i-1] == id) {
+			return nil, fmt.Errorf("%w: signer %d of %d", ErrSession, id, g.N)
+		}
+	}
+	if len(ids) < g.T {
+		return nil, fmt.Errorf("%w: %d signers of %d needed", ErrNotEnoughShares, len(ids), g.T)
+	}
+	return ids, nil
+}
+
+func checkNonce(r NonceReveal) error {
+	if r.A.X == nil || r.A.Y == nil || !group().C.IsOnCurve(r.A.X, r.A.Y) {
+		return fmt.Errorf("%w: nonce of party %d not on curve", ErrBadNonce, r.ID)
+	}
+	return nil
+}
+
+func nonceDigest(id int, A bullet.ECPoint) []byte {
+	enc, _ := group().EncodePoint(A)
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(id))
+	h := sm3.New()
+	h.Write([]byte("threshold/nonce"))
+	h.Write(b[:])
+	h.Write(enc)
+	return h.Sum(nil)
+}
+
+// stream is a deterministic byte stream SM3(seed || counter), used for the
+// decoy responses of a threshold ring signature.
+type stream struct {
+	seed []byte
+	ctr  uint32
+	buf  []byte
+}
+
+func newStream(seed []byte) *stream {
+	return &stream{seed: append([]byte("threshold/stream"), seed...)}
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		if len(s.buf) == 0 {
+			var b [4]byte
+			binary.BigEndian.PutUint32(b[:], s.ctr)
+			s.ctr++
+			h := sm3.New()
+			h.Write(s.seed)
+			h.Write(b[:])
+			s.buf = h.Sum(nil)
+		}
+		c := copy(p[n:], s.buf)
+		s.buf = s.buf[c:]
+		n += c
+	}
+	return len(p), nil
+}
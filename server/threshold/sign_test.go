@@ -0,0 +1,303 @@
+package threshold
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"server/utils"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// runSigningSetup simulates the dealerless signing key setup on top of a
+// joint key generation between n local parties.
+func runSigningSetup(t *testing.T, keys []KeyShare) []SigningShare {
+	setups := make([]*SigningSetup, len(keys))
+	for i, ks := range keys {
+		s, err := NewSigningSetup(ks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setups[i] = s
+	}
+	for _, dealer := range setups {
+		d := dealer.Dealing()
+		for _, s := range setups {
+			g, z, err := dealer.SharesFor(s.ks.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Receive(d, g, z); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	masked := make(map[int]*big.Int)
+	for _, s := range setups {
+		m, err := s.Masked()
+		if err != nil {
+			t.Fatal(err)
+		}
+		masked[s.ks.ID] = m
+	}
+	shares := make([]SigningShare, len(setups))
+	for i, s := range setups {
+		ss, err := s.Finish(masked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shares[i] = ss
+	}
+	return shares
+}
+
+// runSession simulates a signing session between the parties ids, with
+// every commitment and nonce broadcast, and returns the nonces and the
+// partial signatures made by sign.
+func runSession(t *testing.T, shares []SigningShare, ids []int, sign func(*Signer) (PartialSignature, error)) ([]NonceReveal, []PartialSignature) {
+	signers := make([]*Signer, len(ids))
+	for i, id := range ids {
+		s, err := shares[id-1].NewSigner(ids)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers[i] = s
+	}
+	for _, from := range signers {
+		for _, to := range signers {
+			if to != from {
+				if err := to.ReceiveCommitment(from.Commitment()); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	reveals := make([]NonceReveal, len(signers))
+	for i, from := range signers {
+		r, err := from.Reveal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reveals[i] = r
+		for _, to := range signers {
+			if to != from {
+				if err := to.ReceiveReveal(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	parts := make([]PartialSignature, len(signers))
+	for i, s := range signers {
+		ps, err := sign(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[i] = ps
+	}
+	return reveals, parts
+}
+
+func verifySM2(g SigningGroup, msg, sig []byte) bool {
+	uid, err := utils.UIDOf(g.PublicKey())
+	return err == nil && sm2.Verify(g.PublicKey(), uid, msg, sig)
+}
+
+func TestThresholdSignDealt(t *testing.T) {
+	pri, _, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitSigningKey(pri.D, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := shares[0].SigningGroup
+	pub := sm2.CalculatePubKey(pri)
+	if g.P.X.Cmp(pub.X) != 0 || g.P.Y.Cmp(pub.Y) != 0 {
+		t.Fatal("*****Split signing key does not keep the public key")
+	}
+	msg := []byte("Enc_B(m)||Enc_B(b)||OrderNum||Add_A")
+	for _, ids := range [][]int{{1, 2}, {1, 3}, {2, 3}, {1, 2, 3}} {
+		reveals, parts := runSession(t, shares, ids, func(s *Signer) (PartialSignature, error) { return s.SignSM2(msg) })
+		sig, err := g.CombineSM2(msg, reveals, parts)
+		if err != nil {
+			t.Fatalf("*****Signers %v: %v", ids, err)
+		}
+		if !verifySM2(g, msg, sig) {
+			t.Errorf("*****Threshold SM2 signature of signers %v FAILURE", ids)
+		}
+		if verifySM2(g, []byte("another order"), sig) {
+			t.Errorf("*****Threshold SM2 signature of signers %v verified another message", ids)
+		}
+	}
+	fmt.Println("2-of-3 threshold SM2 signatures verify with sm2.Verify")
+}
+
+func TestThresholdSignDealerless(t *testing.T) {
+	keys := runDKG(t, 2, 3)
+	shares := runSigningSetup(t, keys)
+	g := shares[0].SigningGroup
+	if !g.P.Equal(keys[0].Y) {
+		t.Fatal("*****Signing setup changed the joint key")
+	}
+	msg := []byte("jointly owned lot 10000")
+	reveals, parts := runSession(t, shares, []int{3, 1}, func(s *Signer) (PartialSignature, error) { return s.SignSM2(msg) })
+	sig, err := g.CombineSM2(msg, reveals, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifySM2(g, msg, sig) {
+		t.Error("*****Dealerless threshold SM2 signature FAILURE")
+	}
+
+	// the same joint key still decrypts with the decryption shares
+	cipher, err := utils.HomoEncrypt(g.PublicKey(), big.NewInt(7).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := keys[0].DecryptHomo(cipher, partials(t, keys, []int{1, 2}, cipher, nil), nil)
+	if err != nil || new(big.Int).SetBytes(m).Int64() != 7 {
+		t.Errorf("*****Joint key does not decrypt: %v", err)
+	}
+}
+
+func TestThresholdRingSign(t *testing.T) {
+	pri, _, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := SplitSigningKey(pri.D, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := shares[0].SigningGroup
+	pubs := []*sm2.PublicKey{}
+	for i := 0; i < 4; i++ {
+		_, pub, err := sm2.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubs = append(pubs, pub)
+	}
+	pubs = append(pubs[:2], append([]*sm2.PublicKey{g.PublicKey()}, pubs[2:]...)...)
+	msg := []byte("Add_A||Add_B||OrderNum||Sign_B")
+
+	reveals, parts := runSession(t, shares, []int{1, 3}, func(s *Signer) (PartialSignature, error) { return s.SignRing(pubs, msg) })
+	sig, err := g.CombineRing(pubs, msg, reveals, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utils.Verify(pubs, msg, sig) {
+		t.Error("*****Threshold ring signature FAILURE")
+	}
+	if utils.Verify(pubs, []byte("changed"), sig) {
+		t.Error("*****Threshold ring signature verified another message")
+	}
+	if _, err := g.CombineRing(pubs[:2], msg, reveals, parts); !errors.Is(err, ErrBadParams) {
+		t.Errorf("*****Ring without the group key: got %v, want ErrBadParams", err)
+	}
+}
+
+func TestThresholdSignBadPartial(t *testing.T) {
+	pri, _, _ := sm2.GenerateKey(rand.Reader)
+	shares, err := SplitSigningKey(pri.D, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := shares[0].SigningGroup
+	msg := []byte("m")
+	reveals, parts := runSession(t, shares, []int{1, 2}, func(s *Signer) (PartialSignature, error) { return s.SignSM2(msg) })
+
+	bad := append([]PartialSignature(nil), parts...)
+	bad[1].S = new(big.Int).Add(bad[1].S, big.NewInt(1))
+	if _, err := g.CombineSM2(msg, reveals, bad); !errors.Is(err, ErrBadPartialSignature) {
+		t.Errorf("*****Changed partial signature: got %v, want ErrBadPartialSignature", err)
+	}
+	if _, err := g.CombineSM2(msg, reveals, parts[:1]); !errors.Is(err, ErrNotEnoughShares) {
+		t.Errorf("*****Missing partial signature: got %v, want ErrNotEnoughShares", err)
+	}
+	// a partial signature for another message does not combine
+	if _, err := g.CombineSM2([]byte("m2"), reveals, parts); !errors.Is(err, ErrBadPartialSignature) {
+		t.Errorf("*****Partial signatures of another message: got %v, want ErrBadPartialSignature", err)
+	}
+}
+
+func TestThresholdSignSession(t *testing.T) {
+	pri, _, _ := sm2.GenerateKey(rand.Reader)
+	shares, err := SplitSigningKey(pri.D, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shares[0].NewSigner([]int{1}); !errors.Is(err, ErrNotEnoughShares) {
+		t.Errorf("*****One signer of 2: got %v, want ErrNotEnoughShares", err)
+	}
+	if _, err := shares[0].NewSigner([]int{2, 3}); !errors.Is(err, ErrSession) {
+		t.Errorf("*****Party not in the signer set: got %v, want ErrSession", err)
+	}
+	if _, err := shares[0].NewSigner([]int{1, 1}); !errors.Is(err, ErrSession) {
+		t.Errorf("*****Repeated signer: got %v, want ErrSession", err)
+	}
+
+	s1, _ := shares[0].NewSigner([]int{1, 2})
+	s2, _ := shares[1].NewSigner([]int{1, 2})
+	if _, err := s1.Reveal(); !errors.Is(err, ErrSession) {
+		t.Errorf("*****Reveal before every commitment: got %v, want ErrSession", err)
+	}
+	if err := s1.ReceiveCommitment(s2.Commitment()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.ReceiveCommitment(s1.Commitment()); err != nil {
+		t.Fatal(err)
+	}
+	// party 2 reveals a different nonce than it committed to
+	other, _ := shares[1].NewSigner([]int{1, 2})
+	if err := s1.ReceiveReveal(NonceReveal{2, other.A}); !errors.Is(err, ErrBadNonce) {
+		t.Errorf("*****Nonce not matching its commitment: got %v, want ErrBadNonce", err)
+	}
+	r2, _ := s2.Reveal()
+	if err := s1.ReceiveReveal(r2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s1.SignSM2([]byte("m")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s1.SignSM2([]byte("m2")); !errors.Is(err, ErrSession) {
+		t.Errorf("*****Second signature with one nonce: got %v, want ErrSession", err)
+	}
+}
+
+func TestSigningSetup(t *testing.T) {
+	keys := runDKG(t, 2, 2)
+	if _, err := NewSigningSetup(keys[0]); !errors.Is(err, ErrBadParams) {
+		t.Errorf("*****2-of-2 setup without a dealer: got %v, want ErrBadParams", err)
+	}
+
+	keys = runDKG(t, 2, 3)
+	setups := make([]*SigningSetup, 3)
+	for i := range setups {
+		setups[i], _ = NewSigningSetup(keys[i])
+	}
+	for _, dealer := range setups {
+		for _, s := range setups {
+			g, z, _ := dealer.SharesFor(s.ks.ID)
+			if err := s.Receive(dealer.Dealing(), g, z); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	masked := make(map[int]*big.Int)
+	for _, s := range setups {
+		masked[s.ks.ID], _ = s.Masked()
+	}
+	if _, err := setups[0].Finish(map[int]*big.Int{1: masked[1], 2: masked[2]}); !errors.Is(err, ErrNotEnoughShares) {
+		t.Errorf("*****Finish with 2 of 3 masked values: got %v, want ErrNotEnoughShares", err)
+	}
+	masked[2] = new(big.Int).Add(masked[2], big.NewInt(1))
+	if _, err := setups[0].Finish(masked); !errors.Is(err, ErrSetup) {
+		t.Errorf("*****Wrong masked value: got %v, want ErrSetup", err)
+	}
+}
@@ -0,0 +1,265 @@
+package threshold
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	bullet "server/bulletproof/src"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+/*
+An SM2 signature with key d is
+
+	r = e + x(k*G),  s = (1+d)^-1 * (k - r*d)
+
+which is not linear in d. With w = (1+d)^-1 and the nonce written as
+k = a * w^-1 for a random a, it becomes
+
+	k*G = a*H  where H = w^-1*G = P + G
+	s   = w*(k + r) - r = a + r*w - r
+
+so given shares w_i of w and an additive nonce a = sum a_i among the
+signers, both the nonce point and s are linear in what each signer holds:
+signer i publishes a_i*H and later s_i = a_i + r*l_i*w_i, where l_i is its
+Lagrange coefficient in the signing set, and s = sum s_i - r. A partial
+signature is checked by s_i*H == a_i*H + r*l_i*(w_i*H), so the group key
+carries the verification keys w_i*H rather than x_i*G.
+
+The position of the real signer in a ring signature is answered the same
+way, (1+d)^-1 * (k - c*d) for the ring challenge c, which gives the
+threshold ring signatures of SignRing.
+*/
+
+// SigningGroup is the public part of a t-of-n signing key: the joint key
+// P = d*G and the verification key w_i*H of every party's share of w.
+// Parties are numbered 1..N and Shares[i-1] belongs to party i.
+type SigningGroup struct {
+	T, N   int
+	P      bullet.ECPoint
+	Shares []bullet.ECPoint
+}
+
+// PublicKey returns the joint key, which verifies the signatures of the
+// group with sm2.Verify and is registered like any other wallet key.
+func (g SigningGroup) PublicKey() *sm2.PublicKey {
+	return &sm2.PublicKey{X: g.P.X, Y: g.P.Y, Curve: sm2.GetSm2P256V1()}
+}
+
+// base returns H = P + G = (1+d)*G, the base nonces and shares of w are
+// committed on.
+func (g SigningGroup) base() bullet.ECPoint {
+	return group().Add(g.P, basePoint())
+}
+
+// SigningShare is one party's secret share of w = (1+d)^-1.
+type SigningShare struct {
+	ID int
+	W  *big.Int
+	SigningGroup
+}
+
+/*
+SplitSigningKey deals the signing key of an existing SM2 key d to n parties
+with threshold t. As with Split the dealer knows d, so it is only meant for
+moving a key that already exists, such as a lot owner's, to its co-owners,
+and the dealer must delete d afterwards.
+*/
+func SplitSigningKey(d *big.Int, t, n int) ([]SigningShare, error) {
+	if err := checkParams(t, n); err != nil {
+		return nil, err
+	}
+	ec := group()
+	// 1+d must be invertible, so d = N-1 is out of range as for sm2 keys
+	if d == nil || d.Sign() <= 0 || new(big.Int).Add(d, big.NewInt(1)).Cmp(ec.N) >= 0 {
+		return nil, fmt.Errorf("%w: key out of range", ErrBadParams)
+	}
+	w := new(big.Int).Add(d, big.NewInt(1))
+	w.ModInverse(w, ec.N)
+	poly, err := randomPoly(w, t)
+	if err != nil {
+		return nil, err
+	}
+	g := SigningGroup{T: t, N: n, P: ec.Mult(basePoint(), d)}
+	comms := commitPolyOn(g.base(), poly)
+	g.Shares = make([]bullet.ECPoint, n)
+	for j := 1; j <= n; j++ {
+		g.Shares[j-1] = evalCommitments(comms, j)
+	}
+	shares := make([]SigningShare, n)
+	for j := 1; j <= n; j++ {
+		shares[j-1] = SigningShare{j, evalPoly(poly, j), g}
+	}
+	return shares, nil
+}
+
+/*
+SigningSetup turns a key share from the joint key generation into a share
+of w = (1+d)^-1 without any party learning d or w. Every party deals a
+random gamma of degree t-1, committed on H, and a random sharing of zero of
+degree 2t-2, then broadcasts
+
+	delta_i = (1+d_i)*gamma_i + zeta_i
+
+These lie on a polynomial of degree 2t-2 with constant term
+delta = (1+d)*gamma, so 2t-1 of them give delta and w_i = gamma_i/delta is a
+degree t-1 sharing of w; the zero sharing hides everything about the
+products but delta. This needs n >= 2t-1 parties taking part. The steps are
+
+	d := s.Dealing()                 // broadcast
+	g, z, _ := s.SharesFor(j)        // send privately to party j, for every j
+	err := s.Receive(d_i, g_i, z_i)  // for every dealing and shares received
+	delta_i, err := s.Masked()       // broadcast
+	ss, err := s.Finish(deltas)
+
+Finish checks w*H == G, which fails unless every party used its real
+shares; the setup must then be run again.
+*/
+type SigningSetup struct {
+	ks       KeyShare
+	base     bullet.ECPoint
+	gamma    []*big.Int
+	zeta     []*big.Int
+	dealings map[int]Dealing
+	gammas   map[int]*big.Int
+	zetas    map[int]*big.Int
+}
+
+// NewSigningSetup starts the signing key setup for the key share ks.
+func NewSigningSetup(ks KeyShare) (*SigningSetup, error) {
+	if 2*ks.T-1 > ks.N {
+		return nil, fmt.Errorf("%w: %d of %d cannot invert without a dealer, need n >= 2t-1", ErrBadParams, ks.T, ks.N)
+	}
+	gamma, err := randomPoly(nil, ks.T)
+	if err != nil {
+		return nil, err
+	}
+	zeta, err := randomPoly(new(big.Int), 2*ks.T-1)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningSetup{
+		ks:       ks,
+		base:     group().Add(ks.Y, basePoint()),
+		gamma:    gamma,
+		zeta:     zeta,
+		dealings: make(map[int]Dealing),
+		gammas:   make(map[int]*big.Int),
+		zetas:    make(map[int]*big.Int),
+	}, nil
+}
+
+// Dealing returns the commitments gamma_k*H to s's gamma polynomial.
+func (s *SigningSetup) Dealing() Dealing {
+	return Dealing{s.ks.ID, commitPolyOn(s.base, s.gamma)}
+}
+
+// SharesFor returns s's gamma and zero shares for party j, which must be
+// sent to j over a private channel.
+func (s *SigningSetup) SharesFor(j int) (*big.Int, *big.Int, error) {
+	if j < 1 || j > s.ks.N {
+		return nil, nil, fmt.Errorf("%w: party %d of %d", ErrBadParams, j, s.ks.N)
+	}
+	return evalPoly(s.gamma, j), evalPoly(s.zeta, j), nil
+}
+
+// Receive checks the gamma share dealt to s against d and keeps both
+// shares.
+func (s *SigningSetup) Receive(d Dealing, gamma, zeta *big.Int) error {
+	if d.From < 1 || d.From > s.ks.N {
+		return fmt.Errorf("%w: dealer %d of %d", ErrBadParams, d.From, s.ks.N)
+	}
+	if len(d.Commitments) != s.ks.T {
+		return fmt.Errorf("%w: dealer %d committed to %d coefficients, want %d",
+			ErrBadShare, d.From, len(d.Commitments), s.ks.T)
+	}
+	if err := verifyShareOn(s.base, d, s.ks.ID, gamma); err != nil {
+		return err
+	}
+	if zeta == nil || zeta.Sign() < 0 || zeta.Cmp(group().N) >= 0 {
+		return fmt.Errorf("%w: share from %d out of range", ErrBadShare, d.From)
+	}
+	s.dealings[d.From] = d
+	s.gammas[d.From] = gamma
+	s.zetas[d.From] = zeta
+	return nil
+}
+
+// Masked returns delta_i once s holds shares from every dealer.
+func (s *SigningSetup) Masked() (*big.Int, error) {
+	for i := 1; i <= s.ks.N; i++ {
+		if _, ok := s.dealings[i]; !ok {
+			return nil, fmt.Errorf("%w: from party %d", ErrMissingDealing, i)
+		}
+	}
+	N := group().N
+	delta := new(big.Int).Add(s.ks.X, big.NewInt(1))
+	delta.Mul(delta, s.sum(s.gammas))
+	delta.Add(delta, s.sum(s.zetas))
+	return delta.Mod(delta, N), nil
+}
+
+/*
+Finish interpolates delta from the 2t-1 lowest numbered masked values, so
+every party uses the same ones, and returns s's share of w with the
+verification keys of all parties.
+*/
+func (s *SigningSetup) Finish(masked map[int]*big.Int) (SigningShare, error) {
+	if len(s.dealings) != s.ks.N {
+		return SigningShare{}, fmt.Errorf("%w: setup not finished", ErrMissingDealing)
+	}
+	ec := group()
+	ids := make([]int, 0, len(masked))
+	for id, v := range masked {
+		if id < 1 || id > s.ks.N || v == nil {
+			return SigningShare{}, fmt.Errorf("%w: masked value of party %d", ErrBadParams, id)
+		}
+		ids = append(ids, id)
+	}
+	need := 2*s.ks.T - 1
+	if len(ids) < need {
+		return SigningShare{}, fmt.Errorf("%w: %d masked values of %d needed", ErrNotEnoughShares, len(ids), need)
+	}
+	sort.Ints(ids)
+	ids = ids[:need]
+	delta := new(big.Int)
+	for _, id := range ids {
+		delta.Add(delta, new(big.Int).Mul(masked[id], lagrange(ids, id, ec.N)))
+	}
+	delta.Mod(delta, ec.N)
+	if delta.Sign() == 0 {
+		return SigningShare{}, fmt.Errorf("%w: delta is zero", ErrSetup)
+	}
+	inv := new(big.Int).ModInverse(delta, ec.N)
+
+	comms := make([]bullet.ECPoint, s.ks.T)
+	for i := 1; i <= s.ks.N; i++ {
+		for k, c := range s.dealings[i].Commitments {
+			if i == 1 {
+				comms[k] = c
+			} else {
+				comms[k] = ec.Add(comms[k], c)
+			}
+		}
+	}
+	// w*H = gamma*H / delta must be G
+	if !ec.Mult(comms[0], inv).Equal(basePoint()) {
+		return SigningShare{}, fmt.Errorf("%w: w*H != G", ErrSetup)
+	}
+	g := SigningGroup{T: s.ks.T, N: s.ks.N, P: s.ks.Y, Shares: make([]bullet.ECPoint, s.ks.N)}
+	for j := 1; j <= s.ks.N; j++ {
+		g.Shares[j-1] = ec.Mult(evalCommitments(comms, j), inv)
+	}
+	w := new(big.Int).Mul(s.sum(s.gammas), inv)
+	return SigningShare{s.ks.ID, w.Mod(w, ec.N), g}, nil
+}
+
+func (s *SigningSetup) sum(shares map[int]*big.Int) *big.Int {
+	x := new(big.Int)
+	for _, v := range shares {
+		x.Add(x, v)
+	}
+	return x.Mod(x, group().N)
+}
@@ -0,0 +1,237 @@
+package threshold
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"server/utils"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// runDKG simulates a t-of-n key generation between n local parties, with
+// every dealing broadcast and every share delivered to its receiver.
+func runDKG(t *testing.T, thr, n int) []KeyShare {
+	parties := make([]*Participant, n)
+	for i := range parties {
+		p, err := NewParticipant(i+1, thr, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parties[i] = p
+	}
+	for _, dealer := range parties {
+		d := dealer.Dealing()
+		for _, p := range parties {
+			s, err := dealer.ShareFor(p.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Receive(d, s); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	shares := make([]KeyShare, n)
+	for i, p := range parties {
+		ks, err := p.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		shares[i] = ks
+	}
+	return shares
+}
+
+func partials(t *testing.T, shares []KeyShare, ids []int, cipher, context []byte) []PartialDecryption {
+	parts := make([]PartialDecryption, len(ids))
+	for i, id := range ids {
+		pd, err := shares[id-1].PartialDecrypt(cipher, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[i] = pd
+	}
+	return parts
+}
+
+func TestDKGAgreesOnKey(t *testing.T) {
+	shares := runDKG(t, 3, 5)
+	ec := group()
+	for _, ks := range shares[1:] {
+		if !ks.Y.Equal(shares[0].Y) {
+			t.Fatal("*****Parties disagree on the joint key")
+		}
+	}
+	for _, ks := range shares {
+		if !ec.Mult(basePoint(), ks.X).Equal(ks.Shares[ks.ID-1]) {
+			t.Error("*****Verification key does not match share of party", ks.ID)
+		}
+	}
+}
+
+func TestThresholdDecryptHomo(t *testing.T) {
+	shares := runDKG(t, 3, 5)
+	gk := shares[0].GroupKey
+	pub := gk.PublicKey()
+
+	c1, err := utils.HomoEncrypt(pub, big.NewInt(7).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := utils.HomoEncrypt(pub, big.NewInt(5).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := utils.CiperAdd(sm2.GetSm2P256V1(), c1, c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	context := []byte("order-1")
+	for _, ids := range [][]int{{1, 2, 3}, {2, 4, 5}, {5, 3, 1, 4}} {
+		m, err := gk.DecryptHomo(sum, partials(t, shares, ids, sum, context), context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if new(big.Int).SetBytes(m).Int64() != 12 {
+			t.Error("*****Threshold decryption of a homomorphic sum FAILURE with parties", ids)
+		}
+	}
+	fmt.Println("3-of-5 threshold decryption of a homomorphic sum works")
+}
+
+func TestThresholdDecryptSM2(t *testing.T) {
+	shares := runDKG(t, 2, 3)
+	gk := shares[0].GroupKey
+	address := []byte("8b1a9953c4611296a827abf8c47804d7")
+
+	cipher, err := sm2.Encrypt(gk.PublicKey(), address, sm2.C1C3C2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := []byte("order-1")
+	got, err := gk.DecryptSM2(cipher, partials(t, shares, []int{3, 1}, cipher, context), context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(address) {
+		t.Error("*****Threshold decryption of an address FAILURE")
+	}
+
+	cipher[len(cipher)-1] ^= 1
+	if _, err := gk.DecryptSM2(cipher, partials(t, shares, []int{1, 2}, cipher, context), context); !errors.Is(err, ErrDecryption) {
+		t.Error("*****Threshold decryption accepted a tampered ciphertext:", err)
+	}
+}
+
+func TestThresholdNotEnoughShares(t *testing.T) {
+	shares := runDKG(t, 3, 4)
+	gk := shares[0].GroupKey
+	cipher, err := sm2.Encrypt(gk.PublicKey(), []byte("address"), sm2.C1C3C2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	context := []byte("order-1")
+
+	// t-1 parties, even with one of them repeated, learn nothing
+	parts := partials(t, shares, []int{1, 2, 2}, cipher, context)
+	if _, err := gk.DecryptSM2(cipher, parts, context); !errors.Is(err, ErrNotEnoughShares) {
+		t.Error("*****Decryption with t-1 parties gave", err)
+	}
+
+	// a node that lies about its share is caught and skipped
+	parts = partials(t, shares, []int{1, 2, 3, 4}, cipher, context)
+	parts[0].D = group().Add(parts[0].D, basePoint())
+	if err := gk.VerifyPartial(cipher, parts[0], context); !errors.Is(err, ErrBadPartial) {
+		t.Error("*****Wrong partial decryption verified:", err)
+	}
+	if _, err := gk.DecryptSM2(cipher, parts, context); err != nil {
+		t.Error("*****Decryption did not skip the bad share:", err)
+	}
+	if _, err := gk.DecryptSM2(cipher, parts[:3], context); !errors.Is(err, ErrNotEnoughShares) {
+		t.Error("*****Decryption used a bad share:", err)
+	}
+
+	// shares released for one audit cannot be used for another
+	parts = partials(t, shares, []int{1, 2, 3}, cipher, context)
+	if _, err := gk.DecryptSM2(cipher, parts, []byte("order-2")); !errors.Is(err, ErrNotEnoughShares) {
+		t.Error("*****Partial decryptions replayed under another context:", err)
+	}
+}
+
+func TestDKGBadShare(t *testing.T) {
+	a, _ := NewParticipant(1, 2, 3)
+	b, _ := NewParticipant(2, 2, 3)
+
+	s, _ := a.ShareFor(2)
+	bad := new(big.Int).Add(s, big.NewInt(1))
+	if err := b.Receive(a.Dealing(), bad); !errors.Is(err, ErrBadShare) {
+		t.Error("*****Bad share accepted:", err)
+	}
+	if err := b.Receive(a.Dealing(), s); err != nil {
+		t.Error("*****Good share rejected:", err)
+	}
+	if _, err := b.Finish(); !errors.Is(err, ErrMissingDealing) {
+		t.Error("*****Key generation finished without every dealing:", err)
+	}
+	if _, err := NewParticipant(1, 4, 3); !errors.Is(err, ErrBadParams) {
+		t.Error("*****Threshold above n accepted:", err)
+	}
+}
+
+func TestSplitExistingKey(t *testing.T) {
+	pri, pub, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shares, err := Split(pri.D, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gk := shares[0].GroupKey
+	if gk.Y.X.Cmp(pub.X) != 0 || gk.Y.Y.Cmp(pub.Y) != 0 {
+		t.Fatal("*****Split changed the public key")
+	}
+
+	// ciphertexts made under the old CA key stay readable
+	cipher, err := sm2.Encrypt(pub, []byte("address"), sm2.C1C3C2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := gk.DecryptSM2(cipher, partials(t, shares, []int{2, 3}, cipher, nil), nil)
+	if err != nil || string(got) != "address" {
+		t.Error("*****Threshold decryption under a split key FAILURE", err)
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	shares := runDKG(t, 2, 3)
+	gk := shares[0].GroupKey
+	pri, err := Reconstruct(gk, []KeyShare{shares[2], shares[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !group().Mult(basePoint(), pri.D).Equal(gk.Y) {
+		t.Fatal("*****Reconstructed key does not match the joint key")
+	}
+	cipher, _ := utils.HomoEncrypt(gk.PublicKey(), big.NewInt(9).Bytes())
+	if m, err := utils.HomoDecrypt(pri, cipher); err != nil || new(big.Int).SetBytes(m).Int64() != 9 {
+		t.Errorf("*****Reconstructed key does not decrypt: %v", err)
+	}
+
+	if _, err := Reconstruct(gk, shares[:1]); !errors.Is(err, ErrNotEnoughShares) {
+		t.Errorf("*****One share of 2: got %v, want ErrNotEnoughShares", err)
+	}
+	if _, err := Reconstruct(gk, []KeyShare{shares[1], shares[1]}); !errors.Is(err, ErrNotEnoughShares) {
+		t.Errorf("*****Repeated share: got %v, want ErrNotEnoughShares", err)
+	}
+	bad := shares[1]
+	bad.X = new(big.Int).Add(bad.X, big.NewInt(1))
+	if _, err := Reconstruct(gk, []KeyShare{shares[0], bad}); !errors.Is(err, ErrBadShare) {
+		t.Errorf("*****Wrong share: got %v, want ErrBadShare", err)
+	}
+}
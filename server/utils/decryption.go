@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+// DecryptionProofLen 解密证明(c, z)的字节数
+const DecryptionProofLen = 2 * KeyBytes
+
+/*
+ProveDecryption 证明同态密文(C1, C2)在私钥priv下解密为m，不泄露私钥：
+对P = x*G和D = C2 - m*G给出log_G(P) = log_C1(D)的Chaum-Pedersen证明，
+R1 = u*G，R2 = u*C1，c = SM3(context||P||C1||C2||m||R1||R2) mod N，z = u + c*x mod N，
+证明为c||z，各32字节
+*/
+func ProveDecryption(priv *sm2.PrivateKey, cipherText []byte, m int64, context []byte) ([]byte, error) {
+	curve := sm2.GetSm2P256V1()
+	pub := sm2.CalculatePubKey(priv)
+	c1x, c1y, _, _, err := decryptionStatement(curve, cipherText, m)
+	if err != nil {
+		return nil, err
+	}
+	u, err := nextK(rand.Reader, curve.Params().N)
+	if err != nil {
+		return nil, err
+	}
+	r1x, r1y := curve.ScalarBaseMult(u.Bytes())
+	r2x, r2y := curve.ScalarMult(c1x, c1y, u.Bytes())
+	c := decryptionChallenge(curve, context, pub, cipherText, m, r1x, r1y, r2x, r2y)
+	z := new(big.Int).Mul(c, priv.D)
+	z.Add(z, u)
+	z.Mod(z, curve.Params().N)
+	proof := make([]byte, DecryptionProofLen)
+	c.FillBytes(proof[:KeyBytes])
+	z.FillBytes(proof[KeyBytes:])
+	return proof, nil
+}
+
+// VerifyDecryption 验证ProveDecryption给出的证明，即密文cipherText在公钥pub下解密为m
+func VerifyDecryption(pub *sm2.PublicKey, cipherText []byte, m int64, context []byte, proof []byte) error {
+	curve := sm2.GetSm2P256V1()
+	N := curve.Params().N
+	if len(proof) != DecryptionProofLen {
+		return errors.New("malformed decryption proof")
+	}
+	c := new(big.Int).SetBytes(proof[:KeyBytes])
+	z := new(big.Int).SetBytes(proof[KeyBytes:])
+	if c.Cmp(N) >= 0 || z.Cmp(N) >= 0 {
+		return errors.New("malformed decryption proof")
+	}
+	if pub == nil || pub.X == nil || pub.Y == nil || !curve.IsOnCurve(pub.X, pub.Y) {
+		return errors.New("the public key is not on the sm2 curve")
+	}
+	c1x, c1y, dx, dy, err := decryptionStatement(curve, cipherText, m)
+	if err != nil {
+		return err
+	}
+	// R1 = z*G - c*P，R2 = z*C1 - c*D，用(N-c)代替-c，避免对无穷远点求逆
+	negC := new(big.Int).Sub(N, c).Bytes()
+	zGx, zGy := curve.ScalarBaseMult(z.Bytes())
+	cPx, cPy := curve.ScalarMult(pub.X, pub.Y, negC)
+	r1x, r1y := curve.Add(zGx, zGy, cPx, cPy)
+	zCx, zCy := curve.ScalarMult(c1x, c1y, z.Bytes())
+	cDx, cDy := curve.ScalarMult(dx, dy, negC)
+	r2x, r2y := curve.Add(zCx, zCy, cDx, cDy)
+	if decryptionChallenge(curve, context, pub, cipherText, m, r1x, r1y, r2x, r2y).Cmp(c) != 0 {
+		return errors.New("the decryption proof does not verify")
+	}
+	return nil
+}
+
+// decryptionStatement 解析密文(C1, C2)，返回C1和D = C2 - m*G
+func decryptionStatement(curve sm2.P256V1Curve, cipherText []byte, m int64) (*big.Int, *big.Int, *big.Int, *big.Int, error) {
+	pointLen := 2*KeyBytes + 1
+	if len(cipherText) != 2*pointLen {
+		return nil, nil, nil, nil, errors.New("malformed cipher text")
+	}
+	if m < 0 {
+		return nil, nil, nil, nil, errors.New("the plaintext must not be negative")
+	}
+	c1x, c1y := elliptic.Unmarshal(curve, cipherText[:pointLen])
+	c2x, c2y := elliptic.Unmarshal(curve, cipherText[pointLen:])
+	if c1x == nil || c2x == nil {
+		return nil, nil, nil, nil, errors.New("the cipher text is not on the sm2 curve")
+	}
+	// m = 0时N*G为无穷远点(0, 0)，Add按无穷远点处理
+	negM := new(big.Int).Sub(curve.Params().N, big.NewInt(m)).Bytes()
+	mGx, mGy := curve.ScalarBaseMult(negM)
+	dx, dy := curve.Add(c2x, c2y, mGx, mGy)
+	return c1x, c1y, dx, dy, nil
+}
+
+func decryptionChallenge(curve sm2.P256V1Curve, context []byte, pub *sm2.PublicKey, cipherText []byte, m int64, r1x, r1y, r2x, r2y *big.Int) *big.Int {
+	h := sm3.New()
+	h.Write(context)
+	h.Write(elliptic.Marshal(curve, pub.X, pub.Y))
+	h.Write(cipherText)
+	var mb [8]byte
+	binary.BigEndian.PutUint64(mb[:], uint64(m))
+	h.Write(mb[:])
+	h.Write(elliptic.Marshal(curve, r1x, r1y))
+	h.Write(elliptic.Marshal(curve, r2x, r2y))
+	c := new(big.Int).SetBytes(h.Sum(nil))
+	return c.Mod(c, curve.Params().N)
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+func TestDecryptionProof(t *testing.T) {
+	pris, pubs := testKeys(t, 2)
+	context := []byte("market-0-3600")
+	for _, m := range []int64{0, 1, 1234567} {
+		cipher, err := HomoEncrypt(pubs[0], bigEndian(m))
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := ProveDecryption(pris[0], cipher, m, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyDecryption(pubs[0], cipher, m, context, proof); err != nil {
+			t.Errorf("*****Proof for %d: %v", m, err)
+		}
+		if VerifyDecryption(pubs[0], cipher, m+1, context, proof) == nil {
+			t.Errorf("*****Proof for %d verifies %d", m, m+1)
+		}
+		if VerifyDecryption(pubs[1], cipher, m, context, proof) == nil {
+			t.Errorf("*****Proof for %d verifies under another key", m)
+		}
+		if VerifyDecryption(pubs[0], cipher, m, []byte("market-3600-7200"), proof) == nil {
+			t.Errorf("*****Proof for %d verifies in another context", m)
+		}
+		// a claimed total without the key cannot be proven
+		forged, err := ProveDecryption(pris[1], cipher, m+1, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if VerifyDecryption(pubs[0], cipher, m+1, context, forged) == nil {
+			t.Errorf("*****Forged proof for %d verifies", m+1)
+		}
+	}
+}
+
+func bigEndian(m int64) []byte {
+	b := make([]byte, 8)
+	for i := 7; i >= 0; i-- {
+		b[i] = byte(m)
+		m >>= 8
+	}
+	return b
+}
+
+func testKeys(t *testing.T, n int) ([]*sm2.PrivateKey, []*sm2.PublicKey) {
+	pris := make([]*sm2.PrivateKey, n)
+	pubs := make([]*sm2.PublicKey, n)
+	for i := range pris {
+		pri, pub, err := sm2.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pris[i], pubs[i] = pri, pub
+	}
+	return pris, pubs
+}
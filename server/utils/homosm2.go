@@ -120,6 +120,19 @@ func HomoDecrypt(priv *sm2.PrivateKey, cipherText []byte) ([]byte, error) {
 	}
 }
 
+// RecoverPlaintext 从 [m]G 中恢复 m, 用于门限解密合并份额之后
+func RecoverPlaintext(curve elliptic.Curve, mGx *big.Int, mGy *big.Int) ([]byte, error) {
+	if util.IsEcPointInfinity(mGx, mGy) {
+		return []byte{}, nil
+	}
+	params := curve.Params()
+	res, flag := babyStepGiantStep(curve, params.Gx, params.Gy, mGx, mGy, params.N)
+	if !flag || res == nil {
+		return nil, errors.New("failed to recover plaintext from [m]G")
+	}
+	return res.Bytes(), nil
+}
+
 // babyStepGiantStep 解决椭圆曲线上的离散对数问题 mG = (x, y)
 // G 是基点, N 是椭圆曲线的阶, (x, y) 是椭圆曲线上的一个点
 func babyStepGiantStep(curve elliptic.Curve, gx *big.Int, gy *big.Int, x *big.Int, y *big.Int, N *big.Int) (*big.Int, bool) {
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+/*
+身份绑定策略：
+钱包地址 = base64(SM3(公钥JSON))，SM2签名的用户标识(UID)取地址解码后的32字节，
+即 ZA = SM3(ENTL || UID || a || b || xG || yG || xA || yA) 中的 UID 由地址派生。
+所有SM2签名都用该UID签名，验证方从链上密钥登记(GetKey)读取公钥和UID，不信任请求中的用户名；
+环签名的哈希对每个环成员写入其 ZA，把环成员的身份一并绑定进签名
+*/
+
+// AddressOf 由公钥计算钱包地址，与 GetAddress 对公钥文件的计算相同
+func AddressOf(pub *sm2.PublicKey) (string, error) {
+	if pub == nil {
+		return "", fmt.Errorf("missing public key")
+	}
+	pub_bytes, err := json.Marshal(pub)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal public key:%v", err)
+	}
+	h := sm3.New()
+	h.Write(pub_bytes)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// UIDFromAddress 由钱包地址派生SM2签名的UID
+func UIDFromAddress(address string) ([]byte, error) {
+	uid, err := base64.StdEncoding.DecodeString(address)
+	if err != nil || len(uid) != sm3.DigestLength {
+		return nil, fmt.Errorf("invalid wallet address %q", address)
+	}
+	return uid, nil
+}
+
+// UIDOf 公钥对应钱包地址派生的UID
+func UIDOf(pub *sm2.PublicKey) ([]byte, error) {
+	address, err := AddressOf(pub)
+	if err != nil {
+		return nil, err
+	}
+	return UIDFromAddress(address)
+}
+
+// memberZA 环成员的 ZA，UID 由其钱包地址派生
+func memberZA(pub *sm2.PublicKey) ([]byte, error) {
+	uid, err := UIDOf(pub)
+	if err != nil {
+		return nil, err
+	}
+	return CalculateZA(pub, uid)
+}
+
+// ringZA 依次拼接环成员的 ZA，签名和验证时各计算一次，供 hash/hash1 写入
+func ringZA(pubs []*sm2.PublicKey) ([]byte, error) {
+	za := make([]byte, 0, len(pubs)*sm3.DigestLength)
+	for _, pub := range pubs {
+		if pub == nil || pub.X == nil || pub.Y == nil {
+			return nil, fmt.Errorf("missing public key in ring")
+		}
+		z, err := memberZA(pub)
+		if err != nil {
+			return nil, err
+		}
+		za = append(za, z...)
+	}
+	return za, nil
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+func TestAddressOfMatchesKeyFile(t *testing.T) {
+	_, pubs := katKeys(1)
+	// KeyGen writes json.Marshal(pub) and GetAddress hashes the file
+	file, err := json.Marshal(pubs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read *sm2.PublicKey
+	if err := json.Unmarshal(file, &read); err != nil {
+		t.Fatal(err)
+	}
+	h := sm3.New()
+	h.Write(file)
+	digest := h.Sum(nil)
+	want := base64.StdEncoding.EncodeToString(digest)
+
+	got, err := AddressOf(read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("*****AddressOf = %s, want %s", got, want)
+	}
+	uid, err := UIDFromAddress(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(uid, digest) {
+		t.Error("*****UID is not the digest behind the address")
+	}
+}
+
+func TestUIDFromAddressRejectsMalformed(t *testing.T) {
+	for _, address := range []string{"", "Bob", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := UIDFromAddress(address); err == nil {
+			t.Errorf("*****UIDFromAddress accepted %q", address)
+		}
+	}
+}
+
+func TestSM2SignBindsAddressUID(t *testing.T) {
+	pris, pubs := katKeys(2)
+	uid, err := UIDOf(pubs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := UIDOf(pubs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("Enc_B(m)||Enc_B(b)||OrderNum||Add_A")
+	sign, err := sm2.Sign(pris[0], uid, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sm2.Verify(pubs[0], uid, msg, sign) {
+		t.Error("*****SM2 signature with the address UID FAILURE")
+	}
+	if sm2.Verify(pubs[0], other, msg, sign) || sm2.Verify(pubs[0], []byte("Bob"), msg, sign) {
+		t.Error("*****SM2 signature verified under another UID")
+	}
+}
+
+func TestSM2ParticipantRandInt(t *testing.T) {
+	_, pubs := katKeys(1)
+	n := pubs[0].Curve.Params().N
+	for i := 0; i < 16; i++ {
+		s, err := SM2ParticipantRandInt(rand.Reader, pubs[0], []byte("m"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Sign() <= 0 || s.Cmp(n) >= 0 {
+			t.Fatalf("*****response %x out of range", s)
+		}
+	}
+}
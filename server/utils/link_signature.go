@@ -43,76 +43,28 @@ func publicKeysToPoint(pubs []*sm2.PublicKey) (x *big.Int, y *big.Int) {
 	return
 }
 
-func hash1(pubs []*sm2.PublicKey, QpaiX, QpaiY *big.Int, msg []byte, vx, vy, wx, wy *big.Int) *big.Int {
-	// var buffer [32]byte
-	// h := sm3.New()
-	// for _, pub := range pubs {
-	// 	pub.X.FillBytes(buffer[:])
-	// 	h.Write(buffer[:])
-	// 	pub.Y.FillBytes(buffer[:])
-	// 	h.Write(buffer[:])
-	// }
-
-	// QpaiX.FillBytes(buffer[:])
-	// h.Write(buffer[:])
-	// QpaiY.FillBytes(buffer[:])
-	// h.Write(buffer[:])
-
-	// h.Write(msg)
-
-	// if vx != nil && vy != nil {
-	// 	vx.FillBytes(buffer[:])
-	// 	h.Write(buffer[:])
-	// 	vy.FillBytes(buffer[:])
-	// 	h.Write(buffer[:])
-	// }
-
-	// if wx != nil && wy != nil {
-	// 	wx.FillBytes(buffer[:])
-	// 	h.Write(buffer[:])
-	// 	wy.FillBytes(buffer[:])
-	// 	h.Write(buffer[:])
-	// }
-
-	// return hashToInt(h.Sum(nil), pubs[0].Curve)
+/*
+hash1 对环成员的ZA(见 ringZA)、密钥镜像Q、消息以及本轮的点V、W做SM3哈希。
+Variant1 只有点V，Variant2 不带任何点，缺省的点(nil)不写入哈希
+*/
+func hash1(za []byte, QpaiX, QpaiY *big.Int, msg []byte, vx, vy, wx, wy *big.Int) *big.Int {
 	h := sm3.New()
-
-	for _, pub := range pubs {
-		xBytes := pub.X.Bytes()
-		padXBytes := padToFixedLength(xBytes, 32) // 假设公钥的X和Y坐标需要填充到32字节
-		h.Write(padXBytes)
-
-		yBytes := pub.Y.Bytes()
-		padYBytes := padToFixedLength(yBytes, 32) // 假设公钥的X和Y坐标需要填充到32字节
-		h.Write(padYBytes)
+	h.Write(za)
+	h.Write(padToFixedLength(QpaiX.Bytes(), 32))
+	h.Write(padToFixedLength(QpaiY.Bytes(), 32))
+	// gm 的 sm3 写入空切片会panic，空消息不写入与写入空串的哈希相同
+	if len(msg) > 0 {
+		h.Write(msg)
 	}
-
-	qpaixBytes := QpaiX.Bytes()
-	padQpaiXBytes := padToFixedLength(qpaixBytes, 32) // 假设cx和cy需要填充到32字节
-	h.Write(padQpaiXBytes)
-
-	qpaiyBytes := QpaiY.Bytes()
-	padQpaiYBytes := padToFixedLength(qpaiyBytes, 32) // 假设cx和cy需要填充到32字节
-	h.Write(padQpaiYBytes)
-
-	h.Write(msg)
-
-	vxBytes := vx.Bytes()
-	padVXBytes := padToFixedLength(vxBytes, 32) // 假设cx和cy需要填充到32字节
-	h.Write(padVXBytes)
-
-	vyBytes := vy.Bytes()
-	padVYBytes := padToFixedLength(vyBytes, 32) // 假设cx和cy需要填充到32字节
-	h.Write(padVYBytes)
-
-	wxBytes := wx.Bytes()
-	padWXBytes := padToFixedLength(wxBytes, 32) // 假设cx和cy需要填充到32字节
-	h.Write(padWXBytes)
-
-	wyBytes := wy.Bytes()
-	padWYBytes := padToFixedLength(wyBytes, 32) // 假设cx和cy需要填充到32字节
-	h.Write(padWYBytes)
-	return hashToInt(h.Sum(nil), pubs[0].Curve)
+	if vx != nil && vy != nil {
+		h.Write(padToFixedLength(vx.Bytes(), 32))
+		h.Write(padToFixedLength(vy.Bytes(), 32))
+	}
+	if wx != nil && wy != nil {
+		h.Write(padToFixedLength(wx.Bytes(), 32))
+		h.Write(padToFixedLength(wy.Bytes(), 32))
+	}
+	return hashToInt(h.Sum(nil), sm2.GetSm2P256V1())
 }
 
 func (signer *BaseLinkableSigner) Sign(rand io.Reader, participantRandInt ParticipantRandInt, msg []byte) ([]*big.Int, error) {
@@ -124,6 +76,10 @@ func (signer *BaseLinkableSigner) Sign(rand io.Reader, participantRandInt Partic
 	if err != nil {
 		return nil, err
 	}
+	za, err := ringZA(pubs)
+	if err != nil {
+		return nil, err
+	}
 
 	// step 1, Qpai
 	rx, ry := publicKeysToPoint(pubs)
@@ -136,7 +92,7 @@ func (signer *BaseLinkableSigner) Sign(rand io.Reader, participantRandInt Partic
 	}
 	kPaiGx, kPaiGy := priv.Curve.ScalarBaseMult(kPai.Bytes())
 	krx, kry := priv.Curve.ScalarMult(rx, ry, kPai.Bytes())
-	c := hash1(pubs, QpaiX, QpaiY, msg, kPaiGx, kPaiGy, krx, kry)
+	c := hash1(za, QpaiX, QpaiY, msg, kPaiGx, kPaiGy, krx, kry)
 
 	results := make([]*big.Int, n+3)
 	results[0] = QpaiX
@@ -159,7 +115,7 @@ func (signer *BaseLinkableSigner) Sign(rand io.Reader, participantRandInt Partic
 		wx, wy := priv.Curve.ScalarMult(QpaiX, QpaiY, c.Bytes())
 		wx, wy = priv.Curve.Add(sx, sy, wx, wy)
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, wx, wy)
+		c = hash1(za, QpaiX, QpaiY, msg, vx, vy, wx, wy)
 	}
 	results[2] = new(big.Int).Set(c)
 	// [0...pai)
@@ -179,7 +135,7 @@ func (signer *BaseLinkableSigner) Sign(rand io.Reader, participantRandInt Partic
 		wx, wy := priv.Curve.ScalarMult(QpaiX, QpaiY, c.Bytes())
 		wx, wy = priv.Curve.Add(sx, sy, wx, wy)
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, wx, wy)
+		c = hash1(za, QpaiX, QpaiY, msg, vx, vy, wx, wy)
 	}
 	// Step 3: this step is same with SM2 signature scheme
 	c.Mul(c, priv.D)
@@ -204,13 +160,20 @@ func (signer *BaseLinkableSigner) Sign(rand io.Reader, participantRandInt Partic
 
 func (v *BaseLinkableVerfier) Verify(msg []byte, signature []*big.Int) bool {
 	pubs := v.publicKeys
-	if len(pubs)+3 != len(signature) {
+	if len(pubs) < 2 || len(pubs)+3 != len(signature) {
+		return false
+	}
+	za, err := ringZA(pubs)
+	if err != nil {
 		return false
 	}
 
 	rx, ry := publicKeysToPoint(pubs)
 	QpaiX := signature[0]
 	QpaiY := signature[1]
+	if !validKeyImage(pubs, QpaiX, QpaiY) {
+		return false
+	}
 
 	c := new(big.Int).Set(signature[2])
 	for i := 0; i < len(pubs); i++ {
@@ -227,12 +190,17 @@ func (v *BaseLinkableVerfier) Verify(msg []byte, signature []*big.Int) bool {
 		wx, wy := pub.Curve.ScalarMult(QpaiX, QpaiY, c.Bytes())
 		wx, wy = pub.Curve.Add(sx, sy, wx, wy)
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, wx, wy)
+		c = hash1(za, QpaiX, QpaiY, msg, vx, vy, wx, wy)
 	}
 
 	return c.Cmp(signature[2]) == 0
 }
 
+// validKeyImage 检查密钥镜像Q在曲线上，否则标量乘法会panic
+func validKeyImage(pubs []*sm2.PublicKey, x, y *big.Int) bool {
+	return len(pubs) > 0 && x != nil && y != nil && pubs[0].Curve.IsOnCurve(x, y)
+}
+
 func Linkable(signature1, signature2 []*big.Int) bool {
 	return signature1[0].Cmp(signature2[0]) == 0 && signature1[1].Cmp(signature2[1]) == 0
 }
@@ -263,6 +231,10 @@ func (signer *LinkableSignerVariant1) Sign(rand io.Reader, participantRandInt Pa
 	if err != nil {
 		return nil, err
 	}
+	za, err := ringZA(pubs)
+	if err != nil {
+		return nil, err
+	}
 
 	// step 1, Qpai
 	rx, ry := publicKeysToPoint(pubs)
@@ -277,7 +249,7 @@ func (signer *LinkableSignerVariant1) Sign(rand io.Reader, participantRandInt Pa
 	}
 
 	krx, kry := priv.Curve.ScalarMult(rx, ry, kPai.Bytes())
-	c := hash1(pubs, QpaiX, QpaiY, msg, krx, kry, nil, nil)
+	c := hash1(za, QpaiX, QpaiY, msg, krx, kry, nil, nil)
 
 	results := make([]*big.Int, n+3)
 	results[0] = QpaiX
@@ -299,7 +271,7 @@ func (signer *LinkableSignerVariant1) Sign(rand io.Reader, participantRandInt Pa
 		sx, sy := priv.Curve.ScalarMult(rx, ry, s.Bytes())
 		vx, vy = priv.Curve.Add(sx, sy, vx, vy)
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, nil, nil)
+		c = hash1(za, QpaiX, QpaiY, msg, vx, vy, nil, nil)
 	}
 	results[2] = new(big.Int).Set(c)
 	// [0...pai)
@@ -318,7 +290,7 @@ func (signer *LinkableSignerVariant1) Sign(rand io.Reader, participantRandInt Pa
 		sx, sy := priv.Curve.ScalarMult(rx, ry, s.Bytes())
 		vx, vy = priv.Curve.Add(sx, sy, vx, vy)
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, nil, nil)
+		c = hash1(za, QpaiX, QpaiY, msg, vx, vy, nil, nil)
 	}
 	// Step 3: this step is same with SM2 signature scheme
 	c.Mul(c, priv.D)
@@ -341,9 +313,13 @@ func (signer *LinkableSignerVariant1) Sign(rand io.Reader, participantRandInt Pa
 	return results, nil
 }
 
-func (v *LinkableSignerVariant1) Verify(msg []byte, signature []*big.Int) bool {
+func (v *LinkableVerfierVariant1) Verify(msg []byte, signature []*big.Int) bool {
 	pubs := v.publicKeys
-	if len(pubs)+3 != len(signature) {
+	if len(pubs) < 2 || len(pubs)+3 != len(signature) {
+		return false
+	}
+	za, err := ringZA(pubs)
+	if err != nil {
 		return false
 	}
 
@@ -351,6 +327,9 @@ func (v *LinkableSignerVariant1) Verify(msg []byte, signature []*big.Int) bool {
 	rx, ry = pubs[0].Curve.Add(rx, ry, pubs[0].Curve.Params().Gx, pubs[0].Curve.Params().Gy)
 	QpaiX := signature[0]
 	QpaiY := signature[1]
+	if !validKeyImage(pubs, QpaiX, QpaiY) {
+		return false
+	}
 
 	c := new(big.Int).Set(signature[2])
 	for i := 0; i < len(pubs); i++ {
@@ -366,7 +345,7 @@ func (v *LinkableSignerVariant1) Verify(msg []byte, signature []*big.Int) bool {
 		sx, sy := pub.Curve.ScalarMult(rx, ry, s.Bytes())
 		vx, vy = pub.Curve.Add(sx, sy, vx, vy)
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, vx, vy, nil, nil)
+		c = hash1(za, QpaiX, QpaiY, msg, vx, vy, nil, nil)
 	}
 
 	return c.Cmp(signature[2]) == 0
@@ -376,8 +355,8 @@ type LinkableVerfierVariant2 struct {
 	publicKeys []*sm2.PublicKey
 }
 
-func NewLinkableVerfierVariant2(pubs []*sm2.PublicKey) *LinkableVerfierVariant1 {
-	return &LinkableVerfierVariant1{publicKeys: pubs}
+func NewLinkableVerfierVariant2(pubs []*sm2.PublicKey) *LinkableVerfierVariant2 {
+	return &LinkableVerfierVariant2{publicKeys: pubs}
 }
 
 type LinkableSignerVariant2 struct {
@@ -385,8 +364,8 @@ type LinkableSignerVariant2 struct {
 	privateKey *sm2.PrivateKey
 }
 
-func NewLinkableSignerVariant2(privateKey *sm2.PrivateKey, pubs []*sm2.PublicKey) *LinkableSignerVariant1 {
-	return &LinkableSignerVariant1{privateKey: privateKey, LinkableVerfierVariant1: LinkableVerfierVariant1{publicKeys: pubs}}
+func NewLinkableSignerVariant2(privateKey *sm2.PrivateKey, pubs []*sm2.PublicKey) *LinkableSignerVariant2 {
+	return &LinkableSignerVariant2{privateKey: privateKey, LinkableVerfierVariant2: LinkableVerfierVariant2{publicKeys: pubs}}
 }
 
 func (signer *LinkableSignerVariant2) Sign(rand io.Reader, participantRandInt ParticipantRandInt, msg []byte) ([]*big.Int, error) {
@@ -398,6 +377,10 @@ func (signer *LinkableSignerVariant2) Sign(rand io.Reader, participantRandInt Pa
 	if err != nil {
 		return nil, err
 	}
+	za, err := ringZA(pubs)
+	if err != nil {
+		return nil, err
+	}
 
 	// step 1, Qpai
 	rx, ry := publicKeysToPoint(pubs)
@@ -412,7 +395,7 @@ func (signer *LinkableSignerVariant2) Sign(rand io.Reader, participantRandInt Pa
 	}
 
 	krx, _ := priv.Curve.ScalarMult(rx, ry, kPai.Bytes())
-	c := hash1(pubs, QpaiX, QpaiY, msg, nil, nil, nil, nil)
+	c := hash1(za, QpaiX, QpaiY, msg, nil, nil, nil, nil)
 	c.Add(krx, c)
 	c.Mod(c, priv.Curve.Params().N)
 
@@ -436,7 +419,7 @@ func (signer *LinkableSignerVariant2) Sign(rand io.Reader, participantRandInt Pa
 		sx, sy := priv.Curve.ScalarMult(rx, ry, s.Bytes())
 		vx, _ = priv.Curve.Add(sx, sy, vx, vy)
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, nil, nil, nil, nil)
+		c = hash1(za, QpaiX, QpaiY, msg, nil, nil, nil, nil)
 		c.Add(vx, c)
 		c.Mod(c, priv.Curve.Params().N)
 	}
@@ -457,7 +440,7 @@ func (signer *LinkableSignerVariant2) Sign(rand io.Reader, participantRandInt Pa
 		sx, sy := priv.Curve.ScalarMult(rx, ry, s.Bytes())
 		vx, _ = priv.Curve.Add(sx, sy, vx, vy)
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, nil, nil, nil, nil)
+		c = hash1(za, QpaiX, QpaiY, msg, nil, nil, nil, nil)
 		c.Add(vx, c)
 		c.Mod(c, priv.Curve.Params().N)
 	}
@@ -482,9 +465,13 @@ func (signer *LinkableSignerVariant2) Sign(rand io.Reader, participantRandInt Pa
 	return results, nil
 }
 
-func (v *LinkableSignerVariant2) Verify(msg []byte, signature []*big.Int) bool {
+func (v *LinkableVerfierVariant2) Verify(msg []byte, signature []*big.Int) bool {
 	pubs := v.publicKeys
-	if len(pubs)+3 != len(signature) {
+	if len(pubs) < 2 || len(pubs)+3 != len(signature) {
+		return false
+	}
+	za, err := ringZA(pubs)
+	if err != nil {
 		return false
 	}
 
@@ -492,6 +479,9 @@ func (v *LinkableSignerVariant2) Verify(msg []byte, signature []*big.Int) bool {
 	rx, ry = pubs[0].Curve.Add(rx, ry, pubs[0].Curve.Params().Gx, pubs[0].Curve.Params().Gy)
 	QpaiX := signature[0]
 	QpaiY := signature[1]
+	if !validKeyImage(pubs, QpaiX, QpaiY) {
+		return false
+	}
 
 	c := new(big.Int).Set(signature[2])
 	for i := 0; i < len(pubs); i++ {
@@ -507,7 +497,7 @@ func (v *LinkableSignerVariant2) Verify(msg []byte, signature []*big.Int) bool {
 		sx, sy := pub.Curve.ScalarMult(rx, ry, s.Bytes())
 		vx, _ = pub.Curve.Add(sx, sy, vx, vy)
 
-		c = hash1(pubs, QpaiX, QpaiY, msg, nil, nil, nil, nil)
+		c = hash1(za, QpaiX, QpaiY, msg, nil, nil, nil, nil)
 		c.Add(vx, c)
 		c.Mod(c, pub.Curve.Params().N)
 	}
@@ -0,0 +1,337 @@
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+// detReader is a deterministic byte stream SM3(seed || counter) used to make
+// signatures reproducible for known-answer tests.
+type detReader struct {
+	seed    []byte
+	counter uint64
+	buf     []byte
+}
+
+func newDetReader(seed string) *detReader {
+	return &detReader{seed: []byte(seed)}
+}
+
+func (r *detReader) Read(p []byte) (int, error) {
+	for i := range p {
+		if len(r.buf) == 0 {
+			var ctr [8]byte
+			binary.BigEndian.PutUint64(ctr[:], r.counter)
+			r.counter++
+			h := sm3.New()
+			h.Write(r.seed)
+			h.Write(ctr[:])
+			r.buf = h.Sum(nil)
+		}
+		p[i], r.buf = r.buf[0], r.buf[1:]
+	}
+	return len(p), nil
+}
+
+// katKeys derives n fixed key pairs from SM3("kat key i").
+func katKeys(n int) ([]*sm2.PrivateKey, []*sm2.PublicKey) {
+	curve := sm2.GetSm2P256V1()
+	pris := make([]*sm2.PrivateKey, n)
+	pubs := make([]*sm2.PublicKey, n)
+	for i := range pris {
+		h := sm3.New()
+		h.Write([]byte(fmt.Sprintf("kat key %d", i)))
+		d := new(big.Int).SetBytes(h.Sum(nil))
+		d.Mod(d, new(big.Int).Sub(curve.Params().N, big.NewInt(2)))
+		d.Add(d, big.NewInt(1))
+		x, y := curve.ScalarBaseMult(d.Bytes())
+		pubs[i] = &sm2.PublicKey{X: x, Y: y, Curve: curve}
+		pris[i] = &sm2.PrivateKey{D: d, Curve: curve}
+	}
+	return pris, pubs
+}
+
+func katSign(t *testing.T, id SchemeID, priv *sm2.PrivateKey, pubs []*sm2.PublicKey, msg []byte) *LinkableRingSignature {
+	scheme, err := GetLinkableScheme(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign, err := scheme.NewSigner(priv, pubs).Sign(newDetReader(scheme.Name), scheme.ParticipantRandInt, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := NewLinkableRingSignature(sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig.Scheme = id
+	return sig
+}
+
+// linkableKAT are signatures of "kat message" by katKeys(3)[1] over
+// katKeys(3), with the signer's randomness drawn from newDetReader(scheme
+// name) and every ring member's ZA (UID from its wallet address) hashed.
+var linkableKAT = []struct {
+	scheme SchemeID
+	sig    string
+}{
+	{SchemeBase, "0202000003023847add569b04e13be3e8d2fc4786836c821a3b48de116f6663f15092b10f62c2c51704dfa09876aed8f80ec7fbe09316fb8cc56d0f8da22f46320556d32231f144476f62bdebe0e789909691e15b1fc54768fcb4b1271d5a63e50adde578682dbd6890a2b2f5f0629072340a61f908656a5c9d544b460fe7b318abeab289bddb109972bbf50ad050650f599e657f70e4ca754dc07ed1577532023978049ccd5"},
+	{SchemeVariant1, "0202010003023847add569b04e13be3e8d2fc4786836c821a3b48de116f6663f15092b10f62c1083e76f44b30571a032009933d84b40137f7a0dc9a7b83b01ff803bd42ff8d4c0043282a9fe070161a4fbf8f5d5e0700fd2db0934cd1cffd4368342506d5bf99f8ddc9f51bbee4d2c9028cc57a69ec5f2ef011f9c7cbc62adb9ffe0db9ff815608683ebb11bacfe090ff54de1eee9481423fe1b8a0ec76535e6b48e104f5734"},
+	{SchemeVariant2, "0202020003023847add569b04e13be3e8d2fc4786836c821a3b48de116f6663f15092b10f62c74bc4ab727e83d97ff2419561fb8891664b5b214f62160b56a4f1da94b2f0daf2861c9b43e1672d7d185500eea423523c2f310e42cccb41901bf5fa04f1b22be8d634dba357f0cde5b8ca575f3dfd913504e6695b8d8aee4abaa53ad7c08e7d50a4ecb38a33800c086868fd827c8ec2d0788f69858ba83c44393b88b0c500194"},
+}
+
+var (
+	_ RingVerifier = NewBaseLinkableVerfier(nil)
+	_ RingVerifier = NewLinkableVerfierVariant1(nil)
+	_ RingVerifier = NewLinkableVerfierVariant2(nil)
+	_ RingSigner   = NewLinkableSignerVariant2(nil, nil)
+)
+
+func TestLinkableKnownAnswers(t *testing.T) {
+	pris, pubs := katKeys(3)
+	msg := []byte("kat message")
+	for _, kat := range linkableKAT {
+		sig := katSign(t, kat.scheme, pris[1], pubs, msg)
+		b, err := sig.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(b) != kat.sig {
+			t.Errorf("*****Scheme %d does not reproduce its test vector", kat.scheme)
+		}
+		got, err := LinkSignVerify(pubs, msg, kat.sig)
+		if err != nil {
+			t.Errorf("*****Test vector of scheme %d does not verify: %v", kat.scheme, err)
+		} else if got.Scheme != kat.scheme {
+			t.Errorf("*****Test vector decoded as scheme %d, want %d", got.Scheme, kat.scheme)
+		}
+	}
+}
+
+func TestLinkableSchemesRoundTrip(t *testing.T) {
+	pris, pubs := katKeys(4)
+	for _, id := range []SchemeID{SchemeBase, SchemeVariant1, SchemeVariant2} {
+		for pai := range pris {
+			s1, err := GenerateLinkSign(id, pris[pai], pubs, []byte("msg 1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s2, err := GenerateLinkSign(id, pris[pai], pubs, []byte("msg 2"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig1, err := LinkSignVerify(pubs, []byte("msg 1"), s1)
+			if err != nil {
+				t.Fatalf("*****Scheme %d signer %d FAILURE: %v", id, pai, err)
+			}
+			sig2, err := LinkSignVerify(pubs, []byte("msg 2"), s2)
+			if err != nil {
+				t.Fatalf("*****Scheme %d signer %d FAILURE: %v", id, pai, err)
+			}
+			if !sig1.Links(sig2) {
+				t.Errorf("*****Scheme %d: two signatures of signer %d do not link", id, pai)
+			}
+			if _, err := LinkSignVerify(pubs, []byte("msg 2"), s1); err == nil {
+				t.Errorf("*****Scheme %d: signature verified for another message", id)
+			}
+		}
+	}
+	fmt.Println("All linkable ring signature schemes sign, verify and link")
+}
+
+func TestLinkableCrossScheme(t *testing.T) {
+	pris, pubs := katKeys(3)
+	msg := []byte("kat message")
+	ids := []SchemeID{SchemeBase, SchemeVariant1, SchemeVariant2}
+	sigs := make([]*LinkableRingSignature, len(ids))
+	for i, id := range ids {
+		sigs[i] = katSign(t, id, pris[0], pubs, msg)
+	}
+	for i, from := range ids {
+		for j, to := range ids {
+			if i == j {
+				continue
+			}
+			// a signature relabelled with another scheme must not verify
+			relabelled := *sigs[i]
+			relabelled.Scheme = to
+			b, err := relabelled.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LinkSignVerify(pubs, msg, hex.EncodeToString(b)); err == nil {
+				t.Errorf("*****Scheme %d signature verified as scheme %d", from, to)
+			}
+			scheme, _ := GetLinkableScheme(to)
+			if scheme.NewVerifier(pubs).Verify(msg, sigs[i].Slice()) {
+				t.Errorf("*****Scheme %d verifier accepted a scheme %d signature", to, from)
+			}
+			if sigs[i].Links(sigs[j]) {
+				t.Errorf("*****Signatures of schemes %d and %d link", from, to)
+			}
+		}
+	}
+}
+
+func TestLinkableEncodingVersions(t *testing.T) {
+	pris, pubs := katKeys(3)
+	msg := []byte("kat message")
+	b, err := katSign(t, SchemeBase, pris[2], pubs, msg).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// version 1 had no scheme byte and is read as the base scheme
+	legacy := append([]byte{1, kindLinkableSig}, b[3:]...)
+	sig, err := LinkSignVerify(pubs, msg, hex.EncodeToString(legacy))
+	if err != nil || sig.Scheme != SchemeBase {
+		t.Error("*****Version 1 encoding FAILURE:", err)
+	}
+
+	unknown := append([]byte{}, b...)
+	unknown[2] = 0x7f
+	if _, err := DecodeLinkableRingSignature(unknown); !errors.Is(err, ErrMalformedSignature) {
+		t.Error("*****Unknown scheme accepted:", err)
+	}
+	if _, err := DecodeLinkableRingSignature(b[:len(b)-1]); !errors.Is(err, ErrMalformedSignature) {
+		t.Error("*****Truncated signature accepted:", err)
+	}
+	if _, err := DecodeRingSignature(b); !errors.Is(err, ErrMalformedSignature) {
+		t.Error("*****Linkable signature decoded as a plain ring signature:", err)
+	}
+}
+
+var linkableSchemeIDs = []SchemeID{SchemeBase, SchemeVariant1, SchemeVariant2}
+
+func TestLinkableEveryPosition(t *testing.T) {
+	pris, pubs := katKeys(5)
+	msg := []byte("Enc_A(m)||Enc_B(m)||Enc_A(b)")
+	for _, id := range linkableSchemeIDs {
+		for n := 2; n <= 5; n++ {
+			for _, pai := range signerPositions(n, 5) {
+				s, err := GenerateLinkSign(id, pris[pai], pubs[:n], msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := LinkSignVerify(pubs[:n], msg, s); err != nil {
+					t.Errorf("*****Scheme %d FAILURE with ring size %d, signer %d: %v", id, n, pai, err)
+				}
+			}
+		}
+	}
+}
+
+func TestLinkableSizes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("large rings are slow")
+	}
+	pris, pubs := katKeys(64)
+	msg := []byte("ring sizes")
+	for _, id := range linkableSchemeIDs {
+		for i, n := range []int{2, 16, 33, 64} {
+			pai := signerPositions(n, 0)[i%3]
+			s, err := GenerateLinkSign(id, pris[pai], pubs[:n], msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LinkSignVerify(pubs[:n], msg, s); err != nil {
+				t.Errorf("*****Scheme %d FAILURE with ring size %d, signer %d: %v", id, n, pai, err)
+			}
+		}
+	}
+}
+
+func TestLinkableTampering(t *testing.T) {
+	pris, pubs := katKeys(4)
+	msg := []byte("Add_A||Add_B||OrderNum||Sign_B")
+	for _, id := range linkableSchemeIDs {
+		sig := katSign(t, id, pris[2], pubs, msg)
+		scheme, _ := GetLinkableScheme(id)
+		verifier := scheme.NewVerifier(pubs)
+		if !verifier.Verify(msg, sig.Slice()) {
+			t.Fatalf("*****Scheme %d signature does not verify", id)
+		}
+
+		if verifier.Verify(append([]byte{}, msg[1:]...), sig.Slice()) {
+			t.Errorf("*****Scheme %d verified a changed message", id)
+		}
+		swapped := append([]*sm2.PublicKey{}, pubs...)
+		swapped[0], swapped[3] = swapped[3], swapped[0]
+		if scheme.NewVerifier(swapped).Verify(msg, sig.Slice()) {
+			t.Errorf("*****Scheme %d verified with the ring reordered", id)
+		}
+		for i := range sig.Slice() {
+			bad := sig.Slice()
+			bad[i] = new(big.Int).Add(bad[i], big.NewInt(1))
+			if verifier.Verify(msg, bad) {
+				t.Errorf("*****Scheme %d verified with element %d changed", id, i)
+			}
+		}
+	}
+}
+
+func TestLinkability(t *testing.T) {
+	pris, pubs := katKeys(4)
+	for _, id := range linkableSchemeIDs {
+		a1 := katSign(t, id, pris[0], pubs, []byte("order 1"))
+		a2, err := GenerateLinkSign(id, pris[0], pubs, []byte("order 2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a2sig, err := LinkSignVerify(pubs, []byte("order 2"), a2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b1 := katSign(t, id, pris[1], pubs, []byte("order 1"))
+		if !a1.Links(a2sig) {
+			t.Errorf("*****Scheme %d: same key on different messages does not link", id)
+		}
+		if a1.Links(b1) {
+			t.Errorf("*****Scheme %d: different keys link", id)
+		}
+		if !Linkable(a1.Slice(), a2sig.Slice()) || Linkable(a1.Slice(), b1.Slice()) {
+			t.Errorf("*****Scheme %d: Linkable disagrees with Links", id)
+		}
+	}
+}
+
+func BenchmarkLinkableSign(b *testing.B) {
+	pris, pubs := katKeys(64)
+	for _, id := range linkableSchemeIDs {
+		for _, n := range []int{2, 8, 32, 64} {
+			b.Run(fmt.Sprintf("scheme%d/ring%d", id, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := GenerateLinkSign(id, pris[0], pubs[:n], []byte("bench")); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkLinkableVerify(b *testing.B) {
+	pris, pubs := katKeys(64)
+	for _, id := range linkableSchemeIDs {
+		for _, n := range []int{2, 8, 32, 64} {
+			s, err := GenerateLinkSign(id, pris[0], pubs[:n], []byte("bench"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(fmt.Sprintf("scheme%d/ring%d", id, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := LinkSignVerify(pubs[:n], []byte("bench"), s); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// SchemeID 可链接环签名方案的编号，写入签名编码，验证方据此选择验证算法
+type SchemeID byte
+
+const (
+	// SchemeBase BaseLinkableSigner，V=sG+c'P、W=sR+c'Q 两个点都进入哈希
+	SchemeBase SchemeID = 0
+	// SchemeVariant1 LinkableSignerVariant1，只有点 V=sR'+c'(P+Q) 进入哈希，R'=R+G
+	SchemeVariant1 SchemeID = 1
+	// SchemeVariant2 LinkableSignerVariant2，与SM2签名相同，挑战为 e+x(V)
+	SchemeVariant2 SchemeID = 2
+)
+
+// DefaultLinkableScheme 新订单使用的方案，旧订单按签名中记录的方案验证，不受修改影响
+const DefaultLinkableScheme = SchemeBase
+
+// LinkableScheme 一种可链接环签名方案：由私钥和环构造签名者，由环构造验证者，
+// ParticipantRandInt 为签名时其他环成员的随机响应
+type LinkableScheme struct {
+	ID                 SchemeID
+	Name               string
+	NewSigner          func(priv *sm2.PrivateKey, pubs []*sm2.PublicKey) RingSigner
+	NewVerifier        func(pubs []*sm2.PublicKey) RingVerifier
+	ParticipantRandInt ParticipantRandInt
+}
+
+var (
+	schemesMu sync.RWMutex
+	schemes   = map[SchemeID]*LinkableScheme{}
+)
+
+func init() {
+	RegisterLinkableScheme(&LinkableScheme{
+		ID:   SchemeBase,
+		Name: "sm2-linkable-base",
+		NewSigner: func(priv *sm2.PrivateKey, pubs []*sm2.PublicKey) RingSigner {
+			return NewBaseLinkableSigner(priv, pubs)
+		},
+		NewVerifier: func(pubs []*sm2.PublicKey) RingVerifier {
+			return NewBaseLinkableVerfier(pubs)
+		},
+		ParticipantRandInt: SimpleParticipantRandInt,
+	})
+	RegisterLinkableScheme(&LinkableScheme{
+		ID:   SchemeVariant1,
+		Name: "sm2-linkable-variant1",
+		NewSigner: func(priv *sm2.PrivateKey, pubs []*sm2.PublicKey) RingSigner {
+			return NewLinkableSignerVariant1(priv, pubs)
+		},
+		NewVerifier: func(pubs []*sm2.PublicKey) RingVerifier {
+			return NewLinkableVerfierVariant1(pubs)
+		},
+		ParticipantRandInt: SimpleParticipantRandInt,
+	})
+	RegisterLinkableScheme(&LinkableScheme{
+		ID:   SchemeVariant2,
+		Name: "sm2-linkable-variant2",
+		NewSigner: func(priv *sm2.PrivateKey, pubs []*sm2.PublicKey) RingSigner {
+			return NewLinkableSignerVariant2(priv, pubs)
+		},
+		NewVerifier: func(pubs []*sm2.PublicKey) RingVerifier {
+			return NewLinkableVerfierVariant2(pubs)
+		},
+		// 与SM2签名相同的方案，其他成员的响应也按SM2签名的方式生成
+		ParticipantRandInt: SM2ParticipantRandInt,
+	})
+}
+
+// RegisterLinkableScheme 注册方案，编号重复时panic
+func RegisterLinkableScheme(s *LinkableScheme) {
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+	if _, ok := schemes[s.ID]; ok {
+		panic(fmt.Sprintf("linkable scheme %d registered twice", s.ID))
+	}
+	schemes[s.ID] = s
+}
+
+// GetLinkableScheme 按编号查找方案
+func GetLinkableScheme(id SchemeID) (*LinkableScheme, error) {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+	s, ok := schemes[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown linkable scheme %d", id)
+	}
+	return s, nil
+}
+
+// GenerateLinkSign 用指定方案生成可链接环签名，返回链上使用的十六进制编码
+func GenerateLinkSign(id SchemeID, priv *sm2.PrivateKey, pubs []*sm2.PublicKey, msg []byte) (string, error) {
+	scheme, err := GetLinkableScheme(id)
+	if err != nil {
+		return "", err
+	}
+	sign, err := scheme.NewSigner(priv, pubs).Sign(rand.Reader, scheme.ParticipantRandInt, msg)
+	if err != nil {
+		return "", err
+	}
+	link_sign, err := NewLinkableRingSignature(sign)
+	if err != nil {
+		return "", err
+	}
+	link_sign.Scheme = id
+	sign_bytes, err := link_sign.Encode()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sign_bytes), nil
+}
+
+// LinkSignVerify 解码可链接环签名，并按其中记录的方案验证，编码不合法时返回错误而不是退出
+func LinkSignVerify(pubs []*sm2.PublicKey, msg []byte, signature string) (*LinkableRingSignature, error) {
+	b, err := hex.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+	sign, err := DecodeLinkableRingSignature(b)
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := GetLinkableScheme(sign.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	if !scheme.NewVerifier(pubs).Verify(msg, sign.Slice()) {
+		return nil, fmt.Errorf("ring signature verification failed")
+	}
+	return sign, nil
+}
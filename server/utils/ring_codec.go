@@ -0,0 +1,291 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	bullet "server/bulletproof/src"
+
+	"github.com/ZZMarquis/gm/sm2"
+	"github.com/ZZMarquis/gm/sm3"
+)
+
+/*
+环签名的二进制编码，与 bulletproof/src/encoding.go 的约定一致：
+
+	version (1) | kind (1) | [scheme (1)] | ring size n (2, 大端)
+	[key image (33, 压缩点)]    仅可链接环签名
+	c (32) | s_0 ... s_{n-1} (各32)
+
+scheme 为可链接环签名的方案编号(SchemeID)，仅可链接环签名有此字节；
+版本1没有该字节，按 SchemeBase 解码。
+标量固定32字节且必须小于N，密钥镜像不能是无穷远点，长度必须完全吻合，
+任何不符都返回 ErrMalformedSignature，不会让验证方崩溃
+*/
+const (
+	ringCodecVersion = 2
+	kindRingSig      = 1
+	kindLinkableSig  = 2
+	// MaxRingSize 环的最大成员数，解码时据此拒绝异常的长度头
+	MaxRingSize = 1024
+)
+
+// ErrMalformedSignature 环签名编码不合法
+var ErrMalformedSignature = errors.New("utils: malformed ring signature")
+
+// RingSignature 环签名 (c, s_0...s_{n-1})，与 Sign/Verify 的 []*big.Int 一一对应
+type RingSignature struct {
+	C *big.Int
+	S []*big.Int
+}
+
+// LinkableRingSignature 可链接环签名，KeyImage 即 Q_pai，同一私钥在同一环上的签名 KeyImage 相同
+type LinkableRingSignature struct {
+	Scheme   SchemeID
+	KeyImage bullet.ECPoint
+	C        *big.Int
+	S        []*big.Int
+}
+
+// NewRingSignature 由 Sign 返回的 [c, s_0...s_{n-1}] 构造
+func NewRingSignature(signature []*big.Int) (*RingSignature, error) {
+	if len(signature) < 3 {
+		return nil, fmt.Errorf("%w: %d elements", ErrMalformedSignature, len(signature))
+	}
+	return &RingSignature{C: signature[0], S: signature[1:]}, nil
+}
+
+// Slice 转换为 Verify 使用的 [c, s_0...s_{n-1}]
+func (sig *RingSignature) Slice() []*big.Int {
+	return append([]*big.Int{sig.C}, sig.S...)
+}
+
+// NewLinkableRingSignature 由可链接环签名 Sign 返回的 [Qx, Qy, c, s_0...s_{n-1}] 构造，方案为 SchemeBase
+func NewLinkableRingSignature(signature []*big.Int) (*LinkableRingSignature, error) {
+	if len(signature) < 5 {
+		return nil, fmt.Errorf("%w: %d elements", ErrMalformedSignature, len(signature))
+	}
+	return &LinkableRingSignature{
+		KeyImage: bullet.ECPoint{X: signature[0], Y: signature[1]},
+		C:        signature[2],
+		S:        signature[3:],
+	}, nil
+}
+
+// Slice 转换为 Verify 使用的 [Qx, Qy, c, s_0...s_{n-1}]
+func (sig *LinkableRingSignature) Slice() []*big.Int {
+	return append([]*big.Int{sig.KeyImage.X, sig.KeyImage.Y, sig.C}, sig.S...)
+}
+
+// Links 判断两个可链接环签名是否出自同一签名者，不同方案的签名不可链接
+func (sig *LinkableRingSignature) Links(other *LinkableRingSignature) bool {
+	return sig.Scheme == other.Scheme && sig.KeyImage.Equal(other.KeyImage)
+}
+
+// Encode 按上述格式编码
+func (sig *RingSignature) Encode() ([]byte, error) {
+	out, err := ringHeader(len(sig.S), kindRingSig)
+	if err != nil {
+		return nil, err
+	}
+	return appendScalars(out, sig.C, sig.S)
+}
+
+// Encode 按上述格式编码
+func (sig *LinkableRingSignature) Encode() ([]byte, error) {
+	out, err := ringHeader(len(sig.S), kindLinkableSig, byte(sig.Scheme))
+	if err != nil {
+		return nil, err
+	}
+	if sig.KeyImage.X == nil || sig.KeyImage.Y == nil || sig.KeyImage.IsZero() {
+		return nil, fmt.Errorf("%w: missing key image", ErrMalformedSignature)
+	}
+	q, err := ringParams().EncodePoint(sig.KeyImage)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+	return appendScalars(append(out, q...), sig.C, sig.S)
+}
+
+// DecodeRingSignature 严格解码环签名
+func DecodeRingSignature(b []byte) (*RingSignature, error) {
+	n, _, rest, err := readRingHeader(b, kindRingSig)
+	if err != nil {
+		return nil, err
+	}
+	c, s, err := readScalars(rest, n)
+	if err != nil {
+		return nil, err
+	}
+	return &RingSignature{C: c, S: s}, nil
+}
+
+// DecodeLinkableRingSignature 严格解码可链接环签名
+func DecodeLinkableRingSignature(b []byte) (*LinkableRingSignature, error) {
+	n, scheme, rest, err := readRingHeader(b, kindLinkableSig)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < bullet.PointSize {
+		return nil, fmt.Errorf("%w: truncated key image", ErrMalformedSignature)
+	}
+	q, err := ringParams().DecodePoint(rest[:bullet.PointSize])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+	if q.IsZero() {
+		return nil, fmt.Errorf("%w: key image is the identity", ErrMalformedSignature)
+	}
+	c, s, err := readScalars(rest[bullet.PointSize:], n)
+	if err != nil {
+		return nil, err
+	}
+	return &LinkableRingSignature{Scheme: scheme, KeyImage: q, C: c, S: s}, nil
+}
+
+// DecodeSignature 解码链上十六进制形式的可链接环签名，返回 Verify 使用的 []*big.Int
+func DecodeSignature(sign string) ([]*big.Int, error) {
+	b, err := hex.DecodeString(sign)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+	sig, err := DecodeLinkableRingSignature(b)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Slice(), nil
+}
+
+/*
+CanonicalRing 返回按压缩编码排序并去重后的环，签名方和验证方都对它签名/验证，
+这样环的顺序不会暴露签名者是后加入环的那一个
+*/
+func CanonicalRing(pubs []*sm2.PublicKey) ([]*sm2.PublicKey, error) {
+	type member struct {
+		enc []byte
+		pub *sm2.PublicKey
+	}
+	members := make([]member, 0, len(pubs))
+	for _, pub := range pubs {
+		if pub == nil {
+			return nil, fmt.Errorf("missing public key in ring")
+		}
+		enc, err := ringParams().EncodePoint(bullet.ECPoint{X: pub.X, Y: pub.Y})
+		if err != nil || enc[0] == 0 {
+			return nil, fmt.Errorf("invalid public key in ring")
+		}
+		members = append(members, member{enc, pub})
+	}
+	sort.Slice(members, func(i, j int) bool { return bytes.Compare(members[i].enc, members[j].enc) < 0 })
+	ring := make([]*sm2.PublicKey, 0, len(members))
+	for i, m := range members {
+		if i > 0 && bytes.Equal(m.enc, members[i-1].enc) {
+			continue
+		}
+		ring = append(ring, m.pub)
+	}
+	return ring, nil
+}
+
+/*
+RingCommitment 环成员的哈希承诺 SM3("ring" || n || 压缩公钥...)，按环中顺序计算，
+订单只上链这32字节，验证时用重建的环重新计算并比较
+*/
+func RingCommitment(pubs []*sm2.PublicKey) ([]byte, error) {
+	if len(pubs) < 2 || len(pubs) > MaxRingSize {
+		return nil, fmt.Errorf("ring size %d out of range", len(pubs))
+	}
+	h := sm3.New()
+	h.Write([]byte("ring"))
+	var n [2]byte
+	binary.BigEndian.PutUint16(n[:], uint16(len(pubs)))
+	h.Write(n[:])
+	for _, pub := range pubs {
+		enc, err := ringParams().EncodePoint(bullet.ECPoint{X: pub.X, Y: pub.Y})
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key in ring:%v", err)
+		}
+		h.Write(enc)
+	}
+	return h.Sum(nil), nil
+}
+
+func ringParams() *bullet.CryptoParams {
+	return bullet.SM2Params(1)
+}
+
+// ringHeader 写入版本、类型及(仅可链接环签名的)方案编号和环大小
+func ringHeader(n int, kind byte, scheme ...byte) ([]byte, error) {
+	if n < 2 || n > MaxRingSize {
+		return nil, fmt.Errorf("%w: ring size %d", ErrMalformedSignature, n)
+	}
+	out := make([]byte, 0, 5+bullet.PointSize+(n+1)*bullet.ScalarSize)
+	out = append(out, ringCodecVersion, kind)
+	out = append(out, scheme...)
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(n))
+	return append(out, size[:]...), nil
+}
+
+func readRingHeader(b []byte, kind byte) (int, SchemeID, []byte, error) {
+	if len(b) < 2 {
+		return 0, 0, nil, fmt.Errorf("%w: truncated header", ErrMalformedSignature)
+	}
+	version := b[0]
+	if version != 1 && version != ringCodecVersion {
+		return 0, 0, nil, fmt.Errorf("%w: unknown version %d", ErrMalformedSignature, version)
+	}
+	if b[1] != kind {
+		return 0, 0, nil, fmt.Errorf("%w: unexpected kind %d", ErrMalformedSignature, b[1])
+	}
+	b = b[2:]
+	scheme := SchemeBase
+	if kind == kindLinkableSig && version >= 2 {
+		if len(b) < 1 {
+			return 0, 0, nil, fmt.Errorf("%w: truncated header", ErrMalformedSignature)
+		}
+		scheme, b = SchemeID(b[0]), b[1:]
+		if _, err := GetLinkableScheme(scheme); err != nil {
+			return 0, 0, nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+		}
+	}
+	if len(b) < 2 {
+		return 0, 0, nil, fmt.Errorf("%w: truncated header", ErrMalformedSignature)
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if n < 2 || n > MaxRingSize {
+		return 0, 0, nil, fmt.Errorf("%w: ring size %d", ErrMalformedSignature, n)
+	}
+	return n, scheme, b[2:], nil
+}
+
+func appendScalars(out []byte, c *big.Int, s []*big.Int) ([]byte, error) {
+	for _, x := range append([]*big.Int{c}, s...) {
+		enc, err := ringParams().EncodeScalar(x)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+		}
+		out = append(out, enc...)
+	}
+	return out, nil
+}
+
+func readScalars(b []byte, n int) (*big.Int, []*big.Int, error) {
+	if len(b) != (n+1)*bullet.ScalarSize {
+		return nil, nil, fmt.Errorf("%w: %d bytes for a ring of %d", ErrMalformedSignature, len(b), n)
+	}
+	scalars := make([]*big.Int, n+1)
+	for i := range scalars {
+		x, err := ringParams().DecodeScalar(b[i*bullet.ScalarSize : (i+1)*bullet.ScalarSize])
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+		}
+		scalars[i] = x
+	}
+	return scalars[0], scalars[1:], nil
+}
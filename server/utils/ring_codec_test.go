@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+func TestRingSignatureEncodingRoundTrip(t *testing.T) {
+	pris, pubs := katKeys(5)
+	sign, err := Sign(rand.Reader, SimpleParticipantRandInt, pris[4], pubs, []byte("m"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := NewRingSignature(sign)
+	b, err := sig.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 4+6*32 {
+		t.Errorf("*****Ring signature of 5 encodes to %d bytes", len(b))
+	}
+	got, err := DecodeRingSignature(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(pubs, []byte("m"), got.Slice()) {
+		t.Error("*****Decoded ring signature does not verify")
+	}
+
+	// ring size header disagrees with the body
+	b[3]++
+	if _, err := DecodeRingSignature(b); !errors.Is(err, ErrMalformedSignature) {
+		t.Error("*****Wrong ring size accepted:", err)
+	}
+	b[3]--
+	// scalar not reduced mod N
+	for i := len(b) - 32; i < len(b); i++ {
+		b[i] = 0xff
+	}
+	if _, err := DecodeRingSignature(b); !errors.Is(err, ErrMalformedSignature) {
+		t.Error("*****Unreduced scalar accepted:", err)
+	}
+}
+
+func TestRingCommitment(t *testing.T) {
+	_, pubs := katKeys(4)
+	ring, err := CanonicalRing(append(pubs[2:], pubs...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ring) != 4 {
+		t.Fatalf("*****CanonicalRing kept %d of 4 distinct keys", len(ring))
+	}
+	again, _ := CanonicalRing([]*sm2.PublicKey{pubs[3], pubs[1], pubs[0], pubs[2]})
+	c1, _ := RingCommitment(ring)
+	c2, _ := RingCommitment(again)
+	if !bytes.Equal(c1, c2) {
+		t.Error("*****Ring commitment depends on the order keys were supplied in")
+	}
+	c3, _ := RingCommitment(ring[:3])
+	if bytes.Equal(c1, c3) {
+		t.Error("*****Ring commitment ignores a member")
+	}
+}
+
+func FuzzDecodeSignature(f *testing.F) {
+	for _, kat := range linkableKAT {
+		f.Add(kat.sig)
+	}
+	f.Add("")
+	f.Add("0202")
+	f.Add("01020002")
+	f.Fuzz(func(t *testing.T, s string) {
+		sign, err := DecodeSignature(s)
+		if err != nil {
+			if !errors.Is(err, ErrMalformedSignature) {
+				t.Fatalf("unexpected error type: %v", err)
+			}
+			return
+		}
+		// a decoded signature re-encodes to exactly the input (version 2)
+		b, _ := hex.DecodeString(s)
+		sig, err := DecodeLinkableRingSignature(b)
+		if err != nil || len(sign) != len(sig.S)+3 {
+			t.Fatalf("DecodeSignature and DecodeLinkableRingSignature disagree: %v", err)
+		}
+		if b[0] != ringCodecVersion {
+			return
+		}
+		enc, err := sig.Encode()
+		if err != nil {
+			t.Fatalf("decoded signature does not re-encode: %v", err)
+		}
+		if !bytes.Equal(enc, b) {
+			t.Fatalf("non-canonical encoding accepted: %x", b)
+		}
+	})
+}
@@ -6,9 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math/big"
-	"strings"
 
 	"github.com/ZZMarquis/gm/sm2"
 	"github.com/ZZMarquis/gm/sm3"
@@ -42,9 +40,13 @@ func randFieldElement(c elliptic.Curve, rand io.Reader) (k *big.Int, err error)
 	}
 }
 
-// 完全采用了sm2签名随机数r的生成方式，只是这里我们使用的默认uid
-func SM2ParticipantRandInt(rand io.Reader, pub sm2.PublicKey, msg []byte) (*big.Int, error) {
-	m, err := calculateSM2Hash(pub, msg, nil)
+// 完全采用了sm2签名随机数r的生成方式，UID由环成员的钱包地址派生，与其SM2签名的ZA相同
+func SM2ParticipantRandInt(rand io.Reader, pub *sm2.PublicKey, msg []byte) (*big.Int, error) {
+	uid, err := UIDOf(pub)
+	if err != nil {
+		return nil, err
+	}
+	m, err := calculateSM2Hash(pub, msg, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -62,7 +64,7 @@ func SM2ParticipantRandInt(rand io.Reader, pub sm2.PublicKey, msg []byte) (*big.
 		if r.Sign() != 0 {
 			s := new(big.Int).Add(r, k)
 			if s.Cmp(pub.Curve.Params().N) != 0 { // if r != 0 && (r + k) != N then ok
-				return s, nil
+				return s.Mod(s, pub.Curve.Params().N), nil // 响应是模N的标量，编码要求小于N
 			}
 		}
 	}
@@ -70,17 +72,19 @@ func SM2ParticipantRandInt(rand io.Reader, pub sm2.PublicKey, msg []byte) (*big.
 
 var defaultUID = []byte{0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38}
 
-func calculateSM2Hash(pub sm2.PublicKey, data, uid []byte) ([]byte, error) {
+func calculateSM2Hash(pub *sm2.PublicKey, data, uid []byte) ([]byte, error) {
 	if len(uid) == 0 {
 		uid = defaultUID
 	}
-	za, err := CalculateZA(&pub, uid)
+	za, err := CalculateZA(pub, uid)
 	if err != nil {
-		panic(fmt.Sprintf("calculateSM2Hash error:%s\n", err))
+		return nil, fmt.Errorf("calculateSM2Hash error:%v", err)
 	}
 	md := sm3.New()
 	md.Write(za)
-	md.Write(data)
+	if len(data) > 0 {
+		md.Write(data)
+	}
 	return md.Sum(nil), nil
 }
 
@@ -189,36 +193,16 @@ func fermatInverse(k, N *big.Int) *big.Int {
 	return new(big.Int).Exp(k, nMinus2, N)
 }
 
-// 这个hash算法没有给出明确定义
-func hash(pubs []*sm2.PublicKey, msg []byte, cx, cy *big.Int) *big.Int {
-	// var buffer [32]byte
-	// h := sm3.New()
-	// for _, pub := range pubs {
-	// 	pub.X.FillBytes(buffer[:])
-	// 	h.Write(buffer[:])
-	// 	pub.Y.FillBytes(buffer[:])
-	// 	h.Write(buffer[:])
-	// }
-	// h.Write(msg)
-	// cx.FillBytes(buffer[:])
-	// h.Write(buffer[:])
-	// cy.FillBytes(buffer[:])
-	// h.Write(buffer[:])
-	// return hashToInt(h.Sum(nil), pubs[0].Curve)
+// 这个hash算法没有给出明确定义，这里对环成员的ZA(见 ringZA)、消息和本轮的点做SM3哈希
+func hash(za []byte, msg []byte, cx, cy *big.Int) *big.Int {
 	h := sm3.New()
+	h.Write(za)
 
-	for _, pub := range pubs {
-		xBytes := pub.X.Bytes()
-		padXBytes := padToFixedLength(xBytes, 32) // 假设公钥的X和Y坐标需要填充到32字节
-		h.Write(padXBytes)
-
-		yBytes := pub.Y.Bytes()
-		padYBytes := padToFixedLength(yBytes, 32) // 假设公钥的X和Y坐标需要填充到32字节
-		h.Write(padYBytes)
+	// gm 的 sm3 写入空切片会panic，空消息不写入与写入空串的哈希相同
+	if len(msg) > 0 {
+		h.Write(msg)
 	}
 
-	h.Write(msg)
-
 	cxBytes := cx.Bytes()
 	padCXBytes := padToFixedLength(cxBytes, 32) // 假设cx和cy需要填充到32字节
 	h.Write(padCXBytes)
@@ -226,7 +210,7 @@ func hash(pubs []*sm2.PublicKey, msg []byte, cx, cy *big.Int) *big.Int {
 	cyBytes := cy.Bytes()
 	padCYBytes := padToFixedLength(cyBytes, 32) // 假设cx和cy需要填充到32字节
 	h.Write(padCYBytes)
-	return hashToInt(h.Sum(nil), pubs[0].Curve)
+	return hashToInt(h.Sum(nil), sm2.GetSm2P256V1())
 }
 
 // padToFixedLength 将字节切片填充到固定长度。如果原始切片比目标长度短，则在前面填充0。
@@ -238,7 +222,6 @@ func padToFixedLength(slice []byte, length int) []byte {
 
 // http://www.jcr.cacrnet.org.cn/CN/10.13868/j.cnki.jcr.000472
 func Sign(rand io.Reader, participantRandInt ParticipantRandInt, priv *sm2.PrivateKey, pubs []*sm2.PublicKey, msg []byte) ([]*big.Int, error) {
-	n := len(pubs)
 	pai, err := getPai(priv, pubs)
 	if err != nil {
 		return nil, err
@@ -249,38 +232,11 @@ func Sign(rand io.Reader, participantRandInt ParticipantRandInt, priv *sm2.Priva
 		return nil, err
 	}
 	kPaiGx, kPaiGy := priv.Curve.ScalarBaseMult(kPai.Bytes())
-	c := hash(pubs, msg, kPaiGx, kPaiGy)
 
-	results := make([]*big.Int, n+1)
 	// Step 2
-	// [pai+1, ... n)
-	for i := pai + 1; i < n; i++ {
-		s, err := participantRandInt(rand, pubs[i], msg)
-		if err != nil {
-			return nil, err
-		}
-		results[i+1] = s
-		sx, sy := priv.Curve.ScalarBaseMult(s.Bytes())
-		c.Add(s, c)
-		c.Mod(c, priv.Curve.Params().N)
-		cx, cy := priv.Curve.ScalarMult(pubs[i].X, pubs[i].Y, c.Bytes())
-		cx, cy = priv.Curve.Add(sx, sy, cx, cy)
-		c = hash(pubs, msg, cx, cy)
-	}
-	results[0] = new(big.Int).Set(c)
-	// [0...pai)
-	for i := 0; i < pai; i++ {
-		s, err := participantRandInt(rand, pubs[i], msg)
-		if err != nil {
-			return nil, err
-		}
-		results[i+1] = s
-		sx, sy := priv.Curve.ScalarBaseMult(s.Bytes())
-		c.Add(s, c)
-		c.Mod(c, priv.Curve.Params().N)
-		cx, cy := priv.Curve.ScalarMult(pubs[i].X, pubs[i].Y, c.Bytes())
-		cx, cy = priv.Curve.Add(sx, sy, cx, cy)
-		c = hash(pubs, msg, cx, cy)
+	c, results, err := RingChallenge(rand, participantRandInt, pubs, pai, msg, kPaiGx, kPaiGy)
+	if err != nil {
+		return nil, err
 	}
 
 	// Step 3: this step is same with SM2 signature scheme
@@ -297,23 +253,55 @@ func Sign(rand io.Reader, participantRandInt ParticipantRandInt, priv *sm2.Priva
 
 	return results, nil
 }
-func FlodSingature(signature []*big.Int) string {
-	// 将环签名转换为 JSON 字符串
-	signatureJSON, err := json.Marshal(signature)
+
+/*
+RingChallenge 环签名的第1、2步：由签名者的随机点 k*G 出发，为其他成员生成随机响应并沿环计算哈希，
+返回签名者位置pai上的挑战c，以及除 results[pai+1] 外已填好的签名 [c_0, s_0...s_{n-1}]。
+签名者的响应 s_pai = (1+d)^-1 * (k - c*d)，与SM2签名相同，不需要私钥的部分可以单独计算，门限签名据此由多方共同给出 s_pai
+*/
+func RingChallenge(rand io.Reader, participantRandInt ParticipantRandInt, pubs []*sm2.PublicKey, pai int, msg []byte, kx, ky *big.Int) (*big.Int, []*big.Int, error) {
+	n := len(pubs)
+	if n < 2 || pai < 0 || pai >= n {
+		return nil, nil, errors.New("signer is not in the ring")
+	}
+	za, err := ringZA(pubs)
 	if err != nil {
-		log.Fatal("JSON marshaling failed:", err)
+		return nil, nil, err
 	}
-	// 将 JSON 字符串转换为普通字符串
-	return string(signatureJSON)
-}
+	curve := pubs[pai].Curve
+	c := hash(za, msg, kx, ky)
 
-func DecodeSignature(sign string) []*big.Int {
-	// 在需要时，你可以将字符串解析为 []*big.Int 类型的环签名
-	var parsedSignature []*big.Int
-	if err := json.NewDecoder(strings.NewReader(sign)).Decode(&parsedSignature); err != nil {
-		log.Fatal("JSON unmarshaling failed:", err)
+	results := make([]*big.Int, n+1)
+	// [pai+1, ... n)
+	for i := pai + 1; i < n; i++ {
+		s, err := participantRandInt(rand, pubs[i], msg)
+		if err != nil {
+			return nil, nil, err
+		}
+		results[i+1] = s
+		sx, sy := curve.ScalarBaseMult(s.Bytes())
+		c.Add(s, c)
+		c.Mod(c, curve.Params().N)
+		cx, cy := curve.ScalarMult(pubs[i].X, pubs[i].Y, c.Bytes())
+		cx, cy = curve.Add(sx, sy, cx, cy)
+		c = hash(za, msg, cx, cy)
 	}
-	return parsedSignature
+	results[0] = new(big.Int).Set(c)
+	// [0...pai)
+	for i := 0; i < pai; i++ {
+		s, err := participantRandInt(rand, pubs[i], msg)
+		if err != nil {
+			return nil, nil, err
+		}
+		results[i+1] = s
+		sx, sy := curve.ScalarBaseMult(s.Bytes())
+		c.Add(s, c)
+		c.Mod(c, curve.Params().N)
+		cx, cy := curve.ScalarMult(pubs[i].X, pubs[i].Y, c.Bytes())
+		cx, cy = curve.Add(sx, sy, cx, cy)
+		c = hash(za, msg, cx, cy)
+	}
+	return c, results, nil
 }
 
 func publicKeyToBytes(pub *sm2.PublicKey) ([]byte, error) {
@@ -372,7 +360,12 @@ func KeyToString(privateKey *sm2.PrivateKey) (string, error) {
 	return string(privateKeyBytes), nil
 }
 func Verify(pubs []*sm2.PublicKey, msg []byte, signature []*big.Int) bool {
-	if len(pubs)+1 != len(signature) {
+	// 与Sign一致，环至少有两个成员，否则空环上的单个c就能通过验证
+	if len(pubs) < 2 || len(pubs)+1 != len(signature) {
+		return false
+	}
+	za, err := ringZA(pubs)
+	if err != nil {
 		return false
 	}
 	c := new(big.Int).Set(signature[0])
@@ -384,7 +377,7 @@ func Verify(pubs []*sm2.PublicKey, msg []byte, signature []*big.Int) bool {
 		c.Mod(c, pub.Curve.Params().N)
 		cx, cy := pub.Curve.ScalarMult(pubs[i].X, pubs[i].Y, c.Bytes())
 		cx, cy = pub.Curve.Add(sx, sy, cx, cy)
-		c = hash(pubs, msg, cx, cy)
+		c = hash(za, msg, cx, cy)
 	}
 	return c.Cmp(signature[0]) == 0
 }
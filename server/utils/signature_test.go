@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ZZMarquis/gm/sm2"
+)
+
+// signerPositions returns the positions tried for a ring of n members:
+// every position for small rings, the first, middle and last otherwise.
+// Signing costs a few scalar multiplications per member, so every position
+// of every ring up to 64 would take tens of minutes.
+func signerPositions(n, every int) []int {
+	if n <= every {
+		pos := make([]int, n)
+		for i := range pos {
+			pos[i] = i
+		}
+		return pos
+	}
+	return []int{0, n / 2, n - 1}
+}
+
+// ringSizes returns 2..64, or a few representative sizes with -short.
+func ringSizes() []int {
+	if testing.Short() {
+		return []int{2, 3, 5, 16}
+	}
+	sizes := make([]int, 0, 63)
+	for n := 2; n <= 64; n++ {
+		sizes = append(sizes, n)
+	}
+	return sizes
+}
+
+func TestRingSignEveryPosition(t *testing.T) {
+	pris, pubs := katKeys(8)
+	msg := []byte("Enc_A(m)||Enc_B(m)||Enc_A(b)")
+	for n := 2; n <= 8; n++ {
+		for _, pai := range signerPositions(n, 8) {
+			sig, err := Sign(rand.Reader, SimpleParticipantRandInt, pris[pai], pubs[:n], msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !Verify(pubs[:n], msg, sig) {
+				t.Errorf("*****Ring signature FAILURE with ring size %d, signer %d", n, pai)
+			}
+		}
+	}
+}
+
+func TestRingSignSizes(t *testing.T) {
+	pris, pubs := katKeys(64)
+	msg := []byte("ring sizes")
+	for i, n := range ringSizes() {
+		// rotate through first, middle and last so every size is signed
+		// from some position and each position kind is hit often
+		pai := signerPositions(n, 0)[i%3]
+		sig, err := Sign(rand.Reader, SimpleParticipantRandInt, pris[pai], pubs[:n], msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Verify(pubs[:n], msg, sig) {
+			t.Errorf("*****Ring signature FAILURE with ring size %d, signer %d", n, pai)
+		}
+	}
+	sizes := ringSizes()
+	fmt.Println("Ring signature works for ring sizes", sizes[0], "to", sizes[len(sizes)-1])
+}
+
+func TestRingSignTampering(t *testing.T) {
+	pris, pubs := katKeys(5)
+	_, others := katKeys(6)
+	msg := []byte("Add_A||Add_B||OrderNum||Sign_B")
+	sig, err := Sign(rand.Reader, SimpleParticipantRandInt, pris[3], pubs, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, msg...)
+	tampered[0] ^= 1
+	if Verify(pubs, tampered, sig) {
+		t.Error("*****Ring signature verified for a changed message")
+	}
+
+	swapped := append([]*sm2.PublicKey{}, pubs...)
+	swapped[0], swapped[4] = swapped[4], swapped[0]
+	if Verify(swapped, msg, sig) {
+		t.Error("*****Ring signature verified with the ring reordered")
+	}
+
+	replaced := append([]*sm2.PublicKey{}, pubs...)
+	replaced[1] = others[5]
+	if Verify(replaced, msg, sig) {
+		t.Error("*****Ring signature verified with a ring member replaced")
+	}
+	if Verify(pubs[:4], msg, sig) {
+		t.Error("*****Ring signature verified with a ring member dropped")
+	}
+
+	for i := range sig {
+		bad := append([]*big.Int{}, sig...)
+		bad[i] = new(big.Int).Add(sig[i], big.NewInt(1))
+		if Verify(pubs, msg, bad) {
+			t.Errorf("*****Ring signature verified with scalar %d changed", i)
+		}
+	}
+}
+
+func TestRingSignNotInRing(t *testing.T) {
+	pris, pubs := katKeys(4)
+	if _, err := Sign(rand.Reader, SimpleParticipantRandInt, pris[3], pubs[:3], []byte("m")); err == nil {
+		t.Error("*****Signed for a ring that does not contain the signer")
+	}
+	if _, err := Sign(rand.Reader, SimpleParticipantRandInt, pris[0], pubs[:1], []byte("m")); err == nil {
+		t.Error("*****Signed for a ring of one")
+	}
+}
+
+func TestRingSignEmptyMessage(t *testing.T) {
+	pris, pubs := katKeys(3)
+	sig, err := Sign(rand.Reader, SimpleParticipantRandInt, pris[0], pubs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Verify(pubs, []byte{}, sig) {
+		t.Error("*****Ring signature of an empty message FAILURE")
+	}
+	if _, err := GenerateLinkSign(SchemeBase, pris[0], pubs, nil); err != nil {
+		t.Error("*****Linkable ring signature of an empty message FAILURE:", err)
+	}
+}
+
+func TestRingVerifyDegenerate(t *testing.T) {
+	if Verify(nil, []byte("m"), []*big.Int{big.NewInt(1)}) {
+		t.Error("*****Signature over an empty ring verified")
+	}
+	for _, id := range linkableSchemeIDs {
+		scheme, _ := GetLinkableScheme(id)
+		if scheme.NewVerifier(nil).Verify([]byte("m"), []*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(3)}) {
+			t.Errorf("*****Scheme %d signature over an empty ring verified", id)
+		}
+	}
+}
+
+func FuzzVerify(f *testing.F) {
+	pris, pubs := katKeys(3)
+	msg := []byte("fuzz message")
+	sign, err := Sign(newDetReader("fuzz"), SimpleParticipantRandInt, pris[1], pubs, msg)
+	if err != nil {
+		f.Fatal(err)
+	}
+	sig, _ := NewRingSignature(sign)
+	valid, err := sig.Encode()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(msg, valid)
+	f.Add([]byte{}, valid[:len(valid)-1])
+	f.Add(msg, make([]byte, len(valid)))
+
+	f.Fuzz(func(t *testing.T, m []byte, b []byte) {
+		sig, err := DecodeRingSignature(b)
+		if err != nil {
+			return
+		}
+		// anything but the one signature we made is a forgery
+		if Verify(pubs, m, sig.Slice()) && (!bytes.Equal(m, msg) || !bytes.Equal(b, valid)) {
+			t.Fatalf("forged ring signature verified: msg %x sig %x", m, b)
+		}
+	})
+}
+
+func BenchmarkRingSign(b *testing.B) {
+	pris, pubs := katKeys(64)
+	for _, n := range []int{2, 4, 8, 16, 32, 64} {
+		b.Run(fmt.Sprintf("ring%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := Sign(rand.Reader, SimpleParticipantRandInt, pris[0], pubs[:n], []byte("bench")); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRingVerify(b *testing.B) {
+	pris, pubs := katKeys(64)
+	for _, n := range []int{2, 4, 8, 16, 32, 64} {
+		sig, err := Sign(rand.Reader, SimpleParticipantRandInt, pris[0], pubs[:n], []byte("bench"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(fmt.Sprintf("ring%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if !Verify(pubs[:n], []byte("bench"), sig) {
+					b.Fatal("verification failed")
+				}
+			}
+		})
+	}
+}
@@ -4,10 +4,10 @@ import (
 	bullet "server/bulletproof/src"
 
 	"bytes"
+	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/binary"
-	"encoding/gob"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -16,6 +16,7 @@ import (
 	"math/big"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/ZZMarquis/gm/sm2"
 	"github.com/ZZMarquis/gm/sm3"
@@ -120,6 +121,37 @@ func GetAddress(username string) string {
 	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
 
+// FindUserByAddress 在key文件夹的公钥中查找地址为address的用户名，地址计算方式与GetAddress相同
+func FindUserByAddress(address string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(".", "key", "*-pub"))
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		username := strings.TrimSuffix(filepath.Base(f), "-pub")
+		if GetAddress(username) == address {
+			return username, nil
+		}
+	}
+	return "", fmt.Errorf("no user with address %s", address)
+}
+
+// ReadRoleMembers 读取角色名单 key/<role>，如 regulators、operators，内容为用户名的JSON数组；文件不存在时名单为空
+func ReadRoleMembers(role string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(".", "key", role))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s:%v", role, err)
+	}
+	var members []string
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, fmt.Errorf("failed to decode %s:%v", role, err)
+	}
+	return members, nil
+}
+
 // amount
 func EncryptAmount(num int64, pub *sm2.PublicKey) (string, error) {
 	// 使用bytes.Buffer来存储转换后的字节
@@ -153,86 +185,83 @@ func DecryptAmount(ctext string, pri *sm2.PrivateKey) (string, error) {
 	return amount_str, nil
 }
 
-// ECPointToBytes 将 ECPoint 结构体序列化为 []byte。
-func ECPointToBytes(p *bullet.ECPoint) ([]byte, error) {
-	var buf bytes.Buffer
-
-	// 写入 X 的字节表示
-	xBytes := p.X.Bytes()
-	// 写入 X 的长度（为了之后能够反序列化）
-	if err := binary.Write(&buf, binary.BigEndian, uint32(len(xBytes))); err != nil {
-		return nil, err
-	}
-	if _, err := buf.Write(xBytes); err != nil {
-		return nil, err
-	}
+// ECPointToBytes 将 ECPoint 编码为33字节的压缩点，格式见 bulletproof/src/encoding.go
+func ECPointToBytes(ec *bullet.CryptoParams, p *bullet.ECPoint) ([]byte, error) {
+	return ec.EncodePoint(*p)
+}
 
-	// 写入 Y 的字节表示
-	yBytes := p.Y.Bytes()
-	// 写入 Y 的长度（为了之后能够反序列化）
-	if err := binary.Write(&buf, binary.BigEndian, uint32(len(yBytes))); err != nil {
-		return nil, err
-	}
-	if _, err := buf.Write(yBytes); err != nil {
+// BytesToEcpoint 解码压缩点，并检查其在ec的曲线上
+func BytesToEcpoint(ec *bullet.CryptoParams, data []byte) (*bullet.ECPoint, error) {
+	p, err := ec.DecodePoint(data)
+	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return &p, nil
 }
 
-// ECPointFromBytes 将 []byte 反序列化为 ECPoint 结构体。
-func BytesToEcpoint(data []byte) (*bullet.ECPoint, error) {
-	buf := bytes.NewBuffer(data)
+func RangeProofToBytes(ec *bullet.CryptoParams, r *bullet.RangeProof) ([]byte, error) {
+	return ec.EncodeRangeProof(*r)
+}
 
-	// 读取 X 的长度
-	var xLen uint32
-	if err := binary.Read(buf, binary.BigEndian, &xLen); err != nil {
+func BytesToRangeProof(ec *bullet.CryptoParams, b []byte) (*bullet.RangeProof, error) {
+	rp, err := ec.DecodeRangeProof(b)
+	if err != nil {
 		return nil, err
 	}
+	return &rp, nil
+}
 
-	// 读取 X 的字节表示并转换为 *big.Int
-	xBytes := make([]byte, xLen)
-	if _, err := buf.Read(xBytes); err != nil {
-		return nil, err
-	}
-	x := new(big.Int).SetBytes(xBytes)
+func MultiRangeProofToBytes(ec *bullet.CryptoParams, r *bullet.MultiRangeProof) ([]byte, error) {
+	return ec.EncodeMultiRangeProof(*r)
+}
 
-	// 读取 Y 的长度
-	var yLen uint32
-	if err := binary.Read(buf, binary.BigEndian, &yLen); err != nil {
+func BytesToMultiRangeProof(ec *bullet.CryptoParams, b []byte) (*bullet.MultiRangeProof, error) {
+	mrp, err := ec.DecodeMultiRangeProof(b)
+	if err != nil {
 		return nil, err
 	}
+	return &mrp, nil
+}
+
+func EqualityProofToBytes(ec *bullet.CryptoParams, p *bullet.EqualityProof) ([]byte, error) {
+	return ec.EncodeEqualityProof(*p)
+}
 
-	// 读取 Y 的字节表示并转换为 *big.Int
-	yBytes := make([]byte, yLen)
-	if _, err := buf.Read(yBytes); err != nil {
+func BytesToEqualityProof(ec *bullet.CryptoParams, b []byte) (*bullet.EqualityProof, error) {
+	proof, err := ec.DecodeEqualityProof(b)
+	if err != nil {
 		return nil, err
 	}
-	y := new(big.Int).SetBytes(yBytes)
+	return &proof, nil
+}
 
-	// 返回重建的 ECPoint 结构体
-	return &bullet.ECPoint{X: x, Y: y}, nil
+func BalanceProofToBytes(ec *bullet.CryptoParams, p *bullet.BalanceProof) ([]byte, error) {
+	return ec.EncodeBalanceProof(*p)
 }
 
-func RangeProofToBytes(r *bullet.RangeProof) ([]byte, error) {
-	// 使用gob进行序列化
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	if err := enc.Encode(r); err != nil {
+func BytesToBalanceProof(ec *bullet.CryptoParams, b []byte) (*bullet.BalanceProof, error) {
+	proof, err := ec.DecodeBalanceProof(b)
+	if err != nil {
 		return nil, err
 	}
-	// 现在buf.Bytes()是一个[]byte，它包含了rp的序列化表示
-	serializedData := buf.Bytes()
-	return serializedData, nil
+	return &proof, nil
 }
 
-func BytesToRangeProof(b []byte) (*bullet.RangeProof, error) {
-	var decodedRp *bullet.RangeProof
-	dec := gob.NewDecoder(bytes.NewReader(b))
-	if err := dec.Decode(&decodedRp); err != nil {
-		return nil, err
-	}
-	return decodedRp, nil
+// CipherToEcpoints 将同态密文C1||C2拆分为两个点
+func CipherToEcpoints(cipher []byte) (bullet.ECPoint, bullet.ECPoint, error) {
+	curve := sm2.GetSm2P256V1()
+	pointLen := 2*KeyBytes + 1
+	if len(cipher) != 2*pointLen {
+		return bullet.ECPoint{}, bullet.ECPoint{}, fmt.Errorf("ciphertext length %d", len(cipher))
+	}
+	x1, y1 := elliptic.Unmarshal(curve, cipher[:pointLen])
+	x2, y2 := elliptic.Unmarshal(curve, cipher[pointLen:])
+	if x1 == nil || x2 == nil {
+		return bullet.ECPoint{}, bullet.ECPoint{}, fmt.Errorf("ciphertext point not on curve")
+	}
+	return bullet.ECPoint{X: x1, Y: y1}, bullet.ECPoint{X: x2, Y: y2}, nil
 }
+
 func DecodeKeys(pubs []byte) ([]*sm2.PublicKey, error) {
 	var ring []string
 	if err := json.Unmarshal(pubs, &ring); err != nil {
@@ -250,20 +279,6 @@ func DecodeKeys(pubs []byte) ([]*sm2.PublicKey, error) {
 	return ring_pubs, nil
 }
 
-func GenerateLinkSign(baseSigner *BaseLinkableSigner, bytes []byte) (string, error) {
-	sign, err := baseSigner.Sign(rand.Reader, SimpleParticipantRandInt, bytes)
-	if err != nil {
-		return "", err
-	}
-	res_sign := FlodSingature(sign)
-	return res_sign, nil
-}
-
-func LinkSignVerify(baseVerify *BaseLinkableVerfier, msg []byte, signature string) bool {
-	sign := DecodeSignature(signature)
-	return baseVerify.Verify(msg, sign)
-}
-
 func privateKeyToBase64(b []byte) string {
 	base64Str := base64.StdEncoding.EncodeToString(b)
 	return base64Str